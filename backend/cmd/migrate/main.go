@@ -1,41 +1,148 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
+	_ "github.com/lib/pq"
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/migrate"
 )
 
+const usage = `Usage: go run cmd/migrate/main.go [-dir DIR] <command> [args]
+
+Commands:
+  up              Apply all pending migrations
+  down            Roll back the most recent migration
+  redo            Roll back and re-apply the most recent migration
+  status          Show applied and pending migrations
+  goto VERSION    Migrate up or down to VERSION (0 rolls back everything)
+  create NAME     Create a new migration file
+`
+
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run cmd/migrate/main.go [up|down]")
+	dir := flag.String("dir", "migrations", "directory containing migration files")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	// create only touches the filesystem, so it does not need a database
+	if command == "create" {
+		if len(args) != 1 {
+			log.Fatal("Usage: create NAME")
+		}
+		path, err := migrate.Create(*dir, args[0], time.Now())
+		if err != nil {
+			log.Fatalf("Failed to create migration: %v", err)
+		}
+		fmt.Printf("📝 Created %s\n", path)
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	migrations, err := migrate.Load(*dir)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	db, err := sql.Open("postgres", cfg.DatabaseURL.String())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
 	}
+	defer db.Close()
 
-	cfg := config.Load()
-	direction := os.Args[1]
+	ctx := context.Background()
+	migrator := migrate.NewMigrator(db, migrations)
 
-	switch direction {
+	fmt.Printf("🔄 Running %s against %s\n", command, cfg.DatabaseURL.Redacted())
+
+	var steps []migrate.Step
+	switch command {
 	case "up":
-		runMigrationsUp(cfg)
+		steps, err = migrator.Up(ctx)
 	case "down":
-		runMigrationsDown(cfg)
+		steps, err = migrator.Down(ctx)
+	case "redo":
+		steps, err = migrator.Redo(ctx)
+	case "goto":
+		if len(args) != 1 {
+			log.Fatal("Usage: goto VERSION")
+		}
+		version, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil || version < 0 {
+			log.Fatalf("Invalid version %q", args[0])
+		}
+		steps, err = migrator.Goto(ctx, version)
+	case "status":
+		err = printStatus(ctx, migrator)
 	default:
-		log.Fatal("Invalid direction. Use 'up' or 'down'")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, step := range steps {
+		arrow := "⬆️ "
+		if step.Direction == migrate.DirectionDown {
+			arrow = "⬇️ "
+		}
+		fmt.Printf("%s %s (%s)\n", arrow, step.Migration, step.Duration.Round(time.Millisecond))
+	}
+
+	if err != nil {
+		log.Fatalf("❌ Migration failed: %v", err)
 	}
-}
 
-func runMigrationsUp(cfg *config.Config) {
-	fmt.Printf("🔄 Running migrations UP against %s\n", cfg.DatabaseURL)
-	// TODO: Implement actual migration logic
-	// This would typically use a migration library like golang-migrate
-	fmt.Println("✅ Migrations completed successfully")
+	if command != "status" {
+		if len(steps) == 0 {
+			fmt.Println("✅ Nothing to do, schema is up to date")
+		} else {
+			fmt.Printf("✅ %d migration(s) completed successfully\n", len(steps))
+		}
+	}
 }
 
-func runMigrationsDown(cfg *config.Config) {
-	fmt.Printf("🔄 Running migrations DOWN against %s\n", cfg.DatabaseURL)
-	// TODO: Implement actual migration logic
-	// This would typically use a migration library like golang-migrate
-	fmt.Println("✅ Migrations rollback completed successfully")
+// printStatus prints one line per known migration
+func printStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No migrations found")
+		return nil
+	}
+
+	fmt.Printf("%-16s %-8s %-20s %s\n", "VERSION", "STATE", "APPLIED AT", "NAME")
+	for _, s := range statuses {
+		state, appliedAt := "pending", "-"
+		if s.Applied {
+			state = "applied"
+			appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if s.Missing {
+			state = "missing"
+		}
+		fmt.Printf("%-16d %-8s %-20s %s\n", s.Version, state, appliedAt, s.Name)
+	}
+	return nil
 }
@@ -2,22 +2,91 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/buildinfo"
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/handlers"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/health"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/logging"
 	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/middleware"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem/ginproblem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
 )
 
 func main() {
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	// The standard log package now writes through slog as well
+	slog.SetDefault(logger)
+
+	build := buildinfo.Get()
+	log.Printf("📦 Version %s (commit %s, built %s, %s)", build.Version, build.Commit, build.BuildTime, build.GoVersion)
+
+	// Connections are opened lazily, so a database that is still starting
+	// only makes /readyz fail
+	db, err := sql.Open("postgres", cfg.DatabaseURL.String())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	var redisClient *redis.Client
+	if cfg.RedisURL != "" {
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		redisClient = redis.NewClient(opts)
+		defer redisClient.Close()
+	}
+
+	checks := health.NewRegistry(cfg.HealthCacheTTL)
+	mustRegister(checks, health.Check{
+		Name:    "postgres",
+		Func:    health.PingCheck(db),
+		Timeout: cfg.HealthCheckTimeout,
+	})
+	if redisClient != nil {
+		mustRegister(checks, health.Check{
+			Name:        "redis",
+			Func:        health.RedisCheck(redisClient),
+			Timeout:     cfg.HealthCheckTimeout,
+			Criticality: health.Optional,
+		})
+	}
+	mustRegister(checks, health.Check{
+		Name:        "disk",
+		Func:        health.DiskSpaceCheck(cfg.HealthDiskPath, uint64(cfg.HealthDiskMinFree)<<20),
+		Timeout:     cfg.HealthCheckTimeout,
+		Criticality: health.Optional,
+	})
+
+	tokens, err := auth.NewTokenService(cfg.JWTSecret, cfg.JWTIssuer, cfg.JWTAccessTTL, cfg.JWTRefreshTTL)
+	if err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
+	}
+	authHandler := handlers.NewAuthHandler(auth.NewService(auth.NewPostgresStore(db), tokens, cfg.BcryptCost))
+	requireAuth := middleware.Authenticate(tokens)
 
 	// Initialize Gin router
 	if cfg.Env == "production" {
@@ -25,26 +94,70 @@ func main() {
 	}
 
 	router := gin.New()
+	// Unmatched requests get problem details like handler errors
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(ginproblem.NoRoute)
+	router.NoMethod(ginproblem.NoMethod)
+
+	corsPolicy, err := middleware.NewCORSPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Invalid CORS configuration: %v", err)
+	}
 
 	// Add middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
-	router.Use(middleware.CORS())
+	registry := metrics.NewRegistry()
+	httpMetrics := metrics.NewHTTP(registry, "backend")
+
+	router.Use(middleware.RequestID())
+	router.Use(middleware.RequestLogger(logger, middleware.LogOptionsFromConfig(cfg)))
+	router.Use(middleware.Metrics(httpMetrics))
+	router.Use(gin.CustomRecovery(ginproblem.Recovery))
+	router.Use(middleware.CORS(corsPolicy))
+	if cfg.RateLimitEnabled {
+		// Share counters through Redis when several instances run
+		var store ratelimit.Store = ratelimit.NewMemoryStore()
+		if redisClient != nil {
+			store = ratelimit.NewRedisStore(redisClient)
+		}
+		limiter, err := middleware.NewRateLimiter(cfg, store, tokens)
+		if err != nil {
+			log.Fatalf("Invalid rate limit configuration: %v", err)
+		}
+		router.Use(middleware.RateLimit(limiter))
+	}
 
-	// Health check endpoint
+	// Health check endpoints
 	router.GET("/health", handlers.HealthCheck)
+	router.GET("/livez", handlers.Livez)
+	router.GET("/readyz", handlers.Readyz(checks))
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(metrics.Handler(registry)))
 
 	// API routes
 	api := router.Group("/api/v1")
 	{
 		api.GET("/ping", handlers.Ping)
+
+		authRoutes := api.Group("/auth")
+		authRoutes.POST("/register", authHandler.Register)
+		authRoutes.POST("/login", authHandler.Login)
+		authRoutes.POST("/refresh", authHandler.Refresh)
+		authRoutes.POST("/logout", authHandler.Logout)
+		authRoutes.GET("/me", requireAuth, authHandler.Me)
+
+		admin := api.Group("/admin", requireAuth, middleware.RequireRoles(auth.RoleAdmin))
+		admin.GET("/users/:id", authHandler.GetUser)
 		// Add more routes as needed
 	}
 
 	// Create HTTP server
 	server := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: router,
+		Addr:           ":" + cfg.Port,
+		Handler:        router,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
 	}
 
 	// Start server in a goroutine
@@ -61,8 +174,8 @@ func main() {
 	<-quit
 	log.Println("🛑 Shutting down server...")
 
-	// Give outstanding requests 10 seconds to complete
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Give outstanding requests time to complete
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
@@ -71,3 +184,9 @@ func main() {
 
 	log.Println("✅ Server exited")
 }
+
+func mustRegister(registry *health.Registry, check health.Check) {
+	if err := registry.Register(check); err != nil {
+		log.Fatalf("Failed to register health check: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Package auth implements user registration, login and JWT based sessions.
+//
+// A session consists of a short-lived access token, sent with every API
+// request, and a long-lived refresh token that can be exchanged for a new
+// pair exactly once. Refresh tokens are tracked in the store so that they
+// can be revoked on logout, and reusing a consumed refresh token revokes
+// every session of the user.
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Roles known to the server
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Errors returned by the service. Handlers map them to HTTP statuses.
+var (
+	ErrEmailTaken         = errors.New("email is already registered")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// User is a registered account. The password hash never leaves the
+// server.
+type User struct {
+	ID           int64     `json:"id"`
+	Email        string    `json:"email"`
+	Roles        []string  `json:"roles"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// HasRole reports whether the user has role
+func (u *User) HasRole(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID int64
+	Email  string
+	Roles  []string
+}
+
+// HasAnyRole reports whether the principal has at least one of roles
+func (p Principal) HasAnyRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
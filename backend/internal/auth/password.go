@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest accepted password
+const MinPasswordLength = 8
+
+// maxPasswordLength is the longest password bcrypt can hash
+const maxPasswordLength = 72
+
+// ErrWeakPassword is wrapped by every password policy violation
+var ErrWeakPassword = errors.New("password is too weak")
+
+// ValidatePassword checks a new password against the password policy
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, MinPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrWeakPassword, maxPasswordLength)
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return fmt.Errorf("%w: it must contain letters and digits", ErrWeakPassword)
+	}
+	return nil
+}
+
+// HashPassword hashes a password with bcrypt at the given cost
+func HashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the PostgreSQL error code for unique constraint
+// violations
+const uniqueViolation = "23505"
+
+// PostgresStore keeps users and refresh tokens in the tables created by
+// the create_users migration
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a store backed by db
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, email, passwordHash string, roles []string) (*User, error) {
+	user := &User{Email: email, PasswordHash: passwordHash, Roles: roles}
+
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO users (email, password_hash, roles) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		email, passwordHash, pq.Array(roles),
+	).Scan(&user.ID, &user.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) UserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.queryUser(ctx, `SELECT id, email, password_hash, roles, created_at FROM users WHERE LOWER(email) = LOWER($1)`, email)
+}
+
+func (s *PostgresStore) UserByID(ctx context.Context, id int64) (*User, error) {
+	return s.queryUser(ctx, `SELECT id, email, password_hash, roles, created_at FROM users WHERE id = $1`, id)
+}
+
+func (s *PostgresStore) queryUser(ctx context.Context, query string, arg interface{}) (*User, error) {
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(
+		&user.ID, &user.Email, &user.PasswordHash, pq.Array(&user.Roles), &user.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) SaveRefreshToken(ctx context.Context, token RefreshToken) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, expires_at) VALUES ($1, $2, $3)`,
+		token.ID, token.UserID, token.ExpiresAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) RevokeRefreshToken(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return err
+	}
+
+	// Nothing was updated: either the token is unknown or already revoked
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrTokenRevoked
+	}
+	return ErrInvalidToken
+}
+
+func (s *PostgresStore) RevokeUserTokens(ctx context.Context, userID int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/migrate"
+)
+
+func TestPostgresStore(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set, skipping PostgreSQL store tests")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	migrations, err := migrate.Load("../../migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate.NewMigrator(db, migrations).Up(ctx); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	store := NewPostgresStore(db)
+	email := fmt.Sprintf("store-%d@example.com", time.Now().UnixNano())
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE email = $1`, email) })
+
+	user, err := store.CreateUser(ctx, email, "hash", []string{RoleUser, RoleAdmin})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := store.CreateUser(ctx, email, "hash", nil); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Expected ErrEmailTaken, got %v", err)
+	}
+
+	found, err := store.UserByEmail(ctx, email)
+	if err != nil || found.ID != user.ID || !found.HasRole(RoleAdmin) {
+		t.Errorf("Unexpected user %+v, err %v", found, err)
+	}
+	if _, err := store.UserByID(ctx, -1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	token := RefreshToken{ID: newTokenID(), UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveRefreshToken(ctx, token); err != nil {
+		t.Fatalf("SaveRefreshToken failed: %v", err)
+	}
+	if err := store.RevokeRefreshToken(ctx, token.ID); err != nil {
+		t.Errorf("Expected the first revoke to succeed, got %v", err)
+	}
+	if err := store.RevokeRefreshToken(ctx, token.ID); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected ErrTokenRevoked, got %v", err)
+	}
+	if err := store.RevokeRefreshToken(ctx, "unknown"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
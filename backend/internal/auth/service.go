@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// dummyHash is compared against when a login names an unknown user, so that
+// the response time does not reveal which emails are registered
+const dummyHash = "$2a$10$IfrqRQnYLC2rLos7uLWn5ePxGHaS9QraNDomvPBQwmXULMbJ1l.4m"
+
+// Service implements the authentication use cases on top of a Store
+type Service struct {
+	store      Store
+	tokens     *TokenService
+	bcryptCost int
+}
+
+// NewService creates an authentication service
+func NewService(store Store, tokens *TokenService, bcryptCost int) *Service {
+	return &Service{store: store, tokens: tokens, bcryptCost: bcryptCost}
+}
+
+// NormalizeEmail trims and lowercases an email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Register creates a user with the default role and signs them in
+func (s *Service) Register(ctx context.Context, email, password string) (*User, TokenPair, error) {
+	if err := ValidatePassword(password); err != nil {
+		return nil, TokenPair{}, err
+	}
+
+	hash, err := HashPassword(password, s.bcryptCost)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+
+	user, err := s.store.CreateUser(ctx, NormalizeEmail(email), hash, []string{RoleUser})
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+
+	pair, err := s.issue(ctx, user)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	return user, pair, nil
+}
+
+// Login checks the credentials and returns a new token pair. Unknown emails
+// and wrong passwords both return ErrInvalidCredentials.
+func (s *Service) Login(ctx context.Context, email, password string) (*User, TokenPair, error) {
+	user, err := s.store.UserByEmail(ctx, NormalizeEmail(email))
+	if errors.Is(err, ErrUserNotFound) {
+		CheckPassword(dummyHash, password)
+		return nil, TokenPair{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+
+	if !CheckPassword(user.PasswordHash, password) {
+		return nil, TokenPair{}, ErrInvalidCredentials
+	}
+
+	pair, err := s.issue(ctx, user)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	return user, pair, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. Each refresh token can
+// be used once; presenting a consumed token again is treated as theft and
+// revokes all sessions of the user.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	claims, err := s.tokens.Parse(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	userID, _ := claims.UserID()
+
+	if err := s.store.RevokeRefreshToken(ctx, claims.ID); err != nil {
+		if errors.Is(err, ErrTokenRevoked) {
+			if err := s.store.RevokeUserTokens(ctx, userID); err != nil {
+				return TokenPair{}, err
+			}
+		}
+		return TokenPair{}, err
+	}
+
+	// Roles may have changed since the token was issued
+	user, err := s.store.UserByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return TokenPair{}, ErrInvalidToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return s.issue(ctx, user)
+}
+
+// Logout revokes a refresh token. Logging out twice is not an error.
+func (s *Service) Logout(ctx context.Context, refreshToken string) error {
+	claims, err := s.tokens.Parse(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.RevokeRefreshToken(ctx, claims.ID)
+	if errors.Is(err, ErrTokenRevoked) {
+		return nil
+	}
+	return err
+}
+
+// User returns the user with id
+func (s *Service) User(ctx context.Context, id int64) (*User, error) {
+	return s.store.UserByID(ctx, id)
+}
+
+func (s *Service) issue(ctx context.Context, user *User) (TokenPair, error) {
+	pair, err := s.tokens.Issue(user)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	err = s.store.SaveRefreshToken(ctx, RefreshToken{
+		ID:        pair.refreshID,
+		UserID:    user.ID,
+		ExpiresAt: pair.RefreshExpiresAt,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return pair, nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	return NewService(NewMemoryStore(), newTestTokens(t), bcrypt.MinCost)
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"secret12", true},
+		{"short1", false},
+		{"lettersonly", false},
+		{"1234567890", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePassword(tt.password)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidatePassword(%q) = %v, expected valid=%v", tt.password, err, tt.valid)
+		}
+		if err != nil && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("Expected ErrWeakPassword, got %v", err)
+		}
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	user, _, err := svc.Register(ctx, "  Ada@Example.com ", "secret12")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if user.Email != "ada@example.com" || !user.HasRole(RoleUser) {
+		t.Errorf("Unexpected user %+v", user)
+	}
+	if user.PasswordHash == "secret12" || !CheckPassword(user.PasswordHash, "secret12") {
+		t.Error("Expected the password to be stored as a bcrypt hash")
+	}
+
+	if _, _, err := svc.Register(ctx, "ADA@example.com", "secret12"); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Expected ErrEmailTaken, got %v", err)
+	}
+	if _, _, err := svc.Register(ctx, "bob@example.com", "weak"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("Expected ErrWeakPassword, got %v", err)
+	}
+
+	if _, pair, err := svc.Login(ctx, "ada@example.com", "secret12"); err != nil || pair.AccessToken == "" {
+		t.Errorf("Expected login to succeed, got %v", err)
+	}
+	if _, _, err := svc.Login(ctx, "ada@example.com", "wrong-password1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	if _, _, err := svc.Login(ctx, "nobody@example.com", "secret12"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	_, first, err := svc.Register(ctx, "ada@example.com", "secret12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := svc.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("Expected a new refresh token")
+	}
+
+	// Reusing the first token revokes the whole family
+	if _, err := svc.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected ErrTokenRevoked on reuse, got %v", err)
+	}
+	if _, err := svc.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected the rotated token to be revoked after reuse, got %v", err)
+	}
+
+	if _, err := svc.Refresh(ctx, second.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an access token to be refused, got %v", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	_, pair, err := svc.Register(ctx, "ada@example.com", "secret12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Logout(ctx, pair.RefreshToken); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if err := svc.Logout(ctx, pair.RefreshToken); err != nil {
+		t.Errorf("Expected a second logout to succeed, got %v", err)
+	}
+	if _, err := svc.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected ErrTokenRevoked after logout, got %v", err)
+	}
+}
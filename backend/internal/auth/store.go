@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RefreshToken records an issued refresh token
+type RefreshToken struct {
+	ID        string
+	UserID    int64
+	ExpiresAt time.Time
+}
+
+// Store persists users and refresh tokens
+type Store interface {
+	// CreateUser returns ErrEmailTaken if the email is in use, compared
+	// case-insensitively
+	CreateUser(ctx context.Context, email, passwordHash string, roles []string) (*User, error)
+	// UserByEmail and UserByID return ErrUserNotFound for unknown users
+	UserByEmail(ctx context.Context, email string) (*User, error)
+	UserByID(ctx context.Context, id int64) (*User, error)
+
+	SaveRefreshToken(ctx context.Context, token RefreshToken) error
+	// RevokeRefreshToken marks a token as used. It returns ErrTokenRevoked
+	// if the token was already revoked and ErrInvalidToken if it is unknown.
+	RevokeRefreshToken(ctx context.Context, id string) error
+	// RevokeUserTokens revokes every refresh token of a user
+	RevokeUserTokens(ctx context.Context, userID int64) error
+}
+
+// MemoryStore is an in-memory Store for tests and local development
+type MemoryStore struct {
+	mu      sync.Mutex
+	users   map[int64]*User
+	nextID  int64
+	tokens  map[string]*memoryToken
+	nowFunc func() time.Time
+}
+
+type memoryToken struct {
+	RefreshToken
+	revoked bool
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:   make(map[int64]*User),
+		nextID:  1,
+		tokens:  make(map[string]*memoryToken),
+		nowFunc: time.Now,
+	}
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, email, passwordHash string, roles []string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return nil, ErrEmailTaken
+		}
+	}
+
+	user := &User{
+		ID:           s.nextID,
+		Email:        email,
+		Roles:        append([]string(nil), roles...),
+		PasswordHash: passwordHash,
+		CreatedAt:    s.nowFunc(),
+	}
+	s.users[user.ID] = user
+	s.nextID++
+
+	copied := *user
+	return &copied, nil
+}
+
+func (s *MemoryStore) UserByEmail(ctx context.Context, email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (s *MemoryStore) UserByID(ctx context.Context, id int64) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (s *MemoryStore) SaveRefreshToken(ctx context.Context, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = &memoryToken{RefreshToken: token}
+	return nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrInvalidToken
+	}
+	if token.revoked {
+		return ErrTokenRevoked
+	}
+	token.revoked = true
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserTokens(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			token.revoked = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token types, stored in the "typ" claim so that a refresh token cannot be
+// used as an access token and vice versa
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// Claims are the JWT claims issued by the server. The subject holds the
+// user ID and the JWT ID identifies refresh tokens in the store.
+type Claims struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+	Type  string   `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the user ID from the subject claim
+func (c *Claims) UserID() (int64, error) {
+	return strconv.ParseInt(c.Subject, 10, 64)
+}
+
+// TokenPair is returned by login, registration and refresh
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int64     `json:"expires_in"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+
+	// refreshID is the JWT ID of the refresh token, recorded in the store
+	refreshID string
+}
+
+// TokenService signs and verifies tokens with HS256
+type TokenService struct {
+	secret     []byte
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// NewTokenService creates a token service. The secret must not be empty.
+func NewTokenService(secret, issuer string, accessTTL, refreshTTL time.Duration) (*TokenService, error) {
+	if secret == "" {
+		return nil, errors.New("auth: JWT secret must not be empty")
+	}
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return nil, errors.New("auth: token lifetimes must be positive")
+	}
+	return &TokenService{
+		secret:     []byte(secret),
+		issuer:     issuer,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		now:        time.Now,
+	}, nil
+}
+
+// Issue creates an access and a refresh token for user
+func (s *TokenService) Issue(user *User) (TokenPair, error) {
+	now := s.now()
+	pair := TokenPair{
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.accessTTL / time.Second),
+		AccessExpiresAt:  now.Add(s.accessTTL),
+		RefreshExpiresAt: now.Add(s.refreshTTL),
+		refreshID:        newTokenID(),
+	}
+
+	var err error
+	pair.AccessToken, err = s.sign(user, TokenTypeAccess, newTokenID(), now, pair.AccessExpiresAt)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	pair.RefreshToken, err = s.sign(user, TokenTypeRefresh, pair.refreshID, now, pair.RefreshExpiresAt)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return pair, nil
+}
+
+func (s *TokenService) sign(user *User, typ, id string, now, expires time.Time) (string, error) {
+	claims := Claims{
+		Email: user.Email,
+		Roles: user.Roles,
+		Type:  typ,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Issuer:    s.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// Parse verifies a token of the given type and returns its claims. Any
+// problem, including expiry, is reported as ErrInvalidToken.
+func (s *TokenService) Parse(token, typ string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(s.now),
+	)
+	if err != nil || claims.Type != typ {
+		return nil, ErrInvalidToken
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// newTokenID returns a random 128-bit JWT ID
+func newTokenID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("auth: crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestTokens(t *testing.T) *TokenService {
+	t.Helper()
+	tokens, err := NewTokenService("test-secret", "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	tokens := newTestTokens(t)
+	user := &User{ID: 42, Email: "ada@example.com", Roles: []string{RoleUser}}
+
+	pair, err := tokens.Issue(user)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if pair.TokenType != "Bearer" || pair.ExpiresIn != 60 {
+		t.Errorf("Unexpected token pair %+v", pair)
+	}
+
+	claims, err := tokens.Parse(pair.AccessToken, TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if id, _ := claims.UserID(); id != 42 {
+		t.Errorf("Expected user ID 42, got %d", id)
+	}
+	if claims.Email != user.Email || len(claims.Roles) != 1 {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	refresh, err := tokens.Parse(pair.RefreshToken, TokenTypeRefresh)
+	if err != nil {
+		t.Fatalf("Parse refresh failed: %v", err)
+	}
+	if refresh.ID != pair.refreshID {
+		t.Errorf("Expected refresh ID %s, got %s", pair.refreshID, refresh.ID)
+	}
+}
+
+func TestTokenRejected(t *testing.T) {
+	tokens := newTestTokens(t)
+	pair, err := tokens.Issue(&User{ID: 1, Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := NewTokenService("other-secret", "test", time.Minute, time.Hour)
+	otherIssuer, _ := NewTokenService("test-secret", "someone-else", time.Minute, time.Hour)
+	expired := newTestTokens(t)
+	expired.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	tests := []struct {
+		name    string
+		service *TokenService
+		token   string
+		typ     string
+	}{
+		{"refresh used as access", tokens, pair.RefreshToken, TokenTypeAccess},
+		{"access used as refresh", tokens, pair.AccessToken, TokenTypeRefresh},
+		{"wrong secret", other, pair.AccessToken, TokenTypeAccess},
+		{"wrong issuer", otherIssuer, pair.AccessToken, TokenTypeAccess},
+		{"expired", expired, pair.AccessToken, TokenTypeAccess},
+		{"garbage", tokens, "not.a.token", TokenTypeAccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.service.Parse(tt.token, tt.typ); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewTokenServiceValidation(t *testing.T) {
+	if _, err := NewTokenService("", "test", time.Minute, time.Hour); err == nil {
+		t.Error("Expected an error for an empty secret")
+	}
+	if _, err := NewTokenService("secret", "test", 0, time.Hour); err == nil {
+		t.Error("Expected an error for a zero lifetime")
+	}
+}
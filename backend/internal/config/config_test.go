@@ -1,13 +1,24 @@
 package config
 
 import (
+	"errors"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// noFiles keeps tests independent of .env files in the working directory
+var noFiles = Options{DotEnvFiles: []string{}}
+
 func TestLoad(t *testing.T) {
 	// Test default values
-	cfg := Load()
+	cfg, err := LoadWithOptions(noFiles)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
 
 	if cfg.Env != "development" {
 		t.Errorf("Expected default env to be 'development', got '%s'", cfg.Env)
@@ -20,22 +31,32 @@ func TestLoad(t *testing.T) {
 	if cfg.JWTSecret != "your-jwt-secret-key" {
 		t.Errorf("Expected default JWT secret to be 'your-jwt-secret-key', got '%s'", cfg.JWTSecret)
 	}
+
+	if len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "http://localhost:3000" {
+		t.Errorf("Expected default CORS origins [http://localhost:3000], got %v", cfg.CORSOrigins)
+	}
+
+	if cfg.ShutdownTimeout != 10*time.Second {
+		t.Errorf("Expected default shutdown timeout 10s, got %v", cfg.ShutdownTimeout)
+	}
+
+	if src := cfg.Source("PORT"); src.Kind != SourceDefault {
+		t.Errorf("Expected PORT to come from defaults, got %s", src)
+	}
 }
 
 func TestLoadWithEnvVars(t *testing.T) {
-	// Set environment variables
-	os.Setenv("ENV", "test")
-	os.Setenv("PORT", "9000")
-	os.Setenv("JWT_SECRET", "test-secret")
-
-	// Clean up after test
-	defer func() {
-		os.Unsetenv("ENV")
-		os.Unsetenv("PORT")
-		os.Unsetenv("JWT_SECRET")
-	}()
+	// Set environment variables, restored automatically after the test
+	t.Setenv("ENV", "test")
+	t.Setenv("PORT", "9000")
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("CORS_ORIGINS", "http://a.test, http://b.test")
+	t.Setenv("READ_TIMEOUT", "2m")
 
-	cfg := Load()
+	cfg, err := LoadWithOptions(noFiles)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
 
 	if cfg.Env != "test" {
 		t.Errorf("Expected env to be 'test', got '%s'", cfg.Env)
@@ -48,38 +69,325 @@ func TestLoadWithEnvVars(t *testing.T) {
 	if cfg.JWTSecret != "test-secret" {
 		t.Errorf("Expected JWT secret to be 'test-secret', got '%s'", cfg.JWTSecret)
 	}
+
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[1] != "http://b.test" {
+		t.Errorf("Expected two trimmed CORS origins, got %v", cfg.CORSOrigins)
+	}
+
+	if cfg.ReadTimeout != 2*time.Minute {
+		t.Errorf("Expected read timeout 2m, got %v", cfg.ReadTimeout)
+	}
+
+	if src := cfg.Source("PORT"); src.Kind != SourceEnv || src.Location != "PORT" {
+		t.Errorf("Expected PORT to come from env PORT, got %s", src)
+	}
+}
+
+func TestLoadLayerPrecedence(t *testing.T) {
+	dir := t.TempDir()
+
+	configFile := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, configFile, `
+port: 7000
+env: staging
+cors_origins:
+  - https://app.example.com
+  - https://admin.example.com
+shutdown_timeout: 30s
+`)
+
+	dotEnv := filepath.Join(dir, ".env")
+	writeTestFile(t, dotEnv, `
+# comment
+export ENV=dotenv
+JWT_SECRET="from \"dotenv\""
+SOMETHING_ELSE=ignored
+`)
+
+	t.Setenv("ENV", "from-env")
+
+	cfg, err := LoadWithOptions(Options{File: configFile, DotEnvFiles: []string{dotEnv}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		key    string
+		got    string
+		want   string
+		source SourceKind
+	}{
+		{"PORT", cfg.Port, "7000", SourceFile},
+		{"ENV", cfg.Env, "from-env", SourceEnv},
+		{"JWT_SECRET", cfg.JWTSecret, `from "dotenv"`, SourceDotEnv},
+		{"SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout.String(), "30s", SourceFile},
+		{"WRITE_TIMEOUT", cfg.WriteTimeout.String(), "15s", SourceDefault},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.key, tt.want, tt.got)
+		}
+		if src := cfg.Source(tt.key); src.Kind != tt.source {
+			t.Errorf("%s: expected source %s, got %s", tt.key, tt.source, src)
+		}
+	}
+
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[0] != "https://app.example.com" {
+		t.Errorf("Expected CORS origins from the YAML list, got %v", cfg.CORSOrigins)
+	}
 }
 
-func TestGetEnvAsInt(t *testing.T) {
-	// Test with valid integer
-	os.Setenv("TEST_INT", "42")
-	defer os.Unsetenv("TEST_INT")
+func TestLoadConfigFileFormats(t *testing.T) {
+	dir := t.TempDir()
 
-	result := getEnvAsInt("TEST_INT", 10)
-	if result != 42 {
-		t.Errorf("Expected 42, got %d", result)
+	files := map[string]string{
+		"config.json": `{"port": 7001, "cors_origins": ["https://json.test"]}`,
+		"config.toml": "port = 7001\ncors_origins = [\"https://json.test\"]\n",
+		"config.yml":  "port: 7001\ncors_origins: [https://json.test]\n",
 	}
 
-	// Test with fallback
-	result = getEnvAsInt("NON_EXISTENT", 10)
-	if result != 10 {
-		t.Errorf("Expected fallback value 10, got %d", result)
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			writeTestFile(t, path, content)
+
+			cfg, err := LoadWithOptions(Options{File: path, DotEnvFiles: []string{}})
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+
+			if cfg.Port != "7001" {
+				t.Errorf("Expected port 7001, got %s", cfg.Port)
+			}
+			if len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "https://json.test" {
+				t.Errorf("Unexpected CORS origins %v", cfg.CORSOrigins)
+			}
+		})
 	}
 }
 
-func TestGetEnvAsBool(t *testing.T) {
-	// Test with valid boolean
-	os.Setenv("TEST_BOOL", "true")
-	defer os.Unsetenv("TEST_BOOL")
+func TestLoadRejectsInvalidDatabaseURL(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://courseuser:hunter2@%zz/coursedb")
+	t.Setenv("PORT", "eighty")
+
+	_, err := LoadWithOptions(noFiles)
+
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected *config.Error, got %v", err)
+	}
+	keys := make(map[string]bool)
+	for _, p := range cfgErr.Problems {
+		keys[p.Key] = true
+	}
+	if !keys["DATABASE_URL"] || !keys["PORT"] {
+		t.Errorf("Expected problems for DATABASE_URL and PORT, got %v", err)
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected the database password to stay out of the report, got %v", err)
+	}
 
-	result := getEnvAsBool("TEST_BOOL", false)
-	if result != true {
-		t.Errorf("Expected true, got %v", result)
+	t.Setenv("DATABASE_URL", "postgres://courseuser:hunter2@db:5432/coursedb?sslmode=disable")
+	t.Setenv("PORT", "8080")
+	cfg, err := LoadWithOptions(noFiles)
+	if err != nil {
+		t.Fatalf("LoadWithOptions failed: %v", err)
+	}
+	if cfg.DatabaseURL.Host != "db:5432" || cfg.DatabaseURL.Query().Get("sslmode") != "disable" {
+		t.Errorf("Unexpected database URL %v", cfg.DatabaseURL.Redacted())
 	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	t.Setenv("PORT", "eighty")
+	t.Setenv("READ_TIMEOUT", "10")
+	t.Setenv("MAX_HEADER_BYTES", "lots")
+	t.Setenv("DATABASE_URL", "mysql://localhost/db")
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("LOG_SAMPLE_RATE", "1.5")
+
+	_, err := LoadWithOptions(noFiles)
+
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected *config.Error, got %v", err)
+	}
+
+	keys := make(map[string]bool)
+	for _, p := range cfgErr.Problems {
+		keys[p.Key] = true
+	}
+
+	for _, key := range []string{"PORT", "READ_TIMEOUT", "MAX_HEADER_BYTES", "DATABASE_URL", "LOG_LEVEL", "LOG_SAMPLE_RATE"} {
+		if !keys[key] {
+			t.Errorf("Expected a problem for %s in %v", key, err)
+		}
+	}
+
+	if !strings.Contains(err.Error(), "env READ_TIMEOUT") {
+		t.Errorf("Expected the report to name the source, got %v", err)
+	}
+}
+
+func TestLoadUnknownFileKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeTestFile(t, path, `{"prot": "8081"}`)
+
+	if _, err := LoadWithOptions(Options{File: path, DotEnvFiles: []string{}}); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+}
+
+func TestValidateProductionSecret(t *testing.T) {
+	t.Setenv("ENV", "production")
+
+	_, err := LoadWithOptions(noFiles)
+	if err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Fatalf("Expected production to refuse the placeholder secret, got %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "a-real-secret-from-the-vault")
+	cfg, err := LoadWithOptions(noFiles)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	t.Setenv("CORS_ORIGINS", "*")
+
+	_, err := LoadWithOptions(noFiles)
+	if err == nil || !strings.Contains(err.Error(), "CORS_ORIGINS") {
+		t.Fatalf("Expected credentials with any origin to be refused, got %v", err)
+	}
+
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "false")
+	t.Setenv("CORS_MAX_AGE", "1h")
+	cfg, err := LoadWithOptions(noFiles)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if opts := cfg.CORSOptions(); opts.AllowCredentials || opts.MaxAge != time.Hour {
+		t.Errorf("Unexpected CORS options %+v", opts)
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	t.Setenv("JWT_ACCESS_TTL", "1h")
+	t.Setenv("JWT_REFRESH_TTL", "30m")
+	t.Setenv("BCRYPT_COST", "3")
+
+	_, err := LoadWithOptions(noFiles)
+	if err == nil {
+		t.Fatal("Expected invalid auth settings to be refused")
+	}
+	for _, key := range []string{"JWT_REFRESH_TTL", "BCRYPT_COST"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected %s to be reported, got %v", key, err)
+		}
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "lots")
+	t.Setenv("RATE_LIMIT_ALGORITHM", "leaky_bucket")
+
+	_, err := LoadWithOptions(noFiles)
+	if err == nil {
+		t.Fatal("Expected invalid rate limits to be refused")
+	}
+	for _, key := range []string{"RATE_LIMIT", "RATE_LIMIT_ALGORITHM"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected %s to be reported, got %v", key, err)
+		}
+	}
+
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	if _, err := LoadWithOptions(noFiles); err != nil {
+		t.Errorf("Expected disabled rate limits to be ignored, got %v", err)
+	}
+}
+
+func TestTypedFields(t *testing.T) {
+	var dst struct {
+		Count    int           `env:"TEST_INT" default:"10"`
+		Enabled  bool          `env:"TEST_BOOL" default:"false"`
+		Ratio    float64       `env:"TEST_RATIO" default:"0.5"`
+		Wait     time.Duration `env:"TEST_WAIT" default:"1s"`
+		Endpoint *url.URL      `env:"TEST_URL"`
+		Tags     []string      `env:"TEST_TAGS"`
+		Needed   string        `env:"TEST_NEEDED" required:"true"`
+	}
+
+	t.Setenv("TEST_INT", "42")
+	t.Setenv("TEST_BOOL", "true")
+	t.Setenv("TEST_URL", "redis://localhost:6379/0")
+	t.Setenv("TEST_TAGS", "a,b")
+
+	_, problems := load(&dst, noFiles)
+	if len(problems) != 1 || problems[0].Key != "TEST_NEEDED" {
+		t.Fatalf("Expected only TEST_NEEDED to be reported, got %v", problems)
+	}
+
+	if dst.Count != 42 || !dst.Enabled || dst.Ratio != 0.5 || dst.Wait != time.Second {
+		t.Errorf("Unexpected scalar values: %+v", dst)
+	}
+
+	if dst.Endpoint == nil || dst.Endpoint.Host != "localhost:6379" {
+		t.Errorf("Unexpected URL: %v", dst.Endpoint)
+	}
+
+	if len(dst.Tags) != 2 {
+		t.Errorf("Expected 2 tags, got %v", dst.Tags)
+	}
+
+	t.Setenv("TEST_URL", "not a url")
+	t.Setenv("TEST_NEEDED", "here")
+	if _, problems := load(&dst, noFiles); len(problems) != 1 || problems[0].Key != "TEST_URL" {
+		t.Errorf("Expected an invalid URL problem, got %v", problems)
+	}
+}
+
+func TestSettingsMasksSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "super-secret")
+
+	cfg, err := LoadWithOptions(noFiles)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for _, s := range cfg.Settings() {
+		if strings.Contains(s.Value, "super-secret") || strings.Contains(s.Value, "coursepass") {
+			t.Errorf("Secret leaked in setting %s=%s", s.Key, s.Value)
+		}
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	values, err := parseDotEnv("A=1\nB = two # comment\nC='single # kept'\nD=\"line\\nbreak\"\n")
+	if err != nil {
+		t.Fatalf("parseDotEnv failed: %v", err)
+	}
+
+	expected := map[string]string{"A": "1", "B": "two", "C": "single # kept", "D": "line\nbreak"}
+	for key, want := range expected {
+		if values[key] != want {
+			t.Errorf("%s: expected %q, got %q", key, want, values[key])
+		}
+	}
+
+	if _, err := parseDotEnv("NOT A PAIR"); err == nil {
+		t.Error("Expected error for a line without '='")
+	}
+}
 
-	// Test with fallback
-	result = getEnvAsBool("NON_EXISTENT", false)
-	if result != false {
-		t.Errorf("Expected fallback value false, got %v", result)
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
 	}
 }
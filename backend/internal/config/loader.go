@@ -0,0 +1,450 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Options controls where Load looks for configuration
+type Options struct {
+	// File is an optional YAML, TOML or JSON file. When empty, the path in
+	// the CONFIG_FILE environment variable is used, if set.
+	File string
+
+	// DotEnvFiles are read in order, later files overriding earlier ones.
+	// Missing files are skipped. Defaults to .env and .env.local.
+	DotEnvFiles []string
+}
+
+// SourceKind names a configuration layer
+type SourceKind string
+
+// Configuration layers, from lowest to highest precedence
+const (
+	SourceDefault SourceKind = "default"
+	SourceFile    SourceKind = "file"
+	SourceDotEnv  SourceKind = "dotenv"
+	SourceEnv     SourceKind = "env"
+)
+
+// Source tells where a configuration value came from
+type Source struct {
+	Kind SourceKind
+	// Location is the file path for file and dotenv values and the variable
+	// name for environment values
+	Location string
+}
+
+// String returns a human readable description such as "env PORT"
+func (s Source) String() string {
+	if s.Kind == "" {
+		return "unset"
+	}
+	if s.Location == "" {
+		return string(s.Kind)
+	}
+	return string(s.Kind) + " " + s.Location
+}
+
+// Problem describes one missing or malformed configuration value
+type Problem struct {
+	Key     string
+	Source  Source
+	Message string
+}
+
+// Error reports every configuration problem found while loading
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problem(s)):", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  - %s", p.Key)
+		if p.Source.Kind != "" {
+			fmt.Fprintf(&b, " (from %s)", p.Source)
+		}
+		fmt.Fprintf(&b, ": %s", p.Message)
+	}
+	return b.String()
+}
+
+// Setting is a loaded value together with its origin, for display
+type Setting struct {
+	Key    string
+	Value  string
+	Source Source
+}
+
+// Settings lists every configuration value with its source. Values of
+// fields tagged secret:"true" are masked.
+func (c *Config) Settings() []Setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var settings []Setting
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, ok := sf.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		value := fmt.Sprint(v.Field(i).Interface())
+		if stringer, ok := v.Field(i).Addr().Interface().(fmt.Stringer); ok {
+			value = stringer.String()
+		}
+		if sf.Tag.Get("secret") == "true" && value != "" {
+			value = "********"
+		}
+		settings = append(settings, Setting{Key: key, Value: value, Source: c.sources[key]})
+	}
+	return settings
+}
+
+// rawValue is an unparsed value from one of the layers. Lists from
+// structured files keep their elements, everything else is a string.
+type rawValue struct {
+	str    string
+	list   []string
+	isList bool
+	source Source
+}
+
+// field binds a struct field to its environment variable name
+type field struct {
+	key      string
+	value    reflect.Value
+	def      string
+	hasDef   bool
+	required bool
+	// secret values are left out of problems
+	secret bool
+}
+
+// load fills dst from all layers and returns the source of every value
+func load(dst interface{}, opts Options) (map[string]Source, []Problem) {
+	fields := fieldsOf(dst)
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f.key] = true
+	}
+
+	var problems []Problem
+	values := make(map[string]rawValue)
+
+	// Defaults
+	for _, f := range fields {
+		if f.hasDef {
+			values[f.key] = rawValue{str: f.def, source: Source{Kind: SourceDefault}}
+		}
+	}
+
+	// Config file
+	file := opts.File
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file != "" {
+		fileValues, err := readConfigFile(file)
+		if err != nil {
+			problems = append(problems, Problem{
+				Key:     "CONFIG_FILE",
+				Source:  Source{Kind: SourceFile, Location: file},
+				Message: err.Error(),
+			})
+		}
+		for key, v := range fileValues {
+			if !known[key] {
+				problems = append(problems, Problem{Key: key, Source: v.source, Message: "unknown setting"})
+				continue
+			}
+			values[key] = v
+		}
+	}
+
+	// .env files
+	dotEnvFiles := opts.DotEnvFiles
+	if dotEnvFiles == nil {
+		dotEnvFiles = []string{".env", ".env.local"}
+	}
+	for _, path := range dotEnvFiles {
+		envValues, err := readDotEnv(path)
+		if err != nil {
+			problems = append(problems, Problem{
+				Key:     path,
+				Source:  Source{Kind: SourceDotEnv, Location: path},
+				Message: err.Error(),
+			})
+			continue
+		}
+		// Unknown keys are fine here, .env files are shared with other tools
+		for key, v := range envValues {
+			if known[key] {
+				values[key] = v
+			}
+		}
+	}
+
+	// Environment variables
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.key); ok {
+			values[f.key] = rawValue{str: v, source: Source{Kind: SourceEnv, Location: f.key}}
+		}
+	}
+
+	sources := make(map[string]Source, len(fields))
+	for _, f := range fields {
+		v, ok := values[f.key]
+		if !ok || (!v.isList && v.str == "" && f.required) {
+			if f.required {
+				problems = append(problems, Problem{Key: f.key, Source: v.source, Message: "is required"})
+			}
+			continue
+		}
+
+		sources[f.key] = v.source
+		if err := setField(f.value, v); err != nil {
+			message := err.Error()
+			if f.secret {
+				// Parse errors quote the value
+				message = "is invalid (the value is secret and not shown)"
+			}
+			problems = append(problems, Problem{Key: f.key, Source: v.source, Message: message})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Key < problems[j].Key
+	})
+	return sources, problems
+}
+
+// fieldsOf lists the tagged fields of the struct dst points to
+func fieldsOf(dst interface{}) []field {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, ok := sf.Tag.Lookup("env")
+		if !ok || !sf.IsExported() {
+			continue
+		}
+		def, hasDef := sf.Tag.Lookup("default")
+		fields = append(fields, field{
+			key:      key,
+			value:    v.Field(i),
+			def:      def,
+			hasDef:   hasDef,
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	urlType      = reflect.TypeOf(url.URL{})
+)
+
+// setField parses raw into the field, based on the field's type
+func setField(v reflect.Value, raw rawValue) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		items := raw.list
+		if !raw.isList {
+			items = splitList(raw.str)
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	if raw.isList {
+		return errors.New("expected a single value, got a list")
+	}
+	s := strings.TrimSpace(raw.str)
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (use e.g. 30s, 5m, 1h)", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case v.Type() == urlType, v.Kind() == reflect.Ptr && v.Type().Elem() == urlType:
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid URL %q", s)
+		}
+		if v.Kind() == reflect.Ptr {
+			v.Set(reflect.ValueOf(u))
+		} else {
+			v.Set(reflect.ValueOf(*u))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw.str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated value, dropping surrounding spaces
+func splitList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return []string{}
+	}
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return parts
+}
+
+// readConfigFile decodes a flat YAML, TOML or JSON document. Keys are
+// matched case-insensitively against the environment variable names.
+func readConfigFile(path string) (map[string]rawValue, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &doc)
+	case ".toml":
+		err = toml.Unmarshal(content, &doc)
+	case ".json":
+		err = json.Unmarshal(content, &doc)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q (use .yaml, .toml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+
+	source := Source{Kind: SourceFile, Location: path}
+	values := make(map[string]rawValue, len(doc))
+	for key, value := range doc {
+		raw := rawValue{source: source}
+		switch v := value.(type) {
+		case []interface{}:
+			raw.isList = true
+			raw.list = make([]string, len(v))
+			for i, item := range v {
+				raw.list[i] = fmt.Sprint(item)
+			}
+		case map[string]interface{}:
+			return nil, fmt.Errorf("nested tables are not supported (key %q)", key)
+		case nil:
+			raw.str = ""
+		default:
+			raw.str = fmt.Sprint(v)
+		}
+		values[strings.ToUpper(key)] = raw
+	}
+	return values, nil
+}
+
+// readDotEnv parses a .env file. A missing file yields no values.
+func readDotEnv(path string) (map[string]rawValue, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := parseDotEnv(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	source := Source{Kind: SourceDotEnv, Location: path}
+	values := make(map[string]rawValue, len(pairs))
+	for key, value := range pairs {
+		values[key] = rawValue{str: value, source: source}
+	}
+	return values, nil
+}
+
+// parseDotEnv understands KEY=value lines with optional "export" prefixes,
+// comments and single or double quoted values
+func parseDotEnv(content string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n+1)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && strings.LastIndexByte(value, '"') > 0:
+			unquoted, err := strconv.Unquote(value[:strings.LastIndexByte(value, '"')+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted value", n+1)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && strings.LastIndexByte(value, '\'') > 0:
+			value = value[1:strings.LastIndexByte(value, '\'')]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/logging"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem/ginproblem"
+)
+
+// AuthHandler serves the /api/v1/auth endpoints
+type AuthHandler struct {
+	service *auth.Service
+}
+
+// NewAuthHandler creates the authentication handlers
+func NewAuthHandler(service *auth.Service) *AuthHandler {
+	return &AuthHandler{service: service}
+}
+
+type credentialsRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type authResponse struct {
+	User *auth.User `json:"user"`
+	auth.TokenPair
+}
+
+// Register creates an account and returns its first token pair
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ginproblem.Abort(c, problem.Validation("email and password are required", bindingErrors(err, &req)...))
+		return
+	}
+
+	user, pair, err := h.service.Register(c.Request.Context(), req.Email, req.Password)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, authResponse{User: user, TokenPair: pair})
+}
+
+// Login exchanges credentials for a token pair
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ginproblem.Abort(c, problem.Validation("email and password are required", bindingErrors(err, &req)...))
+		return
+	}
+
+	user, pair, err := h.service.Login(c.Request.Context(), req.Email, req.Password)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, authResponse{User: user, TokenPair: pair})
+}
+
+// Refresh exchanges a refresh token for a new pair
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ginproblem.Abort(c, problem.Validation("refresh_token is required", bindingErrors(err, &req)...))
+		return
+	}
+
+	pair, err := h.service.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pair)
+}
+
+// Logout revokes a refresh token
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ginproblem.Abort(c, problem.Validation("refresh_token is required", bindingErrors(err, &req)...))
+		return
+	}
+
+	if err := h.service.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Me returns the authenticated user. It must run behind
+// middleware.Authenticate.
+func (h *AuthHandler) Me(c *gin.Context) {
+	principal, ok := auth.FromContext(c.Request.Context())
+	if !ok {
+		ginproblem.Abort(c, problem.Unauthorized("authentication required"))
+		return
+	}
+
+	user, err := h.service.User(c.Request.Context(), principal.UserID)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// GetUser returns any user by ID, for administrators
+func (h *AuthHandler) GetUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		ginproblem.Abort(c, problem.Validation("invalid user ID", problem.FieldError{In: "path", Field: "id", Message: "must be an integer"}))
+		return
+	}
+
+	user, err := h.service.User(c.Request.Context(), id)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// fail maps service errors to problem details. Validation errors from
+// the password policy are reported as they are.
+func (h *AuthHandler) fail(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrEmailTaken):
+		ginproblem.Abort(c, problem.Conflict(err.Error()))
+	case errors.Is(err, auth.ErrInvalidCredentials),
+		errors.Is(err, auth.ErrInvalidToken),
+		errors.Is(err, auth.ErrTokenRevoked):
+		ginproblem.Abort(c, problem.Unauthorized(err.Error()))
+	case errors.Is(err, auth.ErrUserNotFound):
+		ginproblem.Abort(c, problem.NotFound(err.Error()))
+	case errors.Is(err, auth.ErrWeakPassword):
+		ginproblem.Abort(c, problem.Validation(err.Error(), problem.FieldError{In: "body", Field: "password", Message: err.Error()}))
+	default:
+		logging.FromContext(c.Request.Context()).Error("auth request failed", "error", err)
+		ginproblem.Abort(c, problem.Internal("internal server error", err))
+	}
+}
+
+// bindingErrors lists the fields of req rejected by the binding, nothing
+// if the body could not be decoded at all
+func bindingErrors(err error, req interface{}) []problem.FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	t := reflect.TypeOf(req).Elem()
+	fields := make([]problem.FieldError, len(errs))
+	for i, e := range errs {
+		// The binding reports Go names, clients know the JSON ones
+		name := e.Field()
+		if f, ok := t.FieldByName(e.StructField()); ok {
+			name, _, _ = strings.Cut(f.Tag.Get("json"), ",")
+		}
+		message := "is invalid"
+		switch e.Tag() {
+		case "required":
+			message = "is required"
+		case "email":
+			message = "must be an email address"
+		}
+		fields[i] = problem.FieldError{In: "body", Field: name, Message: message}
+	}
+	return fields
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/middleware"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	tokens, err := auth.NewTokenService("test-secret", "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAuthHandler(auth.NewService(auth.NewMemoryStore(), tokens, bcrypt.MinCost))
+
+	router := gin.New()
+	group := router.Group("/api/v1/auth")
+	group.POST("/register", h.Register)
+	group.POST("/login", h.Login)
+	group.POST("/refresh", h.Refresh)
+	group.POST("/logout", h.Logout)
+	group.GET("/me", middleware.Authenticate(tokens), h.Me)
+	return router
+}
+
+func postJSON(router *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthFlow(t *testing.T) {
+	router := newAuthRouter(t)
+	credentials := map[string]string{"email": "ada@example.com", "password": "secret12"}
+
+	rr := postJSON(router, "/api/v1/auth/register", credentials)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body)
+	}
+	if strings.Contains(rr.Body.String(), "$2a$") {
+		t.Error("Expected the password hash to stay out of the response")
+	}
+
+	if rr := postJSON(router, "/api/v1/auth/register", credentials); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate email, got %d", rr.Code)
+	}
+
+	rr = postJSON(router, "/api/v1/auth/login", credentials)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var login struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		User         struct {
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+	if login.User.Email != "ada@example.com" {
+		t.Errorf("Expected the user in the response, got %q", login.User.Email)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "ada@example.com") {
+		t.Errorf("Expected /me to return the user, got %d: %s", rr.Code, rr.Body)
+	}
+
+	refresh := map[string]string{"refresh_token": login.RefreshToken}
+	if rr := postJSON(router, "/api/v1/auth/refresh", refresh); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for refresh, got %d", rr.Code)
+	}
+	if rr := postJSON(router, "/api/v1/auth/logout", refresh); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 for logout, got %d", rr.Code)
+	}
+	if rr := postJSON(router, "/api/v1/auth/refresh", refresh); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a used refresh token, got %d", rr.Code)
+	}
+}
+
+func TestAuthBadRequests(t *testing.T) {
+	router := newAuthRouter(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       interface{}
+		wantStatus int
+	}{
+		{"missing password", "/api/v1/auth/register", map[string]string{"email": "ada@example.com"}, http.StatusBadRequest},
+		{"invalid email", "/api/v1/auth/register", map[string]string{"email": "ada", "password": "secret12"}, http.StatusBadRequest},
+		{"weak password", "/api/v1/auth/register", map[string]string{"email": "ada@example.com", "password": "short"}, http.StatusBadRequest},
+		{"unknown user", "/api/v1/auth/login", map[string]string{"email": "bob@example.com", "password": "secret12"}, http.StatusUnauthorized},
+		{"missing token", "/api/v1/auth/refresh", map[string]string{}, http.StatusBadRequest},
+		{"garbage token", "/api/v1/auth/logout", map[string]string{"refresh_token": "x"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := postJSON(router, tt.path, tt.body); rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body)
+			}
+		})
+	}
+}
+
+func TestAuthProblemDetails(t *testing.T) {
+	router := newAuthRouter(t)
+
+	rr := postJSON(router, "/api/v1/auth/register", map[string]string{"email": "ada"})
+	if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("Expected Content-Type %s, got %s", problem.ContentType, ct)
+	}
+	var p problem.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	if p.Type != problem.KindValidation.Type() || p.Status != http.StatusBadRequest || p.Instance != "/api/v1/auth/register" {
+		t.Errorf("Unexpected problem %+v", p)
+	}
+	want := []problem.FieldError{
+		{In: "body", Field: "email", Message: "must be an email address"},
+		{In: "body", Field: "password", Message: "is required"},
+	}
+	if len(p.Errors) != len(want) {
+		t.Fatalf("Expected errors %v, got %v", want, p.Errors)
+	}
+	for i := range want {
+		if p.Errors[i] != want[i] {
+			t.Errorf("Expected error %v, got %v", want[i], p.Errors[i])
+		}
+	}
+
+	// Service errors keep their stable type
+	postJSON(router, "/api/v1/auth/register", map[string]string{"email": "ada@example.com", "password": "secret12"})
+	rr = postJSON(router, "/api/v1/auth/register", map[string]string{"email": "ada@example.com", "password": "secret12"})
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if rr.Code != http.StatusConflict || p.Type != problem.KindConflict.Type() {
+		t.Errorf("Expected a conflict problem, got %d %s", rr.Code, rr.Body)
+	}
+}
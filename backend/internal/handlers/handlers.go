@@ -1,20 +1,64 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/buildinfo"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/health"
 )
 
+const serviceName = "sum25-go-flutter-course-backend"
+
+// startTime is used to report the process uptime
+var startTime = time.Now()
+
 // HealthCheck returns server health status
 func HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "healthy",
-		"service": "sum25-go-flutter-course-backend",
-		"version": "1.0.0",
+		"service": serviceName,
+		"build":   buildinfo.Get(),
+	})
+}
+
+// Livez reports whether the process is alive. It never checks
+// dependencies, so an outage of the database does not get the server
+// restarted.
+func Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":         health.StatusUp,
+		"service":        serviceName,
+		"uptime_seconds": int64(time.Since(startTime).Seconds()),
+		"build":          buildinfo.Get(),
 	})
 }
 
+// Readyz reports whether the server can take traffic. It answers 503 when
+// a critical check fails.
+func Readyz(registry *health.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The report is cached and shared, so a client hanging up must not
+		// cancel the checks
+		report := registry.Run(context.WithoutCancel(c.Request.Context()))
+
+		status := http.StatusOK
+		if !report.Healthy() {
+			status = http.StatusServiceUnavailable
+		}
+
+		c.JSON(status, gin.H{
+			"status":     report.Status,
+			"service":    serviceName,
+			"checked_at": report.CheckedAt,
+			"checks":     report.Checks,
+			"build":      buildinfo.Get(),
+		})
+	}
+}
+
 // Ping returns a simple pong response
 func Ping(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
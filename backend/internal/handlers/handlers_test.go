@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/health"
+)
+
+func TestReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		check      health.CheckFunc
+		wantStatus int
+		wantBody   health.Status
+	}{
+		{"ready", func(ctx context.Context) error { return nil }, http.StatusOK, health.StatusUp},
+		{"unready", func(ctx context.Context) error { return errors.New("down") }, http.StatusServiceUnavailable, health.StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := health.NewRegistry(0)
+			if err := registry.Register(health.Check{Name: "postgres", Func: tt.check}); err != nil {
+				t.Fatal(err)
+			}
+
+			router := gin.New()
+			router.GET("/readyz", Readyz(registry))
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+
+			var body struct {
+				Status health.Status   `json:"status"`
+				Checks []health.Result `json:"checks"`
+				Build  map[string]string
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("Could not decode response: %v", err)
+			}
+			if body.Status != tt.wantBody {
+				t.Errorf("Expected status %s, got %s", tt.wantBody, body.Status)
+			}
+			if len(body.Checks) != 1 || body.Checks[0].Name != "postgres" {
+				t.Errorf("Expected the postgres check in the response, got %+v", body.Checks)
+			}
+			if body.Build["go_version"] == "" {
+				t.Error("Expected build info in the response")
+			}
+		})
+	}
+}
+
+func TestLivez(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/livez", Livez)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/livez", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package health
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Pinger is implemented by *sql.DB and *sql.Conn
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingCheck checks a database connection
+func PingCheck(db Pinger) CheckFunc {
+	return db.PingContext
+}
+
+// RedisCheck sends PING to a Redis server
+func RedisCheck(client redis.UniversalClient) CheckFunc {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
+
+// DiskSpaceCheck fails when the file system holding path has less than
+// minFreeBytes available
+func DiskSpaceCheck(path string, minFreeBytes uint64) CheckFunc {
+	return func(ctx context.Context) error {
+		free, err := freeBytes(path)
+		if err != nil {
+			return err
+		}
+		if free < minFreeBytes {
+			return fmt.Errorf("only %d MiB free on %s, need %d MiB", free>>20, path, minFreeBytes>>20)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,223 @@
+// Package health runs dependency checks for the readiness endpoint.
+//
+// Checks are registered once at startup with a timeout and a criticality.
+// A failing critical check makes the service unready, a failing optional
+// check only marks it as degraded.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is used for checks registered without a timeout
+const DefaultTimeout = 2 * time.Second
+
+// Status of a single check or of the whole service
+type Status string
+
+const (
+	StatusUp       Status = "up"
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+)
+
+// Criticality decides how a failing check affects readiness
+type Criticality int
+
+const (
+	// Critical checks make the service unready when they fail
+	Critical Criticality = iota
+	// Optional checks only degrade the service when they fail
+	Optional
+)
+
+// String returns "critical" or "optional"
+func (c Criticality) String() string {
+	if c == Optional {
+		return "optional"
+	}
+	return "critical"
+}
+
+// MarshalText encodes the criticality as its name
+func (c Criticality) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText decodes a name written by MarshalText
+func (c *Criticality) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "critical":
+		*c = Critical
+	case "optional":
+		*c = Optional
+	default:
+		return fmt.Errorf("health: unknown criticality %q", text)
+	}
+	return nil
+}
+
+// CheckFunc reports a problem by returning an error. It must respect ctx
+// cancellation.
+type CheckFunc func(ctx context.Context) error
+
+// Check is a named dependency check
+type Check struct {
+	Name        string
+	Func        CheckFunc
+	Timeout     time.Duration
+	Criticality Criticality
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name        string        `json:"name"`
+	Status      Status        `json:"status"`
+	Criticality Criticality   `json:"criticality"`
+	Latency     time.Duration `json:"-"`
+	LatencyMs   float64       `json:"latency_ms"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Status    Status    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Healthy reports whether the service can take traffic, i.e. no critical
+// check failed
+func (r Report) Healthy() bool {
+	return r.Status != StatusDown
+}
+
+// Registry holds the registered checks and caches their last results
+type Registry struct {
+	cacheTTL time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	checks []Check
+	last   *Report
+}
+
+// NewRegistry creates an empty registry. Reports are reused for cacheTTL
+// so that frequent probes do not hammer the dependencies.
+func NewRegistry(cacheTTL time.Duration) *Registry {
+	return &Registry{cacheTTL: cacheTTL, now: time.Now}
+}
+
+// Register adds a check. Names must be unique.
+func (r *Registry) Register(check Check) error {
+	if check.Name == "" {
+		return errors.New("health: check name is required")
+	}
+	if check.Func == nil {
+		return fmt.Errorf("health: check %q has no function", check.Name)
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.checks {
+		if c.Name == check.Name {
+			return fmt.Errorf("health: check %q already registered", check.Name)
+		}
+	}
+	r.checks = append(r.checks, check)
+	r.last = nil
+	return nil
+}
+
+// Run executes all checks concurrently, or returns the cached report if it
+// is recent enough. Concurrent callers wait for a single run.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last != nil && r.now().Sub(r.last.CheckedAt) < r.cacheTTL {
+		return *r.last
+	}
+
+	report := Report{
+		Status:    StatusUp,
+		CheckedAt: r.now(),
+		Checks:    make([]Result, len(r.checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range r.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == StatusUp {
+			continue
+		}
+		if result.Criticality == Critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusUp {
+			report.Status = StatusDegraded
+		}
+	}
+
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+
+	r.last = &report
+	return report
+}
+
+// runCheck runs one check with its timeout and turns panics into failures
+func runCheck(ctx context.Context, check Check) (result Result) {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	result = Result{Name: check.Name, Criticality: check.Criticality}
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		done <- check.Func(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", check.Timeout)
+		}
+	}
+
+	result.Latency = time.Since(start)
+	result.LatencyMs = float64(result.Latency.Microseconds()) / 1000
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	} else {
+		result.Status = StatusUp
+	}
+	return result
+}
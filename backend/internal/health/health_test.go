@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func ok(ctx context.Context) error { return nil }
+
+func fail(ctx context.Context) error { return errors.New("connection refused") }
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry(0)
+
+	if err := r.Register(Check{Name: "db", Func: ok}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := r.Register(Check{Name: "db", Func: ok}); err == nil {
+		t.Error("Expected duplicate names to be rejected")
+	}
+	if err := r.Register(Check{Func: ok}); err == nil {
+		t.Error("Expected a missing name to be rejected")
+	}
+	if err := r.Register(Check{Name: "nil"}); err == nil {
+		t.Error("Expected a missing function to be rejected")
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []Check
+		want   Status
+	}{
+		{"all up", []Check{{Name: "a", Func: ok}, {Name: "b", Func: ok, Criticality: Optional}}, StatusUp},
+		{"optional down", []Check{{Name: "a", Func: ok}, {Name: "b", Func: fail, Criticality: Optional}}, StatusDegraded},
+		{"critical down", []Check{{Name: "a", Func: fail}, {Name: "b", Func: fail, Criticality: Optional}}, StatusDown},
+		{"no checks", nil, StatusUp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(0)
+			for _, c := range tt.checks {
+				if err := r.Register(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report := r.Run(context.Background())
+			if report.Status != tt.want {
+				t.Errorf("Expected status %s, got %s", tt.want, report.Status)
+			}
+			if report.Healthy() != (tt.want != StatusDown) {
+				t.Errorf("Unexpected Healthy() for status %s", report.Status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("Expected %d results, got %d", len(tt.checks), len(report.Checks))
+			}
+		})
+	}
+}
+
+func TestRunResultDetails(t *testing.T) {
+	r := NewRegistry(0)
+	r.Register(Check{Name: "slow", Func: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, Timeout: 20 * time.Millisecond})
+	r.Register(Check{Name: "panics", Func: func(ctx context.Context) error {
+		panic("boom")
+	}, Criticality: Optional})
+	r.Register(Check{Name: "fine", Func: ok})
+
+	report := r.Run(context.Background())
+
+	byName := make(map[string]Result)
+	for _, res := range report.Checks {
+		byName[res.Name] = res
+	}
+
+	if res := byName["slow"]; res.Status != StatusDown || !strings.Contains(res.Error, "timed out") {
+		t.Errorf("Expected the slow check to time out, got %+v", res)
+	}
+	if res := byName["slow"]; res.Latency < 20*time.Millisecond || res.LatencyMs < 20 {
+		t.Errorf("Expected the latency to be recorded, got %v", res.Latency)
+	}
+	if res := byName["panics"]; res.Status != StatusDown || !strings.Contains(res.Error, "boom") {
+		t.Errorf("Expected the panic to be reported, got %+v", res)
+	}
+	if res := byName["fine"]; res.Status != StatusUp || res.Error != "" {
+		t.Errorf("Expected the fine check to pass, got %+v", res)
+	}
+
+	if report.Checks[0].Name != "fine" {
+		t.Errorf("Expected results sorted by name, got %s first", report.Checks[0].Name)
+	}
+}
+
+func TestRunCachesReport(t *testing.T) {
+	var calls int32
+	r := NewRegistry(time.Minute)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	r.Register(Check{Name: "counted", Func: func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}})
+
+	r.Run(context.Background())
+	r.Run(context.Background())
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Expected a cached report, check ran %d times", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	r.Run(context.Background())
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Expected the check to run again after the TTL, ran %d times", got)
+	}
+}
+
+func TestDiskSpaceCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := DiskSpaceCheck(dir, 0)(context.Background()); err != nil {
+		t.Skipf("disk space not available here: %v", err)
+	}
+
+	if err := DiskSpaceCheck(dir, 1<<62)(context.Background()); err == nil {
+		t.Error("Expected an error when asking for more space than exists")
+	}
+}
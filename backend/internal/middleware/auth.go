@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem/ginproblem"
+)
+
+// Gin context keys set by Authenticate
+const (
+	UserIDKey    = "user_id"
+	UserEmailKey = "user_email"
+	UserRolesKey = "user_roles"
+)
+
+// Authenticate requires a valid access token in the Authorization header.
+// The caller's ID, email and roles are stored in the gin context and the
+// auth principal in the request context.
+func Authenticate(tokens *auth.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer`)
+			ginproblem.Abort(c, problem.Unauthorized("missing bearer token"))
+			return
+		}
+
+		claims, err := tokens.Parse(token, auth.TokenTypeAccess)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			ginproblem.Abort(c, problem.Unauthorized(err.Error()))
+			return
+		}
+
+		userID, _ := claims.UserID()
+		principal := auth.Principal{UserID: userID, Email: claims.Email, Roles: claims.Roles}
+
+		c.Set(UserIDKey, principal.UserID)
+		c.Set(UserEmailKey, principal.Email)
+		c.Set(UserRolesKey, principal.Roles)
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+
+		c.Next()
+	}
+}
+
+// RequireRoles lets a request through if the caller has at least one of
+// roles. It must run after Authenticate.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentUser(c)
+		if !ok {
+			ginproblem.Abort(c, problem.Unauthorized("authentication required"))
+			return
+		}
+		if !principal.HasAnyRole(roles...) {
+			ginproblem.Abort(c, problem.Forbidden("insufficient role"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CurrentUser returns the caller authenticated by Authenticate
+func CurrentUser(c *gin.Context) (auth.Principal, bool) {
+	return auth.FromContext(c.Request.Context())
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
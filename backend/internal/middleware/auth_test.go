@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+)
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tokens, err := auth.NewTokenService("test-secret", "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPair, _ := tokens.Issue(&auth.User{ID: 7, Email: "ada@example.com", Roles: []string{auth.RoleUser}})
+	adminPair, _ := tokens.Issue(&auth.User{ID: 1, Email: "root@example.com", Roles: []string{auth.RoleAdmin}})
+
+	router := gin.New()
+	router.GET("/me", Authenticate(tokens), func(c *gin.Context) {
+		principal, _ := CurrentUser(c)
+		if c.GetInt64(UserIDKey) != principal.UserID || c.GetString(UserEmailKey) != principal.Email {
+			t.Errorf("Expected gin keys to match the principal %+v", principal)
+		}
+		c.String(http.StatusOK, principal.Email)
+	})
+	router.GET("/admin", Authenticate(tokens), RequireRoles(auth.RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		wantStatus int
+	}{
+		{"no header", "/me", "", http.StatusUnauthorized},
+		{"wrong scheme", "/me", "Basic " + userPair.AccessToken, http.StatusUnauthorized},
+		{"refresh token", "/me", "Bearer " + userPair.RefreshToken, http.StatusUnauthorized},
+		{"access token", "/me", "Bearer " + userPair.AccessToken, http.StatusOK},
+		{"missing role", "/admin", "Bearer " + userPair.AccessToken, http.StatusForbidden},
+		{"admin", "/admin", "bearer " + adminPair.AccessToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequireRolesWithoutAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/admin", RequireRoles(auth.RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", rr.Code)
+	}
+}
@@ -2,18 +2,21 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
 )
 
-// CORS middleware to handle Cross-Origin Resource Sharing
-func CORS() gin.HandlerFunc {
-	return gin.HandlerFunc(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+// NewCORSPolicy builds the CORS policy described by the configuration
+func NewCORSPolicy(cfg *config.Config) (*cors.Policy, error) {
+	return cors.New(cfg.CORSOptions())
+}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+// CORS middleware to handle Cross-Origin Resource Sharing. Preflight
+// requests are answered by the policy and do not reach later handlers.
+func CORS(policy *cors.Policy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if policy.Apply(c.Writer, c.Request) {
+			c.Abort()
 			return
 		}
 
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/logging"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
+)
+
+// RequestIDKey is the gin context key holding the request ID
+const RequestIDKey = "request_id"
+
+// RequestID accepts a sane X-Request-ID from the client or issues a new
+// one. The ID is echoed in the response and stored in the request context.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestid.Resolve(c.GetHeader(requestid.Header))
+
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+
+		c.Next()
+	}
+}
+
+// LogOptions controls RequestLogger
+type LogOptions struct {
+	// SampleRate is the fraction of successful requests that are logged.
+	// Responses with status 400 and above are always logged.
+	SampleRate float64
+
+	// LogHeaders adds the request headers to each entry
+	LogHeaders bool
+
+	// RedactHeaders are masked when headers are logged
+	RedactHeaders []string
+}
+
+// LogOptionsFromConfig returns the logging options set in the configuration
+func LogOptionsFromConfig(cfg *config.Config) LogOptions {
+	return LogOptions{
+		SampleRate:    cfg.LogSampleRate,
+		LogHeaders:    cfg.LogRequestHeaders,
+		RedactHeaders: cfg.LogRedactHeaders,
+	}
+}
+
+// RequestLogger writes one structured entry per request. It must run after
+// RequestID. Handlers get a logger tagged with the request ID through
+// logging.FromContext(c.Request.Context()).
+func RequestLogger(logger *slog.Logger, opts LogOptions) gin.HandlerFunc {
+	redact := make(map[string]bool, len(opts.RedactHeaders))
+	for _, h := range opts.RedactHeaders {
+		redact[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		reqLogger := logger.With(slog.String("request_id", requestid.FromContext(c.Request.Context())))
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), reqLogger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest && !sampled(opts.SampleRate) {
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if opts.LogHeaders {
+			attrs = append(attrs, headerAttrs(c.Request.Header, redact))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		reqLogger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// sampled decides whether a successful request is logged
+func sampled(rate float64) bool {
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+// headerAttrs groups the request headers, masking the redacted ones
+func headerAttrs(header http.Header, redact map[string]bool) slog.Attr {
+	attrs := make([]any, 0, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if redact[name] {
+			value = "[REDACTED]"
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group("headers", attrs...)
+}
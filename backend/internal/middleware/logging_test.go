@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/logging"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
+)
+
+func newLoggedRouter(buf *bytes.Buffer, opts LogOptions) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	router := gin.New()
+	router.Use(RequestID())
+	router.Use(RequestLogger(logger, opts))
+
+	router.GET("/users/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("loading user")
+		c.String(http.StatusOK, "hello")
+	})
+	return router
+}
+
+// logLines decodes every JSON entry written to buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf, LogOptions{SampleRate: 1})
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	id := rr.Header().Get(requestid.Header)
+	if !requestid.Valid(id) {
+		t.Fatalf("Expected a request ID in the response, got %q", id)
+	}
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("Expected a handler entry and a request entry, got %d", len(lines))
+	}
+
+	if lines[0]["msg"] != "loading user" || lines[0]["request_id"] != id {
+		t.Errorf("Expected the handler logger to carry the request ID, got %v", lines[0])
+	}
+
+	entry := lines[1]
+	expected := map[string]interface{}{
+		"msg":        "request",
+		"method":     "GET",
+		"route":      "/users/:id",
+		"path":       "/users/42",
+		"status":     float64(200),
+		"bytes":      float64(5),
+		"client_ip":  "203.0.113.7",
+		"request_id": id,
+	}
+	for key, want := range expected {
+		if entry[key] != want {
+			t.Errorf("%s: expected %v, got %v", key, want, entry[key])
+		}
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Errorf("Expected latency_ms in the entry, got %v", entry)
+	}
+	if _, ok := entry["headers"]; ok {
+		t.Error("Expected headers to be left out by default")
+	}
+}
+
+func TestRequestIDAccepted(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf, LogOptions{SampleRate: 1})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set(requestid.Header, "upstream-123")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get(requestid.Header); got != "upstream-123" {
+		t.Errorf("Expected the client request ID to be kept, got %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set(requestid.Header, "bad id\x00")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get(requestid.Header); got == "bad id\x00" || !requestid.Valid(got) {
+		t.Errorf("Expected an invalid request ID to be replaced, got %q", got)
+	}
+}
+
+func TestRequestLoggerRedactsHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf, LogOptions{
+		SampleRate:    1,
+		LogHeaders:    true,
+		RedactHeaders: []string{"authorization", " X-Api-Key"},
+	})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-Api-Key", "key-123")
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), "secret-token") || strings.Contains(buf.String(), "key-123") {
+		t.Fatalf("Secret header leaked into the log: %s", buf.String())
+	}
+
+	lines := logLines(t, &buf)
+	headers, ok := lines[len(lines)-1]["headers"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected a headers group, got %v", lines[len(lines)-1])
+	}
+	if headers["Authorization"] != "[REDACTED]" || headers["Accept"] != "application/json" {
+		t.Errorf("Unexpected logged headers %v", headers)
+	}
+}
+
+func TestRequestLoggerSampling(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf, LogOptions{SampleRate: 0})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	for _, entry := range logLines(t, &buf) {
+		if entry["msg"] == "request" {
+			t.Errorf("Expected successful requests to be sampled out, got %v", entry)
+		}
+	}
+
+	buf.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	lines := logLines(t, &buf)
+	if len(lines) != 1 || lines[0]["status"] != float64(404) || lines[0]["route"] != "unmatched" {
+		t.Errorf("Expected errors to be logged regardless of sampling, got %v", lines)
+	}
+	if len(lines) == 1 && lines[0]["level"] != "WARN" {
+		t.Errorf("Expected a 404 to be logged as a warning, got %v", lines[0]["level"])
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+)
+
+// Metrics records request counts, latencies and in-flight requests per
+// route template
+func Metrics(m *metrics.HTTP) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done := m.Track()
+		defer done()
+
+		start := time.Now()
+		c.Next()
+
+		m.Observe(c.Request.Method, ginRoute(c), c.Writer.Status(), time.Since(start))
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	registry := metrics.NewRegistry()
+	router := gin.New()
+	router.Use(Metrics(metrics.NewHTTP(registry, "backend")))
+	router.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/metrics", gin.WrapH(metrics.Handler(registry)))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/7", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/users/:id",service="backend",status="204"} 1`,
+		`http_requests_total{method="GET",route="unmatched",service="backend",status="404"} 1`,
+		`http_requests_in_flight{service="backend"} 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("Expected %q in the metrics output", want)
+		}
+	}
+}
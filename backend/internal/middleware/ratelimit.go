@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem/ginproblem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
+)
+
+// APIKeyHeader carries the API key of machine clients
+const APIKeyHeader = "X-API-Key"
+
+// authRoutes are limited by RATE_LIMIT_AUTH to slow down password guessing
+var authRoutes = []string{
+	"/api/v1/auth/register",
+	"/api/v1/auth/login",
+	"/api/v1/auth/refresh",
+}
+
+// NewRateLimiter builds the limiter described by the configuration.
+// Clients are identified by user, then API key, then IP address. Probes
+// and metrics are never limited.
+func NewRateLimiter(cfg *config.Config, store ratelimit.Store, tokens *auth.TokenService) (*ratelimit.Limiter, error) {
+	def, err := ratelimit.ParseLimit(cfg.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	strict, err := ratelimit.ParseLimit(cfg.RateLimitAuth)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := ratelimit.ParseAlgorithm(cfg.RateLimitAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	def.Algorithm = algorithm
+	strict.Algorithm = algorithm
+
+	limiter := ratelimit.New(store)
+	limiter.Exempt("/health", "/livez", "/readyz", "/metrics")
+
+	err = limiter.SetDefault(ratelimit.Policy{
+		Limit: def,
+		Key:   ratelimit.FirstOf(UserRateLimitKey(tokens), ratelimit.ByAPIKey(APIKeyHeader), ratelimit.ByIP),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Anonymous endpoints, so only the address identifies the client
+	for _, route := range authRoutes {
+		if err := limiter.Route(http.MethodPost, route, ratelimit.Policy{Limit: strict, Key: ratelimit.ByIP}); err != nil {
+			return nil, err
+		}
+	}
+	return limiter, nil
+}
+
+// UserRateLimitKey identifies authenticated users. It runs before the
+// routes' own authentication, so it verifies the bearer token itself and
+// ignores invalid ones.
+func UserRateLimitKey(tokens *auth.TokenService) ratelimit.KeyFunc {
+	return ratelimit.ByUser(func(r *http.Request) (string, bool) {
+		if p, ok := auth.FromContext(r.Context()); ok {
+			return strconv.FormatInt(p.UserID, 10), true
+		}
+
+		token, ok := bearerToken(r.Header.Get("Authorization"))
+		if !ok {
+			return "", false
+		}
+		claims, err := tokens.Parse(token, auth.TokenTypeAccess)
+		if err != nil {
+			return "", false
+		}
+		return claims.Subject, true
+	})
+}
+
+// RateLimit rejects clients over their quota with 429. Limits apply per
+// route template, unmatched routes share the default policy.
+func RateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := limiter.Check(c.Request, ginRoute(c))
+		if err != nil {
+			// Fail open, an unavailable store must not take the API down
+			_ = c.Error(err)
+		}
+		if res != nil {
+			ratelimit.SetHeaders(c.Writer.Header(), res)
+			if !res.Allowed {
+				ginproblem.Abort(c, problem.RateLimited("rate limit exceeded"))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// ginRoute returns the route template, FullPath is empty for unmatched
+// requests
+func ginRoute(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return metrics.Unmatched
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/auth"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/internal/config"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
+)
+
+func newRateLimitedRouter(t *testing.T) (*gin.Engine, *auth.TokenService) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	tokens, err := auth.NewTokenService("test-secret", "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{RateLimit: "2/1m", RateLimitAuth: "1/1m", RateLimitAlgorithm: "sliding_window"}
+	limiter, err := NewRateLimiter(cfg, ratelimit.NewMemoryStore(), tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(RateLimit(limiter))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/api/v1/ping", ok)
+	router.POST("/api/v1/auth/login", ok)
+	router.GET("/livez", ok)
+	return router, tokens
+}
+
+func TestRateLimit(t *testing.T) {
+	router, _ := newRateLimitedRouter(t)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do("GET", "/api/v1/ping"); rr.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i, rr.Code)
+		}
+	}
+	rr := do("GET", "/api/v1/ping")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Expected rate limit headers, got %v", rr.Header())
+	}
+	var p problem.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil || p.Type != problem.KindRateLimited.Type() || p.Status != http.StatusTooManyRequests {
+		t.Errorf("Unexpected body %s", rr.Body)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("Expected Content-Type %s, got %s", problem.ContentType, ct)
+	}
+
+	// Login has a stricter quota of its own
+	if rr := do("POST", "/api/v1/auth/login"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the first login to pass, got %d", rr.Code)
+	}
+	if rr := do("POST", "/api/v1/auth/login"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the second login to be limited, got %d", rr.Code)
+	}
+
+	for i := 0; i < 5; i++ {
+		if rr := do("GET", "/livez"); rr.Code != http.StatusOK {
+			t.Errorf("Expected probes to be exempt, got %d", rr.Code)
+		}
+	}
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	router, tokens := newRateLimitedRouter(t)
+
+	pair, _ := tokens.Issue(&auth.User{ID: 7, Email: "ada@example.com"})
+	do := func(token string) int {
+		req := httptest.NewRequest("GET", "/api/v1/ping", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The user and the anonymous client at the same address have separate
+	// quotas
+	do(pair.AccessToken)
+	do(pair.AccessToken)
+	if code := do(pair.AccessToken); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the user to be limited, got %d", code)
+	}
+	if code := do(""); code != http.StatusOK {
+		t.Errorf("Expected the anonymous client to have its own quota, got %d", code)
+	}
+}
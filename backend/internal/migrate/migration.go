@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Section markers inside a migration file
+const (
+	upMarker   = "-- +migrate Up"
+	downMarker = "-- +migrate Down"
+)
+
+// versionLayout is the timestamp format used for new migration versions
+const versionLayout = "20060102150405"
+
+var (
+	fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+	nameCleaner     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Common errors
+var (
+	ErrNoUpSection   = errors.New("migration has no up section")
+	ErrNoDownSection = errors.New("migration has no down section")
+)
+
+// Migration is a single versioned schema change read from a SQL file
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// String returns the migration in "version_name" form
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// Load reads all migration files from dir, sorted by version.
+// Files that do not look like "<version>_<name>.sql" are ignored.
+func Load(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations directory: %w", err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int64]string)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%s: invalid version %q", entry.Name(), match[1])
+		}
+
+		if other, exists := seen[version]; exists {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", entry.Name(), err)
+		}
+
+		up, down, err := Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    match[2],
+			Up:      up,
+			Down:    down,
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Parse splits the content of a migration file into its up and down sections
+func Parse(content string) (up, down string, err error) {
+	var (
+		upLines, downLines []string
+		current            *[]string
+		sawUp, sawDown     bool
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.TrimSpace(line) {
+		case upMarker:
+			if sawUp {
+				return "", "", errors.New("duplicate up section")
+			}
+			sawUp = true
+			current = &upLines
+			continue
+		case downMarker:
+			if sawDown {
+				return "", "", errors.New("duplicate down section")
+			}
+			sawDown = true
+			current = &downLines
+			continue
+		}
+
+		if current == nil {
+			if strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				return "", "", errors.New("statements found before the up section")
+			}
+			continue
+		}
+		*current = append(*current, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	up = strings.TrimSpace(strings.Join(upLines, "\n"))
+	down = strings.TrimSpace(strings.Join(downLines, "\n"))
+
+	if up == "" {
+		return "", "", ErrNoUpSection
+	}
+
+	return up, down, nil
+}
+
+// Create writes an empty migration file named after the given time and
+// returns its path
+func Create(dir, name string, now time.Time) (string, error) {
+	slug := strings.Trim(nameCleaner.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "", fmt.Errorf("invalid migration name %q", name)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create migrations directory: %w", err)
+	}
+
+	path := filepath.Join(dir, now.UTC().Format(versionLayout)+"_"+slug+".sql")
+	template := upMarker + "\n\n\n" + downMarker + "\n\n"
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("create migration file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(template); err != nil {
+		return "", fmt.Errorf("write migration file: %w", err)
+	}
+
+	return path, nil
+}
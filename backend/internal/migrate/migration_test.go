@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	content := `-- Create the users table
+-- +migrate Up
+CREATE TABLE users (id SERIAL PRIMARY KEY);
+CREATE INDEX idx_users_id ON users(id);
+
+-- +migrate Down
+DROP TABLE users;
+`
+
+	up, down, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if !strings.HasPrefix(up, "CREATE TABLE users") || !strings.HasSuffix(up, "ON users(id);") {
+		t.Errorf("Unexpected up section: %q", up)
+	}
+
+	if down != "DROP TABLE users;" {
+		t.Errorf("Unexpected down section: %q", down)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"no up section", "-- +migrate Down\nDROP TABLE users;"},
+		{"empty up section", "-- +migrate Up\n\n-- +migrate Down\nDROP TABLE users;"},
+		{"statement before up", "CREATE TABLE users ();\n-- +migrate Up\nSELECT 1;"},
+		{"duplicate up", "-- +migrate Up\nSELECT 1;\n-- +migrate Up\nSELECT 2;"},
+		{"duplicate down", "-- +migrate Up\nSELECT 1;\n-- +migrate Down\nSELECT 2;\n-- +migrate Down\nSELECT 3;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Parse(tt.content); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "20250102000000_create_posts.sql", "-- +migrate Up\nCREATE TABLE posts ();\n-- +migrate Down\nDROP TABLE posts;")
+	writeFile(t, dir, "20250101000000_create_users.sql", "-- +migrate Up\nCREATE TABLE users ();")
+	writeFile(t, dir, "README.md", "not a migration")
+	writeFile(t, dir, ".gitkeep", "")
+
+	migrations, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+	}
+
+	if migrations[0].Version != 20250101000000 || migrations[0].Name != "create_users" {
+		t.Errorf("Expected create_users first, got %s", migrations[0])
+	}
+
+	if migrations[0].Down != "" {
+		t.Errorf("Expected empty down section, got %q", migrations[0].Down)
+	}
+
+	if migrations[1].Down != "DROP TABLE posts;" {
+		t.Errorf("Unexpected down section: %q", migrations[1].Down)
+	}
+}
+
+func TestLoadDuplicateVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "1_first.sql", "-- +migrate Up\nSELECT 1;")
+	writeFile(t, dir, "001_second.sql", "-- +migrate Up\nSELECT 2;")
+
+	if _, err := Load(dir); err == nil {
+		t.Error("Expected error for duplicate versions")
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "1_broken.sql", "-- forgot the markers\n")
+
+	_, err := Load(dir)
+	if !errors.Is(err, ErrNoUpSection) {
+		t.Errorf("Expected ErrNoUpSection, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+	now := time.Date(2025, 7, 8, 9, 0, 8, 0, time.UTC)
+
+	path, err := Create(dir, "Add Users Table!", now)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if filepath.Base(path) != "20250708090008_add_users_table.sql" {
+		t.Errorf("Unexpected file name: %s", filepath.Base(path))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), upMarker) || !strings.Contains(string(content), downMarker) {
+		t.Errorf("Template is missing section markers: %q", content)
+	}
+
+	if _, err := Create(dir, "add users table", now); err == nil {
+		t.Error("Expected error when the file already exists")
+	}
+
+	if _, err := Create(dir, "!!!", now); err == nil {
+		t.Error("Expected error for an empty name")
+	}
+}
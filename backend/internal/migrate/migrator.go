@@ -0,0 +1,388 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// DefaultTable is the bookkeeping table that records applied migrations
+const DefaultTable = "schema_migrations"
+
+// Direction tells whether a step applied or rolled back a migration
+type Direction string
+
+const (
+	DirectionUp   Direction = "up"
+	DirectionDown Direction = "down"
+)
+
+// ErrUnknownVersion is returned by Goto when the target version has no file
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Step describes one migration that was applied or rolled back
+type Step struct {
+	Migration Migration
+	Direction Direction
+	Duration  time.Duration
+}
+
+// Status describes the state of a single migration version
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Missing is set when the version is recorded in the database but its
+	// file is no longer present in the migrations directory
+	Missing bool
+}
+
+// Migrator applies and rolls back migrations against a PostgreSQL database.
+// Every migration runs in its own transaction together with its bookkeeping
+// row, and a session-level advisory lock keeps concurrent runs apart.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	// Table is the bookkeeping table name, DefaultTable unless overridden
+	Table string
+}
+
+// NewMigrator creates a migrator for the given set of migrations
+func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+		Table:      DefaultTable,
+	}
+}
+
+// Up applies every pending migration in version order
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.Goto(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the most recently applied migration
+func (m *Migrator) Down(ctx context.Context) ([]Step, error) {
+	var steps []Step
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		latest, ok := latestVersion(applied)
+		if !ok {
+			return nil
+		}
+		step, err := m.rollback(ctx, conn, latest)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, step)
+		return nil
+	})
+	return steps, err
+}
+
+// Redo rolls back the most recently applied migration and applies it again
+func (m *Migrator) Redo(ctx context.Context) ([]Step, error) {
+	var steps []Step
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		latest, ok := latestVersion(applied)
+		if !ok {
+			return nil
+		}
+
+		down, err := m.rollback(ctx, conn, latest)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, down)
+
+		up, err := m.apply(ctx, conn, down.Migration)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, up)
+		return nil
+	})
+	return steps, err
+}
+
+// Goto migrates the schema so that exactly the migrations up to and
+// including version are applied. Applied migrations above the target are
+// rolled back newest first, pending ones at or below it are applied oldest
+// first. Version 0 rolls back everything.
+func (m *Migrator) Goto(ctx context.Context, version int64) ([]Step, error) {
+	if version != 0 {
+		if _, ok := m.find(version); !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+
+	var steps []Step
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		var above []int64
+		for v := range applied {
+			if v > version {
+				above = append(above, v)
+			}
+		}
+		sort.Slice(above, func(i, j int) bool { return above[i] > above[j] })
+
+		for _, v := range above {
+			step, err := m.rollback(ctx, conn, v)
+			if err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, done := applied[migration.Version]; done {
+				continue
+			}
+			step, err := m.apply(ctx, conn, migration)
+			if err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	return steps, err
+}
+
+// Status reports every known migration and whether it has been applied. It
+// only reads the bookkeeping table, so it neither waits for a running
+// migration nor creates the table, and reports everything as pending while
+// the table does not exist.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	applied := make(map[int64]time.Time)
+	exists, err := m.tableExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = m.applied(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	for version, appliedAt := range applied {
+		if _, ok := m.find(version); !ok {
+			statuses = append(statuses, Status{
+				Version:   version,
+				Applied:   true,
+				AppliedAt: appliedAt,
+				Missing:   true,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// withLock runs fn on a dedicated connection while holding the migration
+// advisory lock, passing it the currently applied versions
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	key := m.lockKey()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// ensureTable creates the bookkeeping table if it does not exist yet
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`, m.quotedTable())
+
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("create %s table: %w", m.Table, err)
+	}
+	return nil
+}
+
+// tableExists reports whether the bookkeeping table has been created
+func (m *Migrator) tableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", m.quotedTable()).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("look up %s table: %w", m.Table, err)
+	}
+	return exists, nil
+}
+
+// applied returns the applied versions and when they were applied
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.quotedTable()))
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("read applied migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// apply runs the up section of a migration and records it
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) (Step, error) {
+	start := time.Now()
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (version, name) VALUES ($1, $2)", m.quotedTable()),
+			migration.Version, migration.Name)
+		return err
+	})
+	if err != nil {
+		return Step{}, fmt.Errorf("apply %s: %w", migration, err)
+	}
+
+	return Step{Migration: migration, Direction: DirectionUp, Duration: time.Since(start)}, nil
+}
+
+// rollback runs the down section of an applied migration and forgets it
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, version int64) (Step, error) {
+	migration, ok := m.find(version)
+	if !ok {
+		return Step{}, fmt.Errorf("roll back %d: migration file is missing", version)
+	}
+	if migration.Down == "" {
+		return Step{}, fmt.Errorf("roll back %s: %w", migration, ErrNoDownSection)
+	}
+
+	start := time.Now()
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE version = $1", m.quotedTable()),
+			migration.Version)
+		return err
+	})
+	if err != nil {
+		return Step{}, fmt.Errorf("roll back %s: %w", migration, err)
+	}
+
+	return Step{Migration: migration, Direction: DirectionDown, Duration: time.Since(start)}, nil
+}
+
+// find looks up a migration by version
+func (m *Migrator) find(version int64) (Migration, bool) {
+	i := sort.Search(len(m.migrations), func(i int) bool {
+		return m.migrations[i].Version >= version
+	})
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i], true
+	}
+	return Migration{}, false
+}
+
+// quotedTable returns the bookkeeping table name as a quoted identifier
+func (m *Migrator) quotedTable() string {
+	return `"` + m.Table + `"`
+}
+
+// lockKey derives the advisory lock key from the bookkeeping table name
+func (m *Migrator) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(m.Table))
+	return int64(h.Sum64())
+}
+
+// inTx runs fn inside a transaction on conn
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// latestVersion returns the highest applied version
+func latestVersion(applied map[int64]time.Time) (int64, bool) {
+	var (
+		latest int64
+		found  bool
+	)
+	for v := range applied {
+		if !found || v > latest {
+			latest, found = v, true
+		}
+	}
+	return latest, found
+}
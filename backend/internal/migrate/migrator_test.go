@@ -0,0 +1,207 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// setupMigrator connects to DATABASE_URL and returns a migrator that uses
+// table names unique to this test run
+func setupMigrator(t *testing.T) (*Migrator, *sql.DB, string) {
+	t.Helper()
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set, skipping PostgreSQL migration tests")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	table := "migrate_test_" + suffix
+
+	migrations := []Migration{
+		{Version: 1, Name: "create_a", Up: fmt.Sprintf("CREATE TABLE a_%s (id INT);", suffix), Down: fmt.Sprintf("DROP TABLE a_%s;", suffix)},
+		{Version: 2, Name: "create_b", Up: fmt.Sprintf("CREATE TABLE b_%s (id INT);", suffix), Down: fmt.Sprintf("DROP TABLE b_%s;", suffix)},
+		{Version: 3, Name: "create_c", Up: fmt.Sprintf("CREATE TABLE c_%s (id INT);", suffix), Down: fmt.Sprintf("DROP TABLE c_%s;", suffix)},
+	}
+
+	migrator := NewMigrator(db, migrations)
+	migrator.Table = table
+
+	t.Cleanup(func() {
+		for _, name := range []string{"a", "b", "c"} {
+			db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s_%s", name, suffix))
+		}
+		db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, table))
+		db.Close()
+	})
+
+	return migrator, db, suffix
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func appliedVersions(t *testing.T, m *Migrator) []int64 {
+	t.Helper()
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	var versions []int64
+	for _, s := range statuses {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	migrator, db, suffix := setupMigrator(t)
+	ctx := context.Background()
+
+	steps, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("Expected 3 steps, got %d", len(steps))
+	}
+	if !tableExists(t, db, "c_"+suffix) {
+		t.Error("Expected table c to exist after Up")
+	}
+
+	steps, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Second Up failed: %v", err)
+	}
+	if len(steps) != 0 {
+		t.Errorf("Expected no steps on second Up, got %d", len(steps))
+	}
+
+	steps, err = migrator.Down(ctx)
+	if err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if len(steps) != 1 || steps[0].Migration.Version != 3 || steps[0].Direction != DirectionDown {
+		t.Errorf("Expected version 3 to be rolled back, got %+v", steps)
+	}
+	if tableExists(t, db, "c_"+suffix) {
+		t.Error("Expected table c to be dropped after Down")
+	}
+
+	if got := appliedVersions(t, migrator); len(got) != 2 {
+		t.Errorf("Expected 2 applied versions, got %v", got)
+	}
+}
+
+func TestMigratorGotoAndRedo(t *testing.T) {
+	migrator, db, suffix := setupMigrator(t)
+	ctx := context.Background()
+
+	if _, err := migrator.Goto(ctx, 2); err != nil {
+		t.Fatalf("Goto(2) failed: %v", err)
+	}
+	if got := appliedVersions(t, migrator); len(got) != 2 || got[1] != 2 {
+		t.Errorf("Expected versions [1 2], got %v", got)
+	}
+
+	steps, err := migrator.Redo(ctx)
+	if err != nil {
+		t.Fatalf("Redo failed: %v", err)
+	}
+	if len(steps) != 2 || steps[0].Direction != DirectionDown || steps[1].Direction != DirectionUp {
+		t.Errorf("Expected a down and an up step, got %+v", steps)
+	}
+	if !tableExists(t, db, "b_"+suffix) {
+		t.Error("Expected table b to exist after Redo")
+	}
+
+	if _, err := migrator.Goto(ctx, 0); err != nil {
+		t.Fatalf("Goto(0) failed: %v", err)
+	}
+	if got := appliedVersions(t, migrator); len(got) != 0 {
+		t.Errorf("Expected no applied versions, got %v", got)
+	}
+
+	if _, err := migrator.Goto(ctx, 42); err == nil {
+		t.Error("Expected error for unknown version")
+	}
+}
+
+func TestMigratorFailedMigrationRollsBack(t *testing.T) {
+	migrator, db, suffix := setupMigrator(t)
+	ctx := context.Background()
+
+	migrator.migrations = append(migrator.migrations, Migration{
+		Version: 4,
+		Name:    "broken",
+		Up:      fmt.Sprintf("CREATE TABLE d_%s (id INT); SELECT no_such_column FROM d_%s;", suffix, suffix),
+	})
+
+	if _, err := migrator.Up(ctx); err == nil {
+		t.Fatal("Expected Up to fail on the broken migration")
+	}
+
+	if tableExists(t, db, "d_"+suffix) {
+		t.Error("Expected the failed migration to be rolled back")
+	}
+
+	if got := appliedVersions(t, migrator); len(got) != 3 {
+		t.Errorf("Expected the first 3 migrations to stay applied, got %v", got)
+	}
+}
+
+func TestMigratorStatusIsReadOnly(t *testing.T) {
+	migrator, db, _ := setupMigrator(t)
+
+	// Hold the migration lock as a running migration would
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_lock($1)", migrator.lockKey()); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrator.lockKey())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed while the lock was held: %v", err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 statuses, got %+v", statuses)
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Errorf("Expected version %d to be pending", s.Version)
+		}
+	}
+	if tableExists(t, db, `"`+migrator.Table+`"`) {
+		t.Error("Expected Status not to create the bookkeeping table")
+	}
+}
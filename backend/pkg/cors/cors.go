@@ -0,0 +1,389 @@
+// Package cors implements an origin-aware Cross-Origin Resource Sharing
+// policy that can be used with any net/http based router.
+//
+// Allowed origins are given as a list of patterns:
+//
+//	https://app.example.com     exact origin
+//	https://*.example.com       any subdomain of example.com (not the apex)
+//	^https://pr-\d+\.example\.dev$
+//	                            regular expression (entries starting with ^)
+//	*                           any origin
+//
+// Only the matching origin is echoed back in Access-Control-Allow-Origin and
+// every response carries Vary: Origin, so shared caches never serve one
+// origin's headers to another.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default values used when the corresponding option is empty
+var (
+	DefaultMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	DefaultHeaders = []string{"Accept", "Authorization", "Content-Type", "Origin", "X-Requested-With"}
+)
+
+// ErrCredentialsWithAnyOrigin is returned when a policy would allow
+// credentialed requests from every origin
+var ErrCredentialsWithAnyOrigin = errors.New("cors: credentials cannot be allowed for the wildcard origin \"*\"")
+
+// Options describes one CORS policy
+type Options struct {
+	// AllowedOrigins lists exact origins, wildcard subdomain patterns,
+	// regular expressions (starting with ^) or "*"
+	AllowedOrigins []string
+
+	// AllowedMethods defaults to DefaultMethods
+	AllowedMethods []string
+
+	// AllowedHeaders defaults to DefaultHeaders. "*" allows any header the
+	// browser asks for.
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers readable by browser scripts
+	ExposedHeaders []string
+
+	// AllowCredentials allows cookies and Authorization headers. It cannot
+	// be combined with the "*" origin.
+	AllowCredentials bool
+
+	// MaxAge tells browsers how long to cache preflight results. Zero
+	// leaves the header out.
+	MaxAge time.Duration
+}
+
+// rules is a compiled set of Options
+type rules struct {
+	anyOrigin    bool
+	exact        map[string]bool
+	wildcards    []wildcard
+	patterns     []*regexp.Regexp
+	methods      map[string]bool
+	anyHeader    bool
+	headers      map[string]bool
+	allowMethods string
+	allowHeaders string
+	exposed      string
+	credentials  bool
+	maxAge       string
+}
+
+// wildcard matches origins such as https://api.example.com against
+// https://*.example.com
+type wildcard struct {
+	prefix string // scheme and "://"
+	suffix string // "." followed by the parent domain and optional port
+}
+
+// route applies its own rules to requests below a path prefix
+type route struct {
+	prefix string
+	rules  *rules
+}
+
+// Policy decides which cross-origin requests are allowed and writes the
+// matching response headers. A Policy is safe for concurrent use once it
+// has been configured.
+type Policy struct {
+	rules  *rules
+	routes []route
+}
+
+// New compiles opts into a Policy
+func New(opts Options) (*Policy, error) {
+	r, err := compile(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{rules: r}, nil
+}
+
+// MustNew is like New but panics if opts are invalid. It is meant for
+// policies built from constant options.
+func MustNew(opts Options) *Policy {
+	p, err := New(opts)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Route sets a different policy for every path below pathPrefix. The route
+// options replace the default ones entirely. When several prefixes match,
+// the longest one wins. Route must be called before the policy serves
+// requests.
+func (p *Policy) Route(pathPrefix string, opts Options) error {
+	r, err := compile(opts)
+	if err != nil {
+		return fmt.Errorf("route %s: %w", pathPrefix, err)
+	}
+
+	for i := range p.routes {
+		if p.routes[i].prefix == pathPrefix {
+			p.routes[i].rules = r
+			return nil
+		}
+	}
+	p.routes = append(p.routes, route{prefix: pathPrefix, rules: r})
+	sort.SliceStable(p.routes, func(i, j int) bool {
+		return len(p.routes[i].prefix) > len(p.routes[j].prefix)
+	})
+	return nil
+}
+
+// Handler wraps next with the policy. Preflight requests are answered
+// directly and never reach next. Its signature matches mux.MiddlewareFunc.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.Apply(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Apply writes the CORS headers for r. It returns true when r was a
+// preflight request and the response has been written.
+func (p *Policy) Apply(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	rules := p.rulesFor(r.URL.Path)
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if !rules.allowsOrigin(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return preflight
+	}
+
+	if rules.anyOrigin && !rules.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if rules.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if rules.exposed != "" {
+			h.Set("Access-Control-Expose-Headers", rules.exposed)
+		}
+		return false
+	}
+
+	// A preflight for a method or header we do not allow gets no allow
+	// headers, which makes the browser block the actual request
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := parseList(r.Header.Get("Access-Control-Request-Headers"))
+	if rules.methods[method] && rules.allowsHeaders(requested) {
+		h.Set("Access-Control-Allow-Methods", rules.allowMethods)
+		if rules.anyHeader {
+			if len(requested) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+		} else {
+			h.Set("Access-Control-Allow-Headers", rules.allowHeaders)
+		}
+		if rules.maxAge != "" {
+			h.Set("Access-Control-Max-Age", rules.maxAge)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// AllowsOrigin reports whether origin is allowed by the default rules. It
+// is handy for websocket upgraders that have to check the origin
+// themselves.
+func (p *Policy) AllowsOrigin(origin string) bool {
+	return p.rules.allowsOrigin(origin)
+}
+
+// CheckOrigin reports whether the Origin of r is allowed for its path.
+// Requests without an Origin header are not cross-origin and are allowed.
+// It matches the websocket.Upgrader.CheckOrigin signature.
+func (p *Policy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || p.rulesFor(r.URL.Path).allowsOrigin(origin)
+}
+
+func (p *Policy) rulesFor(path string) *rules {
+	for _, rt := range p.routes {
+		if strings.HasPrefix(path, rt.prefix) {
+			return rt.rules
+		}
+	}
+	return p.rules
+}
+
+func compile(opts Options) (*rules, error) {
+	r := &rules{
+		exact:       make(map[string]bool),
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		credentials: opts.AllowCredentials,
+	}
+
+	for _, origin := range opts.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "":
+			return nil, errors.New("cors: empty origin")
+		case origin == "*":
+			r.anyOrigin = true
+		case strings.HasPrefix(origin, "^"):
+			re, err := regexp.Compile(origin)
+			if err != nil {
+				return nil, fmt.Errorf("cors: invalid origin pattern %q: %w", origin, err)
+			}
+			r.patterns = append(r.patterns, re)
+		case strings.Contains(origin, "*"):
+			wc, err := parseWildcard(origin)
+			if err != nil {
+				return nil, err
+			}
+			r.wildcards = append(r.wildcards, wc)
+		default:
+			r.exact[normalizeOrigin(origin)] = true
+		}
+	}
+
+	if r.anyOrigin && r.credentials {
+		return nil, ErrCredentialsWithAnyOrigin
+	}
+
+	allowed := opts.AllowedMethods
+	if len(allowed) == 0 {
+		allowed = DefaultMethods
+	}
+	methods := make([]string, len(allowed))
+	for i, m := range allowed {
+		methods[i] = strings.ToUpper(strings.TrimSpace(m))
+		r.methods[methods[i]] = true
+	}
+	r.allowMethods = strings.Join(methods, ", ")
+
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	for _, header := range headers {
+		header = strings.TrimSpace(header)
+		if header == "*" {
+			r.anyHeader = true
+			continue
+		}
+		r.headers[strings.ToLower(header)] = true
+	}
+	r.allowHeaders = strings.Join(headers, ", ")
+
+	r.exposed = strings.Join(opts.ExposedHeaders, ", ")
+
+	if opts.MaxAge < 0 {
+		return nil, errors.New("cors: max age must not be negative")
+	}
+	if opts.MaxAge > 0 {
+		r.maxAge = strconv.Itoa(int(opts.MaxAge / time.Second))
+	}
+
+	return r, nil
+}
+
+// parseWildcard accepts patterns of the form scheme://*.domain[:port]
+func parseWildcard(pattern string) (wildcard, error) {
+	scheme, rest, ok := strings.Cut(normalizeOrigin(pattern), "://")
+	if !ok || scheme == "" || !strings.HasPrefix(rest, "*.") || strings.Count(rest, "*") != 1 || len(rest) < 3 {
+		return wildcard{}, fmt.Errorf("cors: invalid wildcard origin %q (use e.g. https://*.example.com)", pattern)
+	}
+	return wildcard{prefix: scheme + "://", suffix: rest[1:]}, nil
+}
+
+func (r *rules) allowsOrigin(origin string) bool {
+	if r.anyOrigin {
+		return true
+	}
+
+	normalized := normalizeOrigin(origin)
+	if r.exact[normalized] {
+		return true
+	}
+
+	for _, wc := range r.wildcards {
+		if wc.matches(normalized) {
+			return true
+		}
+	}
+
+	for _, re := range r.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (wc wildcard) matches(origin string) bool {
+	if !strings.HasPrefix(origin, wc.prefix) || !strings.HasSuffix(origin, wc.suffix) {
+		return false
+	}
+
+	sub := origin[len(wc.prefix) : len(origin)-len(wc.suffix)]
+	if sub == "" || strings.HasPrefix(sub, ".") {
+		return false
+	}
+	for _, c := range sub {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *rules) allowsHeaders(requested []string) bool {
+	if r.anyHeader {
+		return true
+	}
+	for _, header := range requested {
+		if !r.headers[strings.ToLower(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeOrigin lower-cases an origin and drops a trailing slash, which
+// some configuration files include by habit
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
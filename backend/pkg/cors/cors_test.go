@@ -0,0 +1,245 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestPolicy(t *testing.T, opts Options) *Policy {
+	t.Helper()
+	p, err := New(opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return p
+}
+
+func serve(p *Policy, method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rr := httptest.NewRecorder()
+	p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestOriginMatching(t *testing.T) {
+	p := newTestPolicy(t, Options{AllowedOrigins: []string{
+		"https://app.example.com/",
+		"https://*.example.org",
+		"http://*.local.test:3000",
+		`^https://pr-\d+\.preview\.dev$`,
+	}})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://api.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evil-example.org", false},
+		{"https://example.org.evil.com", false},
+		{"http://api.example.org", false},
+		{"http://web.local.test:3000", true},
+		{"http://web.local.test:4000", false},
+		{"https://pr-42.preview.dev", true},
+		{"https://pr-x.preview.dev", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.AllowsOrigin(tt.origin); got != tt.allowed {
+			t.Errorf("AllowsOrigin(%q): expected %v, got %v", tt.origin, tt.allowed, got)
+		}
+	}
+}
+
+func TestSimpleRequest(t *testing.T) {
+	p := newTestPolicy(t, Options{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		ExposedHeaders:   []string{"X-Request-ID", "ETag"},
+		AllowCredentials: true,
+	})
+
+	rr := serve(p, "GET", "/api", "https://app.example.com", nil)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected the request to reach the handler, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Expected the origin to be echoed, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Expected credentials to be allowed, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-ID, ETag" {
+		t.Errorf("Unexpected exposed headers %q", got)
+	}
+	if got := rr.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Expected Vary: Origin, got %q", got)
+	}
+
+	rr = serve(p, "GET", "/api", "https://evil.com", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected disallowed origins to still reach the handler, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no allow origin header, got %q", got)
+	}
+	if got := rr.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Expected Vary: Origin for rejected origins too, got %q", got)
+	}
+
+	rr = serve(p, "GET", "/api", "", nil)
+	if len(rr.Header()) != 0 {
+		t.Errorf("Expected no CORS headers without an Origin, got %v", rr.Header())
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	p := newTestPolicy(t, Options{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"get", "post"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         10 * time.Minute,
+	})
+
+	rr := serve(p, "OPTIONS", "/api", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type, authorization",
+	})
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("Unexpected allowed methods %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+		t.Errorf("Unexpected allowed headers %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Expected max age 600, got %q", got)
+	}
+	if got := rr.Header().Values("Vary"); len(got) != 3 {
+		t.Errorf("Expected Vary on origin and request method/headers, got %v", got)
+	}
+
+	rr = serve(p, "OPTIONS", "/api", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method": "DELETE",
+	})
+	if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("Expected a disallowed method to get no allow headers, got %q", got)
+	}
+
+	rr = serve(p, "OPTIONS", "/api", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "X-Custom",
+	})
+	if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("Expected a disallowed header to get no allow headers, got %q", got)
+	}
+
+	// A plain OPTIONS request is not a preflight and reaches the handler
+	rr = serve(p, "OPTIONS", "/api", "https://app.example.com", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected plain OPTIONS to reach the handler, got %d", rr.Code)
+	}
+}
+
+func TestAnyOrigin(t *testing.T) {
+	p := newTestPolicy(t, Options{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})
+
+	rr := serve(p, "OPTIONS", "/", "https://anything.test", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "X-One, X-Two",
+	})
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected *, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "X-One, X-Two" {
+		t.Errorf("Expected requested headers to be echoed, got %q", got)
+	}
+
+	_, err := New(Options{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	if !errors.Is(err, ErrCredentialsWithAnyOrigin) {
+		t.Errorf("Expected ErrCredentialsWithAnyOrigin, got %v", err)
+	}
+}
+
+func TestRouteOverrides(t *testing.T) {
+	p := newTestPolicy(t, Options{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true})
+
+	if err := p.Route("/public", Options{AllowedOrigins: []string{"*"}}); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if err := p.Route("/public/admin", Options{AllowedOrigins: []string{"https://admin.example.com"}}); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		origin string
+		want   string
+	}{
+		{"/api", "https://other.test", ""},
+		{"/public/feed", "https://other.test", "*"},
+		{"/public/admin/users", "https://other.test", ""},
+		{"/public/admin/users", "https://admin.example.com", "https://admin.example.com"},
+	}
+
+	for _, tt := range tests {
+		rr := serve(p, "GET", tt.path, tt.origin, nil)
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("%s from %s: expected %q, got %q", tt.path, tt.origin, tt.want, got)
+		}
+	}
+
+	if err := p.Route("/bad", Options{AllowedOrigins: []string{"https://*"}}); err == nil {
+		t.Error("Expected an invalid wildcard to be rejected")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	invalid := []Options{
+		{AllowedOrigins: []string{""}},
+		{AllowedOrigins: []string{"^https://(unclosed"}},
+		{AllowedOrigins: []string{"https://api.*.example.com"}},
+		{AllowedOrigins: []string{"*.example.com"}},
+		{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: -time.Second},
+	}
+
+	for _, opts := range invalid {
+		if _, err := New(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	p := newTestPolicy(t, Options{AllowedOrigins: []string{"https://app.example.com"}})
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	if !p.CheckOrigin(req) {
+		t.Error("Expected same-origin requests without Origin to pass")
+	}
+
+	req.Header.Set("Origin", "https://evil.com")
+	if p.CheckOrigin(req) {
+		t.Error("Expected a foreign origin to be rejected")
+	}
+}
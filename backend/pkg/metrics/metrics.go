@@ -0,0 +1,175 @@
+// Package metrics exposes Prometheus metrics for the HTTP services of the
+// course.
+//
+// Every service records the same request metrics, distinguished by a
+// constant "service" label, so one dashboard works for all of them:
+//
+//	http_requests_total{service, method, route, status}
+//	http_request_duration_seconds{service, method, route}
+//	http_requests_in_flight{service}
+//
+// Routes are reported as templates such as /api/messages/{id}, never as
+// raw paths, to keep the number of series bounded.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Unmatched is the route label for requests that matched no route
+const Unmatched = "unmatched"
+
+// NewRegistry returns a registry with the Go runtime and process
+// collectors already registered
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// Handler serves the metrics gathered by reg in the Prometheus text format
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+// HTTP records request metrics for one service
+type HTTP struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// NewHTTP registers the request metrics of service with reg. Several
+// services may share a registry. It panics if service is registered twice.
+func NewHTTP(reg prometheus.Registerer, service string) *HTTP {
+	labels := prometheus.Labels{"service": service}
+
+	m := &HTTP{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "http_requests_total",
+			Help:        "HTTP requests handled, by method, route template and status code.",
+			ConstLabels: labels,
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "http_request_duration_seconds",
+			Help:        "HTTP request latency, by method and route template.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "http_requests_in_flight",
+			Help:        "HTTP requests currently being handled.",
+			ConstLabels: labels,
+		}),
+	}
+
+	reg.MustRegister(m.requests, m.duration, m.inFlight)
+	return m
+}
+
+// Track marks a request as in flight until the returned function is called
+func (m *HTTP) Track() (done func()) {
+	m.inFlight.Inc()
+	return m.inFlight.Dec
+}
+
+// Observe records a finished request
+func (m *HTTP) Observe(method, route string, status int, elapsed time.Duration) {
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// RouteFunc returns the route template of a request. It is called after
+// the request has been handled.
+type RouteFunc func(r *http.Request) string
+
+// Middleware instruments a router. route reports the matched template.
+// Its result matches mux.MiddlewareFunc.
+func (m *HTTP) Middleware(route RouteFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			done := m.Track()
+			defer done()
+
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			m.Observe(r.Method, route(r), rec.statusCode(), time.Since(start))
+		})
+	}
+}
+
+// Wrap instruments a single handler registered under a fixed route, which
+// suits http.ServeMux
+func (m *HTTP) Wrap(route string, next http.Handler) http.Handler {
+	return m.Middleware(func(*http.Request) string { return route })(next)
+}
+
+// statusRecorder remembers the status code written by a handler. It keeps
+// the optional interfaces needed by websockets and streaming responses.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush supports streaming responses
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack supports websocket upgrades, which are recorded as 101
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("metrics: response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
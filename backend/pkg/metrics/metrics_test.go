@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewHTTP(reg, "test")
+
+	handler := m.Middleware(func(*http.Request) string { return "/items/{id}" })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if testutil.ToFloat64(m.inFlight) != 1 {
+				t.Errorf("Expected one request in flight, got %v", testutil.ToFloat64(m.inFlight))
+			}
+			if r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+
+	for _, method := range []string{"GET", "GET", "DELETE"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/items/1", nil))
+	}
+
+	if got := testutil.ToFloat64(m.requests.WithLabelValues("GET", "/items/{id}", "200")); got != 2 {
+		t.Errorf("Expected 2 GET requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.requests.WithLabelValues("DELETE", "/items/{id}", "404")); got != 1 {
+		t.Errorf("Expected 1 DELETE request, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.inFlight); got != 0 {
+		t.Errorf("Expected no requests in flight, got %v", got)
+	}
+	if got := testutil.CollectAndCount(m.duration); got != 2 {
+		t.Errorf("Expected 2 latency series, got %d", got)
+	}
+}
+
+func TestSharedRegistry(t *testing.T) {
+	reg := NewRegistry()
+	gateway := NewHTTP(reg, "gateway")
+	websocket := NewHTTP(reg, "websocket")
+
+	gateway.Wrap("/api", http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	websocket.Wrap("/stats", http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stats", nil))
+
+	rr := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/api",service="gateway",status="404"} 1`,
+		`http_requests_total{method="GET",route="/stats",service="websocket",status="404"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the metrics output", want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering the same service twice to panic")
+		}
+	}()
+	NewHTTP(reg, "gateway")
+}
+
+func TestStatusRecorderKeepsInterfaces(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+
+	var w http.ResponseWriter = rec
+	if _, ok := w.(http.Flusher); !ok {
+		t.Error("Expected the recorder to implement http.Flusher")
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		t.Error("Expected the recorder to implement http.Hijacker")
+	}
+
+	// httptest.ResponseRecorder cannot be hijacked
+	if _, _, err := rec.Hijack(); err == nil {
+		t.Error("Expected an error when the underlying writer cannot hijack")
+	}
+
+	rec.Flush()
+	if rec.statusCode() != http.StatusOK {
+		t.Errorf("Expected 200 after a flush, got %d", rec.statusCode())
+	}
+}
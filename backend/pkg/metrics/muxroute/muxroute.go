@@ -0,0 +1,24 @@
+// Package muxroute reports gorilla/mux route templates for request
+// metrics, e.g.
+//
+//	router.Use(httpMetrics.Middleware(muxroute.Template))
+package muxroute
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+)
+
+// Template returns the path template of the route that matched r
+func Template(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return metrics.Unmatched
+	}
+	if tpl, err := route.GetPathTemplate(); err == nil {
+		return tpl
+	}
+	return metrics.Unmatched
+}
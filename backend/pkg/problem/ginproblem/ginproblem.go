@@ -0,0 +1,47 @@
+// Package ginproblem writes problem details from Gin handlers, e.g.
+//
+//	router.Use(middleware.RequestID(), gin.CustomRecovery(ginproblem.Recovery))
+//	router.NoRoute(ginproblem.NoRoute)
+//	router.NoMethod(ginproblem.NoMethod)
+//
+// and in handlers
+//
+//	ginproblem.Abort(c, problem.NotFound("user not found"))
+package ginproblem
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
+)
+
+// Abort answers with the problem details of err and stops the handler
+// chain. The error is recorded in c.Errors for the request logger.
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	p := problem.From(err)
+	p.Instance = c.Request.URL.Path
+	p.RequestID = requestid.FromContext(c.Request.Context())
+
+	// c.JSON keeps a Content-Type that is already set
+	c.Header("Content-Type", problem.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// NoRoute answers requests that match no route
+func NoRoute(c *gin.Context) {
+	Abort(c, problem.NotFound("No route for "+c.Request.URL.Path))
+}
+
+// NoMethod answers requests whose path only matches routes of other
+// methods. It needs router.HandleMethodNotAllowed.
+func NoMethod(c *gin.Context) {
+	Abort(c, problem.Newf(problem.KindMethodNotAllowed, "Method %s is not allowed for %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// Recovery answers requests whose handler panicked, for
+// gin.CustomRecovery. The panic value is not sent to the client.
+func Recovery(c *gin.Context, recovered interface{}) {
+	Abort(c, problem.Internal("", nil))
+}
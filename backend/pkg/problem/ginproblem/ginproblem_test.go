@@ -0,0 +1,50 @@
+package ginproblem
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+func TestAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(NoRoute)
+	router.NoMethod(NoMethod)
+	router.Use(gin.CustomRecovery(Recovery))
+	router.GET("/items/:id", func(c *gin.Context) {
+		Abort(c, problem.NotFound("item "+c.Param("id")+" not found"))
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("database password is hunter2")
+	})
+
+	tests := []struct {
+		method, path string
+		kind         problem.Kind
+		detail       string
+	}{
+		{"GET", "/items/7", problem.KindNotFound, "item 7 not found"},
+		{"GET", "/missing", problem.KindNotFound, "No route for /missing"},
+		{"POST", "/items/7", problem.KindMethodNotAllowed, "Method POST is not allowed for /items/7"},
+		{"GET", "/panic", problem.KindInternal, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+			t.Errorf("%s %s: expected Content-Type %s, got %s", tt.method, tt.path, problem.ContentType, ct)
+		}
+		var p problem.Problem
+		if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s %s: failed to decode %s: %v", tt.method, tt.path, rr.Body, err)
+		}
+		if rr.Code != tt.kind.Status() || p.Type != tt.kind.Type() || p.Detail != tt.detail || p.Instance != tt.path {
+			t.Errorf("%s %s: unexpected %d %+v", tt.method, tt.path, rr.Code, p)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
+)
+
+// Write answers r with the problem details of err. The request ID is taken
+// from the request context, see requestid.Middleware.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	p := From(err)
+	p.Instance = r.URL.Path
+	p.RequestID = requestid.FromContext(r.Context())
+	WriteProblem(w, p)
+}
+
+// WriteProblem writes p with its status
+func WriteProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// NotFoundHandler answers requests that match no route
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, NotFound("No route for "+r.URL.Path))
+	})
+}
+
+// MethodNotAllowedHandler answers requests whose path only matches routes
+// of other methods. Routers set the Allow header before calling it.
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, New(KindMethodNotAllowed, "Method "+r.Method+" is not allowed for "+r.URL.Path))
+	})
+}
@@ -0,0 +1,58 @@
+// Package muxproblem answers the unmatched requests of gorilla/mux
+// routers with problem details, e.g.
+//
+//	router.Use(requestid.Middleware)
+//	muxproblem.Install(router)
+//
+// Handlers write their own errors with problem.Write.
+package muxproblem
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
+)
+
+// methods are tried to tell 404 from 405
+var methods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// Install sets the not found and method not allowed handlers of router.
+// mux skips middleware for unmatched requests, so these resolve the
+// request ID themselves.
+//
+// mux forgets a method mismatch when a later route of the same subrouter
+// shares its path prefix, so unmatched requests are probed with the other
+// methods as well. 405 responses list them in the Allow header.
+func Install(router *mux.Router) {
+	unmatched := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(router, r)
+		if len(allowed) == 0 {
+			problem.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		problem.MethodNotAllowedHandler().ServeHTTP(w, r)
+	}))
+	router.NotFoundHandler = unmatched
+	router.MethodNotAllowedHandler = unmatched
+}
+
+// allowedMethods returns the methods router serves for the path of r
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range methods {
+		if method == r.Method {
+			continue
+		}
+		probe := r.WithContext(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,42 @@
+package muxproblem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+func TestInstall(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	router := mux.NewRouter()
+	Install(router)
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/items", h).Methods("GET", "POST")
+	// A later route sharing the prefix makes mux report 404 for /api/items
+	api.HandleFunc("/other", h).Methods("GET")
+
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{"DELETE", "/api/items", http.StatusMethodNotAllowed, "GET, POST"},
+		{"DELETE", "/api/other", http.StatusMethodNotAllowed, "GET"},
+		{"GET", "/api/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.status || rr.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s: expected %d with Allow %q, got %d with %q", tt.method, tt.path, tt.status, tt.allow, rr.Code, rr.Header().Get("Allow"))
+		}
+		var p problem.Problem
+		if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil || p.Status != tt.status || p.RequestID == "" {
+			t.Errorf("%s %s: unexpected problem %s", tt.method, tt.path, rr.Body)
+		}
+	}
+}
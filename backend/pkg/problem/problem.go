@@ -0,0 +1,212 @@
+// Package problem reports HTTP API errors as RFC 7807 problem details.
+//
+// Handlers return typed errors such as NotFound or Validation, and the
+// adapter of their router writes them as application/problem+json:
+//
+//	{
+//	  "type": "https://github.com/timur-harin/sum25-go-flutter-course/problems/validation",
+//	  "title": "Validation failed",
+//	  "status": 400,
+//	  "detail": "Invalid message",
+//	  "instance": "/api/messages",
+//	  "request_id": "4f1c...",
+//	  "errors": [{"in": "body", "field": "content", "message": "must not be empty"}]
+//	}
+//
+// Type URIs are stable, clients may switch on them. Title only depends on
+// the type, detail explains the occurrence. Errors that are not typed are
+// reported as internal errors without exposing their message.
+//
+// Write serves net/http routers, muxproblem and ginproblem adapt
+// gorilla/mux and Gin.
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the type URIs of the kinds
+const TypeBase = "https://github.com/timur-harin/sum25-go-flutter-course/problems/"
+
+// Kind classifies errors. Each kind has its own type URI, title and
+// status.
+type Kind int
+
+// Kinds of errors
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindMethodNotAllowed
+	KindConflict
+	KindPreconditionFailed
+	KindFailedDependency
+	KindRateLimited
+	KindUnavailable
+)
+
+var kinds = [...]struct {
+	slug, title string
+	status      int
+}{
+	KindInternal:           {"internal", "Internal server error", http.StatusInternalServerError},
+	KindValidation:         {"validation", "Validation failed", http.StatusBadRequest},
+	KindUnauthorized:       {"unauthorized", "Authentication required", http.StatusUnauthorized},
+	KindForbidden:          {"forbidden", "Permission denied", http.StatusForbidden},
+	KindNotFound:           {"not-found", "Resource not found", http.StatusNotFound},
+	KindMethodNotAllowed:   {"method-not-allowed", "Method not allowed", http.StatusMethodNotAllowed},
+	KindConflict:           {"conflict", "Conflict with the current state", http.StatusConflict},
+	KindPreconditionFailed: {"precondition-failed", "Precondition failed", http.StatusPreconditionFailed},
+	KindFailedDependency:   {"failed-dependency", "Depends on a failed operation", http.StatusFailedDependency},
+	KindRateLimited:        {"rate-limited", "Rate limit exceeded", http.StatusTooManyRequests},
+	KindUnavailable:        {"upstream-unavailable", "Upstream service unavailable", http.StatusServiceUnavailable},
+}
+
+// Type returns the type URI of the kind
+func (k Kind) Type() string {
+	return TypeBase + kinds[k].slug
+}
+
+// Title returns the summary shared by all problems of the kind
+func (k Kind) Title() string {
+	return kinds[k].title
+}
+
+// Status returns the HTTP status of the kind
+func (k Kind) Status() int {
+	return kinds[k].status
+}
+
+// String returns the last segment of the type URI
+func (k Kind) String() string {
+	return kinds[k].slug
+}
+
+// FieldError describes an invalid request field
+type FieldError struct {
+	// In is body, path, query or header
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a typed error carrying what its problem details report
+type Error struct {
+	Kind Kind
+	// Detail explains this occurrence to the client
+	Detail string
+	// Fields lists the invalid fields of validation errors
+	Fields []FieldError
+	// Err is the cause, which is never sent to clients
+	Err error
+}
+
+// Error returns the detail, and the cause if there is one
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Kind.Title()
+	}
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error of kind
+func New(kind Kind, detail string) *Error {
+	return &Error{Kind: kind, Detail: detail}
+}
+
+// Newf returns an error of kind with a formatted detail
+func Newf(kind Kind, format string, args ...interface{}) *Error {
+	return New(kind, fmt.Sprintf(format, args...))
+}
+
+// Wrap returns an error of kind caused by err
+func Wrap(kind Kind, detail string, err error) *Error {
+	return &Error{Kind: kind, Detail: detail, Err: err}
+}
+
+// Validation reports an invalid request, with the fields at fault
+func Validation(detail string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Detail: detail, Fields: fields}
+}
+
+// Unauthorized reports a request without valid credentials
+func Unauthorized(detail string) *Error { return New(KindUnauthorized, detail) }
+
+// Forbidden reports a caller without the permission for a request
+func Forbidden(detail string) *Error { return New(KindForbidden, detail) }
+
+// NotFound reports a missing resource
+func NotFound(detail string) *Error { return New(KindNotFound, detail) }
+
+// Conflict reports a request at odds with the state of a resource
+func Conflict(detail string) *Error { return New(KindConflict, detail) }
+
+// PreconditionFailed reports a failed conditional request
+func PreconditionFailed(detail string) *Error { return New(KindPreconditionFailed, detail) }
+
+// FailedDependency reports an operation not performed because another
+// one of the same request failed
+func FailedDependency(detail string) *Error { return New(KindFailedDependency, detail) }
+
+// RateLimited reports a client over its quota
+func RateLimited(detail string) *Error { return New(KindRateLimited, detail) }
+
+// Unavailable reports a failed call to a service the API depends on
+func Unavailable(detail string, err error) *Error { return Wrap(KindUnavailable, detail, err) }
+
+// Internal reports an unexpected failure. The detail is sent to clients,
+// the cause is not.
+func Internal(detail string, err error) *Error { return Wrap(KindInternal, detail, err) }
+
+// KindOf returns the kind of err, KindInternal if it is not typed
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
+// Problem is the RFC 7807 body of an error response
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// RequestID lets clients quote the request when reporting problems
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// From returns the problem details of err. Untyped errors become internal
+// errors with a generic detail.
+func From(err error) *Problem {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Kind: KindInternal}
+	}
+	return &Problem{
+		Type:   e.Kind.Type(),
+		Title:  e.Kind.Title(),
+		Status: e.Kind.Status(),
+		Detail: e.Detail,
+		Errors: e.Fields,
+	}
+}
@@ -0,0 +1,101 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
+)
+
+func TestKinds(t *testing.T) {
+	for kind := KindInternal; kind <= KindUnavailable; kind++ {
+		if !strings.HasPrefix(kind.Type(), TypeBase) || kind.String() == "" || kind.Title() == "" {
+			t.Errorf("Kind %d is not described", kind)
+		}
+		if kind.Status() < 400 || kind.Status() > 599 {
+			t.Errorf("Kind %s has status %d", kind, kind.Status())
+		}
+	}
+	if got := KindNotFound.Type(); got != TypeBase+"not-found" {
+		t.Errorf("Expected a stable type URI, got %s", got)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := fmt.Errorf("calling calculator: %w", Unavailable("Calculator service unavailable", cause))
+
+	if KindOf(err) != KindUnavailable {
+		t.Errorf("Expected %s, got %s", KindUnavailable, KindOf(err))
+	}
+	if !errors.Is(err, cause) {
+		t.Error("Expected the cause to be unwrapped")
+	}
+	if KindOf(cause) != KindInternal {
+		t.Errorf("Expected untyped errors to be internal, got %s", KindOf(cause))
+	}
+}
+
+func TestFrom(t *testing.T) {
+	p := From(Validation("Invalid message", FieldError{In: "body", Field: "content", Message: "is required"}))
+	if p.Type != KindValidation.Type() || p.Status != http.StatusBadRequest || p.Detail != "Invalid message" {
+		t.Errorf("Unexpected problem %+v", p)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "content" {
+		t.Errorf("Expected the field error, got %v", p.Errors)
+	}
+
+	// Untyped errors and causes never reach clients
+	for _, err := range []error{errors.New("pq: password authentication failed"), Internal("", errors.New("secret"))} {
+		p := From(err)
+		if p.Status != http.StatusInternalServerError || p.Detail != "" {
+			t.Errorf("Expected a bare internal error, got %+v", p)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	handler := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, NotFound("Message not found"))
+	}))
+	req := httptest.NewRequest("GET", "/api/messages/7", nil)
+	req.Header.Set(requestid.Header, "req-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected Content-Type %s, got %s", ContentType, ct)
+	}
+	var p Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	want := Problem{
+		Type:      KindNotFound.Type(),
+		Title:     KindNotFound.Title(),
+		Status:    http.StatusNotFound,
+		Detail:    "Message not found",
+		Instance:  "/api/messages/7",
+		RequestID: "req-1",
+	}
+	if p.Type != want.Type || p.Title != want.Title || p.Status != want.Status || p.Detail != want.Detail ||
+		p.Instance != want.Instance || p.RequestID != want.RequestID {
+		t.Errorf("Expected %+v, got %+v", want, p)
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	MethodNotAllowedHandler().ServeHTTP(rr, httptest.NewRequest("PATCH", "/api/health", nil))
+	if rr.Code != http.StatusMethodNotAllowed || !strings.Contains(rr.Body.String(), KindMethodNotAllowed.Type()) {
+		t.Errorf("Expected a method not allowed problem, got %d %s", rr.Code, rr.Body)
+	}
+}
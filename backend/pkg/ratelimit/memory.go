@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops idle clients
+const sweepInterval = time.Minute
+
+// MemoryStore keeps counters in process memory. It suits a single
+// instance; use RedisStore when several instances share the limits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time
+}
+
+type window struct {
+	hits    []time.Time
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		windows: make(map[string]*window),
+	}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	if limit.algorithm() == SlidingWindow {
+		return s.slidingWindow(key, limit, now), nil
+	}
+	return s.tokenBucket(key, limit, now), nil
+}
+
+func (s *MemoryStore) tokenBucket(key string, limit Limit, now time.Time) Result {
+	capacity := float64(limit.capacity())
+	rate := float64(limit.Requests) / float64(limit.Window) // tokens per nanosecond
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)*rate)
+		b.updated = now
+	}
+
+	res := Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = nanos((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = nanos((capacity - b.tokens) / rate)
+	b.expires = now.Add(res.Reset)
+	return res
+}
+
+func (s *MemoryStore) slidingWindow(key string, limit Limit, now time.Time) Result {
+	w, ok := s.windows[key]
+	if !ok {
+		w = &window{}
+		s.windows[key] = w
+	}
+
+	// Forget hits that left the window
+	start := now.Add(-limit.Window)
+	i := 0
+	for i < len(w.hits) && !w.hits[i].After(start) {
+		i++
+	}
+	w.hits = w.hits[i:]
+
+	res := Result{Limit: limit}
+	if len(w.hits) < limit.Requests {
+		w.hits = append(w.hits, now)
+		res.Allowed = true
+	}
+	res.Remaining = limit.Requests - len(w.hits)
+	res.Reset = w.hits[0].Add(limit.Window).Sub(now)
+	if !res.Allowed {
+		res.RetryAfter = res.Reset
+	}
+	w.expires = w.hits[len(w.hits)-1].Add(limit.Window)
+	return res
+}
+
+// sweep drops clients whose quota is full again, which bounds memory to
+// the clients seen recently
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.expires) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		if !now.Before(w.expires) {
+			delete(s.windows, key)
+		}
+	}
+}
+
+// Len returns the number of clients being tracked
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets) + len(s.windows)
+}
+
+// nanos rounds up, float error must not make clients retry too early
+func nanos(ns float64) time.Duration {
+	return time.Duration(math.Ceil(ns))
+}
@@ -0,0 +1,353 @@
+// Package ratelimit throttles HTTP clients for the services of the course.
+//
+// A Limiter picks a Policy for each request, either the one registered for
+// its route or the default, and asks a Store whether the client identified
+// by the policy's KeyFunc may proceed. Two algorithms are available:
+//
+//   - TokenBucket refills Requests tokens per Window and allows bursts of up
+//     to Burst requests
+//   - SlidingWindow allows at most Requests in any Window
+//
+// Responses carry the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers of the IETF draft, and rejected requests a
+// Retry-After header. How a rejection is rendered is left to each router.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithm selects how requests are counted
+type Algorithm string
+
+// Supported algorithms
+const (
+	TokenBucket   Algorithm = "token_bucket"
+	SlidingWindow Algorithm = "sliding_window"
+)
+
+// ParseAlgorithm accepts the names of the supported algorithms
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(strings.ToLower(strings.TrimSpace(s))); a {
+	case TokenBucket, SlidingWindow:
+		return a, nil
+	default:
+		return "", fmt.Errorf("ratelimit: unknown algorithm %q, expected %s or %s", s, TokenBucket, SlidingWindow)
+	}
+}
+
+// Limit allows Requests per Window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+
+	// Algorithm defaults to TokenBucket
+	Algorithm Algorithm
+
+	// Burst is the bucket capacity of TokenBucket, Requests if zero. It is
+	// ignored by SlidingWindow.
+	Burst int
+}
+
+// ParseLimit parses limits written as "100/1m" or "5/s"
+func ParseLimit(s string) (Limit, error) {
+	requests, window, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: limit %q must look like 100/1m", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil {
+		return Limit{}, fmt.Errorf("ratelimit: invalid request count in %q", s)
+	}
+
+	// Allow "s" as a shorthand for "1s"
+	if window != "" && (window[0] < '0' || window[0] > '9') {
+		window = "1" + window
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return Limit{}, fmt.Errorf("ratelimit: invalid window in %q", s)
+	}
+
+	limit := Limit{Requests: n, Window: d}
+	return limit, limit.Validate()
+}
+
+// Validate reports limits that cannot be enforced
+func (l Limit) Validate() error {
+	if l.Requests <= 0 {
+		return errors.New("ratelimit: requests must be positive")
+	}
+	if l.Window <= 0 {
+		return errors.New("ratelimit: window must be positive")
+	}
+	if l.Burst < 0 {
+		return errors.New("ratelimit: burst must not be negative")
+	}
+	if l.Algorithm != "" {
+		if _, err := ParseAlgorithm(string(l.Algorithm)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l Limit) algorithm() Algorithm {
+	if l.Algorithm == "" {
+		return TokenBucket
+	}
+	return l.Algorithm
+}
+
+// capacity is the number of requests a client can make at once
+func (l Limit) capacity() int {
+	if l.algorithm() == TokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// Result is the outcome of a single request
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+
+	// Reset is the time until the client regains one request of quota
+	// (SlidingWindow) or its full burst (TokenBucket)
+	Reset time.Duration
+
+	// RetryAfter is set on rejected requests
+	RetryAfter time.Duration
+}
+
+// Store counts requests. Implementations must be safe for concurrent use
+// and apply each call atomically.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// KeyFunc identifies the client of a request. It returns false if it
+// cannot, so that another KeyFunc may be tried.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// ByIP identifies clients by the remote address of the connection
+func ByIP(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host, host != ""
+}
+
+// ByAPIKey identifies clients by an API key sent in header. Keys are
+// hashed so that they never reach the store.
+func ByAPIKey(header string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		key := r.Header.Get(header)
+		if key == "" {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:12]), true
+	}
+}
+
+// ByUser identifies clients by the user ID returned by user
+func ByUser(user func(r *http.Request) (string, bool)) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		id, ok := user(r)
+		if !ok || id == "" {
+			return "", false
+		}
+		return "user:" + id, true
+	}
+}
+
+// FirstOf uses the first KeyFunc that identifies the client
+func FirstOf(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, key := range keys {
+			if k, ok := key(r); ok {
+				return k, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Policy is a limit applied to clients identified by Key
+type Policy struct {
+	// Name separates the counters of different policies, so a client can
+	// have its own quota on each route. It defaults to the route.
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// Limiter applies per-route policies backed by a Store
+type Limiter struct {
+	store  Store
+	prefix string
+	def    *Policy
+	routes map[string]Policy
+	exempt map[string]bool
+	now    func() time.Time
+}
+
+// New creates a limiter without any policy. Requests are only limited
+// once SetDefault or Route has been called.
+func New(store Store) *Limiter {
+	return &Limiter{
+		store:  store,
+		prefix: "ratelimit",
+		routes: make(map[string]Policy),
+		exempt: make(map[string]bool),
+		now:    time.Now,
+	}
+}
+
+// SetDefault sets the policy of routes without a policy of their own
+func (l *Limiter) SetDefault(p Policy) error {
+	p, err := l.checkPolicy(p, "default")
+	if err != nil {
+		return err
+	}
+	l.def = &p
+	return nil
+}
+
+// Route sets the policy of a route template such as /api/messages/{id}.
+// An empty method applies the policy to every method of the route.
+func (l *Limiter) Route(method, route string, p Policy) error {
+	name := route
+	if method != "" {
+		name = method + " " + route
+	}
+	p, err := l.checkPolicy(p, name)
+	if err != nil {
+		return err
+	}
+	l.routes[routeKey(method, route)] = p
+	return nil
+}
+
+// Exempt excludes routes from the default policy, e.g. health checks and
+// metrics scraped by the infrastructure
+func (l *Limiter) Exempt(routes ...string) {
+	for _, route := range routes {
+		l.exempt[route] = true
+	}
+}
+
+func (l *Limiter) checkPolicy(p Policy, name string) (Policy, error) {
+	if err := p.Limit.Validate(); err != nil {
+		return p, fmt.Errorf("%w (policy %s)", err, name)
+	}
+	if p.Key == nil {
+		p.Key = ByIP
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	return p, nil
+}
+
+func routeKey(method, route string) string {
+	return strings.ToUpper(method) + " " + route
+}
+
+func (l *Limiter) policy(method, route string) (Policy, bool) {
+	if p, ok := l.routes[routeKey(method, route)]; ok {
+		return p, true
+	}
+	if p, ok := l.routes[routeKey("", route)]; ok {
+		return p, true
+	}
+	if l.def != nil && !l.exempt[route] {
+		return *l.def, true
+	}
+	return Policy{}, false
+}
+
+// Check counts a request against the policy of its route. It returns nil
+// if no policy applies or the client cannot be identified.
+func (l *Limiter) Check(r *http.Request, route string) (*Result, error) {
+	p, ok := l.policy(r.Method, route)
+	if !ok {
+		return nil, nil
+	}
+	client, ok := p.Key(r)
+	if !ok {
+		return nil, nil
+	}
+
+	res, err := l.store.Allow(r.Context(), l.prefix+":"+p.Name+":"+client, p.Limit, l.now())
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RouteFunc returns the route template of a request
+type RouteFunc func(r *http.Request) string
+
+// DenyFunc renders a rejected request. The rate limit headers have already
+// been set.
+type DenyFunc func(w http.ResponseWriter, r *http.Request, res *Result)
+
+// Middleware limits the requests of a router. Store failures let requests
+// through, so an outage of Redis does not take the API down with it.
+// Its result matches mux.MiddlewareFunc.
+func (l *Limiter) Middleware(route RouteFunc, deny DenyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := l.Check(r, route(r))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "rate limit check failed", "error", err)
+			}
+			if res != nil {
+				SetHeaders(w.Header(), res)
+				if !res.Allowed {
+					deny(w, r, res)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetHeaders sets the rate limit headers of a response
+func SetHeaders(h http.Header, res *Result) {
+	limit := res.Limit
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, seconds(limit.Window))
+	if limit.algorithm() == TokenBucket && limit.Burst > 0 {
+		policy += fmt.Sprintf(";burst=%d", limit.Burst)
+	}
+
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.capacity()))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(seconds(res.Reset), 10))
+	h.Set("RateLimit-Policy", policy)
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(seconds(res.RetryAfter), 10))
+	}
+}
+
+// seconds rounds d up, so clients never retry too early
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
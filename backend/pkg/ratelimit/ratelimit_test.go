@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{"100/1m", Limit{Requests: 100, Window: time.Minute}, false},
+		{"5/s", Limit{Requests: 5, Window: time.Second}, false},
+		{" 10/2h ", Limit{Requests: 10, Window: 2 * time.Hour}, false},
+		{"100", Limit{}, true},
+		{"x/1m", Limit{}, true},
+		{"0/1m", Limit{}, true},
+		{"10/forever", Limit{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, expected error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, expected %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:5555"
+
+	if key, _ := ByIP(r); key != "ip:203.0.113.7" {
+		t.Errorf("Expected ip:203.0.113.7, got %s", key)
+	}
+
+	apiKey := ByAPIKey("X-API-Key")
+	if _, ok := apiKey(r); ok {
+		t.Error("Expected no key without the header")
+	}
+	r.Header.Set("X-API-Key", "secret-key")
+	key, ok := apiKey(r)
+	if !ok || key == "key:secret-key" {
+		t.Errorf("Expected a hashed API key, got %q", key)
+	}
+
+	noUser := ByUser(func(*http.Request) (string, bool) { return "", false })
+	if key, _ := FirstOf(noUser, apiKey, ByIP)(r); key[:4] != "key:" {
+		t.Errorf("Expected the API key to be chosen, got %s", key)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := New(NewMemoryStore())
+	if err := limiter.SetDefault(Policy{Limit: Limit{Requests: 3, Window: time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Route("POST", "/login", Policy{Limit: Limit{Requests: 1, Window: time.Minute, Algorithm: SlidingWindow}}); err != nil {
+		t.Fatal(err)
+	}
+	limiter.Exempt("/health")
+	if err := limiter.Route("", "/bad", Policy{}); err == nil {
+		t.Error("Expected an invalid policy to be refused")
+	}
+
+	var denied int
+	handler := limiter.Middleware(
+		func(r *http.Request) string { return r.URL.Path },
+		func(w http.ResponseWriter, r *http.Request, res *Result) {
+			denied++
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	rr := do("POST", "/login")
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Policy") != "1;w=60" {
+		t.Errorf("Expected the login policy, got %d %v", rr.Code, rr.Header())
+	}
+	rr = do("POST", "/login")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 429 with Retry-After 60, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	// Other routes use the default policy and their own counter
+	for i := 0; i < 3; i++ {
+		if rr := do("GET", "/login"); rr.Code != http.StatusOK {
+			t.Errorf("Request %d: expected 200, got %d", i, rr.Code)
+		}
+	}
+	rr = do("GET", "/login")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the default policy to run out, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("Expected RateLimit-Remaining 0, got %q", got)
+	}
+	for i := 0; i < 5; i++ {
+		if rr := do("GET", "/health"); rr.Code != http.StatusOK {
+			t.Errorf("Expected exempt routes to be unlimited, got %d", rr.Code)
+		}
+	}
+	if denied != 2 {
+		t.Errorf("Expected 2 denied requests, got %d", denied)
+	}
+}
+
+func TestMiddlewareWithoutPolicy(t *testing.T) {
+	limiter := New(NewMemoryStore())
+	handler := limiter.Middleware(
+		func(r *http.Request) string { return r.URL.Path },
+		func(w http.ResponseWriter, r *http.Request, res *Result) { t.Error("Unexpected denial") },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Header().Get("RateLimit-Limit") != "" {
+		t.Error("Expected no rate limit headers without a policy")
+	}
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills and takes a token atomically. Times are in
+// milliseconds. Lua numbers are truncated when returned to Redis, so the
+// token count travels as a string.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil then
+	tokens = capacity
+	ts = now
+end
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// slidingWindowScript keeps the hits of the window in a sorted set scored
+// by time. It returns whether the hit was counted, the number of hits in
+// the window and the time of the oldest one.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {allowed, count, oldest[2]}
+`)
+
+// RedisStore keeps counters in Redis so that several instances share the
+// limits. Each check is a single Lua script and therefore atomic.
+type RedisStore struct {
+	client redis.Scripter
+}
+
+// NewRedisStore creates a store using client
+func NewRedisStore(client redis.Scripter) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	// Redis keeps time in milliseconds
+	if limit.Window < time.Millisecond {
+		return Result{}, fmt.Errorf("ratelimit: window %v is shorter than a millisecond", limit.Window)
+	}
+	if limit.algorithm() == SlidingWindow {
+		return s.slidingWindow(ctx, key, limit, now)
+	}
+	return s.tokenBucket(ctx, key, limit, now)
+}
+
+func (s *RedisStore) tokenBucket(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	capacity := float64(limit.capacity())
+	rate := float64(limit.Requests) / float64(limit.Window.Milliseconds()) // tokens per millisecond
+
+	reply, err := tokenBucketScript.Run(ctx, s.client, []string{key},
+		limit.capacity(), strconv.FormatFloat(rate, 'g', -1, 64), now.UnixMilli()).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: %w", err)
+	}
+	if len(reply) != 2 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+
+	allowed, _ := reply[0].(int64)
+	text, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: invalid token count %q", text)
+	}
+
+	res := Result{
+		Allowed:   allowed == 1,
+		Limit:     limit,
+		Remaining: int(tokens),
+		Reset:     millis((capacity - tokens) / rate),
+	}
+	if !res.Allowed {
+		res.RetryAfter = millis((1 - tokens) / rate)
+	}
+	return res, nil
+}
+
+func (s *RedisStore) slidingWindow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	// Members must be unique, two hits can share a millisecond
+	nowMs := now.UnixMilli()
+	member := strconv.FormatInt(nowMs, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	reply, err := slidingWindowScript.Run(ctx, s.client, []string{key},
+		limit.Requests, limit.Window.Milliseconds(), nowMs, member).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: %w", err)
+	}
+	if len(reply) != 3 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+
+	allowed, _ := reply[0].(int64)
+	count, _ := reply[1].(int64)
+	text, _ := reply[2].(string)
+	score, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: invalid score %q", text)
+	}
+	oldest := int64(score)
+
+	res := Result{
+		Allowed:   allowed == 1,
+		Limit:     limit,
+		Remaining: limit.Requests - int(count),
+		Reset:     time.Duration(oldest+limit.Window.Milliseconds()-nowMs) * time.Millisecond,
+	}
+	if !res.Allowed {
+		res.RetryAfter = res.Reset
+	}
+	return res, nil
+}
+
+func millis(ms float64) time.Duration {
+	return nanos(ms * float64(time.Millisecond))
+}
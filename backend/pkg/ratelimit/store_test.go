@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testStore runs the behaviour every Store must share
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+	start := time.Unix(1_700_000_000, 0)
+
+	t.Run("token bucket", func(t *testing.T) {
+		store := newStore(t)
+		limit := Limit{Requests: 2, Window: time.Second, Burst: 3}
+
+		for i := 0; i < 3; i++ {
+			res, err := store.Allow(ctx, "tb", limit, start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Allowed || res.Remaining != 2-i {
+				t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 2-i, res)
+			}
+		}
+
+		res, _ := store.Allow(ctx, "tb", limit, start)
+		if res.Allowed {
+			t.Fatal("Expected the fourth request of a burst of 3 to be rejected")
+		}
+		if res.RetryAfter != 500*time.Millisecond {
+			t.Errorf("Expected to retry after 500ms, got %v", res.RetryAfter)
+		}
+
+		// Half a second refills one token
+		res, _ = store.Allow(ctx, "tb", limit, start.Add(500*time.Millisecond))
+		if !res.Allowed || res.Remaining != 0 {
+			t.Errorf("Expected a refilled token, got %+v", res)
+		}
+
+		if res, _ := store.Allow(ctx, "other", limit, start); !res.Allowed {
+			t.Error("Expected clients to have separate buckets")
+		}
+	})
+
+	t.Run("sliding window", func(t *testing.T) {
+		store := newStore(t)
+		limit := Limit{Requests: 2, Window: time.Minute, Algorithm: SlidingWindow}
+
+		store.Allow(ctx, "sw", limit, start)
+		res, _ := store.Allow(ctx, "sw", limit, start.Add(30*time.Second))
+		if !res.Allowed || res.Remaining != 0 {
+			t.Fatalf("Expected the second request to be allowed, got %+v", res)
+		}
+
+		res, _ = store.Allow(ctx, "sw", limit, start.Add(45*time.Second))
+		if res.Allowed {
+			t.Fatal("Expected the third request in the window to be rejected")
+		}
+		if res.RetryAfter != 15*time.Second {
+			t.Errorf("Expected to retry after 15s, got %v", res.RetryAfter)
+		}
+
+		// The first hit has left the window
+		res, _ = store.Allow(ctx, "sw", limit, start.Add(61*time.Second))
+		if !res.Allowed || res.Reset != 29*time.Second {
+			t.Errorf("Expected a slot after the first hit expired, got %+v", res)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemoryStore() })
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 10, Window: time.Second}
+	start := time.Unix(1_700_000_000, 0)
+
+	store.Allow(context.Background(), "a", limit, start)
+	store.Allow(context.Background(), "b", limit, start.Add(2*sweepInterval))
+	if n := store.Len(); n != 1 {
+		t.Errorf("Expected the idle client to be dropped, %d tracked", n)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewRedisStore(client)
+	})
+}
+
+func TestRedisStoreExpiresKeys(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	store := NewRedisStore(client)
+	limit := Limit{Requests: 5, Window: time.Minute, Algorithm: SlidingWindow}
+	if _, err := store.Allow(context.Background(), "ratelimit:test", limit, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := server.TTL("ratelimit:test"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected the key to expire within the window, got TTL %v", ttl)
+	}
+	server.FastForward(time.Minute)
+	if server.Exists("ratelimit:test") {
+		t.Error("Expected the key to be gone after the window")
+	}
+}
+
+func TestRedisStoreUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	defer client.Close()
+	server.Close()
+
+	store := NewRedisStore(client)
+	if _, err := store.Allow(context.Background(), "k", Limit{Requests: 1, Window: time.Second}, time.Now()); err == nil {
+		t.Error("Expected an error when Redis is down")
+	}
+}
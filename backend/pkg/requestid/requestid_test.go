@@ -0,0 +1,77 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	a, b := New(), New()
+	if len(a) != 32 || a == b {
+		t.Errorf("Expected two distinct 32 character IDs, got %q and %q", a, b)
+	}
+	if !Valid(a) {
+		t.Errorf("Expected generated ID %q to be valid", a)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		received string
+		keep     bool
+	}{
+		{"abc-123", true},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793", true},
+		{"", false},
+		{"has space", false},
+		{"line\nbreak", false},
+		{"<script>", false},
+		{strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		got := Resolve(tt.received)
+		if tt.keep && got != tt.received {
+			t.Errorf("Expected %q to be kept, got %q", tt.received, got)
+		}
+		if !tt.keep && (got == tt.received || !Valid(got)) {
+			t.Errorf("Expected %q to be replaced, got %q", tt.received, got)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if id := FromContext(ctx); id != "" {
+		t.Errorf("Expected no ID, got %q", id)
+	}
+
+	ctx = NewContext(ctx, "req-1")
+	if id := FromContext(ctx); id != "req-1" {
+		t.Errorf("Expected req-1, got %q", id)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(Header, "abc-123")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if seen != "abc-123" || rr.Header().Get(Header) != "abc-123" {
+		t.Errorf("Expected the received ID to be kept, got %q and %q", seen, rr.Header().Get(Header))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if !Valid(seen) || seen == "abc-123" || rr.Header().Get(Header) != seen {
+		t.Errorf("Expected a new ID, got %q and %q", seen, rr.Header().Get(Header))
+	}
+}
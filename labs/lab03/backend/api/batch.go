@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// batchStatus is the status of each kind of operation when it succeeds
+var batchStatus = map[string]int{
+	storage.BatchCreate: http.StatusCreated,
+	storage.BatchUpdate: http.StatusOK,
+	storage.BatchDelete: http.StatusNoContent,
+}
+
+// BatchMessages handles POST /api/messages:batch. It applies up to
+// models.MaxBatchOperations creates, updates and deletes in order, all or
+// none unless the mode is best_effort. Operations follow the rules of
+// their own routes: only members post in a room, and messages of private
+// rooms are not found for other requesters.
+//
+// Processed batches answer 200 with one result per operation, holding
+// the status and problem the operation would have had on its own.
+// Operations of a failed atomic batch that did not fail themselves report
+// 424 Failed Dependency.
+func (h *Handler) BatchMessages(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+	var fields []problem.FieldError
+	for i, op := range req.Operations {
+		if err := op.Validate(); err != nil {
+			fields = append(fields, problem.FieldError{In: "body", Field: fmt.Sprintf("operations[%d]", i), Message: err.Error()})
+		}
+	}
+	if len(fields) > 0 {
+		h.writeError(w, r, problem.Validation("Invalid operations", fields...))
+		return
+	}
+
+	response := models.BatchResponse{Mode: req.Mode, Results: make([]models.BatchResult, len(req.Operations))}
+	if response.Mode == "" {
+		response.Mode = models.BatchAtomic
+	}
+	atomic := response.Mode == models.BatchAtomic
+
+	// Operations the requester may not perform never reach the store
+	var ops []storage.BatchOp
+	var positions []int
+	results := make([]storage.BatchResult, len(req.Operations))
+	for i, op := range req.Operations {
+		if err := h.checkBatchOperation(r, op); err != nil {
+			results[i].Err = err
+			if atomic {
+				ops = nil
+				for j := range results {
+					if j != i {
+						results[j].Err = storage.ErrBatchAborted
+					}
+				}
+				break
+			}
+			continue
+		}
+		ops = append(ops, storage.BatchOp{
+			Op:       op.Op,
+			Room:     op.Room,
+			ParentID: op.ParentID,
+			Username: op.Username,
+			Content:  op.Content,
+			ID:       op.ID,
+			Version:  op.Version,
+		})
+		positions = append(positions, i)
+	}
+	if len(ops) > 0 {
+		applied, err := h.storage.Batch(ops, atomic)
+		if err != nil {
+			h.writeError(w, r, problem.Internal("Failed to apply batch", err))
+			return
+		}
+		for k, result := range applied {
+			results[positions[k]] = result
+		}
+	}
+
+	for i, result := range results {
+		response.Results[i] = batchResult(i, req.Operations[i].Op, result)
+		if result.Err == nil {
+			response.Applied++
+		} else {
+			response.Failed++
+		}
+	}
+	h.writeJSON(w, http.StatusOK, models.APIResponse{
+		Success: response.Failed == 0,
+		Data:    response,
+	})
+}
+
+// checkBatchOperation applies the access rules of the routes of an
+// operation. The store checks that rooms, parents and messages exist.
+func (h *Handler) checkBatchOperation(r *http.Request, op models.BatchOperation) error {
+	name := op.Room
+	if op.Op != storage.BatchCreate {
+		room, err := h.storage.RoomOf(op.ID)
+		if err != nil {
+			return nil
+		}
+		name = room
+	}
+	if name == "" {
+		return nil
+	}
+
+	room, err := h.storage.GetRoom(name)
+	if err != nil {
+		return err
+	}
+	if !room.CanRead(requester(r)) {
+		if op.Op == storage.BatchCreate {
+			return storage.ErrRoomNotFound
+		}
+		return storage.ErrMessageNotFound
+	}
+	if op.Op == storage.BatchCreate && !room.IsMember(op.Username) {
+		return problem.Forbidden("Only members can post in this room")
+	}
+	return nil
+}
+
+// batchResult reports the outcome of the operation at index
+func batchResult(index int, op string, result storage.BatchResult) models.BatchResult {
+	if result.Err != nil {
+		p := problem.From(storageProblem(result.Err, "Failed to apply operation"))
+		return models.BatchResult{Index: index, Status: p.Status, Error: p}
+	}
+	res := models.BatchResult{Index: index, Status: batchStatus[op]}
+	if op != storage.BatchDelete {
+		res.Message = result.Message
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// etag returns the entity tag of a message. Replies and reactions change
+// the representation but not the version, so the tag is the quoted version
+// followed by a hash of them once there are any, like "3-5d1f0a2c".
+func etag(message *models.Message) string {
+	tag := strconv.Itoa(message.Version)
+	if message.ReplyCount > 0 || len(message.Reactions) > 0 {
+		emojis := make([]string, 0, len(message.Reactions))
+		for emoji := range message.Reactions {
+			emojis = append(emojis, emoji)
+		}
+		sort.Strings(emojis)
+
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%d", message.ReplyCount)
+		for _, emoji := range emojis {
+			fmt.Fprintf(h, "\x00%s\x00%d", emoji, message.Reactions[emoji])
+		}
+		tag += fmt.Sprintf("-%08x", h.Sum32())
+	}
+	return `"` + tag + `"`
+}
+
+// matchETag reports whether a list of entity tags from If-None-Match
+// contains tag, using the weak comparison
+func matchETag(list []string, tag string) bool {
+	for _, candidate := range etagCandidates(list) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchVersion reports whether a list of entity tags from If-Match
+// contains a tag of version. It uses the strong comparison, which never
+// matches weak tags, and ignores the hash of replies and reactions, so
+// they do not fail writes.
+func matchVersion(list []string, version int) bool {
+	for _, candidate := range etagCandidates(list) {
+		if candidate == "*" {
+			return true
+		}
+		tag, ok := strings.CutPrefix(candidate, `"`)
+		if !ok || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		tag, _, _ = strings.Cut(strings.TrimSuffix(tag, `"`), "-")
+		if tag == strconv.Itoa(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagCandidates splits the values of If-Match or If-None-Match headers
+// into entity tags
+func etagCandidates(list []string) []string {
+	var candidates []string
+	for _, header := range list {
+		for _, candidate := range strings.Split(header, ",") {
+			candidates = append(candidates, strings.TrimSpace(candidate))
+		}
+	}
+	return candidates
+}
+
+// precondition evaluates If-Match for a write to message id. It returns
+// the version the write must apply to, or writes an error and returns
+// false.
+func (h *Handler) precondition(w http.ResponseWriter, r *http.Request, id int) (int, bool) {
+	ifMatch := r.Header.Values("If-Match")
+	if len(ifMatch) == 0 {
+		return storage.AnyVersion, true
+	}
+
+	current, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to read message")
+		return 0, false
+	}
+	if !matchVersion(ifMatch, current.Version) {
+		h.writeError(w, r, problem.PreconditionFailed("Message has been modified"))
+		return 0, false
+	}
+	return current.Version, true
+}
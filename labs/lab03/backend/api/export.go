@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"io"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// exportPageSize is the number of messages read from storage at a time
+const exportPageSize = 500
+
+// messageWriter writes messages in an export format. Close completes the
+// document, it does not close the underlying writer.
+type messageWriter interface {
+	Write(message *models.Message) error
+	Close() error
+}
+
+// exportFormat is a format of ExportMessages
+type exportFormat struct {
+	contentType string
+	// newWriter starts a document titled after the exported room
+	newWriter func(w io.Writer, room string) (messageWriter, error)
+}
+
+// exportFormats are the formats of ExportMessages by name, which is also
+// the file extension
+var exportFormats = map[string]exportFormat{
+	"jsonl": {"application/x-ndjson", newJSONLWriter},
+	"csv":   {"text/csv; charset=utf-8", newCSVWriter},
+	"html":  {"text/html; charset=utf-8", newHTMLWriter},
+}
+
+// csvColumns are the columns of CSV exports, which imports read by name
+var csvColumns = []string{"id", "username", "content", "timestamp", "edited_at", "version", "parent_id", "room"}
+
+// ExportMessages handles GET /api/messages/export. It streams the
+// messages of the lobby, or of the room parameter, ordered by ID as
+// format=jsonl (the default), csv or html. The username, since and until
+// parameters filter like on GetMessages. Deleted messages are left out.
+//
+// Messages are read from storage a page at a time. If reading fails
+// midway, the connection is aborted so clients do not mistake a partial
+// export for a complete one.
+func (h *Handler) ExportMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("format")
+	if name == "" {
+		name = "jsonl"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		h.writeError(w, r, problem.Validation("format must be jsonl, csv or html"))
+		return
+	}
+
+	opts := storage.ListOptions{
+		Room:     query.Get("room"),
+		Username: query.Get("username"),
+		SortBy:   storage.SortByID,
+		Limit:    exportPageSize,
+	}
+	var err error
+	if opts.Since, err = parseTime(query, "since"); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+	if opts.Until, err = parseTime(query, "until"); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+	if opts.Room != "" {
+		room, err := h.storage.GetRoom(opts.Room)
+		if err == nil && !room.CanRead(requester(r)) {
+			err = storage.ErrRoomNotFound
+		}
+		if err != nil {
+			h.writeStorageError(w, r, err, "Failed to read room")
+			return
+		}
+	}
+
+	page, err := h.storage.List(opts)
+	if err != nil {
+		h.writeError(w, r, problem.Internal("Failed to list messages", err))
+		return
+	}
+
+	// Large exports outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	filename := "messages"
+	if opts.Room != "" {
+		filename = opts.Room
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+"."+name+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	mw, err := format.newWriter(w, opts.Room)
+	if err != nil {
+		return
+	}
+	for {
+		for _, message := range page.Messages {
+			if err := mw.Write(message); err != nil {
+				return
+			}
+		}
+		if !page.HasMore {
+			break
+		}
+		if rc.Flush() != nil {
+			return
+		}
+		last := storage.PositionOf(page.Messages[len(page.Messages)-1])
+		opts.After = &last
+		if page, err = h.storage.List(opts); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+	mw.Close()
+}
+
+// jsonlWriter writes one JSON message per line
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer, room string) (messageWriter, error) {
+	return jsonlWriter{json.NewEncoder(w)}, nil
+}
+
+func (jw jsonlWriter) Write(message *models.Message) error { return jw.encoder.Encode(message) }
+func (jw jsonlWriter) Close() error                        { return nil }
+
+// csvWriter writes a header of csvColumns and a record per message
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, room string) (messageWriter, error) {
+	writer := csv.NewWriter(w)
+	return csvWriter{writer}, writer.Write(csvColumns)
+}
+
+func (cw csvWriter) Write(message *models.Message) error {
+	record := []string{
+		strconv.Itoa(message.ID),
+		message.Username,
+		message.Content,
+		message.Timestamp.Format(time.RFC3339Nano),
+		"",
+		strconv.Itoa(message.Version),
+		"",
+		message.Room,
+	}
+	if message.EditedAt != nil {
+		record[4] = message.EditedAt.Format(time.RFC3339Nano)
+	}
+	if message.ParentID != nil {
+		record[6] = strconv.Itoa(*message.ParentID)
+	}
+	return cw.writer.Write(record)
+}
+
+func (cw csvWriter) Close() error {
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// htmlExport renders a standalone page, header and footer around one
+// item per message
+var htmlExport = template.Must(template.New("export").Parse(`
+{{- define "header" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; }
+li { margin-bottom: 1rem; }
+.meta { color: #666; font-size: 0.875rem; }
+p { margin: 0.25rem 0 0; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.}}</h1>
+<ol>
+{{end}}
+{{- define "message" -}}
+<li id="m{{.ID}}"><span class="meta"><strong>{{.Username}}</strong>
+<time datetime="{{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}}">{{.Timestamp.Format "2006-01-02 15:04"}}</time>
+{{- if .EditedAt}} (edited){{end}}
+{{- with .ParentID}} in reply to <a href="#m{{.}}">#{{.}}</a>{{end}}</span>
+<p>{{.Content}}</p></li>
+{{end}}
+{{- define "footer" -}}
+</ol>
+</body>
+</html>
+{{end}}`))
+
+// htmlWriter writes a page listing the messages
+type htmlWriter struct {
+	w io.Writer
+}
+
+func newHTMLWriter(w io.Writer, room string) (messageWriter, error) {
+	title := "Chat history of the lobby"
+	if room != "" {
+		title = "Chat history of " + room
+	}
+	return htmlWriter{w}, htmlExport.ExecuteTemplate(w, "header", title)
+}
+
+func (hw htmlWriter) Write(message *models.Message) error {
+	return htmlExport.ExecuteTemplate(hw.w, "message", message)
+}
+
+func (hw htmlWriter) Close() error {
+	return htmlExport.ExecuteTemplate(hw.w, "footer", nil)
+}
@@ -3,32 +3,92 @@ package api
 import (
 	"encoding/json"
 	"lab03-backend/models"
+	"lab03-backend/openapi"
 	"lab03-backend/storage"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics/muxroute"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem/muxproblem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
 )
 
+// defaultCORS allows any origin, which is what the lab frontend expects
+// during development
+var defaultCORS = cors.Options{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+	AllowedHeaders: []string{"Content-Type", "Authorization", "Last-Event-ID", "If-Match", "If-None-Match", userHeader, requestid.Header},
+	ExposedHeaders: []string{"ETag", requestid.Header, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+}
+
 // Handler holds the storage instance
 type Handler struct {
-	storage *storage.MemoryStorage
+	storage  storage.MessageStore
+	cors     *cors.Policy
+	registry *prometheus.Registry
+	metrics  *metrics.HTTP
+	limiter  *ratelimit.Limiter
+	// heartbeat is the interval of keep-alive comments on event streams
+	heartbeat time.Duration
+	// spec documents the routes, built by SetupRoutes
+	spec *openapi.Document
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(storage *storage.MemoryStorage) *Handler {
+func NewHandler(storage storage.MessageStore) *Handler {
+	registry := metrics.NewRegistry()
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "messages_stored",
+		Help: "Messages currently held in storage.",
+	}, func() float64 {
+		return float64(storage.Count())
+	}))
+
 	return &Handler{
-		storage: storage,
+		storage:   storage,
+		cors:      cors.MustNew(defaultCORS),
+		registry:  registry,
+		metrics:   metrics.NewHTTP(registry, "lab03-api"),
+		heartbeat: defaultHeartbeat,
 	}
 }
 
+// SetCORSPolicy replaces the default allow-all CORS policy. It must be
+// called before SetupRoutes.
+func (h *Handler) SetCORSPolicy(policy *cors.Policy) {
+	h.cors = policy
+}
+
+// SetRateLimiter limits requests with limiter. Requests are not limited by
+// default. It must be called before SetupRoutes.
+func (h *Handler) SetRateLimiter(limiter *ratelimit.Limiter) {
+	h.limiter = limiter
+}
+
 // SetupRoutes configures all API routes
 func (h *Handler) SetupRoutes() *mux.Router {
 	router := mux.NewRouter()
+	muxproblem.Install(router)
+
+	// Add request ID, metrics and CORS middleware
+	router.Use(requestid.Middleware)
+	router.Use(h.metrics.Middleware(muxroute.Template))
+	router.Use(h.cors.Handler)
+	if h.limiter != nil {
+		router.Use(h.limiter.Middleware(muxroute.Template, h.rateLimited))
+	}
 
-	// Add CORS middleware
-	router.Use(corsMiddleware)
+	// Prometheus metrics
+	router.Handle("/metrics", metrics.Handler(h.registry)).Methods("GET")
 
 	// Create API v1 subrouter with prefix "/api"
 	apiRouter := router.PathPrefix("/api").Subrouter()
@@ -36,42 +96,171 @@ func (h *Handler) SetupRoutes() *mux.Router {
 	// Add the following routes:
 	apiRouter.HandleFunc("/messages", h.GetMessages).Methods("GET")
 	apiRouter.HandleFunc("/messages", h.CreateMessage).Methods("POST")
-	apiRouter.HandleFunc("/messages/{id}", h.UpdateMessage).Methods("PUT")
-	apiRouter.HandleFunc("/messages/{id}", h.DeleteMessage).Methods("DELETE")
+	apiRouter.HandleFunc("/messages/stream", h.StreamMessages).Methods("GET")
+	apiRouter.HandleFunc("/messages/search", h.SearchMessages).Methods("GET")
+	apiRouter.HandleFunc("/messages:batch", h.BatchMessages).Methods("POST")
+	apiRouter.HandleFunc("/messages/export", h.ExportMessages).Methods("GET")
+	apiRouter.HandleFunc("/messages/import", h.ImportMessages).Methods("POST")
+
+	// Other methods of the paths above would reach the {id} routes below
+	// and fail as invalid IDs, so they are answered here
+	for path, allowed := range map[string]string{
+		"/messages/stream": "GET",
+		"/messages/search": "GET",
+		"/messages/export": "GET",
+		"/messages/import": "POST",
+	} {
+		apiRouter.Handle(path, methodNotAllowed(allowed)).MatcherFunc(isNotOptions)
+	}
+
+	// Messages of private rooms are hidden from non-members
+	messageRouter := apiRouter.PathPrefix("/messages/{id}").Subrouter()
+	messageRouter.Use(h.roomAccess)
+	messageRouter.HandleFunc("", h.GetMessage).Methods("GET")
+	messageRouter.HandleFunc("", h.UpdateMessage).Methods("PUT")
+	messageRouter.HandleFunc("", h.DeleteMessage).Methods("DELETE")
+	messageRouter.HandleFunc("/history", h.GetMessageHistory).Methods("GET")
+	messageRouter.HandleFunc("/restore", h.RestoreMessage).Methods("POST")
+	messageRouter.HandleFunc("/replies", h.GetMessageReplies).Methods("GET")
+	messageRouter.HandleFunc("/reactions/{emoji}", h.AddReaction).Methods("POST")
+	messageRouter.HandleFunc("/reactions/{emoji}", h.RemoveReaction).Methods("DELETE")
+
+	apiRouter.HandleFunc("/rooms", h.ListRooms).Methods("GET")
+	apiRouter.HandleFunc("/rooms", h.CreateRoom).Methods("POST")
+	apiRouter.HandleFunc("/rooms/{room}", h.GetRoom).Methods("GET")
+	apiRouter.HandleFunc("/rooms/{room}", h.UpdateRoom).Methods("PUT")
+	apiRouter.HandleFunc("/rooms/{room}", h.DeleteRoom).Methods("DELETE")
+	apiRouter.HandleFunc("/rooms/{room}/members", h.AddRoomMember).Methods("POST")
+	apiRouter.HandleFunc("/rooms/{room}/members/{username}", h.RemoveRoomMember).Methods("DELETE")
+	apiRouter.HandleFunc("/rooms/{room}/messages", h.GetRoomMessages).Methods("GET")
+	apiRouter.HandleFunc("/rooms/{room}/messages", h.CreateRoomMessage).Methods("POST")
+	apiRouter.HandleFunc("/rooms/{room}/messages/search", h.SearchRoomMessages).Methods("GET")
+
 	apiRouter.HandleFunc("/status/{code}", h.GetHTTPStatus).Methods("GET")
 	apiRouter.HandleFunc("/health", h.HealthCheck).Methods("GET")
+	apiRouter.HandleFunc("/openapi.json", h.GetOpenAPI).Methods("GET")
+
+	// Requests are validated against the document of the routes above
+	spec, err := openAPIDocument(router)
+	if err != nil {
+		panic(err)
+	}
+	h.spec = spec
+	router.Use(openapi.Middleware(h.spec, routeOf, h.invalidRequest))
+
+	// mux only runs middleware for matched routes, so preflight requests
+	// need a route of their own to reach the CORS policy. A method matcher
+	// would turn every unknown path into 405 Method Not Allowed.
+	router.MatcherFunc(isOptions).HandlerFunc(handleOptions)
 
 	return router
 }
 
-// GetMessages handles GET /api/messages
+// GetMessages handles GET /api/messages. See parseListOptions for the
+// supported query parameters.
 func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
-	messages := h.storage.GetAll()
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	h.writePage(w, r, opts)
+}
+
+// GetMessageReplies handles GET /api/messages/{id}/replies. It takes the
+// query parameters of GetMessages.
+func (h *Handler) GetMessageReplies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
+		return
+	}
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+	parent, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to read message")
+		return
+	}
+	opts.ParentID = id
+	opts.Room = parent.Room
+
+	h.writePage(w, r, opts)
+}
+
+// writePage answers with a page of messages
+func (h *Handler) writePage(w http.ResponseWriter, r *http.Request, opts storage.ListOptions) {
+	page, err := h.storage.List(opts)
+	if err != nil {
+		h.writeError(w, r, problem.Internal("Failed to list messages", err))
+		return
+	}
+
 	response := models.APIResponse{
 		Success: true,
-		Data:    messages,
+		Data:    page.Messages,
+		Total:   &page.Total,
+	}
+	if page.HasMore {
+		response.NextCursor = encodeCursor(opts, page.Messages[len(page.Messages)-1])
 	}
 	h.writeJSON(w, http.StatusOK, response)
 }
 
-// CreateMessage handles POST /api/messages
+// CreateMessage handles POST /api/messages, which posts to the lobby. A
+// parent_id makes the message a reply.
 func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
+	h.createMessage(w, r, nil)
+}
+
+// createMessage creates a message from the request body in room, or in
+// the lobby if room is nil. Only members may post in a room, and replies
+// must be in the room of their parent.
+func (h *Handler) createMessage(w http.ResponseWriter, r *http.Request, room *models.Room) {
 	var req models.CreateMessageRequest
 	if err := h.parseJSON(r, &req); err != nil {
-		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
 		return
 	}
 
-	if err := req.Validate(); err != nil {
-		h.writeError(w, http.StatusBadRequest, err.Error())
+	err := req.Validate()
+	if err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
 		return
 	}
 
-	message, err := h.storage.Create(req.Username, req.Content)
+	roomName := ""
+	if room != nil {
+		if !room.IsMember(req.Username) {
+			h.writeError(w, r, problem.Forbidden("Only members can post in this room"))
+			return
+		}
+		roomName = room.Name
+	}
+
+	var message *models.Message
+	switch {
+	case req.ParentID != nil:
+		if parentRoom, roomErr := h.storage.RoomOf(*req.ParentID); roomErr == nil && parentRoom != roomName {
+			h.writeStorageError(w, r, storage.ErrParentNotFound, "")
+			return
+		}
+		message, err = h.storage.CreateReply(*req.ParentID, req.Username, req.Content)
+	case room != nil:
+		message, err = h.storage.CreateInRoom(room.Name, req.Username, req.Content)
+	default:
+		message, err = h.storage.Create(req.Username, req.Content)
+	}
 	if err != nil {
-		h.writeError(w, http.StatusInternalServerError, "Failed to create message")
+		h.writeStorageError(w, r, err, "Failed to create message")
 		return
 	}
+	w.Header().Set("ETag", etag(message))
 
 	response := models.APIResponse{
 		Success: true,
@@ -80,37 +269,69 @@ func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusCreated, response)
 }
 
-// UpdateMessage handles PUT /api/messages/{id}
+// GetMessage handles GET /api/messages/{id}. It answers 304 if the
+// If-None-Match header lists the current ETag.
+func (h *Handler) GetMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
+		return
+	}
+
+	message, err := h.storage.GetByID(id)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to read message")
+		return
+	}
+
+	tag := etag(message)
+	w.Header().Set("ETag", tag)
+	if matchETag(r.Header.Values("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    message,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateMessage handles PUT /api/messages/{id}. With If-Match it only
+// applies to the listed version and answers 412 otherwise.
 func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		h.writeError(w, http.StatusBadRequest, "Invalid message ID")
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
 		return
 	}
 
 	var req models.UpdateMessageRequest
 	if err := h.parseJSON(r, &req); err != nil {
-		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
 		return
 	}
 
 	if err := req.Validate(); err != nil {
-		h.writeError(w, http.StatusBadRequest, err.Error())
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	version, ok := h.precondition(w, r, id)
+	if !ok {
 		return
 	}
 
-	message, err := h.storage.Update(id, req.Content)
+	message, err := h.storage.UpdateIf(id, version, req.Content)
 	if err != nil {
-		if err == storage.ErrMessageNotFound {
-			h.writeError(w, http.StatusNotFound, "Message not found")
-		} else {
-			h.writeError(w, http.StatusInternalServerError, "Failed to update message")
-		}
+		h.writeStorageError(w, r, err, "Failed to update message")
 		return
 	}
+	w.Header().Set("ETag", etag(message))
 
 	response := models.APIResponse{
 		Success: true,
@@ -119,29 +340,77 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, response)
 }
 
-// DeleteMessage handles DELETE /api/messages/{id}
+// DeleteMessage handles DELETE /api/messages/{id}, conditional on If-Match
+// like UpdateMessage. The message is kept until it is purged, see
+// RestoreMessage.
 func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		h.writeError(w, http.StatusBadRequest, "Invalid message ID")
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
 		return
 	}
 
-	if err := h.storage.Delete(id); err != nil {
-		if err == storage.ErrMessageNotFound {
-			h.writeError(w, http.StatusNotFound, "Message not found")
-		} else {
-			h.writeError(w, http.StatusInternalServerError, "Failed to delete message")
-		}
+	version, ok := h.precondition(w, r, id)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.DeleteIf(id, version); err != nil {
+		h.writeStorageError(w, r, err, "Failed to delete message")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetMessageHistory handles GET /api/messages/{id}/history. It lists the
+// revisions of the message, oldest first, including deleted messages.
+func (h *Handler) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
+		return
+	}
+
+	revisions, err := h.storage.History(id)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to read message history")
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    revisions,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// RestoreMessage handles POST /api/messages/{id}/restore, which brings back
+// a deleted message that has not been purged yet
+func (h *Handler) RestoreMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
+		return
+	}
+
+	message, err := h.storage.Restore(id)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to restore message")
+		return
+	}
+	w.Header().Set("ETag", etag(message))
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    message,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
 // GetHTTPStatus handles GET /api/status/{code}
 func (h *Handler) GetHTTPStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -149,13 +418,13 @@ func (h *Handler) GetHTTPStatus(w http.ResponseWriter, r *http.Request) {
 
 	code, err := strconv.Atoi(codeStr)
 	if err != nil {
-		h.writeError(w, http.StatusBadRequest, "Invalid status code")
+		h.writeError(w, r, problem.Validation("Invalid status code"))
 		return
 	}
 
 	// Validate status code (must be between 100-599)
 	if code < 100 || code > 599 {
-		h.writeError(w, http.StatusBadRequest, "Status code must be between 100 and 599")
+		h.writeError(w, r, problem.Validation("Status code must be between 100 and 599"))
 		return
 	}
 
@@ -172,7 +441,8 @@ func (h *Handler) GetHTTPStatus(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, apiResponse)
 }
 
-// HealthCheck handles GET /api/health
+// HealthCheck handles GET /api/health. rooms holds the message count of
+// each room.
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	healthData := map[string]interface{}{
 		"status":         "ok",
@@ -180,6 +450,15 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 		"timestamp":      time.Now().Format(time.RFC3339),
 		"total_messages": h.storage.Count(),
 	}
+	if rooms, err := h.storage.ListRooms(); err == nil {
+		counts := make(map[string]int, len(rooms))
+		for _, room := range rooms {
+			counts[room.Name] = room.MessageCount
+		}
+		healthData["rooms"] = counts
+	} else {
+		healthData["status"] = "degraded"
+	}
 
 	response := models.APIResponse{
 		Success: true,
@@ -199,13 +478,47 @@ func (h *Handler) writeJSON(w http.ResponseWriter, status int, data interface{})
 	}
 }
 
-// Helper function to write error responses
-func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
-	response := models.APIResponse{
-		Success: false,
-		Error:   message,
+// writeError answers with the problem details of err
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	problem.Write(w, r, err)
+}
+
+// storageErrors maps storage errors to the problems reported to clients
+var storageErrors = map[error]*problem.Error{
+	storage.ErrMessageNotFound:  problem.NotFound("Message not found"),
+	storage.ErrVersionConflict:  problem.PreconditionFailed("Message has been modified"),
+	storage.ErrNotDeleted:       problem.Conflict("Message is not deleted"),
+	storage.ErrIDTaken:          problem.Conflict("Message ID is taken"),
+	storage.ErrParentNotFound:   problem.Validation("Parent message not found", problem.FieldError{In: "body", Field: "parent_id", Message: "must be a message of the room"}),
+	storage.ErrReactionExists:   problem.Conflict("Reaction already exists"),
+	storage.ErrReactionNotFound: problem.NotFound("Reaction not found"),
+	storage.ErrRoomNotFound:     problem.NotFound("Room not found"),
+	storage.ErrRoomExists:       problem.Conflict("Room already exists"),
+	storage.ErrAlreadyMember:    problem.Conflict("User is already a member"),
+	storage.ErrNotMember:        problem.NotFound("User is not a member"),
+	storage.ErrBatchAborted:     problem.FailedDependency("Not applied because another operation failed"),
+}
+
+// writeStorageError answers with the problem of a storage error
+func (h *Handler) writeStorageError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	h.writeError(w, r, storageProblem(err, message))
+}
+
+// storageProblem returns the problem of a storage error, and an internal
+// error with message for unexpected ones. Typed errors are kept.
+func storageProblem(err error, message string) error {
+	if p, ok := storageErrors[err]; ok {
+		return p
+	}
+	if problem.KindOf(err) != problem.KindInternal {
+		return err
 	}
-	h.writeJSON(w, status, response)
+	return problem.Internal(message, err)
+}
+
+// rateLimited answers requests over their rate limit
+func (h *Handler) rateLimited(w http.ResponseWriter, r *http.Request, res *ratelimit.Result) {
+	h.writeError(w, r, problem.RateLimited("Rate limit exceeded"))
 }
 
 // Helper function to parse JSON request body
@@ -270,21 +583,26 @@ func getHTTPStatusDescription(code int) string {
 	}
 }
 
-// CORS middleware
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// Handle OPTIONS preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// handleOptions answers OPTIONS requests that are not CORS preflights
+func handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isOptions matches OPTIONS requests to any path
+func isOptions(r *http.Request, _ *mux.RouteMatch) bool {
+	return r.Method == http.MethodOptions
+}
+
+// isNotOptions matches requests of every other method
+func isNotOptions(r *http.Request, match *mux.RouteMatch) bool {
+	return !isOptions(r, match)
+}
 
-		// Call next handler for non-OPTIONS requests
-		next.ServeHTTP(w, r)
+// methodNotAllowed answers 405 Method Not Allowed, listing the allowed
+// methods in the Allow header
+func methodNotAllowed(allowed ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		problem.MethodNotAllowedHandler().ServeHTTP(w, r)
 	})
 }
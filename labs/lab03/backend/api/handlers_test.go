@@ -1,13 +1,25 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
 	"lab03-backend/models"
 	"lab03-backend/storage"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
 )
 
 func setupTestHandler() *Handler {
@@ -209,3 +221,1179 @@ func TestHealthCheck(t *testing.T) {
 		t.Errorf("Expected Content-Type application/json, got %s", contentType)
 	}
 }
+
+func TestCORSPreflight(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	req, err := http.NewRequest("OPTIONS", "/api/messages/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("Expected status %v, got %v", http.StatusNoContent, status)
+	}
+
+	if got := rr.Header().Get("Access-Control-Allow-Methods"); got == "" {
+		t.Error("Expected Access-Control-Allow-Methods to be set")
+	}
+
+	policy, err := cors.New(cors.Options{AllowedOrigins: []string{"https://app.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler = setupTestHandler()
+	handler.SetCORSPolicy(policy)
+	router = handler.SetupRoutes()
+
+	req, _ = http.NewRequest("GET", "/api/messages", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no Access-Control-Allow-Origin for a foreign origin, got %q", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	handler.storage.Create("alice", "hello")
+
+	req, _ := http.NewRequest("DELETE", "/api/messages/99", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, status)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"messages_stored 1",
+		`http_requests_total{method="DELETE",route="/api/messages/{id}",service="lab03-api",status="404"} 1`,
+	} {
+		if !bytes.Contains([]byte(body), []byte(want)) {
+			t.Errorf("Expected %q in the metrics output", want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore())
+	limiter.Exempt("/api/health")
+	if err := limiter.SetDefault(ratelimit.Policy{Limit: ratelimit.Limit{Requests: 1, Window: time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := setupTestHandler()
+	handler.SetRateLimiter(limiter)
+	router := handler.SetupRoutes()
+
+	req, _ := http.NewRequest("GET", "/api/messages", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, status)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %v, got %v", http.StatusTooManyRequests, status)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	var response problem.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Type != problem.KindRateLimited.Type() || response.Detail != "Rate limit exceeded" {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/health", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Expected the health check to be exempt, got %v", status)
+	}
+}
+
+func TestGetMessagesPagination(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	for _, username := range []string{"alice", "bob", "alice", "bob", "alice"} {
+		handler.storage.Create(username, "hello from "+username)
+	}
+
+	get := func(query string) models.APIResponse {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "/api/messages"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d: %s", query, rr.Code, rr.Body)
+		}
+		var response models.APIResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	ids := func(response models.APIResponse) []int {
+		var ids []int
+		for _, item := range response.Data.([]interface{}) {
+			ids = append(ids, int(item.(map[string]interface{})["id"].(float64)))
+		}
+		return ids
+	}
+
+	// Walk all pages newest first
+	var seen []int
+	query := "?limit=2&order=desc"
+	for {
+		response := get(query)
+		if response.Total == nil || *response.Total != 5 {
+			t.Fatalf("Expected total 5, got %v", response.Total)
+		}
+		seen = append(seen, ids(response)...)
+		if response.NextCursor == "" {
+			break
+		}
+		query = "?limit=2&order=desc&cursor=" + response.NextCursor
+	}
+	if len(seen) != 5 || seen[0] != 5 || seen[4] != 1 {
+		t.Errorf("Expected IDs 5 to 1, got %v", seen)
+	}
+
+	response := get("?username=alice&offset=1")
+	if got := ids(response); len(got) != 2 || got[0] != 3 || *response.Total != 3 {
+		t.Errorf("Expected alice's messages 3 and 5 of 3, got %v of %d", got, *response.Total)
+	}
+
+	response = get("?contains=BOB&sort=timestamp")
+	if got := ids(response); len(got) != 2 || got[0] != 2 {
+		t.Errorf("Expected bob's messages, got %v", got)
+	}
+}
+
+func TestGetMessagesInvalidQuery(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	handler.storage.Create("alice", "one")
+	handler.storage.Create("alice", "two")
+
+	// A cursor issued for one order cannot be used with another
+	req, _ := http.NewRequest("GET", "/api/messages?limit=1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var response models.APIResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=1000",
+		"offset=-1",
+		"sort=username",
+		"order=up",
+		"since=yesterday",
+		"cursor=!!!",
+		"cursor=" + response.NextCursor + "&offset=1",
+		"cursor=" + response.NextCursor + "&order=desc",
+	} {
+		req, _ := http.NewRequest("GET", "/api/messages?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+// sseEvent is a frame read from an event stream
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvents parses the frames of an event stream. Comments are reported
+// as events named "comment", the retry hint is skipped.
+func readEvents(body io.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(body)
+		var frame sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if frame.event != "" {
+					events <- frame
+				}
+				frame = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				events <- sseEvent{event: "comment", data: line}
+			default:
+				field, value, _ := strings.Cut(line, ": ")
+				switch field {
+				case "id":
+					frame.id = value
+				case "event":
+					frame.event = value
+				case "data":
+					frame.data = value
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Expected an event, stream closed")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an event, timed out")
+	}
+	return sseEvent{}
+}
+
+func openStream(t *testing.T, url string, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %q", ct)
+	}
+	return readEvents(resp.Body)
+}
+
+func TestStreamMessages(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := NewHandler(store)
+	server := httptest.NewServer(handler.SetupRoutes())
+	// Runs after the streams are closed, Close waits for their handlers
+	t.Cleanup(server.Close)
+
+	events := openStream(t, server.URL+"/api/messages/stream", "")
+	// The subscription is in place once the handler flushed the headers
+	message, _ := store.Create("alice", "hello")
+	store.Update(message.ID, "edited")
+	store.Delete(message.ID)
+
+	for _, want := range []struct{ id, event, content string }{
+		{"1", "created", "hello"},
+		{"2", "updated", "edited"},
+		{"3", "deleted", "edited"},
+	} {
+		e := nextEvent(t, events)
+		if e.id != want.id || e.event != want.event {
+			t.Errorf("Expected event %s %s, got %s %s", want.id, want.event, e.id, e.event)
+		}
+		var m models.Message
+		if err := json.Unmarshal([]byte(e.data), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != message.ID || m.Content != want.content {
+			t.Errorf("Expected message %d %q, got %d %q", message.ID, want.content, m.ID, m.Content)
+		}
+	}
+}
+
+func TestStreamMessagesResume(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := NewHandler(store)
+	server := httptest.NewServer(handler.SetupRoutes())
+	// Runs after the streams are closed, Close waits for their handlers
+	t.Cleanup(server.Close)
+
+	store.Create("alice", "one")
+	store.Create("alice", "two")
+	store.Create("alice", "three")
+
+	events := openStream(t, server.URL+"/api/messages/stream", "1")
+	for _, want := range []string{"2", "3"} {
+		if e := nextEvent(t, events); e.id != want {
+			t.Errorf("Expected replayed event %s, got %s", want, e.id)
+		}
+	}
+
+	// IDs the server does not know, e.g. from before a restart
+	events = openStream(t, server.URL+"/api/messages/stream", "42")
+	if e := nextEvent(t, events); e.event != "reset" {
+		t.Errorf("Expected reset event, got %q", e.event)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/messages/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	handler.SetupRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestStreamMessagesFilterAndHeartbeat(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := NewHandler(store)
+	handler.heartbeat = 20 * time.Millisecond
+	server := httptest.NewServer(handler.SetupRoutes())
+	// Runs after the streams are closed, Close waits for their handlers
+	t.Cleanup(server.Close)
+
+	events := openStream(t, server.URL+"/api/messages/stream?username=bob", "")
+	store.Create("alice", "ignored")
+	store.Create("bob", "wanted")
+
+	for {
+		e := nextEvent(t, events)
+		if e.event == "comment" {
+			if e.data != ": heartbeat" {
+				t.Errorf("Expected heartbeat comment, got %q", e.data)
+			}
+			continue
+		}
+		if e.event != "created" || e.id != "2" {
+			t.Errorf("Expected bob's message as event 2, got %s %s", e.event, e.id)
+		}
+		break
+	}
+
+	// Heartbeats keep coming while nothing happens
+	if e := nextEvent(t, events); e.event != "comment" {
+		t.Errorf("Expected heartbeat, got %q", e.event)
+	}
+}
+
+func TestStreamRoomMessages(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := NewHandler(store)
+	server := httptest.NewServer(handler.SetupRoutes())
+	t.Cleanup(server.Close)
+
+	store.CreateRoom(models.Room{Name: "secret", Owner: "alice", Private: true})
+	resp, err := http.Get(server.URL + "/api/messages/stream?room=secret&user=bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %v for a non-member, got %v", http.StatusNotFound, resp.StatusCode)
+	}
+
+	events := openStream(t, server.URL+"/api/messages/stream?room=secret&user=alice", "")
+	store.Create("alice", "lobby")
+	store.CreateInRoom("secret", "alice", "psst")
+
+	if e := nextEvent(t, events); e.event != "created" || e.id != "2" {
+		t.Errorf("Expected the room message as event 2, got %s %s", e.event, e.id)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "hello")
+
+	do := func(method, ifMatch, ifNoneMatch, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/api/messages/1", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "", "", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Expected 200 with ETag \"1\", got %v %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	if rr = do("GET", "", `"1"`, ""); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected empty 304, got %v %q", rr.Code, rr.Body.String())
+	}
+	if rr = do("GET", "", `W/"1"`, ""); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected weak match to give 304, got %v", rr.Code)
+	}
+
+	// Two clients edit version 1, the second one must be told
+	if rr = do("PUT", `"1"`, "", `{"content":"first"}`); rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected 200 with ETag \"2\", got %v %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	if rr = do("PUT", `"1"`, "", `{"content":"second"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %v, got %v", http.StatusPreconditionFailed, rr.Code)
+	}
+	if rr = do("PUT", `W/"2"`, "", `{"content":"weak"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected weak tags to fail If-Match, got %v", rr.Code)
+	}
+	if rr = do("GET", "", `"1"`, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected stale If-None-Match to give 200, got %v", rr.Code)
+	}
+
+	if rr = do("DELETE", `"1", "3"`, "", ""); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %v, got %v", http.StatusPreconditionFailed, rr.Code)
+	}
+	if rr = do("DELETE", `"1", "2"`, "", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status %v, got %v", http.StatusNoContent, rr.Code)
+	}
+	if rr = do("PUT", "*", "", `{"content":"gone"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestConditionalRequestsSeeRepliesAndReactions(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "hello")
+
+	do := func(method, path, header, tag, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if tag != "" {
+			req.Header.Set(header, tag)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cached := do("GET", "/api/messages/1", "", "", "").Header().Get("ETag")
+	thumbs := url.PathEscape("👍")
+	if rr := do("POST", "/api/messages/1/reactions/"+thumbs, "", "", `{"username":"bob"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %v, got %v", http.StatusCreated, rr.Code)
+	}
+	rr := do("GET", "/api/messages/1", "If-None-Match", cached, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"reactions"`) {
+		t.Fatalf("Expected 200 with the reaction after reacting, got %v %s", rr.Code, rr.Body.String())
+	}
+	reacted := rr.Header().Get("ETag")
+	if rr = do("GET", "/api/messages/1", "If-None-Match", reacted, ""); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected the new ETag to give 304, got %v", rr.Code)
+	}
+
+	handler.storage.CreateReply(1, "bob", "hi alice")
+	if rr = do("GET", "/api/messages/1", "If-None-Match", reacted, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 after a reply, got %v", rr.Code)
+	}
+
+	// Writes only compare the version, so both tags still apply
+	if rr = do("PUT", "/api/messages/1", "If-Match", cached, `{"content":"edited"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected If-Match with the old ETag to pass, got %v", rr.Code)
+	}
+	if rr = do("PUT", "/api/messages/1", "If-Match", reacted, `{"content":"again"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected If-Match with an edited version to fail, got %v", rr.Code)
+	}
+}
+
+func TestMessageHistoryAndRestore(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "hello")
+	handler.storage.Update(1, "hello, edited")
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "/api/messages/1")
+	var message map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &struct {
+		Data *map[string]interface{} `json:"data"`
+	}{&message})
+	if message["edited_at"] == nil || message["deleted"] != false {
+		t.Errorf("Expected edited_at and deleted in %v", message)
+	}
+
+	if rr = do("DELETE", "/api/messages/1"); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %v, got %v", http.StatusNoContent, rr.Code)
+	}
+	if rr = do("GET", "/api/messages/1"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected deleted message to give %v, got %v", http.StatusNotFound, rr.Code)
+	}
+	rr = do("GET", "/api/messages?include_deleted=true")
+	if !strings.Contains(rr.Body.String(), `"deleted":true`) {
+		t.Errorf("Expected the deleted message in %s", rr.Body.String())
+	}
+
+	rr = do("GET", "/api/messages/1/history")
+	var history struct {
+		Data []models.Revision `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	if rr.Code != http.StatusOK || len(history.Data) != 2 || history.Data[0].Content != "hello" {
+		t.Errorf("Unexpected history %v %s", rr.Code, rr.Body.String())
+	}
+
+	if rr = do("POST", "/api/messages/1/restore"); rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"4"` {
+		t.Errorf("Expected 200 with ETag \"4\", got %v %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	if rr = do("POST", "/api/messages/1/restore"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %v, got %v", http.StatusConflict, rr.Code)
+	}
+	if rr = do("POST", "/api/messages/9/restore"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr = do("GET", "/api/messages/9/history"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestRepliesAndReactions(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "question")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, data interface{}) {
+		t.Helper()
+		if err := json.Unmarshal(rr.Body.Bytes(), &models.APIResponse{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rr := do("POST", "/api/messages", `{"username":"bob","content":"answer","parent_id":1}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %v, got %v", http.StatusCreated, rr.Code)
+	}
+	do("POST", "/api/messages", `{"username":"carol","content":"another","parent_id":1}`)
+	if rr := do("POST", "/api/messages", `{"username":"bob","content":"x","parent_id":9}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for an unknown parent, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := do("POST", "/api/messages", `{"username":"bob","content":"x","parent_id":-1}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for an invalid parent, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	rr := do("GET", "/api/messages/1/replies?limit=1", "")
+	var replies []models.Message
+	decode(rr, &replies)
+	if rr.Code != http.StatusOK || len(replies) != 1 || replies[0].Username != "bob" ||
+		!strings.Contains(rr.Body.String(), `"total":2`) || !strings.Contains(rr.Body.String(), "next_cursor") {
+		t.Errorf("Unexpected replies %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("GET", "/api/messages/9/replies", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	// Emoji arrive percent-encoded
+	thumbs := url.PathEscape("👍")
+	if rr := do("POST", "/api/messages/1/reactions/"+thumbs, `{"username":"bob"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %v, got %v %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if rr := do("POST", "/api/messages/1/reactions/"+thumbs, `{"username":"bob"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %v for a second reaction, got %v", http.StatusConflict, rr.Code)
+	}
+	rr = do("POST", "/api/messages/1/reactions/"+thumbs, `{"username":"carol"}`)
+	var message models.Message
+	decode(rr, &message)
+	if message.Reactions["👍"] != 2 {
+		t.Errorf("Expected 2 reactions, got %v", message.Reactions)
+	}
+
+	rr = do("GET", "/api/messages/1", "")
+	decode(rr, &message)
+	if message.ReplyCount != 2 || message.Reactions["👍"] != 2 {
+		t.Errorf("Expected 2 replies and 2 reactions, got %d and %v", message.ReplyCount, message.Reactions)
+	}
+
+	if rr := do("DELETE", "/api/messages/1/reactions/"+thumbs, `{"username":"bob"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status %v, got %v", http.StatusOK, rr.Code)
+	}
+	if rr := do("DELETE", "/api/messages/1/reactions/"+thumbs, `{"username":"bob"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+	if rr := do("POST", "/api/messages/1/reactions/abc", `{"username":"bob"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for a non-emoji, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := do("POST", "/api/messages/1/reactions/"+thumbs, `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v without a username, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "Café opens at nine")
+	handler.storage.Create("bob", "The cafeteria is closed")
+	handler.storage.Create("carol", "Lunch <b>at</b> noon")
+
+	search := func(query string) (*httptest.ResponseRecorder, []storage.SearchResult) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "/api/messages/search?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var results []storage.SearchResult
+		json.Unmarshal(rr.Body.Bytes(), &models.APIResponse{Data: &results})
+		return rr, results
+	}
+
+	rr, results := search("q=" + url.QueryEscape("CAFÉ OR lunch"))
+	if rr.Code != http.StatusOK || len(results) != 2 || !strings.Contains(rr.Body.String(), `"total":2`) {
+		t.Fatalf("Expected 2 results, got %v %s", rr.Code, rr.Body.String())
+	}
+	if results[0].Message == nil || results[0].Snippet == "" || results[0].Score <= 0 {
+		t.Errorf("Unexpected result %+v", results[0])
+	}
+	for _, result := range results {
+		if result.Message.ID == 3 && result.Snippet != "<mark>Lunch</mark> &lt;b&gt;at&lt;/b&gt; noon" {
+			t.Errorf("Unexpected snippet %q", result.Snippet)
+		}
+	}
+
+	if _, results := search("q=caf*&limit=1"); len(results) != 1 {
+		t.Errorf("Expected 1 result, got %d", len(results))
+	}
+	if _, results := search("q=caf*&offset=1"); len(results) != 1 {
+		t.Errorf("Expected 1 result, got %d", len(results))
+	}
+	if rr, results := search("q=nothing"); rr.Code != http.StatusOK || len(results) != 0 || !strings.Contains(rr.Body.String(), `"data":[]`) {
+		t.Errorf("Expected an empty list, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	for _, query := range []string{"", "q=", "q=%2A", "q=caf&limit=0", "q=caf&offset=x"} {
+		if rr, _ := search(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestRooms(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set("X-Username", user)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, status int) {
+		t.Helper()
+		if rr.Code != status {
+			t.Errorf("Expected status %v, got %v: %s", status, rr.Code, rr.Body.String())
+		}
+	}
+
+	expect(do("POST", "/api/rooms", "", `{"name":"team"}`), http.StatusUnauthorized)
+	expect(do("POST", "/api/rooms", "alice", `{"name":"Team Chat"}`), http.StatusBadRequest)
+	expect(do("POST", "/api/rooms", "alice", `{"name":"team","description":"Team chat"}`), http.StatusCreated)
+	expect(do("POST", "/api/rooms", "bob", `{"name":"team"}`), http.StatusConflict)
+	expect(do("POST", "/api/rooms", "alice", `{"name":"secret","private":true}`), http.StatusCreated)
+
+	// Private rooms are invisible to non-members
+	var rooms []models.Room
+	json.Unmarshal(do("GET", "/api/rooms", "bob", "").Body.Bytes(), &models.APIResponse{Data: &rooms})
+	if len(rooms) != 1 || rooms[0].Name != "team" {
+		t.Errorf("Expected bob to see room team only, got %v", rooms)
+	}
+	expect(do("GET", "/api/rooms/secret", "bob", ""), http.StatusNotFound)
+	expect(do("GET", "/api/rooms/secret", "alice", ""), http.StatusOK)
+	expect(do("POST", "/api/rooms/secret/members", "bob", `{"username":"bob"}`), http.StatusNotFound)
+
+	// Posting needs membership
+	expect(do("POST", "/api/rooms/team/messages", "", `{"username":"bob","content":"hi"}`), http.StatusForbidden)
+	expect(do("POST", "/api/rooms/team/members", "bob", `{"username":"bob"}`), http.StatusCreated)
+	expect(do("POST", "/api/rooms/team/members", "bob", `{"username":"bob"}`), http.StatusConflict)
+	expect(do("POST", "/api/rooms/team/messages", "", `{"username":"bob","content":"hi team"}`), http.StatusCreated)
+	expect(do("POST", "/api/rooms/secret/messages", "alice", `{"username":"alice","content":"psst"}`), http.StatusCreated)
+	expect(do("POST", "/api/messages", "", `{"username":"carol","content":"hi lobby"}`), http.StatusCreated)
+
+	rr := do("GET", "/api/rooms/team/messages", "", "")
+	var messages []models.Message
+	json.Unmarshal(rr.Body.Bytes(), &models.APIResponse{Data: &messages})
+	if len(messages) != 1 || messages[0].Content != "hi team" || messages[0].Room != "team" {
+		t.Errorf("Expected the message of room team, got %v", messages)
+	}
+	json.Unmarshal(do("GET", "/api/messages", "", "").Body.Bytes(), &models.APIResponse{Data: &messages})
+	if len(messages) != 1 || messages[0].Content != "hi lobby" {
+		t.Errorf("Expected the lobby message only, got %v", messages)
+	}
+	expect(do("GET", "/api/rooms/secret/messages/search?q=psst", "alice", ""), http.StatusOK)
+	expect(do("GET", "/api/rooms/secret/messages/search?q=psst", "bob", ""), http.StatusNotFound)
+
+	// Message routes hide private rooms too, and replies stay in their room
+	expect(do("GET", "/api/messages/2", "bob", ""), http.StatusNotFound)
+	expect(do("GET", "/api/messages/2", "alice", ""), http.StatusOK)
+	expect(do("DELETE", "/api/messages/2", "bob", ""), http.StatusNotFound)
+	expect(do("POST", "/api/messages", "", `{"username":"bob","content":"re","parent_id":1}`), http.StatusBadRequest)
+	expect(do("POST", "/api/rooms/team/messages", "", `{"username":"bob","content":"re","parent_id":1}`), http.StatusCreated)
+
+	// Only the owner changes the room; members leave on their own
+	expect(do("PUT", "/api/rooms/team", "bob", `{"private":true}`), http.StatusForbidden)
+	rr = do("PUT", "/api/rooms/team", "alice", `{"description":"Renamed"}`)
+	var room models.Room
+	json.Unmarshal(rr.Body.Bytes(), &models.APIResponse{Data: &room})
+	if rr.Code != http.StatusOK || room.Description != "Renamed" || room.Private || room.MessageCount != 2 {
+		t.Errorf("Unexpected room %v %+v", rr.Code, room)
+	}
+	expect(do("DELETE", "/api/rooms/team/members/alice", "bob", ""), http.StatusForbidden)
+	expect(do("DELETE", "/api/rooms/team/members/bob", "bob", ""), http.StatusOK)
+	expect(do("DELETE", "/api/rooms/team/members/bob", "bob", ""), http.StatusNotFound)
+
+	rr = do("GET", "/api/health", "", "")
+	if !strings.Contains(rr.Body.String(), `"rooms":{"secret":1,"team":2}`) {
+		t.Errorf("Expected room counts in health, got %s", rr.Body.String())
+	}
+
+	expect(do("DELETE", "/api/rooms/team", "bob", ""), http.StatusForbidden)
+	expect(do("DELETE", "/api/rooms/team", "alice", ""), http.StatusNoContent)
+	expect(do("GET", "/api/rooms/team", "alice", ""), http.StatusNotFound)
+	expect(do("GET", "/api/messages/1", "", ""), http.StatusNotFound)
+}
+
+func TestBatchMessages(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "hello")
+	handler.storage.CreateRoom(models.Room{Name: "secret", Owner: "alice", Private: true})
+	handler.storage.CreateInRoom("secret", "alice", "psst")
+
+	batch := func(user, body string) (*httptest.ResponseRecorder, models.BatchResponse) {
+		req, _ := http.NewRequest("POST", "/api/messages:batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set("X-Username", user)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response models.BatchResponse
+		json.Unmarshal(rr.Body.Bytes(), &models.APIResponse{Data: &response})
+		return rr, response
+	}
+	statuses := func(response models.BatchResponse) []int {
+		var statuses []int
+		for _, result := range response.Results {
+			statuses = append(statuses, result.Status)
+		}
+		return statuses
+	}
+
+	// Atomic by default: a failure leaves the store untouched
+	rr, response := batch("", `{"operations":[
+		{"op":"create","username":"bob","content":"imported"},
+		{"op":"update","id":1,"content":"hello, edited"},
+		{"op":"delete","id":2}]}`)
+	if rr.Code != http.StatusOK || response.Mode != models.BatchAtomic || response.Applied != 0 || response.Failed != 3 {
+		t.Fatalf("Unexpected response %v %+v", rr.Code, response)
+	}
+	if got := fmt.Sprint(statuses(response)); got != "[424 424 404]" {
+		t.Errorf("Expected statuses [424 424 404], got %s", got)
+	}
+	if response.Results[2].Error == nil || response.Results[2].Error.Type != problem.KindNotFound.Type() {
+		t.Errorf("Expected a not found problem, got %+v", response.Results[2].Error)
+	}
+	if handler.storage.Count() != 2 {
+		t.Errorf("Expected 2 messages, got %d", handler.storage.Count())
+	}
+
+	rr, response = batch("alice", `{"operations":[
+		{"op":"create","username":"bob","content":"imported"},
+		{"op":"create","username":"bob","content":"reply","parent_id":3},
+		{"op":"update","id":1,"version":1,"content":"hello, edited"},
+		{"op":"delete","id":2}]}`)
+	if response.Applied != 4 || fmt.Sprint(statuses(response)) != "[201 201 200 204]" {
+		t.Fatalf("Unexpected response %v %s", rr.Code, rr.Body.String())
+	}
+	if response.Results[1].Message.ParentID == nil || response.Results[2].Message.Content != "hello, edited" ||
+		response.Results[3].Message != nil {
+		t.Errorf("Unexpected results %+v", response.Results)
+	}
+
+	// Best effort applies what it can
+	rr, response = batch("bob", `{"mode":"best_effort","operations":[
+		{"op":"create","room":"secret","username":"bob","content":"let me in"},
+		{"op":"update","id":2,"content":"peek"},
+		{"op":"update","id":1,"version":1,"content":"stale"},
+		{"op":"create","username":"bob","content":"still here"}]}`)
+	if response.Applied != 1 || response.Failed != 3 || fmt.Sprint(statuses(response)) != "[404 404 412 201]" {
+		t.Errorf("Unexpected response %v %s", rr.Code, rr.Body.String())
+	}
+
+	handler.storage.AddMember("secret", "bob")
+	_, response = batch("bob", `{"operations":[{"op":"create","room":"secret","username":"carol","content":"hi"}]}`)
+	if fmt.Sprint(statuses(response)) != "[403]" {
+		t.Errorf("Expected non-members to be forbidden, got %v", statuses(response))
+	}
+
+	// Invalid batches are rejected as a whole
+	rr, _ = batch("", `{"operations":[{"op":"create","username":"bob"},{"op":"restore","id":1}]}`)
+	var p problem.Problem
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if rr.Code != http.StatusBadRequest || len(p.Errors) != 2 || p.Errors[1].Field != "operations[1]" {
+		t.Errorf("Expected 2 invalid operations, got %v %s", rr.Code, rr.Body.String())
+	}
+	tooMany := `{"operations":[` + strings.Repeat(`{"op":"delete","id":1},`, models.MaxBatchOperations) + `{"op":"delete","id":1}]}`
+	if rr, _ := batch("", tooMany); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for too many operations, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, rr.Code)
+	}
+
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to decode document: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("Expected OpenAPI 3.0.3, got %q", doc.OpenAPI)
+	}
+	for key := range operations {
+		method, path, _ := strings.Cut(key, " ")
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("Expected %s in the document", key)
+		}
+	}
+	if !strings.Contains(rr.Body.String(), `"operationId":"createMessage"`) {
+		t.Error("Expected operation IDs derived from handler names")
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	do := func(method, path, body string) problem.Problem {
+		t.Helper()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected status 400, got %d", method, path, rr.Code)
+		}
+		var p problem.Problem
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if p.Type != problem.KindValidation.Type() {
+			t.Errorf("%s %s: expected a validation problem, got %q", method, path, p.Type)
+		}
+		return p
+	}
+	expect := func(response problem.Problem, want ...problem.FieldError) {
+		t.Helper()
+		if len(response.Errors) != len(want) {
+			t.Fatalf("Expected errors %v, got %v", want, response.Errors)
+		}
+		for i := range want {
+			if response.Errors[i] != want[i] {
+				t.Errorf("Expected error %v, got %v", want[i], response.Errors[i])
+			}
+		}
+	}
+
+	expect(do("POST", "/api/messages", `{"username":"","content":5,"parent_id":0}`),
+		problem.FieldError{In: "body", Field: "content", Message: "must be a string"},
+		problem.FieldError{In: "body", Field: "parent_id", Message: "must be at least 1"},
+		problem.FieldError{In: "body", Field: "username", Message: "must not be empty"},
+	)
+	expect(do("POST", "/api/messages", `{"content":"hi"}`),
+		problem.FieldError{In: "body", Field: "username", Message: "is required"},
+	)
+	expect(do("POST", "/api/messages", `{`),
+		problem.FieldError{In: "body", Message: "must be valid JSON"},
+	)
+	expect(do("GET", "/api/messages?limit=abc&order=up", ""),
+		problem.FieldError{In: "query", Field: "limit", Message: "must be an integer"},
+		problem.FieldError{In: "query", Field: "order", Message: "must be one of asc, desc"},
+	)
+	expect(do("GET", "/api/messages/search", ""),
+		problem.FieldError{In: "query", Field: "q", Message: "is required"},
+	)
+	expect(do("GET", "/api/messages/abc", ""),
+		problem.FieldError{In: "path", Field: "id", Message: "must be an integer"},
+	)
+	expect(do("GET", "/api/status/42", ""),
+		problem.FieldError{In: "path", Field: "code", Message: "must be at least 100"},
+	)
+
+	// Valid requests still reach the handlers with their body
+	req, _ := http.NewRequest("POST", "/api/messages", strings.NewReader(`{"username":"alice","content":"hi"}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status %v, got %v: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+
+	do := func(method, path string) (*httptest.ResponseRecorder, problem.Problem) {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("X-Request-ID", "req-42")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var p problem.Problem
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		return rr, p
+	}
+
+	tests := []struct {
+		method, path string
+		kind         problem.Kind
+	}{
+		{"GET", "/api/messages/7", problem.KindNotFound},
+		{"POST", "/api/messages/7/restore", problem.KindNotFound},
+		{"GET", "/api/unknown", problem.KindNotFound},
+		{"PATCH", "/api/health", problem.KindMethodNotAllowed},
+		{"GET", "/api/messages/import", problem.KindMethodNotAllowed},
+		{"DELETE", "/api/messages/export", problem.KindMethodNotAllowed},
+		{"POST", "/api/rooms", problem.KindValidation},
+	}
+	for _, tt := range tests {
+		rr, p := do(tt.method, tt.path)
+		if rr.Code != tt.kind.Status() || p.Type != tt.kind.Type() || p.Status != rr.Code {
+			t.Errorf("%s %s: expected %s, got %d %s", tt.method, tt.path, tt.kind, rr.Code, rr.Body)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+			t.Errorf("%s %s: expected Content-Type %s, got %s", tt.method, tt.path, problem.ContentType, ct)
+		}
+		if p.RequestID != "req-42" || p.Instance != tt.path {
+			t.Errorf("%s %s: expected request ID and instance, got %+v", tt.method, tt.path, p)
+		}
+	}
+	if rr, _ := do("PATCH", "/api/health"); rr.Header().Get("Allow") != "GET" {
+		t.Errorf("Expected Allow: GET, got %q", rr.Header().Get("Allow"))
+	}
+	// The fixed paths under /api/messages are not taken for message IDs
+	if rr, _ := do("GET", "/api/messages/import"); rr.Header().Get("Allow") != "POST" {
+		t.Errorf("Expected Allow: POST, got %q", rr.Header().Get("Allow"))
+	}
+	if rr, _ := do("OPTIONS", "/api/messages/import"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected OPTIONS to give %v, got %v", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestExportMessages(t *testing.T) {
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	handler.storage.Create("alice", "hello")
+	handler.storage.Create("bob", "<b>bold</b>, \"quoted\"")
+	handler.storage.CreateReply(1, "bob", "hi alice")
+	handler.storage.Delete(2)
+	handler.storage.CreateRoom(models.Room{Name: "secret", Owner: "alice", Private: true})
+	handler.storage.CreateInRoom("secret", "alice", "psst")
+
+	export := func(user, query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/messages/export"+query, nil)
+		if user != "" {
+			req.Header.Set("X-Username", user)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := export("", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Expected JSON Lines, got %s", got)
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="messages.jsonl"` {
+		t.Errorf("Unexpected Content-Disposition %s", got)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 messages without the deleted one, got %q", lines)
+	}
+	var reply models.Message
+	json.Unmarshal([]byte(lines[1]), &reply)
+	if reply.ID != 3 || reply.ParentID == nil || *reply.ParentID != 1 {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(export("", "?username=bob").Body.String()), "\n"); len(lines) != 1 {
+		t.Errorf("Expected 1 message by bob, got %q", lines)
+	}
+
+	rr = export("", "?format=csv")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected a header and 2 records, got %q %v", records, err)
+	}
+	if got := strings.Join(records[0], ","); got != "id,username,content,timestamp,edited_at,version,parent_id,room" {
+		t.Errorf("Unexpected header %s", got)
+	}
+	if records[2][6] != "1" {
+		t.Errorf("Expected parent_id 1, got %q", records[2][6])
+	}
+
+	// Private rooms are hidden from non-members
+	if rr := export("bob", "?room=secret&format=html"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	rr = export("alice", "?room=secret&format=html")
+	if body := rr.Body.String(); rr.Code != http.StatusOK || !strings.Contains(body, "Chat history of secret") ||
+		!strings.Contains(body, "psst") || !strings.HasSuffix(body, "</html>\n") {
+		t.Errorf("Unexpected page %d %s", rr.Code, body)
+	}
+
+	handler.storage.Restore(2)
+	if body := export("", "?format=html").Body.String(); !strings.Contains(body, "&lt;b&gt;bold&lt;/b&gt;") {
+		t.Errorf("Expected content to be escaped, got %s", body)
+	}
+
+	if rr := export("", "?format=xml"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestImportMessages(t *testing.T) {
+	source := setupTestHandler()
+	source.storage.Create("alice", "hello")
+	source.storage.Create("bob", "skipped")
+	source.storage.CreateReply(1, "bob", "hi alice")
+	source.storage.Update(3, "hi alice!")
+	source.storage.Delete(2)
+
+	req, _ := http.NewRequest("GET", "/api/messages/export", nil)
+	exported := httptest.NewRecorder()
+	source.SetupRoutes().ServeHTTP(exported, req)
+
+	handler := setupTestHandler()
+	router := handler.SetupRoutes()
+	importMessages := func(user, contentType, query, body string) (*httptest.ResponseRecorder, models.ImportResponse) {
+		req, _ := http.NewRequest("POST", "/api/messages/import"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if user != "" {
+			req.Header.Set("X-Username", user)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response models.ImportResponse
+		json.Unmarshal(rr.Body.Bytes(), &models.APIResponse{Data: &response})
+		return rr, response
+	}
+
+	// A round trip keeps IDs, timestamps and versions
+	rr, response := importMessages("", "application/x-ndjson", "", exported.Body.String())
+	if rr.Code != http.StatusOK || response.Imported != 2 || len(response.Remapped) != 0 || len(response.Skipped) != 0 {
+		t.Fatalf("Unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+	original, _ := source.storage.GetByID(3)
+	imported, err := handler.storage.GetByID(3)
+	if err != nil || imported.Version != 2 || !imported.Timestamp.Equal(original.Timestamp) || imported.EditedAt == nil ||
+		imported.ParentID == nil || *imported.ParentID != 1 {
+		t.Errorf("Expected %+v, got %+v %v", original, imported, err)
+	}
+
+	// Taken IDs are skipped on request
+	_, response = importMessages("", "application/x-ndjson", "?on_conflict=skip", exported.Body.String())
+	if response.Imported != 0 || len(response.Skipped) != 2 || response.Skipped[0].Reason != "Message ID is taken" {
+		t.Errorf("Expected both rows to be skipped, got %+v", response)
+	}
+
+	// and remapped by default, with replies following their parent
+	_, response = importMessages("", "application/x-ndjson", "", exported.Body.String())
+	if response.Imported != 2 || fmt.Sprint(response.Remapped) != "[{1 4} {3 5}]" {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+	if reply, _ := handler.storage.GetByID(5); reply.ParentID == nil || *reply.ParentID != 4 {
+		t.Errorf("Expected the reply to follow its parent, got %+v", reply)
+	}
+
+	csvBody := "username,content,parent_id,room\n" +
+		"carol,from a spreadsheet,,\n" +
+		"carol,,,\n" +
+		"carol,reply,999,\n" +
+		"carol,\"broken,,\n"
+	rr, response = importMessages("", "text/csv", "", csvBody)
+	if rr.Code != http.StatusOK || response.Imported != 1 || len(response.Skipped) != 3 {
+		t.Fatalf("Unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+	want := []models.SkippedRow{
+		{Line: 3, Reason: "content is required"},
+		{Line: 4, Reason: "Parent message not found"},
+		{Line: 5, Reason: "invalid CSV: extraneous or missing \" in quoted-field"},
+	}
+	for i, row := range response.Skipped {
+		if row != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], row)
+		}
+	}
+
+	handler.storage.CreateRoom(models.Room{Name: "secret", Owner: "alice", Private: true})
+	_, response = importMessages("bob", "text/csv", "", "username,content,room\nbob,sneaky,secret\n")
+	if response.Imported != 0 || len(response.Skipped) != 1 || response.Skipped[0].Reason != "Room not found" {
+		t.Errorf("Expected the room to be hidden, got %+v", response)
+	}
+	_, response = importMessages("alice", "text/csv", "", "username,content,room\nalice,welcome,secret\n")
+	if response.Imported != 1 {
+		t.Errorf("Expected members to import into the room, got %+v", response)
+	}
+
+	for _, tc := range []struct{ contentType, query, body string }{
+		{"text/plain", "", "hello"},
+		{"text/csv", "", "id,content\n1,hello\n"},
+		{"text/csv", "?on_conflict=overwrite", "username,content\n"},
+	} {
+		if rr, _ := importMessages("", tc.contentType, tc.query, tc.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %+v, got %d", tc, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// maxImportLine bounds the length of a JSON Lines row
+const maxImportLine = 1 << 20
+
+// importMediaTypes maps the media types of import bodies to their formats
+var importMediaTypes = map[string]string{
+	"application/x-ndjson": "jsonl",
+	"application/jsonl":    "jsonl",
+	"text/csv":             "csv",
+}
+
+// rowError is a row that cannot be read. Reading continues with the next
+// row.
+type rowError struct {
+	line   int
+	reason string
+}
+
+func (e *rowError) Error() string { return e.reason }
+
+// messageReader reads the rows of an import. It returns io.EOF at the
+// end, *rowError for unreadable rows and other errors when reading cannot
+// go on.
+type messageReader interface {
+	Read() (line int, message models.Message, err error)
+}
+
+// ImportMessages handles POST /api/messages/import. The body holds
+// messages as written by ExportMessages, in JSON Lines or CSV as given by
+// the format parameter or the Content-Type. Rows are imported one by one
+// with their ID, timestamp, version and edit time, and replies follow
+// their parent if it was imported under another ID.
+//
+// A row whose ID is taken gets a new ID, or is skipped with
+// on_conflict=skip. Rows that cannot be imported are skipped and reported
+// with the reason. Importing into a room requires membership.
+func (h *Handler) ImportMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importMediaTypes[mediaType]
+	}
+	var reader messageReader
+	switch format {
+	case "jsonl":
+		reader = newJSONLReader(r.Body)
+	case "csv":
+		var err error
+		if reader, err = newCSVReader(r.Body); err != nil {
+			h.writeError(w, r, problem.Validation(err.Error()))
+			return
+		}
+	default:
+		h.writeError(w, r, problem.Validation("format must be jsonl or csv"))
+		return
+	}
+	var remap bool
+	switch query.Get("on_conflict") {
+	case "", "remap":
+		remap = true
+	case "skip":
+	default:
+		h.writeError(w, r, problem.Validation("on_conflict must be remap or skip"))
+		return
+	}
+
+	response := models.ImportResponse{Remapped: []models.IDMapping{}, Skipped: []models.SkippedRow{}}
+	skip := func(line, id int, reason string) {
+		response.Skipped = append(response.Skipped, models.SkippedRow{Line: line, ID: id, Reason: reason})
+	}
+	// ids maps the IDs of the imported rows to the IDs they got
+	ids := make(map[int]int)
+	rooms := make(map[string]error)
+
+	for {
+		line, message, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			skip(rowErr.line, 0, rowErr.reason)
+			continue
+		}
+		if err != nil {
+			h.writeError(w, r, problem.Validation(fmt.Sprintf("Import stopped at line %d after %d messages: %v",
+				line, response.Imported, err)))
+			return
+		}
+
+		req := models.CreateMessageRequest{Username: message.Username, Content: message.Content, ParentID: message.ParentID}
+		if err := req.Validate(); err != nil {
+			skip(line, message.ID, err.Error())
+			continue
+		}
+		if message.Room != "" {
+			err, checked := rooms[message.Room]
+			if !checked {
+				err = h.checkImportRoom(r, message.Room)
+				rooms[message.Room] = err
+			}
+			if err != nil {
+				skip(line, message.ID, reason(err))
+				continue
+			}
+		}
+		if message.ParentID != nil {
+			if parentID, ok := ids[*message.ParentID]; ok {
+				message.ParentID = &parentID
+			}
+		}
+
+		original := message.ID
+		stored, err := h.storage.Import(message, remap)
+		if err != nil {
+			skip(line, original, reason(storageProblem(err, "Failed to import message")))
+			continue
+		}
+		response.Imported++
+		if original > 0 {
+			ids[original] = stored.ID
+			if stored.ID != original {
+				response.Remapped = append(response.Remapped, models.IDMapping{From: original, To: stored.ID})
+			}
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+// checkImportRoom returns why the requester may not import into a room
+func (h *Handler) checkImportRoom(r *http.Request, name string) error {
+	room, err := h.storage.GetRoom(name)
+	if err == nil && !room.CanRead(requester(r)) {
+		err = storage.ErrRoomNotFound
+	}
+	if err != nil {
+		return storageProblem(err, "Failed to read room")
+	}
+	if !room.IsMember(requester(r)) {
+		return problem.Forbidden("Only members can import into this room")
+	}
+	return nil
+}
+
+// reason describes why a row was skipped
+func reason(err error) string {
+	p := problem.From(err)
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// jsonlReader reads a JSON message per line, skipping blank lines
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	return &jsonlReader{scanner: scanner}
+}
+
+func (jr *jsonlReader) Read() (int, models.Message, error) {
+	for jr.scanner.Scan() {
+		jr.line++
+		data := bytes.TrimSpace(jr.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var message models.Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			return jr.line, message, &rowError{jr.line, "invalid JSON"}
+		}
+		return jr.line, message, nil
+	}
+	if err := jr.scanner.Err(); err != nil {
+		return jr.line + 1, models.Message{}, err
+	}
+	return jr.line, models.Message{}, io.EOF
+}
+
+// csvReader reads messages from CSV with a header naming the columns, see
+// csvColumns. Only username and content are required, unknown columns
+// are ignored.
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	// line is where the last record started
+	line int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV must start with a header")
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Spreadsheets may start the file with a byte order mark
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, required := range []string{"username", "content"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header must name the %s column", required)
+		}
+	}
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (cr *csvReader) Read() (int, models.Message, error) {
+	record, err := cr.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, models.Message{}, &rowError{parseErr.StartLine, "invalid CSV: " + parseErr.Err.Error()}
+	}
+	if err != nil {
+		return cr.line + 1, models.Message{}, err
+	}
+	line, _ := cr.reader.FieldPos(0)
+	cr.line = line
+
+	field := func(name string) string {
+		if i, ok := cr.columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	message := models.Message{Username: field("username"), Content: field("content"), Room: field("room")}
+	if message.ID, err = atoiField(field("id")); err != nil {
+		return line, message, &rowError{line, "id must be a number"}
+	}
+	if message.Version, err = atoiField(field("version")); err != nil {
+		return line, message, &rowError{line, "version must be a number"}
+	}
+	if v := field("parent_id"); v != "" {
+		parentID, err := strconv.Atoi(v)
+		if err != nil {
+			return line, message, &rowError{line, "parent_id must be a number"}
+		}
+		message.ParentID = &parentID
+	}
+	if v := field("timestamp"); v != "" {
+		if message.Timestamp, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return line, message, &rowError{line, "timestamp must be an RFC 3339 time"}
+		}
+	}
+	if v := field("edited_at"); v != "" {
+		editedAt, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return line, message, &rowError{line, "edited_at must be an RFC 3339 time"}
+		}
+		message.EditedAt = &editedAt
+	}
+	return line, message, nil
+}
+
+// atoiField parses an optional number, zero if empty
+func atoiField(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
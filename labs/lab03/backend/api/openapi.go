@@ -0,0 +1,347 @@
+package api
+
+import (
+	"fmt"
+	"lab03-backend/models"
+	"lab03-backend/openapi"
+	"lab03-backend/storage"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// operation documents a route of SetupRoutes
+type operation struct {
+	summary string
+	tag     string
+	query   []*openapi.Parameter
+	// body is the request body type, nil for none
+	body interface{}
+	// accepts lists the media types of request bodies that are not JSON,
+	// which are not validated
+	accepts []string
+	// status is the success status and data the type of its data, nil
+	// when the response has no body
+	status int
+	data   interface{}
+	// produces lists the media types of responses that are not JSON,
+	// such as event streams and downloads
+	produces []string
+}
+
+// Tags group the operations of the document
+const (
+	tagMessages = "messages"
+	tagRooms    = "rooms"
+	tagMeta     = "meta"
+)
+
+// operations documents the routes by method and path template. Building
+// the document fails for routes missing here, so it stays in step with
+// SetupRoutes.
+var operations = map[string]operation{
+	"GET /api/messages": {summary: "List messages of the lobby", tag: tagMessages,
+		query: listParameters, status: http.StatusOK, data: []models.Message{}},
+	"POST /api/messages": {summary: "Post a message or reply to the lobby", tag: tagMessages,
+		body: models.CreateMessageRequest{}, status: http.StatusCreated, data: models.Message{}},
+	"GET /api/messages/stream": {summary: "Stream message changes as Server-Sent Events", tag: tagMessages,
+		query: streamParameters, status: http.StatusOK, produces: []string{"text/event-stream"}},
+	"GET /api/messages/search": {summary: "Search messages of the lobby", tag: tagMessages,
+		query: searchParameters, status: http.StatusOK, data: []storage.SearchResult{}},
+	"POST /api/messages:batch": {summary: "Create, edit and delete messages in one request", tag: tagMessages,
+		body: models.BatchRequest{}, status: http.StatusOK, data: models.BatchResponse{}},
+	"GET /api/messages/export": {summary: "Download messages as JSON Lines, CSV or HTML", tag: tagMessages,
+		query: exportParameters, status: http.StatusOK, produces: exportMediaTypes()},
+	"POST /api/messages/import": {summary: "Upload messages exported as JSON Lines or CSV", tag: tagMessages,
+		query: importParameters, accepts: []string{"application/x-ndjson", "text/csv"}, status: http.StatusOK,
+		data: models.ImportResponse{}},
+	"GET /api/messages/{id}": {summary: "Get a message", tag: tagMessages,
+		status: http.StatusOK, data: models.Message{}},
+	"PUT /api/messages/{id}": {summary: "Edit a message", tag: tagMessages,
+		body: models.UpdateMessageRequest{}, status: http.StatusOK, data: models.Message{}},
+	"DELETE /api/messages/{id}": {summary: "Delete a message", tag: tagMessages,
+		status: http.StatusNoContent},
+	"GET /api/messages/{id}/history": {summary: "List the revisions of a message", tag: tagMessages,
+		status: http.StatusOK, data: []models.Revision{}},
+	"POST /api/messages/{id}/restore": {summary: "Restore a deleted message", tag: tagMessages,
+		status: http.StatusOK, data: models.Message{}},
+	"GET /api/messages/{id}/replies": {summary: "List the replies to a message", tag: tagMessages,
+		query: listParameters, status: http.StatusOK, data: []models.Message{}},
+	"POST /api/messages/{id}/reactions/{emoji}": {summary: "React to a message", tag: tagMessages,
+		body: models.ReactionRequest{}, status: http.StatusCreated, data: models.Message{}},
+	"DELETE /api/messages/{id}/reactions/{emoji}": {summary: "Withdraw a reaction", tag: tagMessages,
+		body: models.ReactionRequest{}, status: http.StatusOK, data: models.Message{}},
+
+	"GET /api/rooms": {summary: "List the visible rooms", tag: tagRooms,
+		status: http.StatusOK, data: []models.Room{}},
+	"POST /api/rooms": {summary: "Create a room", tag: tagRooms,
+		body: models.CreateRoomRequest{}, status: http.StatusCreated, data: models.Room{}},
+	"GET /api/rooms/{room}": {summary: "Get a room", tag: tagRooms,
+		status: http.StatusOK, data: models.Room{}},
+	"PUT /api/rooms/{room}": {summary: "Update a room", tag: tagRooms,
+		body: models.UpdateRoomRequest{}, status: http.StatusOK, data: models.Room{}},
+	"DELETE /api/rooms/{room}": {summary: "Delete a room and its messages", tag: tagRooms,
+		status: http.StatusNoContent},
+	"POST /api/rooms/{room}/members": {summary: "Join a room or invite a user", tag: tagRooms,
+		body: models.MemberRequest{}, status: http.StatusCreated, data: models.Room{}},
+	"DELETE /api/rooms/{room}/members/{username}": {summary: "Leave a room or remove a member", tag: tagRooms,
+		status: http.StatusOK, data: models.Room{}},
+	"GET /api/rooms/{room}/messages": {summary: "List messages of a room", tag: tagRooms,
+		query: listParameters, status: http.StatusOK, data: []models.Message{}},
+	"POST /api/rooms/{room}/messages": {summary: "Post a message or reply to a room", tag: tagRooms,
+		body: models.CreateMessageRequest{}, status: http.StatusCreated, data: models.Message{}},
+	"GET /api/rooms/{room}/messages/search": {summary: "Search messages of a room", tag: tagRooms,
+		query: searchParameters, status: http.StatusOK, data: []storage.SearchResult{}},
+
+	"GET /api/status/{code}": {summary: "Describe an HTTP status code", tag: tagMeta,
+		status: http.StatusOK, data: models.HTTPStatusResponse{}},
+	"GET /api/health": {summary: "Report the health of the API", tag: tagMeta,
+		status: http.StatusOK, data: map[string]interface{}{}},
+	"GET /api/openapi.json": {summary: "Get this document", tag: tagMeta,
+		status: http.StatusOK},
+}
+
+// pathParameters describes the variables of the path templates
+var pathParameters = map[string]*openapi.Schema{
+	"id":       {Type: "integer", Minimum: float(1)},
+	"emoji":    {Type: "string", MinLength: length(1)},
+	"room":     {Type: "string", MaxLength: length(32), Pattern: "^[a-z0-9][a-z0-9_-]*$"},
+	"username": {Type: "string", MinLength: length(1)},
+	"code":     {Type: "integer", Minimum: float(100), Maximum: float(599)},
+}
+
+// listParameters are read by parseListOptions
+var listParameters = []*openapi.Parameter{
+	{Name: "limit", In: openapi.InQuery, Schema: &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(maxPageSize)}},
+	{Name: "cursor", In: openapi.InQuery, Description: "next_cursor of the previous page", Schema: &openapi.Schema{Type: "string"}},
+	{Name: "offset", In: openapi.InQuery, Schema: &openapi.Schema{Type: "integer", Minimum: float(0)}},
+	{Name: "username", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string"}},
+	{Name: "since", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	{Name: "until", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	{Name: "contains", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string"}},
+	{Name: "sort", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string", Enum: []string{"id", "timestamp"}}},
+	{Name: "order", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string", Enum: []string{"asc", "desc"}}},
+	{Name: "include_deleted", In: openapi.InQuery, Schema: &openapi.Schema{Type: "boolean"}},
+}
+
+// searchParameters are read by search
+var searchParameters = []*openapi.Parameter{
+	{Name: "q", In: openapi.InQuery, Required: true, Description: "words, OR and trailing * for prefixes",
+		Schema: &openapi.Schema{Type: "string", MinLength: length(1)}},
+	listParameters[0],
+	listParameters[2],
+}
+
+// streamParameters are read by StreamMessages
+var streamParameters = []*openapi.Parameter{
+	{Name: "last_event_id", In: openapi.InQuery, Schema: &openapi.Schema{Type: "integer", Minimum: float(0)}},
+	{Name: "username", In: openapi.InQuery, Description: "only messages of this author", Schema: &openapi.Schema{Type: "string"}},
+	{Name: "room", In: openapi.InQuery, Schema: pathParameters["room"]},
+	{Name: "user", In: openapi.InQuery, Description: "the requester, for clients that cannot set " + userHeader,
+		Schema: &openapi.Schema{Type: "string"}},
+}
+
+// exportParameters are read by ExportMessages
+var exportParameters = []*openapi.Parameter{
+	{Name: "format", In: openapi.InQuery, Schema: &openapi.Schema{Type: "string", Enum: []string{"jsonl", "csv", "html"}}},
+	listParameters[3],
+	listParameters[4],
+	listParameters[5],
+	streamParameters[2],
+	streamParameters[3],
+}
+
+// importParameters are read by ImportMessages
+var importParameters = []*openapi.Parameter{
+	{Name: "format", In: openapi.InQuery, Description: "defaults to the format of the Content-Type",
+		Schema: &openapi.Schema{Type: "string", Enum: []string{"jsonl", "csv"}}},
+	{Name: "on_conflict", In: openapi.InQuery, Description: "what happens to rows whose ID is taken",
+		Schema: &openapi.Schema{Type: "string", Enum: []string{"remap", "skip"}}},
+}
+
+// exportMediaTypes returns the media types of exportFormats
+func exportMediaTypes() []string {
+	var types []string
+	for _, format := range exportFormats {
+		mediaType, _, _ := mime.ParseMediaType(format.contentType)
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// userParameter identifies the requester for room access
+var userParameter = &openapi.Parameter{Name: userHeader, In: openapi.InHeader, Schema: &openapi.Schema{Type: "string"}}
+
+// rawContent describes bodies of the given media types as strings
+func rawContent(mediaTypes []string) map[string]*openapi.MediaType {
+	content := make(map[string]*openapi.MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string"}}
+	}
+	return content
+}
+
+func float(f float64) *float64 { return &f }
+func length(n int) *int        { return &n }
+
+// templateVar matches the variables of path templates, with an optional
+// pattern
+var templateVar = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIDocument describes the /api routes of router
+func openAPIDocument(router *mux.Router) (*openapi.Document, error) {
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Lab 03 chat API",
+			Description: "Messages, rooms and live updates of the lab 03 chat.",
+			Version:     "1.0.0",
+		},
+		Paths: make(map[string]openapi.PathItem),
+	}
+	gen := openapi.NewGenerator(doc)
+	errorResponse := &openapi.Response{
+		Description: "Error",
+		Content:     map[string]*openapi.MediaType{problem.ContentType: {Schema: gen.Schema(problem.Problem{})}},
+	}
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		// Path prefixes of subrouters have no methods
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			spec, ok := operations[method+" "+template]
+			if !ok {
+				return fmt.Errorf("route %s %s is not documented", method, template)
+			}
+			op := &openapi.Operation{
+				OperationID: operationID(route.GetHandler()),
+				Summary:     spec.summary,
+				Tags:        []string{spec.tag},
+				Responses:   map[string]*openapi.Response{"default": errorResponse},
+			}
+
+			for _, match := range templateVar.FindAllStringSubmatch(template, -1) {
+				schema, ok := pathParameters[match[1]]
+				if !ok {
+					return fmt.Errorf("path parameter %s of %s is not documented", match[1], template)
+				}
+				op.Parameters = append(op.Parameters, &openapi.Parameter{Name: match[1], In: openapi.InPath, Required: true, Schema: schema})
+			}
+			op.Parameters = append(op.Parameters, spec.query...)
+			if spec.tag != tagMeta {
+				op.Parameters = append(op.Parameters, userParameter)
+			}
+			if spec.body != nil {
+				op.RequestBody = openapi.JSONBody(gen.Schema(spec.body))
+			}
+			if len(spec.accepts) > 0 {
+				op.RequestBody = &openapi.RequestBody{Required: true, Content: rawContent(spec.accepts)}
+			}
+
+			description := http.StatusText(spec.status)
+			switch {
+			case len(spec.produces) > 0:
+				op.Responses[fmt.Sprint(spec.status)] = &openapi.Response{Description: description,
+					Content: rawContent(spec.produces)}
+			case spec.data != nil:
+				op.Responses[fmt.Sprint(spec.status)] = openapi.JSONResponse(description, envelope(gen, spec.data))
+			case spec.status == http.StatusNoContent:
+				op.Responses[fmt.Sprint(spec.status)] = &openapi.Response{Description: description}
+			default:
+				op.Responses[fmt.Sprint(spec.status)] = openapi.JSONResponse(description, &openapi.Schema{Type: "object"})
+			}
+
+			if doc.Paths[template] == nil {
+				doc.Paths[template] = make(openapi.PathItem)
+			}
+			doc.Paths[template][strings.ToLower(method)] = op
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every documented operation must exist, or the table has gone stale
+	var stale []string
+	for key := range operations {
+		method, template, _ := strings.Cut(key, " ")
+		if doc.Operation(template, method) == nil {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return nil, fmt.Errorf("documented routes do not exist: %s", strings.Join(stale, ", "))
+	}
+	return doc, nil
+}
+
+// envelope is the APIResponse schema with data of the given type
+func envelope(gen *openapi.Generator, data interface{}) *openapi.Schema {
+	return &openapi.Schema{
+		Type:     "object",
+		Required: []string{"success", "data"},
+		Properties: map[string]*openapi.Schema{
+			"success":     {Type: "boolean"},
+			"data":        gen.Schema(data),
+			"total":       {Type: "integer"},
+			"next_cursor": {Type: "string"},
+		},
+	}
+}
+
+// operationID derives the operation ID from the name of the handler
+// method, GetMessages becomes getMessages
+func operationID(handler http.Handler) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	name = name[strings.LastIndex(name, ".")+1:]
+	if name == "" {
+		return ""
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// routeOf reports the path template and variables of the route r matched
+func routeOf(r *http.Request) (string, map[string]string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", nil, false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "", nil, false
+	}
+	return template, mux.Vars(r), true
+}
+
+// GetOpenAPI handles GET /api/openapi.json, the OpenAPI document of the API
+func (h *Handler) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.spec)
+}
+
+// invalidRequest answers requests that do not match the document
+func (h *Handler) invalidRequest(w http.ResponseWriter, r *http.Request, errs []openapi.FieldError) {
+	fields := make([]problem.FieldError, len(errs))
+	for i, e := range errs {
+		fields[i] = problem.FieldError{In: e.In, Field: e.Field, Message: e.Message}
+	}
+	h.writeError(w, r, problem.Validation("Invalid request", fields...))
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Page sizes of GET /api/messages
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// cursor is the opaque position handed out as next_cursor. It records the
+// order it was issued for, so it cannot be replayed against another one.
+type cursor struct {
+	SortBy     storage.SortField `json:"s"`
+	Descending bool              `json:"d,omitempty"`
+	ID         int               `json:"i"`
+	Timestamp  int64             `json:"t,omitempty"`
+}
+
+// encodeCursor returns the cursor pointing after m
+func encodeCursor(opts storage.ListOptions, m *models.Message) string {
+	c := cursor{SortBy: opts.SortBy, Descending: opts.Descending, ID: m.ID}
+	if opts.SortBy == storage.SortByTimestamp {
+		c.Timestamp = m.Timestamp.UnixNano()
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, opts storage.ListOptions) (*storage.Position, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if c.SortBy != opts.SortBy || c.Descending != opts.Descending {
+		return nil, errors.New("cursor does not match the requested sort order")
+	}
+
+	pos := &storage.Position{ID: c.ID}
+	if c.SortBy == storage.SortByTimestamp {
+		pos.Timestamp = time.Unix(0, c.Timestamp)
+	}
+	return pos, nil
+}
+
+// parseListOptions reads the query parameters of GET /api/messages:
+//
+//	limit               page size, 1 to 200, default 50
+//	cursor | offset     where the page starts, next_cursor or a count
+//	username            exact author
+//	since, until        RFC 3339 times, since inclusive, until exclusive
+//	contains            substring of the content, ignoring case
+//	sort                id (default) or timestamp
+//	order               asc (default) or desc
+//	include_deleted     true to list deleted messages as well
+func parseListOptions(query url.Values) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Limit:    defaultPageSize,
+		Username: query.Get("username"),
+		Contains: query.Get("contains"),
+		SortBy:   storage.SortByID,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		opts.Limit = limit
+	}
+
+	switch sortBy := storage.SortField(query.Get("sort")); sortBy {
+	case "":
+	case storage.SortByID, storage.SortByTimestamp:
+		opts.SortBy = sortBy
+	default:
+		return opts, errors.New("sort must be id or timestamp")
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+
+	if v := query.Get("include_deleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, errors.New("include_deleted must be true or false")
+		}
+		opts.IncludeDeleted = include
+	}
+
+	var err error
+	if opts.Since, err = parseTime(query, "since"); err != nil {
+		return opts, err
+	}
+	if opts.Until, err = parseTime(query, "until"); err != nil {
+		return opts, err
+	}
+
+	cursorParam, offsetParam := query.Get("cursor"), query.Get("offset")
+	if cursorParam != "" && offsetParam != "" {
+		return opts, errors.New("cursor and offset cannot be combined")
+	}
+	if cursorParam != "" {
+		if opts.After, err = decodeCursor(cursorParam, opts); err != nil {
+			return opts, err
+		}
+	}
+	if offsetParam != "" {
+		offset, err := strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return opts, errors.New("offset must be a non-negative number")
+		}
+		opts.Offset = offset
+	}
+
+	return opts, nil
+}
+
+func parseTime(query url.Values, key string) (time.Time, error) {
+	v := query.Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", key)
+	}
+	return t, nil
+}
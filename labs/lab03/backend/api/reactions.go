@@ -0,0 +1,66 @@
+package api
+
+import (
+	"lab03-backend/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// AddReaction handles POST /api/messages/{id}/reactions/{emoji}. The body
+// names the reacting user, who can react once with each emoji.
+func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, true)
+}
+
+// RemoveReaction handles DELETE /api/messages/{id}/reactions/{emoji}, with
+// the same body as AddReaction
+func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, false)
+}
+
+func (h *Handler) changeReaction(w http.ResponseWriter, r *http.Request, add bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		h.writeError(w, r, problem.Validation("Invalid message ID"))
+		return
+	}
+	emoji := vars["emoji"]
+	if err := models.ValidateEmoji(emoji); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	var req models.ReactionRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	var message *models.Message
+	status := http.StatusOK
+	if add {
+		message, err = h.storage.AddReaction(id, emoji, req.Username)
+		status = http.StatusCreated
+	} else {
+		message, err = h.storage.RemoveReaction(id, emoji, req.Username)
+	}
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to change reaction")
+		return
+	}
+	w.Header().Set("ETag", etag(message))
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    message,
+	}
+	h.writeJSON(w, status, response)
+}
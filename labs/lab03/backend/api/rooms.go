@@ -0,0 +1,304 @@
+package api
+
+import (
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// userHeader names the user making a request. There is no authentication,
+// clients are trusted to send their own name. EventSource cannot set
+// headers, so the user query parameter is accepted as well.
+const userHeader = "X-Username"
+
+// requester returns the user making a request, empty if unknown
+func requester(r *http.Request) string {
+	if user := r.Header.Get(userHeader); user != "" {
+		return user
+	}
+	return r.URL.Query().Get("user")
+}
+
+// ListRooms handles GET /api/rooms. Private rooms are only listed for
+// their members.
+func (h *Handler) ListRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := h.storage.ListRooms()
+	if err != nil {
+		h.writeError(w, r, problem.Internal("Failed to list rooms", err))
+		return
+	}
+
+	user := requester(r)
+	visible := make([]*models.Room, 0, len(rooms))
+	for _, room := range rooms {
+		if room.CanRead(user) {
+			visible = append(visible, room)
+		}
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    visible,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// CreateRoom handles POST /api/rooms. The requester owns the room and is
+// its first member.
+func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
+	user := requester(r)
+	if user == "" {
+		h.writeError(w, r, problem.Unauthorized(userHeader+" header is required"))
+		return
+	}
+
+	var req models.CreateRoomRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	room, err := h.storage.CreateRoom(models.Room{
+		Name:        req.Name,
+		Description: req.Description,
+		Private:     req.Private,
+		Owner:       user,
+	})
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to create room")
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    room,
+	}
+	h.writeJSON(w, http.StatusCreated, response)
+}
+
+// GetRoom handles GET /api/rooms/{room}
+func (h *Handler) GetRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    room,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateRoom handles PUT /api/rooms/{room}. Only the owner may change the
+// description and visibility.
+func (h *Handler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.ownedRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateRoomRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
+		return
+	}
+	description, private := room.Description, room.Private
+	if req.Description != nil {
+		description = *req.Description
+	}
+	if req.Private != nil {
+		private = *req.Private
+	}
+
+	updated, err := h.storage.UpdateRoom(room.Name, description, private)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to update room")
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    updated,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteRoom handles DELETE /api/rooms/{room}. Only the owner may delete a
+// room, which permanently removes its messages.
+func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.ownedRoom(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.DeleteRoom(room.Name); err != nil {
+		h.writeStorageError(w, r, err, "Failed to delete room")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddRoomMember handles POST /api/rooms/{room}/members. Anyone may join a
+// public room; private rooms are joined by invitation of a member.
+func (h *Handler) AddRoomMember(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.MemberRequest
+	if err := h.parseJSON(r, &req); err != nil {
+		h.writeError(w, r, problem.Validation("Invalid JSON format"))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	updated, err := h.storage.AddMember(room.Name, req.Username)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to join room")
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    updated,
+	}
+	h.writeJSON(w, http.StatusCreated, response)
+}
+
+// RemoveRoomMember handles DELETE /api/rooms/{room}/members/{username}.
+// Members may leave, and the owner may remove anyone.
+func (h *Handler) RemoveRoomMember(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	if user := requester(r); user != username && user != room.Owner {
+		h.writeError(w, r, problem.Forbidden("Only the owner can remove other members"))
+		return
+	}
+
+	updated, err := h.storage.RemoveMember(room.Name, username)
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to leave room")
+		return
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Data:    updated,
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// GetRoomMessages handles GET /api/rooms/{room}/messages. It takes the
+// query parameters of GetMessages.
+func (h *Handler) GetRoomMessages(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+	opts.Room = room.Name
+
+	h.writePage(w, r, opts)
+}
+
+// CreateRoomMessage handles POST /api/rooms/{room}/messages. The author
+// must be a member of the room.
+func (h *Handler) CreateRoomMessage(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return
+	}
+
+	h.createMessage(w, r, room)
+}
+
+// SearchRoomMessages handles GET /api/rooms/{room}/messages/search like
+// SearchMessages
+func (h *Handler) SearchRoomMessages(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return
+	}
+
+	h.search(w, r, room.Name)
+}
+
+// readableRoom returns the room named in the path if the requester may
+// see it. Private rooms are not found for non-members.
+func (h *Handler) readableRoom(w http.ResponseWriter, r *http.Request) (*models.Room, bool) {
+	room, err := h.storage.GetRoom(mux.Vars(r)["room"])
+	if err != nil {
+		h.writeStorageError(w, r, err, "Failed to read room")
+		return nil, false
+	}
+	if !room.CanRead(requester(r)) {
+		h.writeStorageError(w, r, storage.ErrRoomNotFound, "")
+		return nil, false
+	}
+	return room, true
+}
+
+// ownedRoom returns the room named in the path if the requester owns it
+func (h *Handler) ownedRoom(w http.ResponseWriter, r *http.Request) (*models.Room, bool) {
+	room, ok := h.readableRoom(w, r)
+	if !ok {
+		return nil, false
+	}
+	if requester(r) != room.Owner {
+		h.writeError(w, r, problem.Forbidden("Only the owner can change the room"))
+		return nil, false
+	}
+	return room, true
+}
+
+// roomAccess answers 404 on the /api/messages/{id} routes for messages of
+// rooms the requester may not see. Invalid and unknown IDs are left to
+// the handlers.
+func (h *Handler) roomAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, err := h.storage.RoomOf(id)
+		if err != nil || name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		room, err := h.storage.GetRoom(name)
+		if err != nil {
+			h.writeError(w, r, problem.Internal("Failed to read room", err))
+			return
+		}
+		if !room.CanRead(requester(r)) {
+			h.writeStorageError(w, r, storage.ErrMessageNotFound, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"lab03-backend/models"
+	"lab03-backend/storage"
+	"net/http"
+	"strconv"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// SearchMessages handles GET /api/messages/search, which searches the
+// lobby. It takes the query in q, see storage.ParseQuery, and limit and
+// offset like GetMessages. Results are ranked best first and carry a
+// highlighted snippet.
+func (h *Handler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	h.search(w, r, "")
+}
+
+// search answers with the messages of room matching the request
+func (h *Handler) search(w http.ResponseWriter, r *http.Request, room string) {
+	params := r.URL.Query()
+
+	query, err := storage.ParseQuery(params.Get("q"))
+	if err != nil {
+		h.writeError(w, r, problem.Validation(err.Error()))
+		return
+	}
+
+	limit := defaultPageSize
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			h.writeError(w, r, problem.Validation(fmt.Sprintf("limit must be between 1 and %d", maxPageSize)))
+			return
+		}
+	}
+	offset := 0
+	if v := params.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			h.writeError(w, r, problem.Validation("offset must be a non-negative number"))
+			return
+		}
+	}
+
+	page, err := h.storage.Search(room, query, offset, limit)
+	if err != nil {
+		h.writeError(w, r, problem.Internal("Failed to search messages", err))
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    page.Results,
+		Total:   &page.Total,
+	})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"lab03-backend/storage"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// Stream timing. Heartbeats keep proxies from closing idle connections,
+// retry tells EventSource how long to wait before reconnecting.
+const (
+	defaultHeartbeat = 15 * time.Second
+	streamRetry      = 3 * time.Second
+)
+
+// StreamMessages handles GET /api/messages/stream, a Server-Sent Events
+// feed of created, updated and deleted messages.
+//
+// Clients resume with the Last-Event-ID header, or the last_event_id query
+// parameter on the first connection. Events still in the replay buffer are
+// sent again; if some were lost a reset event asks the client to reload
+// the messages. The feed covers the lobby, or the room named by the room
+// parameter, and the username parameter restricts it to one author.
+func (h *Handler) StreamMessages(w http.ResponseWriter, r *http.Request) {
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID uint64
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			h.writeError(w, r, problem.Validation("Invalid last event ID"))
+			return
+		}
+		lastEventID = id
+	}
+	username := r.URL.Query().Get("username")
+	room := r.URL.Query().Get("room")
+	if room != "" {
+		found, err := h.storage.GetRoom(room)
+		if err == nil && !found.CanRead(requester(r)) {
+			err = storage.ErrRoomNotFound
+		}
+		if err != nil {
+			h.writeStorageError(w, r, err, "Failed to read room")
+			return
+		}
+	}
+	wanted := func(event storage.Event) bool {
+		return event.Message.Room == room && (username == "" || event.Message.Username == username)
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	sub := h.storage.Subscribe(lastEventID)
+	defer sub.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Disable response buffering in nginx
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	if sub.Missed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range sub.Replay {
+		if wanted(event) {
+			writeEvent(w, event)
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-sub.C:
+			if !ok {
+				// The client fell behind, it reconnects and catches up
+				return
+			}
+			if !wanted(event) {
+				continue
+			}
+			writeEvent(w, event)
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes event in the SSE wire format, the message as data
+func writeEvent(w http.ResponseWriter, event storage.Event) {
+	data, _ := json.Marshal(event.Message)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
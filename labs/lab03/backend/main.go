@@ -2,22 +2,77 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"lab03-backend/api"
 	"lab03-backend/storage"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
 )
 
 func main() {
-	// Create a new memory storage instance
-	storage := storage.NewMemoryStorage()
+	// Open the storage selected by STORAGE (memory, sqlite or file) at
+	// STORAGE_PATH
+	store, err := openStore(os.Getenv("STORAGE"), os.Getenv("STORAGE_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// Purge deleted messages once they are older than MESSAGE_RETENTION,
+	// 30 days by default. Zero keeps them forever.
+	retention := 30 * 24 * time.Hour
+	if v := os.Getenv("MESSAGE_RETENTION"); v != "" {
+		if retention, err = time.ParseDuration(v); err != nil || retention < 0 {
+			log.Fatalf("Invalid MESSAGE_RETENTION %q", v)
+		}
+	}
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	if retention > 0 {
+		go purgeDeleted(purgeCtx, store, retention)
+	}
 
 	// Create a new API handler with the storage
-	handler := api.NewHandler(storage)
+	handler := api.NewHandler(store)
+
+	// Restrict CORS to a list of origins, e.g.
+	// CORS_ORIGINS=http://localhost:3000,https://*.example.com
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		policy, err := cors.New(cors.Options{AllowedOrigins: strings.Split(origins, ",")})
+		if err != nil {
+			log.Fatalf("Invalid CORS_ORIGINS: %v", err)
+		}
+		handler.SetCORSPolicy(policy)
+	}
+
+	// Limit each client, identified by API key or address, e.g.
+	// RATE_LIMIT=100/1m
+	if spec := os.Getenv("RATE_LIMIT"); spec != "" {
+		limit, err := ratelimit.ParseLimit(spec)
+		if err != nil {
+			log.Fatalf("Invalid RATE_LIMIT: %v", err)
+		}
+		limiter := ratelimit.New(ratelimit.NewMemoryStore())
+		limiter.Exempt("/metrics", "/api/health")
+		if err := limiter.SetDefault(ratelimit.Policy{
+			Limit: limit,
+			Key:   ratelimit.FirstOf(ratelimit.ByAPIKey("X-API-Key"), ratelimit.ByIP),
+		}); err != nil {
+			log.Fatalf("Invalid RATE_LIMIT: %v", err)
+		}
+		handler.SetRateLimiter(limiter)
+	}
 
 	// Setup routes using the handler
 	router := handler.SetupRoutes()
@@ -64,3 +119,47 @@ func main() {
 
 	log.Println("Server exited")
 }
+
+// openStore opens the message store of the given kind. Messages are kept
+// in memory unless a kind is configured.
+func openStore(kind, path string) (storage.MessageStore, error) {
+	switch kind {
+	case "", "memory":
+		return storage.NewMemoryStorage(), nil
+	case "sqlite":
+		if path == "" {
+			path = "messages.db"
+		}
+		log.Printf("Storing messages in SQLite database %s", path)
+		return storage.NewSQLiteStorage(path)
+	case "file":
+		if path == "" {
+			path = "messages.jsonl"
+		}
+		log.Printf("Storing messages in append-only file %s", path)
+		return storage.NewFileStorage(path)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q, expected memory, sqlite or file", kind)
+	}
+}
+
+// purgeDeleted periodically removes messages deleted longer than retention
+// ago, until ctx is done
+func purgeDeleted(ctx context.Context, store storage.MessageStore, retention time.Duration) {
+	ticker := time.NewTicker(min(retention, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := store.Purge(now.Add(-retention))
+			if err != nil {
+				log.Printf("Failed to purge deleted messages: %v", err)
+			} else if n > 0 {
+				log.Printf("Purged %d deleted messages", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+)
+
+// Batch modes
+const (
+	// BatchAtomic applies all operations or none, the default
+	BatchAtomic = "atomic"
+	// BatchBestEffort applies every operation that succeeds
+	BatchBestEffort = "best_effort"
+)
+
+// MaxBatchOperations bounds the operations of one batch
+const MaxBatchOperations = 100
+
+// BatchRequest represents the request to apply several message
+// operations at once
+type BatchRequest struct {
+	// Mode is atomic or best_effort
+	Mode       string           `json:"mode,omitempty"`
+	Operations []BatchOperation `json:"operations" validate:"required"`
+}
+
+// BatchOperation is one create, update or delete of a batch
+type BatchOperation struct {
+	Op string `json:"op" validate:"required"`
+	// ID selects the message to update or delete. A version makes the
+	// operation conditional, like If-Match.
+	ID      int `json:"id,omitempty"`
+	Version int `json:"version,omitempty"`
+	// Room, ParentID and Username describe created messages, like the
+	// create requests. Content is also the new content of updates.
+	Room     string `json:"room,omitempty"`
+	ParentID *int   `json:"parent_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// BatchResult reports the outcome of one operation with the status it
+// would have had on its own
+type BatchResult struct {
+	Index  int `json:"index"`
+	Status int `json:"status"`
+	// Message is the created or updated message
+	Message *Message `json:"message,omitempty"`
+	// Error describes why the operation was not applied
+	Error *problem.Problem `json:"error,omitempty"`
+}
+
+// BatchResponse represents the outcome of a batch
+type BatchResponse struct {
+	Mode string `json:"mode"`
+	// Applied and Failed count the operations, aborted ones are failed
+	Applied int           `json:"applied"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
+
+// Validate checks if the batch request is valid. Operations are checked
+// with their own Validate.
+func (r *BatchRequest) Validate() error {
+	switch r.Mode {
+	case "", BatchAtomic, BatchBestEffort:
+	default:
+		return errors.New("mode must be atomic or best_effort")
+	}
+	if len(r.Operations) == 0 {
+		return errors.New("operations are required")
+	}
+	if len(r.Operations) > MaxBatchOperations {
+		return fmt.Errorf("a batch holds at most %d operations", MaxBatchOperations)
+	}
+	return nil
+}
+
+// Validate checks if the batch operation is valid
+func (o *BatchOperation) Validate() error {
+	switch o.Op {
+	case "create":
+		req := CreateMessageRequest{Username: o.Username, Content: o.Content, ParentID: o.ParentID}
+		if err := req.Validate(); err != nil {
+			return err
+		}
+		if o.Room != "" {
+			if err := ValidateRoomName(o.Room); err != nil {
+				return err
+			}
+		}
+	case "update":
+		if o.ID <= 0 {
+			return errors.New("id must be a positive message ID")
+		}
+		req := UpdateMessageRequest{Content: o.Content}
+		if err := req.Validate(); err != nil {
+			return err
+		}
+	case "delete":
+		if o.ID <= 0 {
+			return errors.New("id must be a positive message ID")
+		}
+	default:
+		return errors.New("op must be create, update or delete")
+	}
+	if o.Version < 0 {
+		return errors.New("version must not be negative")
+	}
+	return nil
+}
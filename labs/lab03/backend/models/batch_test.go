@@ -0,0 +1,60 @@
+package models
+
+import "testing"
+
+func TestBatchRequestValidation(t *testing.T) {
+	operation := BatchOperation{Op: "delete", ID: 1}
+	tooMany := make([]BatchOperation, MaxBatchOperations+1)
+
+	tests := []struct {
+		name      string
+		request   BatchRequest
+		shouldErr bool
+	}{
+		{"default mode", BatchRequest{Operations: []BatchOperation{operation}}, false},
+		{"best effort", BatchRequest{Mode: BatchBestEffort, Operations: []BatchOperation{operation}}, false},
+		{"unknown mode", BatchRequest{Mode: "eventually", Operations: []BatchOperation{operation}}, true},
+		{"no operations", BatchRequest{Mode: BatchAtomic}, true},
+		{"too many operations", BatchRequest{Operations: tooMany}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Validate()
+			if (err != nil) != tt.shouldErr {
+				t.Errorf("Expected error: %v, got: %v", tt.shouldErr, err)
+			}
+		})
+	}
+}
+
+func TestBatchOperationValidation(t *testing.T) {
+	parentID := 0
+
+	tests := []struct {
+		name      string
+		operation BatchOperation
+		shouldErr bool
+	}{
+		{"create", BatchOperation{Op: "create", Username: "alice", Content: "hello"}, false},
+		{"create in room", BatchOperation{Op: "create", Room: "team", Username: "alice", Content: "hello"}, false},
+		{"create without content", BatchOperation{Op: "create", Username: "alice"}, true},
+		{"create in invalid room", BatchOperation{Op: "create", Room: "Team!", Username: "alice", Content: "hello"}, true},
+		{"create invalid reply", BatchOperation{Op: "create", ParentID: &parentID, Username: "alice", Content: "hello"}, true},
+		{"update", BatchOperation{Op: "update", ID: 1, Version: 2, Content: "edited"}, false},
+		{"update without ID", BatchOperation{Op: "update", Content: "edited"}, true},
+		{"update without content", BatchOperation{Op: "update", ID: 1}, true},
+		{"delete", BatchOperation{Op: "delete", ID: 1}, false},
+		{"delete negative version", BatchOperation{Op: "delete", ID: 1, Version: -1}, true},
+		{"unknown op", BatchOperation{Op: "restore", ID: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.operation.Validate()
+			if (err != nil) != tt.shouldErr {
+				t.Errorf("Expected error: %v, got: %v", tt.shouldErr, err)
+			}
+		})
+	}
+}
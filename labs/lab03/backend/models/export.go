@@ -0,0 +1,25 @@
+package models
+
+// ImportResponse reports the outcome of an import
+type ImportResponse struct {
+	Imported int `json:"imported"`
+	// Remapped lists the imported messages whose ID was taken
+	Remapped []IDMapping `json:"remapped"`
+	// Skipped lists the rows that were not imported
+	Skipped []SkippedRow `json:"skipped"`
+}
+
+// IDMapping is the ID a message was imported under
+type IDMapping struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// SkippedRow is a row that was not imported, with the reason
+type SkippedRow struct {
+	// Line is where the row starts in the imported file, from 1
+	Line int `json:"line"`
+	// ID is the ID of the row, if it could be read
+	ID     int    `json:"id,omitempty"`
+	Reason string `json:"reason"`
+}
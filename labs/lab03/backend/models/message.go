@@ -3,6 +3,8 @@ package models
 import (
 	"errors"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Message represents a chat message
@@ -11,12 +13,42 @@ type Message struct {
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	// Version starts at 1 and increases with every edit, deletion and
+	// restore. Replies and reactions do not change it, so If-Match only
+	// compares the version part of the ETag.
+	Version int `json:"version"`
+	// EditedAt is the time of the last edit, null if there was none
+	EditedAt *time.Time `json:"edited_at"`
+	// Deleted messages are kept until they are purged, so they can be
+	// restored
+	Deleted   bool       `json:"deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// ParentID is set on replies, ReplyCount counts the replies that are
+	// not deleted
+	ParentID   *int `json:"parent_id"`
+	ReplyCount int  `json:"reply_count"`
+	// Room is empty for messages in the lobby. Replies are in the room of
+	// their parent.
+	Room string `json:"room,omitempty"`
+	// Reactions counts the users who reacted with each emoji. Stores
+	// replace the map rather than modify it, so copies may share it.
+	Reactions map[string]int `json:"reactions,omitempty"`
+}
+
+// Revision is one version of the content of a message
+type Revision struct {
+	Version int    `json:"version"`
+	Content string `json:"content"`
+	// CreatedAt is when the content was written
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // CreateMessageRequest represents the request to create a new message
 type CreateMessageRequest struct {
 	Username string `json:"username" validate:"required"`
 	Content  string `json:"content" validate:"required"`
+	// ParentID makes the message a reply
+	ParentID *int `json:"parent_id,omitempty" validate:"min=1"`
 }
 
 // UpdateMessageRequest represents the request to update a message
@@ -24,6 +56,11 @@ type UpdateMessageRequest struct {
 	Content string `json:"content" validate:"required"`
 }
 
+// ReactionRequest represents the request to add or remove a reaction
+type ReactionRequest struct {
+	Username string `json:"username" validate:"required"`
+}
+
 // HTTPStatusResponse represents the response for HTTP status code endpoint
 type HTTPStatusResponse struct {
 	StatusCode  int    `json:"status_code"`
@@ -36,6 +73,11 @@ type APIResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+
+	// Total and NextCursor are set on paginated listings. NextCursor is
+	// empty on the last page.
+	Total      *int   `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // NewMessage creates a new message with the current timestamp
@@ -45,6 +87,7 @@ func NewMessage(id int, username, content string) *Message {
 		Username:  username,
 		Content:   content,
 		Timestamp: time.Now(),
+		Version:   1,
 	}
 }
 
@@ -56,6 +99,9 @@ func (r *CreateMessageRequest) Validate() error {
 	if r.Content == "" {
 		return errors.New("content is required")
 	}
+	if r.ParentID != nil && *r.ParentID <= 0 {
+		return errors.New("parent_id must be a positive message ID")
+	}
 	return nil
 }
 
@@ -66,3 +112,72 @@ func (r *UpdateMessageRequest) Validate() error {
 	}
 	return nil
 }
+
+// Validate checks if the reaction request is valid
+func (r *ReactionRequest) Validate() error {
+	if r.Username == "" {
+		return errors.New("username is required")
+	}
+	return nil
+}
+
+// maxEmojiRunes allows sequences such as families and flags
+const maxEmojiRunes = 10
+
+// ValidateEmoji accepts a single emoji: one symbol with an optional
+// variation selector or skin tone modifier, symbols joined with ZWJ, a
+// flag, a keycap or a tag sequence. Runs of several emoji are rejected.
+func ValidateEmoji(emoji string) error {
+	runes := []rune(emoji)
+	if len(runes) == 0 || len(runes) > maxEmojiRunes || !utf8.ValidString(emoji) ||
+		!isKeycap(runes) && !isFlag(runes) && !isEmojiSequence(runes) {
+		return errors.New("reaction must be a single emoji")
+	}
+	return nil
+}
+
+// isKeycap matches keycaps such as 1️⃣
+func isKeycap(runes []rune) bool {
+	if len(runes) == 3 && runes[1] == '\ufe0f' {
+		runes = []rune{runes[0], runes[2]}
+	}
+	return len(runes) == 2 && runes[1] == '\u20e3' &&
+		(runes[0] == '#' || runes[0] == '*' || runes[0] >= '0' && runes[0] <= '9')
+}
+
+// isFlag matches a pair of regional indicators
+func isFlag(runes []rune) bool {
+	return len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1])
+}
+
+// isEmojiSequence matches symbols joined with ZWJ, each followed by
+// optional variation selectors, skin tone modifiers and tags
+func isEmojiSequence(runes []rune) bool {
+	i := 0
+	for {
+		if i == len(runes) || !unicode.Is(unicode.So, runes[i]) || isRegionalIndicator(runes[i]) {
+			return false
+		}
+		i++
+		for i < len(runes) && isEmojiModifier(runes[i]) {
+			i++
+		}
+		if i == len(runes) {
+			return true
+		}
+		if runes[i] != '\u200d' {
+			return false
+		}
+		i++
+	}
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isEmojiModifier reports whether r changes the preceding symbol: the
+// emoji variation selector, a skin tone or a tag of a subdivision flag
+func isEmojiModifier(r rune) bool {
+	return r == '\ufe0f' || r >= 0x1f3fb && r <= 0x1f3ff || r >= 0xe0020 && r <= 0xe007f
+}
@@ -74,6 +74,24 @@ func TestCreateMessageRequestValidation(t *testing.T) {
 			},
 			shouldErr: true,
 		},
+		{
+			name: "reply",
+			request: CreateMessageRequest{
+				Username: "testuser",
+				Content:  "test content",
+				ParentID: intPtr(1),
+			},
+			shouldErr: false,
+		},
+		{
+			name: "invalid parent",
+			request: CreateMessageRequest{
+				Username: "testuser",
+				Content:  "test content",
+				ParentID: intPtr(0),
+			},
+			shouldErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -123,3 +141,48 @@ func TestUpdateMessageRequestValidation(t *testing.T) {
 		})
 	}
 }
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestValidateEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		valid bool
+	}{
+		{"👍", true},
+		{"👍🏽", true},
+		{"❤️", true},
+		{"👨‍👩‍👧", true},
+		{"🇫🇷", true},
+		{"1️⃣", true},
+		{"#️⃣", true},
+		{"🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"", false},
+		{"a", false},
+		{"1", false},
+		{"👍 ", false},
+		{"<script>", false},
+		{"👍👍👍👍👍👍👍👍👍👍👍", false},
+		{"👍👍", false},
+		{"👍👍👍", false},
+		{"❤️❤️", false},
+		{"🇫🇷🇩🇪", false},
+		{"🇫", false},
+		{"👍\u200d", false},
+		{"\ufe0f", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.emoji, func(t *testing.T) {
+			err := ValidateEmoji(tt.emoji)
+			if tt.valid && err != nil {
+				t.Errorf("Expected %q to be valid, got: %v", tt.emoji, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("Expected %q to be invalid", tt.emoji)
+			}
+		})
+	}
+}
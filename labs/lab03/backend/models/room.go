@@ -0,0 +1,86 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Room groups messages. Messages outside any room belong to the lobby,
+// which is served by /api/messages.
+type Room struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Private rooms and their messages are only visible to members
+	Private   bool      `json:"private"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+	// Members is sorted. Stores replace the slice rather than modify it,
+	// so copies may share it.
+	Members []string `json:"members"`
+	// MessageCount counts the messages that are not deleted
+	MessageCount int `json:"message_count"`
+}
+
+// IsMember reports whether username has joined the room
+func (r *Room) IsMember(username string) bool {
+	i := sort.SearchStrings(r.Members, username)
+	return i < len(r.Members) && r.Members[i] == username
+}
+
+// CanRead reports whether username may see the room and its messages
+func (r *Room) CanRead(username string) bool {
+	return !r.Private || r.IsMember(username)
+}
+
+// CreateRoomRequest represents the request to create a room
+type CreateRoomRequest struct {
+	Name        string `json:"name" validate:"required,max=32" pattern:"^[a-z0-9][a-z0-9_-]*$"`
+	Description string `json:"description"`
+	Private     bool   `json:"private"`
+}
+
+// UpdateRoomRequest represents the request to update a room. Omitted
+// fields are left unchanged.
+type UpdateRoomRequest struct {
+	Description *string `json:"description"`
+	Private     *bool   `json:"private"`
+}
+
+// MemberRequest represents the request to add a member to a room
+type MemberRequest struct {
+	Username string `json:"username" validate:"required"`
+}
+
+// maxRoomName keeps room names usable in paths and UIs
+const maxRoomName = 32
+
+// ValidateRoomName accepts 1 to 32 lowercase letters, digits, - and _,
+// starting with a letter or digit
+func ValidateRoomName(name string) error {
+	if name == "" || len(name) > maxRoomName {
+		return errors.New("room name must be 1 to 32 characters")
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '-' || c == '_') && i > 0:
+		default:
+			return errors.New("room name may only contain lowercase letters, digits, - and _")
+		}
+	}
+	return nil
+}
+
+// Validate checks if the create room request is valid
+func (r *CreateRoomRequest) Validate() error {
+	return ValidateRoomName(r.Name)
+}
+
+// Validate checks if the member request is valid
+func (r *MemberRequest) Validate() error {
+	if r.Username == "" {
+		return errors.New("username is required")
+	}
+	return nil
+}
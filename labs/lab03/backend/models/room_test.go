@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func TestValidateRoomName(t *testing.T) {
+	for _, name := range []string{"general", "go-1", "a", "team_chat", "0day"} {
+		if err := ValidateRoomName(name); err != nil {
+			t.Errorf("ValidateRoomName(%q): expected no error, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "General", "-start", "with space", "a/b", "café", "abcdefghijklmnopqrstuvwxyz0123456"} {
+		if err := ValidateRoomName(name); err == nil {
+			t.Errorf("ValidateRoomName(%q): expected error, got nil", name)
+		}
+	}
+}
+
+func TestRoomAccess(t *testing.T) {
+	room := Room{Name: "team", Members: []string{"alice", "carol"}}
+	if !room.IsMember("carol") || room.IsMember("bob") {
+		t.Errorf("Unexpected membership for %v", room.Members)
+	}
+	if !room.CanRead("bob") {
+		t.Error("Expected public room to be readable by anyone")
+	}
+
+	room.Private = true
+	if room.CanRead("bob") || room.CanRead("") || !room.CanRead("alice") {
+		t.Error("Expected private room to be readable by members only")
+	}
+}
@@ -0,0 +1,121 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type item struct {
+	Name  string   `json:"name" validate:"required,max=8" pattern:"^[a-z]+$"`
+	Count int      `json:"count" validate:"min=1"`
+	Tags  []string `json:"tags,omitempty"`
+	Note  *string  `json:"note"`
+}
+
+type order struct {
+	Items   []item    `json:"items" validate:"required"`
+	Placed  time.Time `json:"placed"`
+	Ignored string    `json:"-"`
+}
+
+func TestGeneratorSchema(t *testing.T) {
+	doc := &Document{}
+	gen := NewGenerator(doc)
+
+	ref := gen.Schema(order{})
+	if ref.Ref != refPrefix+"order" {
+		t.Fatalf("Expected a reference to order, got %+v", ref)
+	}
+
+	s := doc.Components.Schemas["order"]
+	if s == nil || s.Type != "object" {
+		t.Fatalf("Expected an object component, got %+v", s)
+	}
+	if len(s.Required) != 1 || s.Required[0] != "items" {
+		t.Errorf("Expected items to be required, got %v", s.Required)
+	}
+	if _, ok := s.Properties["Ignored"]; ok {
+		t.Error("Expected fields tagged - to be skipped")
+	}
+	if placed := s.Properties["placed"]; placed.Type != "string" || placed.Format != "date-time" {
+		t.Errorf("Expected a date-time string, got %+v", placed)
+	}
+	if items := s.Properties["items"]; items.Type != "array" || items.Items.Ref != refPrefix+"item" {
+		t.Errorf("Expected an array of item, got %+v", items)
+	}
+
+	it := doc.Components.Schemas["item"]
+	name := it.Properties["name"]
+	if *name.MinLength != 1 || *name.MaxLength != 8 || name.Pattern != "^[a-z]+$" {
+		t.Errorf("Expected name constraints, got %+v", name)
+	}
+	if count := it.Properties["count"]; count.Type != "integer" || *count.Minimum != 1 {
+		t.Errorf("Expected an integer of at least 1, got %+v", count)
+	}
+	if note := it.Properties["note"]; !note.Nullable {
+		t.Errorf("Expected pointers to be nullable, got %+v", note)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	doc := &Document{}
+	gen := NewGenerator(doc)
+	op := &Operation{
+		Parameters: []*Parameter{
+			{Name: "id", In: InPath, Required: true, Schema: &Schema{Type: "integer"}},
+			{Name: "verbose", In: InQuery, Schema: &Schema{Type: "boolean"}},
+		},
+		RequestBody: JSONBody(gen.Schema(order{})),
+	}
+
+	tests := []struct {
+		name, id, query, body string
+		want                  []FieldError
+	}{
+		{"valid", "1", "verbose=true", `{"items":[{"name":"pen","count":2}]}`, nil},
+		{"path", "x", "", `{"items":[]}`, []FieldError{{In: InPath, Field: "id", Message: "must be an integer"}}},
+		{"query", "1", "verbose=maybe", `{"items":[]}`, []FieldError{{In: InQuery, Field: "verbose", Message: "must be true or false"}}},
+		{"missing body", "1", "", "", []FieldError{{In: "body", Message: "is required"}}},
+		{"nested", "1", "", `{"items":[{"name":"Pen!","count":0}],"placed":"today"}`, []FieldError{
+			{In: "body", Field: "items[0].count", Message: "must be at least 1"},
+			{In: "body", Field: "items[0].name", Message: "must match ^[a-z]+$"},
+			{In: "body", Field: "placed", Message: "must be an RFC 3339 time"},
+		}},
+		{"null", "1", "", `{"items":null}`, []FieldError{{In: "body", Field: "items", Message: "must not be null"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "/orders?"+tt.query, strings.NewReader(tt.body))
+			errs, err := doc.ValidateRequest(op, r, map[string]string{"id": tt.id})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Expected errors %v, got %v", tt.want, errs)
+			}
+			for i := range tt.want {
+				if errs[i] != tt.want[i] {
+					t.Errorf("Expected error %v, got %v", tt.want[i], errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRequestRestoresBody(t *testing.T) {
+	doc := &Document{}
+	op := &Operation{RequestBody: JSONBody(&Schema{Type: "object"})}
+
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"a":1}`))
+	if errs, err := doc.ValidateRequest(op, r, nil); err != nil || len(errs) > 0 {
+		t.Fatalf("Expected a valid body, got %v %v", errs, err)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil || string(body) != `{"a":1}` {
+		t.Errorf("Expected the body to be readable again, got %q", body)
+	}
+}
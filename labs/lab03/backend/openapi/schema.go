@@ -0,0 +1,154 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// refPrefix starts the references to component schemas
+const refPrefix = "#/components/schemas/"
+
+// Generator turns Go types into schemas. Named struct types become
+// components of the document and are referenced with $ref.
+//
+// Struct fields are named by their json tag. The validate tag adds
+// constraints: required, min=N and max=N, which bound the length of
+// strings and the value of numbers. Required strings must not be empty.
+// The pattern tag holds a regular expression strings must match.
+type Generator struct {
+	doc *Document
+}
+
+// NewGenerator returns a generator adding components to doc
+func NewGenerator(doc *Document) *Generator {
+	if doc.Components.Schemas == nil {
+		doc.Components.Schemas = make(map[string]*Schema)
+	}
+	return &Generator{doc: doc}
+}
+
+// Schema returns the schema of the type of v
+func (g *Generator) Schema(v interface{}) *Schema {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schemaOf(t.Elem())
+		if s.Ref != "" {
+			// Siblings of $ref are ignored, nullable cannot be expressed
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, done := g.doc.Components.Schemas[t.Name()]; !done {
+			// Reserve the name first, types may refer to themselves
+			g.doc.Components.Schemas[t.Name()] = &Schema{}
+			*g.doc.Components.Schemas[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: refPrefix + t.Name()}
+	default:
+		// interface{} and anything else accept any value
+		return &Schema{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n, _, _ := strings.Cut(tag, ","); n != "" {
+				name = n
+			}
+		}
+
+		prop := g.schemaOf(field.Type)
+		if prop.Ref == "" {
+			if constrain(prop, field.Tag) {
+				s.Required = append(s.Required, name)
+			}
+		}
+		s.Properties[name] = prop
+	}
+	return s
+}
+
+// constrain applies the validate and pattern tags to s and reports
+// whether the field is required
+func constrain(s *Schema, tag reflect.StructTag) bool {
+	required := false
+	for _, rule := range strings.Split(tag.Get("validate"), ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		n, err := strconv.Atoi(value)
+		switch {
+		case key == "required":
+			required = true
+		case (key == "min" || key == "max") && err == nil:
+			bound(s, key == "min", n)
+		}
+	}
+	if required && s.Type == "string" && s.MinLength == nil {
+		bound(s, true, 1)
+	}
+	s.Pattern = tag.Get("pattern")
+	return required
+}
+
+// bound sets the minimum or maximum of a number, or length of a string
+func bound(s *Schema, min bool, n int) {
+	switch s.Type {
+	case "string":
+		if min {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case "integer", "number":
+		f := float64(n)
+		if min {
+			s.Minimum = &f
+		} else {
+			s.Maximum = &f
+		}
+	}
+}
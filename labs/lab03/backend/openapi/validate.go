@@ -0,0 +1,297 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError is a request value that does not match its schema
+type FieldError struct {
+	// In is body, path, query or header
+	In string `json:"in"`
+	// Field is the parameter name, or the path to a body value such as
+	// items[0].name, empty for the whole body
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// RouteFunc returns the path template a request was routed to and its
+// path parameters, or false if it matched no route
+type RouteFunc func(r *http.Request) (template string, params map[string]string, ok bool)
+
+// maxBody bounds the request bodies read for validation
+const maxBody = 1 << 20
+
+// Middleware validates requests against the operation of their route in
+// doc. Invalid requests are passed to invalid with their errors, others
+// continue with the body restored. Requests without an operation are not
+// checked. Unknown query parameters and body properties are allowed.
+func Middleware(doc *Document, route RouteFunc, invalid func(w http.ResponseWriter, r *http.Request, errs []FieldError)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			template, params, ok := route(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			op := doc.Operation(template, r.Method)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			errs, err := doc.ValidateRequest(op, r, params)
+			if err != nil {
+				errs = append(errs, FieldError{In: "body", Message: err.Error()})
+			}
+			if len(errs) > 0 {
+				invalid(w, r, errs)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidateRequest checks the parameters and body of r against op. The
+// body is read and replaced, so handlers can still decode it. The error
+// reports a body that could not be read.
+func (d *Document) ValidateRequest(op *Operation, r *http.Request, params map[string]string) ([]FieldError, error) {
+	var errs []FieldError
+	query := r.URL.Query()
+
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case InPath:
+			raw, present = params[p.Name]
+		case InQuery:
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		case InHeader:
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		}
+		if !present {
+			if p.Required {
+				errs = append(errs, FieldError{In: p.In, Field: p.Name, Message: "is required"})
+			}
+			continue
+		}
+
+		value, msg := parseParameter(d.resolve(p.Schema), raw)
+		if msg != "" {
+			errs = append(errs, FieldError{In: p.In, Field: p.Name, Message: msg})
+			continue
+		}
+		d.validate(p.Schema, value, p.In, p.Name, &errs)
+	}
+
+	if op.RequestBody == nil || op.RequestBody.Content[JSON] == nil {
+		return errs, nil
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return errs, fmt.Errorf("could not be read: %w", err)
+	}
+	if len(data) > maxBody {
+		return append(errs, FieldError{In: "body", Message: fmt.Sprintf("must not exceed %d bytes", maxBody)}), nil
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if op.RequestBody.Required {
+			errs = append(errs, FieldError{In: "body", Message: "is required"})
+		}
+		return errs, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return append(errs, FieldError{In: "body", Message: "must be valid JSON"}), nil
+	}
+	d.validate(op.RequestBody.Content[JSON].Schema, body, "body", "", &errs)
+	return errs, nil
+}
+
+// parseParameter converts a parameter to the JSON value its schema
+// describes, or returns why it cannot
+func parseParameter(s *Schema, raw string) (interface{}, string) {
+	if s == nil {
+		return raw, ""
+	}
+	switch s.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, "must be an integer"
+		}
+		return json.Number(raw), ""
+	case "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return nil, "must be a number"
+		}
+		return json.Number(raw), ""
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, "must be true or false"
+		}
+		return b, ""
+	default:
+		return raw, ""
+	}
+}
+
+// validate appends the errors of value, decoded with UseNumber, against s
+func (d *Document) validate(s *Schema, value interface{}, in, field string, errs *[]FieldError) {
+	s = d.resolve(s)
+	if s == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{In: in, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		if !s.Nullable && s.Type != "" {
+			fail("must not be null")
+		}
+		return
+	}
+
+	switch s.Type {
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			if *s.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters", *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" && !compile(s.Pattern).MatchString(str) {
+			fail("must match %s", s.Pattern)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			fail("must be one of %s", strings.Join(s.Enum, ", "))
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				fail("must be an RFC 3339 time")
+			}
+		}
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		if s.Type == "integer" {
+			if _, err := num.Int64(); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		f, err := num.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			d.validate(s.Items, item, in, fmt.Sprintf("%s[%d]", field, i), errs)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range s.Required {
+			if _, present := object[name]; !present {
+				*errs = append(*errs, FieldError{In: in, Field: join(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v := object[name]
+			if prop, known := s.Properties[name]; known {
+				d.validate(prop, v, in, join(field, name), errs)
+			} else if s.AdditionalProperties != nil {
+				d.validate(s.AdditionalProperties, v, in, join(field, name), errs)
+			}
+		}
+	}
+}
+
+// join appends a property name to the path of a body value
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// patterns caches compiled schema patterns
+var patterns sync.Map
+
+func compile(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
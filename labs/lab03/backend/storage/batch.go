@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+)
+
+// Kinds of batch operations
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchOp is one change of a batch
+type BatchOp struct {
+	Op string
+	// Room, ParentID and Username describe created messages. Room is
+	// empty for the lobby. Replies must be in the room of their parent,
+	// or fail with ErrParentNotFound. Content is also the new content of
+	// updates.
+	Room     string
+	ParentID *int
+	Username string
+	Content  string
+	// ID selects the message to update or delete, which must be at
+	// Version unless it is AnyVersion
+	ID      int
+	Version int
+}
+
+// BatchResult is the outcome of one operation of a batch. Message is the
+// message as the operation left it, nil if it failed.
+type BatchResult struct {
+	Message *models.Message
+	Err     error
+}
+
+// ErrBatchAborted is the result of the operations of an atomic batch that
+// were not applied because another one failed
+var ErrBatchAborted = errors.New("batch aborted")
+
+// errUnknownBatchOp is returned for operations of an unknown kind
+var errUnknownBatchOp = errors.New("unknown batch operation")
+
+// batchChange is an operation applied by a batch, published once the
+// batch is complete
+type batchChange struct {
+	typ     EventType
+	message *models.Message
+	// rec logs the change and undo reverts it, for the stores keeping
+	// their state in a MemoryStorage
+	rec  record
+	undo func()
+}
+
+// abortBatch marks every operation but the failed one as aborted
+func abortBatch(results []BatchResult, failed int) {
+	for i := range results {
+		if i != failed {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+}
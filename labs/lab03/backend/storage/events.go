@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"lab03-backend/models"
+	"sync"
+	"time"
+)
+
+// EventType describes a change to a message
+type EventType string
+
+// Event types published by the stores
+const (
+	EventCreated  EventType = "created"
+	EventUpdated  EventType = "updated"
+	EventDeleted  EventType = "deleted"
+	EventRestored EventType = "restored"
+	// EventReacted carries the new reaction counts of a message
+	EventReacted EventType = "reacted"
+)
+
+// Event is a change to a message. IDs increase by one per event, so a
+// client that remembers the last ID it saw can resume from there.
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type EventType `json:"type"`
+	// Message is a copy of the message after the change, or before it for
+	// deletions
+	Message *models.Message `json:"message"`
+	Time    time.Time       `json:"time"`
+}
+
+// Default sizes of the replay buffer and of each subscriber's queue
+const (
+	DefaultReplayBuffer = 256
+	subscriberQueue     = 64
+)
+
+// Broker fans events out to subscribers and keeps the most recent ones for
+// clients that reconnect
+type Broker struct {
+	mutex  sync.Mutex
+	lastID uint64
+	buffer []Event
+	size   int
+	subs   map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker replaying up to bufferSize events
+func NewBroker(bufferSize int) *Broker {
+	return &Broker{
+		size: bufferSize,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to every subscriber.
+// Subscribers that fall behind are dropped and must resubscribe.
+func (b *Broker) Publish(typ EventType, message *models.Message) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	copied := *message
+	b.lastID++
+	event := Event{ID: b.lastID, Type: typ, Message: &copied, Time: time.Now()}
+
+	b.buffer = append(b.buffer, event)
+	if len(b.buffer) > b.size {
+		b.buffer = b.buffer[len(b.buffer)-b.size:]
+	}
+
+	for sub := range b.subs {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subs, sub)
+			close(sub.events)
+		}
+	}
+	return event
+}
+
+// Subscribe starts delivering events. If lastEventID is not zero, the
+// buffered events after it are returned in Replay, and Missed reports
+// whether some of them are no longer available.
+func (b *Broker) Subscribe(lastEventID uint64) *Subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	sub := &Subscription{broker: b, events: make(chan Event, subscriberQueue)}
+	sub.C = sub.events
+
+	if lastEventID > 0 {
+		// IDs from a previous run of the server are unknown as well
+		oldest := b.lastID + 1
+		if len(b.buffer) > 0 {
+			oldest = b.buffer[0].ID
+		}
+		sub.Missed = lastEventID+1 < oldest || lastEventID > b.lastID
+
+		for _, event := range b.buffer {
+			if event.ID > lastEventID {
+				sub.Replay = append(sub.Replay, event)
+			}
+		}
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Subscription receives events until it is closed
+type Subscription struct {
+	// C delivers live events. It is closed when the subscriber falls
+	// behind or the subscription is closed.
+	C <-chan Event
+	// Replay holds the buffered events after the requested ID
+	Replay []Event
+	// Missed is set when events after the requested ID were dropped
+	Missed bool
+
+	broker *Broker
+	events chan Event
+}
+
+// Close stops the delivery of events
+func (s *Subscription) Close() {
+	b := s.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
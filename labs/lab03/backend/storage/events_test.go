@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"lab03-backend/models"
+	"testing"
+)
+
+func publish(b *Broker, n int) {
+	for i := 0; i < n; i++ {
+		b.Publish(EventCreated, &models.Message{ID: i + 1})
+	}
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(3)
+	publish(b, 5)
+
+	tests := []struct {
+		name        string
+		lastEventID uint64
+		replay      []uint64
+		missed      bool
+	}{
+		{"fresh subscriber", 0, nil, false},
+		{"up to date", 5, nil, false},
+		{"within buffer", 3, []uint64{4, 5}, false},
+		{"oldest buffered", 2, []uint64{3, 4, 5}, false},
+		{"before buffer", 1, []uint64{3, 4, 5}, true},
+		{"unknown ID", 9, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := b.Subscribe(tt.lastEventID)
+			defer sub.Close()
+
+			var ids []uint64
+			for _, event := range sub.Replay {
+				ids = append(ids, event.ID)
+			}
+			if len(ids) != len(tt.replay) {
+				t.Fatalf("Expected replay %v, got %v", tt.replay, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.replay[i] {
+					t.Fatalf("Expected replay %v, got %v", tt.replay, ids)
+				}
+			}
+			if sub.Missed != tt.missed {
+				t.Errorf("Expected missed %v, got %v", tt.missed, sub.Missed)
+			}
+		})
+	}
+}
+
+func TestBrokerCopiesMessages(t *testing.T) {
+	b := NewBroker(1)
+	message := &models.Message{ID: 1, Content: "before"}
+	event := b.Publish(EventUpdated, message)
+	message.Content = "after"
+
+	if event.Message.Content != "before" {
+		t.Errorf("Expected event to keep %q, got %q", "before", event.Message.Content)
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	b := NewBroker(1)
+	slow := b.Subscribe(0)
+	publish(b, subscriberQueue+1)
+
+	received := 0
+	for range slow.C {
+		received++
+	}
+	if received != subscriberQueue {
+		t.Errorf("Expected %d queued events before the drop, got %d", subscriberQueue, received)
+	}
+
+	// Closing a dropped subscription is harmless
+	slow.Close()
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	b := NewBroker(1)
+	sub := b.Subscribe(0)
+	sub.Close()
+	sub.Close()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("Expected closed channel")
+	}
+	publish(b, 1)
+}
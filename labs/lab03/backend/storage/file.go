@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lab03-backend/models"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the log of FileStorage
+const (
+	opCreate  = "create"
+	opUpdate  = "update"
+	opDelete  = "delete"
+	opRestore = "restore"
+	opPurge   = "purge"
+	opReact   = "react"
+	opUnreact = "unreact"
+	// opBatch holds the records of a batch, so that a torn write loses
+	// all of them
+	opBatch = "batch"
+
+	opCreateRoom = "create_room"
+	opUpdateRoom = "update_room"
+	opDeleteRoom = "delete_room"
+	opJoin       = "join"
+	opLeave      = "leave"
+)
+
+// record is one line of the log
+type record struct {
+	Op      string          `json:"op"`
+	Message *models.Message `json:"message,omitempty"`
+	ID      int             `json:"id,omitempty"`
+	Content string          `json:"content,omitempty"`
+	// Emoji and Username identify a reaction, Username also the member
+	// joining or leaving a room
+	Emoji    string `json:"emoji,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Room is a created or updated room, RoomName the room of the other
+	// room operations
+	Room     *models.Room `json:"room,omitempty"`
+	RoomName string       `json:"room_name,omitempty"`
+	// Time is when an update, delete or batch happened, or the cutoff of
+	// a purge
+	Time *time.Time `json:"time,omitempty"`
+	// Batch holds the records of a batch, which share its time
+	Batch []record `json:"batch,omitempty"`
+}
+
+// FileStorage keeps messages in memory and records every change in an
+// append-only JSON lines file, which is replayed on startup
+type FileStorage struct {
+	mutex  sync.Mutex
+	memory *MemoryStorage
+	file   *os.File
+}
+
+// NewFileStorage opens or creates the log at path and replays it. A
+// partly written last line, left by a crash, is discarded.
+func NewFileStorage(path string) (*FileStorage, error) {
+	// O_APPEND keeps writes at the end even after a torn line is truncated
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStorage{memory: NewMemoryStorage(), file: file}
+	if err := fs.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("storage: replaying %s: %w", path, err)
+	}
+	return fs, nil
+}
+
+func (fs *FileStorage) replay() error {
+	reader := bufio.NewReaderSize(fs.file, 64*1024)
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline is a torn write
+			return fs.file.Truncate(offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			return fmt.Errorf("invalid record at offset %d: %w", offset, err)
+		}
+		if err := fs.apply(rec); err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+	}
+}
+
+// apply replays a record against the in-memory state
+func (fs *FileStorage) apply(rec record) error {
+	ms := fs.memory
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	// Logs written before times and versions were recorded lack them
+	at := time.Now()
+	if rec.Time != nil {
+		at = *rec.Time
+	}
+	if rec.Op == opCreate && rec.Message != nil && rec.Message.Version == 0 {
+		rec.Message.Version = 1
+	}
+
+	_, err := applyRecord(ms, rec, at)
+	return err
+}
+
+// applyRecord changes the state of ms, whose lock the caller holds. It
+// returns the changed message, if any.
+func applyRecord(ms *MemoryStorage, rec record, at time.Time) (*models.Message, error) {
+	switch rec.Op {
+	case opCreate:
+		if rec.Message == nil {
+			return nil, errors.New("create without a message")
+		}
+		ms.insert(rec.Message)
+		return rec.Message, nil
+	case opUpdate:
+		return ms.update(rec.ID, AnyVersion, rec.Content, at)
+	case opDelete:
+		return ms.remove(rec.ID, AnyVersion, at)
+	case opRestore:
+		return ms.restore(rec.ID)
+	case opPurge:
+		ms.purge(at)
+		return nil, nil
+	case opReact, opUnreact:
+		return ms.react(rec.ID, rec.Emoji, rec.Username, rec.Op == opReact)
+	case opCreateRoom:
+		if rec.Room == nil {
+			return nil, errors.New("create_room without a room")
+		}
+		return nil, ms.createRoom(rec.Room)
+	case opUpdateRoom:
+		if rec.Room == nil {
+			return nil, errors.New("update_room without a room")
+		}
+		_, err := ms.updateRoom(rec.Room.Name, rec.Room.Description, rec.Room.Private)
+		return nil, err
+	case opDeleteRoom:
+		return nil, ms.deleteRoom(rec.RoomName)
+	case opJoin, opLeave:
+		_, err := ms.member(rec.RoomName, rec.Username, rec.Op == opJoin)
+		return nil, err
+	case opBatch:
+		for _, sub := range rec.Batch {
+			if _, err := applyRecord(ms, sub, at); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", rec.Op)
+	}
+}
+
+// append writes a record and syncs it to disk
+func (fs *FileStorage) append(rec record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return fs.file.Sync()
+}
+
+// Close closes the log file
+func (fs *FileStorage) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.file.Close()
+}
+
+// GetAll returns all messages
+func (fs *FileStorage) GetAll() []*models.Message {
+	return fs.memory.GetAll()
+}
+
+// List returns the messages selected by opts
+func (fs *FileStorage) List(opts ListOptions) (Page, error) {
+	return fs.memory.List(opts)
+}
+
+// Search returns the messages of room matching q, best first
+func (fs *FileStorage) Search(room string, q Query, offset, limit int) (SearchPage, error) {
+	return fs.memory.Search(room, q, offset, limit)
+}
+
+// GetByID returns a message by its ID
+func (fs *FileStorage) GetByID(id int) (*models.Message, error) {
+	return fs.memory.GetByID(id)
+}
+
+// Create adds a new message and records it
+func (fs *FileStorage) Create(username, content string) (*models.Message, error) {
+	return fs.write(EventCreated, func(ms *MemoryStorage) (record, error) {
+		message := models.NewMessage(ms.nextID, username, content)
+		message.Timestamp = message.Timestamp.Round(0)
+		return record{Op: opCreate, Message: message}, nil
+	})
+}
+
+// CreateReply adds a reply to a message and records it
+func (fs *FileStorage) CreateReply(parentID int, username, content string) (*models.Message, error) {
+	return fs.write(EventCreated, func(ms *MemoryStorage) (record, error) {
+		parent, err := ms.lookup(parentID, AnyVersion)
+		if err != nil {
+			return record{}, ErrParentNotFound
+		}
+		message := models.NewMessage(ms.nextID, username, content)
+		message.Timestamp = message.Timestamp.Round(0)
+		message.ParentID = &parentID
+		message.Room = parent.Room
+		return record{Op: opCreate, Message: message}, nil
+	})
+}
+
+// CreateInRoom adds a new message to a room and records it
+func (fs *FileStorage) CreateInRoom(room, username, content string) (*models.Message, error) {
+	return fs.write(EventCreated, func(ms *MemoryStorage) (record, error) {
+		if _, exists := ms.rooms[room]; !exists {
+			return record{}, ErrRoomNotFound
+		}
+		message := models.NewMessage(ms.nextID, username, content)
+		message.Timestamp = message.Timestamp.Round(0)
+		message.Room = room
+		return record{Op: opCreate, Message: message}, nil
+	})
+}
+
+// Import stores an exported message and records it
+func (fs *FileStorage) Import(message models.Message, remap bool) (*models.Message, error) {
+	return fs.write(EventCreated, func(ms *MemoryStorage) (record, error) {
+		if err := ms.prepareImport(&message, remap); err != nil {
+			return record{}, err
+		}
+		return record{Op: opCreate, Message: &message}, nil
+	})
+}
+
+// RoomOf returns the room of a message, deleted or not
+func (fs *FileStorage) RoomOf(id int) (string, error) {
+	return fs.memory.RoomOf(id)
+}
+
+// AddReaction records the reaction of a user to a message
+func (fs *FileStorage) AddReaction(id int, emoji, username string) (*models.Message, error) {
+	return fs.write(EventReacted, func(ms *MemoryStorage) (record, error) {
+		if _, err := ms.lookup(id, AnyVersion); err != nil {
+			return record{}, err
+		}
+		if _, reacted := ms.reactions[id][emoji][username]; reacted {
+			return record{}, ErrReactionExists
+		}
+		return record{Op: opReact, ID: id, Emoji: emoji, Username: username}, nil
+	})
+}
+
+// RemoveReaction withdraws the reaction of a user to a message
+func (fs *FileStorage) RemoveReaction(id int, emoji, username string) (*models.Message, error) {
+	return fs.write(EventReacted, func(ms *MemoryStorage) (record, error) {
+		if _, err := ms.lookup(id, AnyVersion); err != nil {
+			return record{}, err
+		}
+		if _, reacted := ms.reactions[id][emoji][username]; !reacted {
+			return record{}, ErrReactionNotFound
+		}
+		return record{Op: opUnreact, ID: id, Emoji: emoji, Username: username}, nil
+	})
+}
+
+// Update modifies a message and records the change
+func (fs *FileStorage) Update(id int, content string) (*models.Message, error) {
+	return fs.UpdateIf(id, AnyVersion, content)
+}
+
+// UpdateIf modifies a message at the given version and records the change
+func (fs *FileStorage) UpdateIf(id, version int, content string) (*models.Message, error) {
+	return fs.write(EventUpdated, func(ms *MemoryStorage) (record, error) {
+		_, err := ms.lookup(id, version)
+		return record{Op: opUpdate, ID: id, Content: content}, err
+	})
+}
+
+// Delete marks a message as deleted and records it
+func (fs *FileStorage) Delete(id int) error {
+	return fs.DeleteIf(id, AnyVersion)
+}
+
+// DeleteIf marks a message at the given version as deleted and records it
+func (fs *FileStorage) DeleteIf(id, version int) error {
+	_, err := fs.write(EventDeleted, func(ms *MemoryStorage) (record, error) {
+		_, err := ms.lookup(id, version)
+		return record{Op: opDelete, ID: id}, err
+	})
+	return err
+}
+
+// Restore brings back a deleted message and records it
+func (fs *FileStorage) Restore(id int) (*models.Message, error) {
+	return fs.write(EventRestored, func(ms *MemoryStorage) (record, error) {
+		message, exists := ms.messages[id]
+		if !exists {
+			return record{}, ErrMessageNotFound
+		}
+		if !message.Deleted {
+			return record{}, ErrNotDeleted
+		}
+		return record{Op: opRestore, ID: id}, nil
+	})
+}
+
+// Batch applies ops and records the applied ones as a single line. If the
+// line cannot be written, nothing is applied.
+func (fs *FileStorage) Batch(ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	ms := fs.memory
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	now := time.Now().Round(0)
+	changes, results := ms.applyBatch(ops, atomic, now)
+	if len(changes) == 0 {
+		return results, nil
+	}
+
+	batch := record{Op: opBatch, Time: &now, Batch: make([]record, len(changes))}
+	for i, change := range changes {
+		batch.Batch[i] = change.rec
+	}
+	if err := fs.append(batch); err != nil {
+		undoBatch(changes)
+		return nil, err
+	}
+	for _, change := range changes {
+		ms.events.Publish(change.typ, change.message)
+	}
+	return results, nil
+}
+
+// Purge permanently removes messages deleted before the given time and
+// records it
+func (fs *FileStorage) Purge(before time.Time) (int, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	ms := fs.memory
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	// Keep the log free of purges that remove nothing
+	due := false
+	for _, message := range ms.messages {
+		if message.Deleted && message.DeletedAt.Before(before) {
+			due = true
+			break
+		}
+	}
+	if !due {
+		return 0, nil
+	}
+
+	before = before.Round(0)
+	if err := fs.append(record{Op: opPurge, Time: &before}); err != nil {
+		return 0, err
+	}
+	return ms.purge(before), nil
+}
+
+// CreateRoom adds a room and records it
+func (fs *FileStorage) CreateRoom(room models.Room) (*models.Room, error) {
+	return fs.writeRoom(func(ms *MemoryStorage) (record, error) {
+		if _, exists := ms.rooms[room.Name]; exists {
+			return record{}, ErrRoomExists
+		}
+		room.CreatedAt = time.Now().Round(0)
+		return record{Op: opCreateRoom, Room: &room}, nil
+	})
+}
+
+// GetRoom returns a room by its name
+func (fs *FileStorage) GetRoom(name string) (*models.Room, error) {
+	return fs.memory.GetRoom(name)
+}
+
+// ListRooms returns all rooms ordered by name
+func (fs *FileStorage) ListRooms() ([]*models.Room, error) {
+	return fs.memory.ListRooms()
+}
+
+// UpdateRoom changes a room and records the change
+func (fs *FileStorage) UpdateRoom(name, description string, private bool) (*models.Room, error) {
+	return fs.writeRoom(func(ms *MemoryStorage) (record, error) {
+		if _, exists := ms.rooms[name]; !exists {
+			return record{}, ErrRoomNotFound
+		}
+		room := &models.Room{Name: name, Description: description, Private: private}
+		return record{Op: opUpdateRoom, Room: room}, nil
+	})
+}
+
+// DeleteRoom removes a room with its messages and records it
+func (fs *FileStorage) DeleteRoom(name string) error {
+	_, err := fs.writeRoom(func(ms *MemoryStorage) (record, error) {
+		if _, exists := ms.rooms[name]; !exists {
+			return record{}, ErrRoomNotFound
+		}
+		return record{Op: opDeleteRoom, RoomName: name}, nil
+	})
+	return err
+}
+
+// AddMember lets a user join a room and records it
+func (fs *FileStorage) AddMember(name, username string) (*models.Room, error) {
+	return fs.writeRoom(func(ms *MemoryStorage) (record, error) {
+		room, exists := ms.rooms[name]
+		if !exists {
+			return record{}, ErrRoomNotFound
+		}
+		if room.IsMember(username) {
+			return record{}, ErrAlreadyMember
+		}
+		return record{Op: opJoin, RoomName: name, Username: username}, nil
+	})
+}
+
+// RemoveMember makes a user leave a room and records it
+func (fs *FileStorage) RemoveMember(name, username string) (*models.Room, error) {
+	return fs.writeRoom(func(ms *MemoryStorage) (record, error) {
+		room, exists := ms.rooms[name]
+		if !exists {
+			return record{}, ErrRoomNotFound
+		}
+		if !room.IsMember(username) {
+			return record{}, ErrNotMember
+		}
+		return record{Op: opLeave, RoomName: name, Username: username}, nil
+	})
+}
+
+// writeRoom is write for room operations, which publish no events. It
+// returns the room afterwards, nil if it was deleted.
+func (fs *FileStorage) writeRoom(prepare func(ms *MemoryStorage) (record, error)) (*models.Room, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	ms := fs.memory
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	rec, err := prepare(ms)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.append(rec); err != nil {
+		return nil, err
+	}
+	if _, err := applyRecord(ms, rec, time.Now()); err != nil {
+		return nil, err
+	}
+
+	name := rec.RoomName
+	if rec.Room != nil {
+		name = rec.Room.Name
+	}
+	room, exists := ms.rooms[name]
+	if !exists {
+		return nil, nil
+	}
+	return ms.roomCopy(room), nil
+}
+
+// History returns the revisions of a message
+func (fs *FileStorage) History(id int) ([]models.Revision, error) {
+	return fs.memory.History(id)
+}
+
+// write checks a change with prepare, which returns its record, logs it
+// and applies it. The memory stays locked throughout, so the check holds
+// when the change is applied.
+func (fs *FileStorage) write(typ EventType, prepare func(ms *MemoryStorage) (record, error)) (*models.Message, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	ms := fs.memory
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	rec, err := prepare(ms)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Round(0)
+	if rec.Op == opUpdate || rec.Op == opDelete {
+		rec.Time = &now
+	}
+	if err := fs.append(rec); err != nil {
+		return nil, err
+	}
+
+	message, err := applyRecord(ms, rec, now)
+	if err != nil {
+		return nil, err
+	}
+	ms.events.Publish(typ, message)
+	return clone(message), nil
+}
+
+// Subscribe delivers an event for every change made after startup
+func (fs *FileStorage) Subscribe(lastEventID uint64) *Subscription {
+	return fs.memory.Subscribe(lastEventID)
+}
+
+// Count returns the total number of messages
+func (fs *FileStorage) Count() int {
+	return fs.memory.Count()
+}
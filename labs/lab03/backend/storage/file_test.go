@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"errors"
+	"lab03-backend/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorageReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.Create("alice", "hello")
+	second, _ := store.Create("bob", "hi")
+	store.Update(first.ID, "hello, edited")
+	store.Delete(second.ID)
+	store.Close()
+
+	// Simulate a crash in the middle of a write
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"op":"create","mess`)
+	f.Close()
+
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+
+	if store.Count() != 1 {
+		t.Fatalf("Expected 1 message after replay, got %d", store.Count())
+	}
+	got, err := store.GetByID(first.ID)
+	if err != nil || got.Content != "hello, edited" || got.Version != 2 || !got.Timestamp.Equal(first.Timestamp) {
+		t.Errorf("Unexpected replayed message %+v, err %v", got, err)
+	}
+
+	third, err := store.Create("carol", "new")
+	if err != nil || third.ID != 3 {
+		t.Errorf("Expected the next ID to be 3, got %+v, err %v", third, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if data[len(data)-1] != '\n' {
+		t.Error("Expected the torn line to be discarded")
+	}
+}
+
+func TestFileStorageCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	os.WriteFile(path, []byte("not json\n"), 0o644)
+
+	if _, err := NewFileStorage(path); err == nil {
+		t.Error("Expected an error for a corrupt log")
+	}
+}
+
+func TestFileStorageReplaysChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.Create("alice", "hello")
+	edited, _ := store.Update(first.ID, "hello, edited")
+	second, _ := store.Create("bob", "hi")
+	store.Delete(second.ID)
+	third, _ := store.Create("carol", "bye")
+	store.Delete(third.ID)
+	store.Purge(time.Now())
+	store.CreateReply(first.ID, "bob", "welcome")
+	store.AddReaction(first.ID, "👋", "bob")
+	store.AddReaction(first.ID, "👋", "carol")
+	store.RemoveReaction(first.ID, "👋", "carol")
+	store.CreateRoom(models.Room{Name: "team", Owner: "alice"})
+	store.UpdateRoom("team", "Team chat", true)
+	store.AddMember("team", "bob")
+	store.AddMember("team", "carol")
+	store.RemoveMember("team", "alice")
+	store.CreateInRoom("team", "bob", "in the room")
+	store.CreateRoom(models.Room{Name: "gone", Owner: "alice"})
+	store.CreateInRoom("gone", "alice", "gone too")
+	store.DeleteRoom("gone")
+	store.Close()
+
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+
+	revisions, err := store.History(first.ID)
+	if err != nil || len(revisions) != 2 || revisions[0].Content != "hello" {
+		t.Fatalf("Unexpected history %+v, err %v", revisions, err)
+	}
+	got, _ := store.GetByID(first.ID)
+	if got.EditedAt == nil || !got.EditedAt.Equal(*edited.EditedAt) {
+		t.Errorf("Expected edit time %v, got %v", edited.EditedAt, got.EditedAt)
+	}
+	if got.ReplyCount != 1 || len(got.Reactions) != 1 || got.Reactions["👋"] != 1 {
+		t.Errorf("Expected 1 reply and 1 reaction, got %d and %v", got.ReplyCount, got.Reactions)
+	}
+	if _, err := store.History(second.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected purged message to stay purged, got %v", err)
+	}
+	if _, err := store.Restore(third.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Expected purged message to stay purged, got %v", err)
+	}
+
+	room, err := store.GetRoom("team")
+	if err != nil || room.Description != "Team chat" || !room.Private || room.MessageCount != 1 ||
+		len(room.Members) != 2 || room.Members[0] != "bob" {
+		t.Errorf("Unexpected room %+v, err %v", room, err)
+	}
+	if _, err := store.GetRoom("gone"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("Expected deleted room to stay deleted, got %v", err)
+	}
+
+	q, _ := ParseQuery("edited OR hi OR welcome")
+	if page, _ := store.Search("", q, 0, 10); page.Total != 2 {
+		t.Errorf("Expected the replayed index to match 2 messages, got %d", page.Total)
+	}
+}
+
+func TestFileStorageReplaysBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.Create("alice", "hello")
+	parentID := 2
+	batch, _ := store.Batch([]BatchOp{
+		{Op: BatchCreate, Username: "bob", Content: "hi"},
+		{Op: BatchCreate, ParentID: &parentID, Username: "alice", Content: "welcome"},
+		{Op: BatchUpdate, ID: first.ID, Content: "hello, edited"},
+		{Op: BatchDelete, ID: 42},
+	}, false)
+	store.Batch([]BatchOp{
+		{Op: BatchDelete, ID: first.ID},
+		{Op: BatchUpdate, ID: 42, Content: "missing"},
+	}, true)
+	store.Close()
+
+	lines := 0
+	data, _ := os.ReadFile(path)
+	for _, b := range data {
+		if b == '\n' {
+			lines++
+		}
+	}
+	if lines != 2 {
+		t.Errorf("Expected the create and one batch line, got %d lines", lines)
+	}
+
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+
+	got, err := store.GetByID(first.ID)
+	if err != nil || got.Content != "hello, edited" || !got.EditedAt.Equal(*batch[2].Message.EditedAt) {
+		t.Errorf("Unexpected replayed message %+v, err %v", got, err)
+	}
+	parent, _ := store.GetByID(2)
+	if parent == nil || parent.ReplyCount != 1 || !parent.Timestamp.Equal(batch[0].Message.Timestamp) {
+		t.Errorf("Expected the batch parent with 1 reply, got %+v", parent)
+	}
+	if store.Count() != 3 {
+		t.Errorf("Expected 3 messages, got %d", store.Count())
+	}
+}
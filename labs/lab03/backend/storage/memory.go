@@ -3,29 +3,84 @@ package storage
 import (
 	"errors"
 	"lab03-backend/models"
+	"sort"
 	"sync"
+	"time"
 )
 
-// MemoryStorage implements in-memory storage for messages
+// MaxRevisions bounds the edit history kept per message. Older revisions
+// are dropped first.
+const MaxRevisions = 50
+
+// MemoryStorage implements in-memory storage for messages. It hands out
+// copies, so callers cannot change stored messages behind its back.
 type MemoryStorage struct {
 	mutex    sync.RWMutex
 	messages map[int]*models.Message
-	nextID   int
+	// history holds the replaced revisions of each message, oldest first
+	history map[int][]models.Revision
+	// reactions holds the users who reacted to each message, by emoji
+	reactions map[int]map[string]map[string]struct{}
+	rooms     map[string]*models.Room
+	// byRoom holds the IDs of the messages in each room, deleted ones
+	// included. The lobby is the empty name.
+	byRoom map[string]map[int]struct{}
+	nextID int
+	events *Broker
+	// index holds the contents of the messages that are not deleted
+	index *searchIndex
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		messages: make(map[int]*models.Message),
-		nextID:   1,
+		messages:  make(map[int]*models.Message),
+		history:   make(map[int][]models.Revision),
+		reactions: make(map[int]map[string]map[string]struct{}),
+		rooms:     make(map[string]*models.Room),
+		byRoom:    make(map[string]map[int]struct{}),
+		nextID:    1,
+		events:    NewBroker(DefaultReplayBuffer),
+		index:     newSearchIndex(),
 	}
 }
 
-// GetAll returns all messages
+// GetAll returns all messages ordered by ID
 func (ms *MemoryStorage) GetAll() []*models.Message {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
 
+	return cloneAll(listMessages(ms.all(), ListOptions{AllRooms: true}).Messages)
+}
+
+// List returns the messages selected by opts
+func (ms *MemoryStorage) List(opts ListOptions) (Page, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	messages := ms.all()
+	if !opts.AllRooms {
+		messages = ms.inRoom(opts.Room)
+	}
+	page := listMessages(messages, opts)
+	page.Messages = cloneAll(page.Messages)
+	return page, nil
+}
+
+// inRoom returns the messages of a room in no particular order, deleted
+// ones included. The caller must hold the lock.
+func (ms *MemoryStorage) inRoom(room string) []*models.Message {
+	ids := ms.byRoom[room]
+	messages := make([]*models.Message, 0, len(ids))
+	for id := range ids {
+		messages = append(messages, ms.messages[id])
+	}
+	return messages
+}
+
+// all returns the stored messages in no particular order, deleted ones
+// included. The caller must hold the lock.
+func (ms *MemoryStorage) all() []*models.Message {
 	messages := make([]*models.Message, 0, len(ms.messages))
 	for _, message := range ms.messages {
 		messages = append(messages, message)
@@ -38,11 +93,11 @@ func (ms *MemoryStorage) GetByID(id int) (*models.Message, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
 
-	message, exists := ms.messages[id]
-	if !exists {
-		return nil, ErrMessageNotFound
+	message, err := ms.lookup(id, AnyVersion)
+	if err != nil {
+		return nil, err
 	}
-	return message, nil
+	return clone(message), nil
 }
 
 // Create adds a new message to storage
@@ -50,52 +105,727 @@ func (ms *MemoryStorage) Create(username, content string) (*models.Message, erro
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	id := ms.nextID
-	message := models.NewMessage(id, username, content)
-	ms.messages[id] = message
-	ms.nextID++
+	message := models.NewMessage(ms.nextID, username, content)
+	ms.insert(message)
+	ms.events.Publish(EventCreated, message)
 
-	return message, nil
+	return clone(message), nil
+}
+
+// CreateReply adds a reply to a message
+func (ms *MemoryStorage) CreateReply(parentID int, username, content string) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	parent, err := ms.lookup(parentID, AnyVersion)
+	if err != nil {
+		return nil, ErrParentNotFound
+	}
+	message := models.NewMessage(ms.nextID, username, content)
+	message.ParentID = &parentID
+	message.Room = parent.Room
+	ms.insert(message)
+	ms.events.Publish(EventCreated, message)
+
+	return clone(message), nil
+}
+
+// CreateInRoom adds a new message to a room
+func (ms *MemoryStorage) CreateInRoom(room, username, content string) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.rooms[room]; !exists {
+		return nil, ErrRoomNotFound
+	}
+	message := models.NewMessage(ms.nextID, username, content)
+	message.Room = room
+	ms.insert(message)
+	ms.events.Publish(EventCreated, message)
+
+	return clone(message), nil
+}
+
+// Import stores an exported message
+func (ms *MemoryStorage) Import(message models.Message, remap bool) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if err := ms.prepareImport(&message, remap); err != nil {
+		return nil, err
+	}
+	ms.insert(&message)
+	ms.events.Publish(EventCreated, &message)
+
+	return clone(&message), nil
+}
+
+// RoomOf returns the room of a message, deleted or not
+func (ms *MemoryStorage) RoomOf(id int) (string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	message, exists := ms.messages[id]
+	if !exists {
+		return "", ErrMessageNotFound
+	}
+	return message.Room, nil
+}
+
+// AddReaction records the reaction of a user to a message
+func (ms *MemoryStorage) AddReaction(id int, emoji, username string) (*models.Message, error) {
+	return ms.changeReaction(id, emoji, username, true)
+}
+
+// RemoveReaction withdraws the reaction of a user to a message
+func (ms *MemoryStorage) RemoveReaction(id int, emoji, username string) (*models.Message, error) {
+	return ms.changeReaction(id, emoji, username, false)
+}
+
+func (ms *MemoryStorage) changeReaction(id int, emoji, username string, add bool) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	message, err := ms.react(id, emoji, username, add)
+	if err != nil {
+		return nil, err
+	}
+	ms.events.Publish(EventReacted, message)
+	return clone(message), nil
 }
 
 // Update modifies an existing message
 func (ms *MemoryStorage) Update(id int, content string) (*models.Message, error) {
+	return ms.UpdateIf(id, AnyVersion, content)
+}
+
+// UpdateIf modifies a message if it is at the given version
+func (ms *MemoryStorage) UpdateIf(id, version int, content string) (*models.Message, error) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
+	message, err := ms.update(id, version, content, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	ms.events.Publish(EventUpdated, message)
+	return clone(message), nil
+}
+
+// Delete marks a message as deleted
+func (ms *MemoryStorage) Delete(id int) error {
+	return ms.DeleteIf(id, AnyVersion)
+}
+
+// DeleteIf marks a message as deleted if it is at the given version
+func (ms *MemoryStorage) DeleteIf(id, version int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	message, err := ms.remove(id, version, time.Now())
+	if err != nil {
+		return err
+	}
+	ms.events.Publish(EventDeleted, message)
+	return nil
+}
+
+// Restore brings back a deleted message
+func (ms *MemoryStorage) Restore(id int) (*models.Message, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	message, err := ms.restore(id)
+	if err != nil {
+		return nil, err
+	}
+	ms.events.Publish(EventRestored, message)
+	return clone(message), nil
+}
+
+// Batch applies ops in order under a single lock, so readers never see
+// part of a batch
+func (ms *MemoryStorage) Batch(ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	changes, results := ms.applyBatch(ops, atomic, time.Now())
+	for _, change := range changes {
+		ms.events.Publish(change.typ, change.message)
+	}
+	return results, nil
+}
+
+// History returns the revisions of a message, oldest first, ending with
+// the current content. It includes deleted messages.
+func (ms *MemoryStorage) History(id int) ([]models.Revision, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
 	message, exists := ms.messages[id]
 	if !exists {
 		return nil, ErrMessageNotFound
 	}
+	past := ms.history[id]
+	revisions := make([]models.Revision, len(past), len(past)+1)
+	copy(revisions, past)
+	return append(revisions, currentRevision(message)), nil
+}
 
-	message.Content = content
-	return message, nil
+// Purge permanently removes the messages deleted before the given time
+func (ms *MemoryStorage) Purge(before time.Time) (int, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return ms.purge(before), nil
 }
 
-// Delete removes a message from storage
-func (ms *MemoryStorage) Delete(id int) error {
+// Search returns the messages of room matching q, best first
+func (ms *MemoryStorage) Search(room string, q Query, offset, limit int) (SearchPage, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return searchPage(ms.index.search(q, room), q, offset, limit, func(id int) (*models.Message, error) {
+		return clone(ms.messages[id]), nil
+	})
+}
+
+// CreateRoom adds a room with its owner as the only member
+func (ms *MemoryStorage) CreateRoom(room models.Room) (*models.Room, error) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	_, exists := ms.messages[id]
+	room.CreatedAt = time.Now()
+	if err := ms.createRoom(&room); err != nil {
+		return nil, err
+	}
+	return ms.roomCopy(&room), nil
+}
+
+// GetRoom returns a room by its name
+func (ms *MemoryStorage) GetRoom(name string) (*models.Room, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	room, exists := ms.rooms[name]
 	if !exists {
-		return ErrMessageNotFound
+		return nil, ErrRoomNotFound
+	}
+	return ms.roomCopy(room), nil
+}
+
+// ListRooms returns all rooms ordered by name
+func (ms *MemoryStorage) ListRooms() ([]*models.Room, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	rooms := make([]*models.Room, 0, len(ms.rooms))
+	for _, room := range ms.rooms {
+		rooms = append(rooms, ms.roomCopy(room))
 	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms, nil
+}
+
+// UpdateRoom changes the description and visibility of a room
+func (ms *MemoryStorage) UpdateRoom(name, description string, private bool) (*models.Room, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 
-	delete(ms.messages, id)
+	room, err := ms.updateRoom(name, description, private)
+	if err != nil {
+		return nil, err
+	}
+	return ms.roomCopy(room), nil
+}
+
+// DeleteRoom removes a room and its messages
+func (ms *MemoryStorage) DeleteRoom(name string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return ms.deleteRoom(name)
+}
+
+// AddMember lets a user join a room
+func (ms *MemoryStorage) AddMember(name, username string) (*models.Room, error) {
+	return ms.changeMember(name, username, true)
+}
+
+// RemoveMember makes a user leave a room
+func (ms *MemoryStorage) RemoveMember(name, username string) (*models.Room, error) {
+	return ms.changeMember(name, username, false)
+}
+
+func (ms *MemoryStorage) changeMember(name, username string, add bool) (*models.Room, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	room, err := ms.member(name, username, add)
+	if err != nil {
+		return nil, err
+	}
+	return ms.roomCopy(room), nil
+}
+
+// roomCopy returns a copy of room with its message count. The caller must
+// hold the lock.
+func (ms *MemoryStorage) roomCopy(room *models.Room) *models.Room {
+	copied := *room
+	copied.MessageCount = 0
+	for id := range ms.byRoom[room.Name] {
+		if !ms.messages[id].Deleted {
+			copied.MessageCount++
+		}
+	}
+	return &copied
+}
+
+// The methods below change the state without locking or publishing
+// events. FileStorage replays its log with them.
+
+// insert stores a new message
+func (ms *MemoryStorage) insert(message *models.Message) {
+	ms.messages[message.ID] = message
+	if message.ID >= ms.nextID {
+		ms.nextID = message.ID + 1
+	}
+	ms.countReply(message, 1)
+	if ms.byRoom[message.Room] == nil {
+		ms.byRoom[message.Room] = make(map[int]struct{})
+	}
+	ms.byRoom[message.Room][message.ID] = struct{}{}
+	if !message.Deleted {
+		ms.index.add(message.ID, message.Room, message.Content)
+	}
+}
+
+// countReply adds delta to the reply count of the parent of message
+func (ms *MemoryStorage) countReply(message *models.Message, delta int) {
+	if message.ParentID == nil {
+		return
+	}
+	if parent, exists := ms.messages[*message.ParentID]; exists {
+		parent.ReplyCount += delta
+	}
+}
+
+// react adds or removes the reaction of a user
+func (ms *MemoryStorage) react(id int, emoji, username string, add bool) (*models.Message, error) {
+	message, err := ms.lookup(id, AnyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	byEmoji := ms.reactions[id]
+	_, reacted := byEmoji[emoji][username]
+	switch {
+	case add && reacted:
+		return nil, ErrReactionExists
+	case !add && !reacted:
+		return nil, ErrReactionNotFound
+	case add:
+		if byEmoji == nil {
+			byEmoji = make(map[string]map[string]struct{})
+			ms.reactions[id] = byEmoji
+		}
+		if byEmoji[emoji] == nil {
+			byEmoji[emoji] = make(map[string]struct{})
+		}
+		byEmoji[emoji][username] = struct{}{}
+	default:
+		delete(byEmoji[emoji], username)
+		if len(byEmoji[emoji]) == 0 {
+			delete(byEmoji, emoji)
+		}
+	}
+
+	// Copies handed out earlier share the old map
+	var counts map[string]int
+	if len(byEmoji) > 0 {
+		counts = make(map[string]int, len(byEmoji))
+		for e, users := range byEmoji {
+			counts[e] = len(users)
+		}
+	}
+	message.Reactions = counts
+	return message, nil
+}
+
+// update replaces the content of a message and records the old one
+func (ms *MemoryStorage) update(id, version int, content string, at time.Time) (*models.Message, error) {
+	message, err := ms.lookup(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	history := append(ms.history[id], currentRevision(message))
+	if len(history) > MaxRevisions {
+		history = history[len(history)-MaxRevisions:]
+	}
+	ms.history[id] = history
+
+	// Replace rather than modify, earlier copies may still be read
+	updated := clone(message)
+	updated.Content = content
+	updated.Version++
+	updated.EditedAt = &at
+	ms.messages[id] = updated
+	ms.index.add(id, updated.Room, content)
+	return updated, nil
+}
+
+// remove marks a message as deleted
+func (ms *MemoryStorage) remove(id, version int, at time.Time) (*models.Message, error) {
+	message, err := ms.lookup(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := clone(message)
+	deleted.Version++
+	deleted.Deleted = true
+	deleted.DeletedAt = &at
+	ms.messages[id] = deleted
+	ms.countReply(deleted, -1)
+	ms.index.remove(id)
+	return deleted, nil
+}
+
+// restore clears the deleted mark of a message
+func (ms *MemoryStorage) restore(id int) (*models.Message, error) {
+	message, exists := ms.messages[id]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	if !message.Deleted {
+		return nil, ErrNotDeleted
+	}
+
+	restored := clone(message)
+	restored.Version++
+	restored.Deleted = false
+	restored.DeletedAt = nil
+	ms.messages[id] = restored
+	ms.countReply(restored, 1)
+	ms.index.add(id, restored.Room, restored.Content)
+	return restored, nil
+}
+
+// applyBatch applies ops in order and returns the applied changes. An
+// atomic batch stops at the first failure and reverts what it applied.
+func (ms *MemoryStorage) applyBatch(ops []BatchOp, atomic bool, at time.Time) ([]batchChange, []BatchResult) {
+	results := make([]BatchResult, len(ops))
+	var changes []batchChange
+	for i, op := range ops {
+		change, err := ms.applyOp(op, at)
+		if err != nil {
+			results[i].Err = err
+			if atomic {
+				undoBatch(changes)
+				abortBatch(results, i)
+				return nil, results
+			}
+			continue
+		}
+		changes = append(changes, change)
+		results[i].Message = clone(change.message)
+	}
+	return changes, results
+}
+
+// applyOp applies one operation of a batch, recording how to revert it
+func (ms *MemoryStorage) applyOp(op BatchOp, at time.Time) (batchChange, error) {
+	switch op.Op {
+	case BatchCreate:
+		message := models.NewMessage(ms.nextID, op.Username, op.Content)
+		message.Timestamp = at
+		message.Room = op.Room
+		if op.ParentID != nil {
+			parent, err := ms.lookup(*op.ParentID, AnyVersion)
+			if err != nil || parent.Room != op.Room {
+				return batchChange{}, ErrParentNotFound
+			}
+			parentID := *op.ParentID
+			message.ParentID = &parentID
+		} else if _, exists := ms.rooms[op.Room]; op.Room != "" && !exists {
+			return batchChange{}, ErrRoomNotFound
+		}
+		nextID := ms.nextID
+		ms.insert(message)
+		return batchChange{
+			typ:     EventCreated,
+			message: message,
+			// Later operations may count replies to the message
+			rec: record{Op: opCreate, Message: clone(message)},
+			undo: func() {
+				ms.countReply(message, -1)
+				ms.drop(message)
+				ms.nextID = nextID
+			},
+		}, nil
+
+	case BatchUpdate:
+		previous, history := ms.messages[op.ID], ms.history[op.ID]
+		message, err := ms.update(op.ID, op.Version, op.Content, at)
+		if err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{
+			typ:     EventUpdated,
+			message: message,
+			rec:     record{Op: opUpdate, ID: op.ID, Content: op.Content},
+			undo: func() {
+				ms.messages[op.ID] = previous
+				if history == nil {
+					delete(ms.history, op.ID)
+				} else {
+					ms.history[op.ID] = history
+				}
+				ms.index.add(op.ID, previous.Room, previous.Content)
+			},
+		}, nil
+
+	case BatchDelete:
+		previous := ms.messages[op.ID]
+		message, err := ms.remove(op.ID, op.Version, at)
+		if err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{
+			typ:     EventDeleted,
+			message: message,
+			rec:     record{Op: opDelete, ID: op.ID},
+			undo: func() {
+				ms.messages[op.ID] = previous
+				ms.countReply(previous, 1)
+				ms.index.add(op.ID, previous.Room, previous.Content)
+			},
+		}, nil
+
+	default:
+		return batchChange{}, errUnknownBatchOp
+	}
+}
+
+// undoBatch reverts applied changes, latest first
+func undoBatch(changes []batchChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		changes[i].undo()
+	}
+}
+
+// prepareImport checks an imported message and assigns its ID
+func (ms *MemoryStorage) prepareImport(message *models.Message, remap bool) error {
+	normalizeImport(message)
+	if message.ParentID != nil {
+		parent, err := ms.lookup(*message.ParentID, AnyVersion)
+		if err != nil || parent.Room != message.Room {
+			return ErrParentNotFound
+		}
+	} else if _, exists := ms.rooms[message.Room]; message.Room != "" && !exists {
+		return ErrRoomNotFound
+	}
+
+	if _, taken := ms.messages[message.ID]; taken {
+		if !remap {
+			return ErrIDTaken
+		}
+		message.ID = 0
+	}
+	if message.ID == 0 {
+		message.ID = ms.nextID
+	}
+	return nil
+}
+
+// purge drops messages deleted before the given time with their history
+func (ms *MemoryStorage) purge(before time.Time) int {
+	purged := 0
+	for _, message := range ms.messages {
+		if message.Deleted && message.DeletedAt.Before(before) {
+			ms.drop(message)
+			purged++
+		}
+	}
+	return purged
+}
+
+// drop forgets a message entirely
+func (ms *MemoryStorage) drop(message *models.Message) {
+	delete(ms.messages, message.ID)
+	delete(ms.history, message.ID)
+	delete(ms.reactions, message.ID)
+	delete(ms.byRoom[message.Room], message.ID)
+	ms.index.remove(message.ID)
+}
+
+// createRoom stores a new room, setting its members to the owner
+func (ms *MemoryStorage) createRoom(room *models.Room) error {
+	if _, exists := ms.rooms[room.Name]; exists {
+		return ErrRoomExists
+	}
+	room.Members = []string{room.Owner}
+	room.MessageCount = 0
+	ms.rooms[room.Name] = room
 	return nil
 }
 
-// Count returns the total number of messages
+// updateRoom replaces the description and visibility of a room
+func (ms *MemoryStorage) updateRoom(name, description string, private bool) (*models.Room, error) {
+	room, exists := ms.rooms[name]
+	if !exists {
+		return nil, ErrRoomNotFound
+	}
+	updated := *room
+	updated.Description = description
+	updated.Private = private
+	ms.rooms[name] = &updated
+	return &updated, nil
+}
+
+// deleteRoom drops a room with all its messages
+func (ms *MemoryStorage) deleteRoom(name string) error {
+	if _, exists := ms.rooms[name]; !exists {
+		return ErrRoomNotFound
+	}
+	for _, message := range ms.inRoom(name) {
+		ms.drop(message)
+	}
+	delete(ms.byRoom, name)
+	delete(ms.rooms, name)
+	return nil
+}
+
+// member adds or removes a member of a room
+func (ms *MemoryStorage) member(name, username string, add bool) (*models.Room, error) {
+	room, exists := ms.rooms[name]
+	if !exists {
+		return nil, ErrRoomNotFound
+	}
+	isMember := room.IsMember(username)
+	switch {
+	case add && isMember:
+		return nil, ErrAlreadyMember
+	case !add && !isMember:
+		return nil, ErrNotMember
+	}
+
+	// Copies handed out earlier share the old slice
+	members := make([]string, 0, len(room.Members)+1)
+	for _, member := range room.Members {
+		if member != username {
+			members = append(members, member)
+		}
+	}
+	if add {
+		members = append(members, username)
+		sort.Strings(members)
+	}
+	updated := *room
+	updated.Members = members
+	ms.rooms[name] = &updated
+	return &updated, nil
+}
+
+// lookup returns the stored message if it exists, is not deleted and is
+// at version. The caller must hold the lock.
+func (ms *MemoryStorage) lookup(id, version int) (*models.Message, error) {
+	message, exists := ms.messages[id]
+	if !exists || message.Deleted {
+		return nil, ErrMessageNotFound
+	}
+	if version != AnyVersion && message.Version != version {
+		return nil, ErrVersionConflict
+	}
+	return message, nil
+}
+
+// Subscribe delivers an event for every change
+func (ms *MemoryStorage) Subscribe(lastEventID uint64) *Subscription {
+	return ms.events.Subscribe(lastEventID)
+}
+
+// Count returns the number of messages that are not deleted
 func (ms *MemoryStorage) Count() int {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
 
-	return len(ms.messages)
+	count := 0
+	for _, message := range ms.messages {
+		if !message.Deleted {
+			count++
+		}
+	}
+	return count
+}
+
+// currentRevision returns the revision a message is at
+func currentRevision(message *models.Message) models.Revision {
+	revision := models.Revision{
+		Version:   message.Version,
+		Content:   message.Content,
+		CreatedAt: message.Timestamp,
+	}
+	if message.EditedAt != nil {
+		revision.CreatedAt = *message.EditedAt
+	}
+	return revision
+}
+
+// normalizeImport resets what imports do not keep: deletion, reactions
+// and the reply count, which follows the imported replies. A missing
+// timestamp or version is set as for new messages. Times lose their
+// monotonic clock reading, which cannot be stored.
+func normalizeImport(message *models.Message) {
+	message.Deleted = false
+	message.DeletedAt = nil
+	message.Reactions = nil
+	message.ReplyCount = 0
+	if message.ID < 0 {
+		message.ID = 0
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	message.Timestamp = message.Timestamp.Round(0)
+	if message.EditedAt != nil {
+		editedAt := message.EditedAt.Round(0)
+		message.EditedAt = &editedAt
+	}
+	if message.Version < 1 {
+		message.Version = 1
+	}
+}
+
+func clone(message *models.Message) *models.Message {
+	copied := *message
+	return &copied
+}
+
+func cloneAll(messages []*models.Message) []*models.Message {
+	for i, message := range messages {
+		messages[i] = clone(message)
+	}
+	return messages
 }
 
 // Common errors
 var (
 	ErrMessageNotFound = errors.New("message not found")
 	ErrInvalidID       = errors.New("invalid message ID")
+	ErrVersionConflict = errors.New("message version does not match")
+	ErrNotDeleted      = errors.New("message is not deleted")
+	ErrParentNotFound  = errors.New("parent message not found")
+	// ErrIDTaken is returned for imported messages whose ID is in use
+	ErrIDTaken = errors.New("message ID is taken")
+	// ErrReactionExists and ErrReactionNotFound concern the reaction of
+	// one user with one emoji
+	ErrReactionExists   = errors.New("reaction already exists")
+	ErrReactionNotFound = errors.New("reaction not found")
+	ErrRoomNotFound     = errors.New("room not found")
+	ErrRoomExists       = errors.New("room already exists")
+	// ErrAlreadyMember and ErrNotMember concern the membership of one user
+	ErrAlreadyMember = errors.New("user is already a member")
+	ErrNotMember     = errors.New("user is not a member")
 )
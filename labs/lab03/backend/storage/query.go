@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"lab03-backend/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortField is a message attribute results can be ordered by
+type SortField string
+
+// Supported sort fields. Ties on the timestamp are broken by ID, so every
+// order is stable.
+const (
+	SortByID        SortField = "id"
+	SortByTimestamp SortField = "timestamp"
+)
+
+// Position identifies a message in a sorted result, for keyset pagination
+type Position struct {
+	ID        int
+	Timestamp time.Time
+}
+
+// ListOptions selects, orders and pages messages. Zero values disable a
+// filter.
+type ListOptions struct {
+	// Room selects the messages of a room, the lobby by default
+	Room string
+	// AllRooms lists the messages of every room and ignores Room
+	AllRooms bool
+	// Username matches exactly
+	Username string
+	// Since is inclusive, Until exclusive
+	Since time.Time
+	Until time.Time
+	// Contains matches a substring of the content, ignoring case
+	Contains string
+	// IncludeDeleted lists deleted messages as well
+	IncludeDeleted bool
+	// ParentID selects the replies to a message
+	ParentID int
+
+	SortBy     SortField
+	Descending bool
+
+	// After skips messages up to and including the given position in the
+	// chosen order. Offset skips a number of messages. They are not meant
+	// to be combined.
+	After  *Position
+	Offset int
+	// Limit caps the page size, zero means no limit
+	Limit int
+}
+
+// Page is one page of a listing
+type Page struct {
+	Messages []*models.Message
+	// Total is the number of messages matching the filters, on all pages
+	Total int
+	// HasMore reports whether messages follow this page
+	HasMore bool
+}
+
+// matches reports whether m passes the filters of opts
+func (opts ListOptions) matches(m *models.Message) bool {
+	if m.Deleted && !opts.IncludeDeleted {
+		return false
+	}
+	if !opts.AllRooms && m.Room != opts.Room {
+		return false
+	}
+	if opts.ParentID != 0 && (m.ParentID == nil || *m.ParentID != opts.ParentID) {
+		return false
+	}
+	if opts.Username != "" && m.Username != opts.Username {
+		return false
+	}
+	if !opts.Since.IsZero() && m.Timestamp.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && !m.Timestamp.Before(opts.Until) {
+		return false
+	}
+	if opts.Contains != "" && !strings.Contains(strings.ToLower(m.Content), strings.ToLower(opts.Contains)) {
+		return false
+	}
+	return true
+}
+
+// less orders a before b in ascending order of the sort field
+func (opts ListOptions) less(a, b Position) bool {
+	if opts.SortBy == SortByTimestamp && !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
+
+// before reports whether a comes before b in the requested direction
+func (opts ListOptions) before(a, b Position) bool {
+	if opts.Descending {
+		return opts.less(b, a)
+	}
+	return opts.less(a, b)
+}
+
+// PositionOf returns the position of a message, for use as ListOptions.After
+func PositionOf(m *models.Message) Position {
+	return Position{ID: m.ID, Timestamp: m.Timestamp}
+}
+
+// listMessages applies opts to an unordered set of messages. It backs the
+// in-memory stores.
+func listMessages(all []*models.Message, opts ListOptions) Page {
+	matched := make([]*models.Message, 0, len(all))
+	for _, m := range all {
+		if opts.matches(m) {
+			matched = append(matched, m)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return opts.before(PositionOf(matched[i]), PositionOf(matched[j]))
+	})
+
+	page := Page{Total: len(matched)}
+
+	if opts.After != nil {
+		i := sort.Search(len(matched), func(i int) bool {
+			return opts.before(*opts.After, PositionOf(matched[i]))
+		})
+		matched = matched[i:]
+	}
+	if opts.Offset > 0 {
+		matched = matched[min(opts.Offset, len(matched)):]
+	}
+	if opts.Limit > 0 && len(matched) > opts.Limit {
+		matched = matched[:opts.Limit]
+		page.HasMore = true
+	}
+
+	page.Messages = matched
+	return page
+}
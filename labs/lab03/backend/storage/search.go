@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"lab03-backend/models"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Limits of search queries
+const (
+	maxQueryTerms = 16
+	// maxPrefixTerms bounds the index terms a prefix expands to
+	maxPrefixTerms = 64
+)
+
+// ErrInvalidQuery is wrapped by the errors of ParseQuery
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// Term is a word of a query. Prefix terms match every word starting with
+// Text.
+type Term struct {
+	Text   string
+	Prefix bool
+}
+
+// Query matches messages containing all terms of at least one clause
+type Query struct {
+	Clauses [][]Term
+}
+
+// ParseQuery reads queries such as "deploy failed OR rollback*". Words are
+// combined with AND unless separated by OR, which binds more loosely.
+// A trailing * makes a word a prefix. Words are split and lowercased like
+// the indexed content.
+func ParseQuery(s string) (Query, error) {
+	var q Query
+	var clause []Term
+	count := 0
+
+	for _, field := range strings.Fields(s) {
+		switch field {
+		case "OR":
+			if len(clause) > 0 {
+				q.Clauses = append(q.Clauses, clause)
+				clause = nil
+			}
+			continue
+		case "AND":
+			continue
+		}
+
+		prefix := strings.HasSuffix(field, "*")
+		tokens := tokenize(strings.TrimRight(field, "*"))
+		for i, tok := range tokens {
+			clause = append(clause, Term{Text: tok.text, Prefix: prefix && i == len(tokens)-1})
+			count++
+		}
+	}
+	if len(clause) > 0 {
+		q.Clauses = append(q.Clauses, clause)
+	}
+
+	if count == 0 {
+		return q, fmt.Errorf("%w: no words to search for", ErrInvalidQuery)
+	}
+	if count > maxQueryTerms {
+		return q, fmt.Errorf("%w: more than %d words", ErrInvalidQuery, maxQueryTerms)
+	}
+	return q, nil
+}
+
+// matches reports whether an indexed word satisfies a term of the query
+func (q Query) matches(word string) bool {
+	for _, clause := range q.Clauses {
+		for _, term := range clause {
+			if word == term.Text || term.Prefix && strings.HasPrefix(word, term.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SearchResult is a message matching a query
+type SearchResult struct {
+	Message *models.Message `json:"message"`
+	// Score ranks results by TF-IDF, higher is better
+	Score float64 `json:"score"`
+	// Snippet is an HTML-escaped excerpt of the content with the matching
+	// words wrapped in <mark> tags
+	Snippet string `json:"snippet"`
+}
+
+// SearchPage is one page of search results
+type SearchPage struct {
+	Results []SearchResult
+	Total   int
+}
+
+// token is a word of a text and its byte offsets
+type token struct {
+	text       string
+	start, end int
+}
+
+// tokenize splits text into lowercased words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// searchIndex is an inverted index of message contents. It is safe for
+// concurrent use; stores update it together with the messages.
+type searchIndex struct {
+	mutex sync.RWMutex
+	// postings maps words to the messages containing them, with the
+	// number of occurrences
+	postings map[string]map[int]int
+	// docs maps messages to their words, and lengths to their word count
+	docs    map[int]map[string]int
+	lengths map[int]int
+	// rooms maps messages to their room
+	rooms map[int]string
+	// words holds the keys of postings in order, for prefix queries
+	words []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[int]int),
+		docs:     make(map[int]map[string]int),
+		lengths:  make(map[int]int),
+		rooms:    make(map[int]string),
+	}
+}
+
+// add indexes the content of a message, replacing an earlier version
+func (ix *searchIndex) add(id int, room, content string) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+
+	ix.removeLocked(id)
+
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, tok := range tokens {
+		counts[tok.text]++
+	}
+	for word, n := range counts {
+		docs, ok := ix.postings[word]
+		if !ok {
+			docs = make(map[int]int)
+			ix.postings[word] = docs
+			i := sort.SearchStrings(ix.words, word)
+			ix.words = append(ix.words, "")
+			copy(ix.words[i+1:], ix.words[i:])
+			ix.words[i] = word
+		}
+		docs[id] = n
+	}
+	ix.docs[id] = counts
+	ix.lengths[id] = len(tokens)
+	ix.rooms[id] = room
+}
+
+// remove drops a message from the index
+func (ix *searchIndex) remove(id int) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+
+	ix.removeLocked(id)
+}
+
+func (ix *searchIndex) removeLocked(id int) {
+	for word := range ix.docs[id] {
+		docs := ix.postings[word]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(ix.postings, word)
+			i := sort.SearchStrings(ix.words, word)
+			ix.words = append(ix.words[:i], ix.words[i+1:]...)
+		}
+	}
+	delete(ix.docs, id)
+	delete(ix.lengths, id)
+	delete(ix.rooms, id)
+}
+
+// expand returns the indexed words matching a term
+func (ix *searchIndex) expand(term Term) []string {
+	if !term.Prefix {
+		if _, ok := ix.postings[term.Text]; ok {
+			return []string{term.Text}
+		}
+		return nil
+	}
+	var words []string
+	for i := sort.SearchStrings(ix.words, term.Text); i < len(ix.words) && len(words) < maxPrefixTerms; i++ {
+		if !strings.HasPrefix(ix.words[i], term.Text) {
+			break
+		}
+		words = append(words, ix.words[i])
+	}
+	return words
+}
+
+// hit is a matching message and its score
+type hit struct {
+	id    int
+	score float64
+}
+
+// search returns the messages of room matching q, best first. Ties are
+// broken by ID, newest first. Word rarity is measured across all rooms.
+func (ix *searchIndex) search(q Query, room string) []hit {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+
+	matched := make(map[int]bool)
+	scored := make(map[string]bool)
+
+	for _, clause := range q.Clauses {
+		var candidates map[int]bool
+		for _, term := range clause {
+			docs := make(map[int]bool)
+			for _, word := range ix.expand(term) {
+				scored[word] = true
+				for id := range ix.postings[word] {
+					if candidates == nil || candidates[id] {
+						docs[id] = true
+					}
+				}
+			}
+			candidates = docs
+			if len(candidates) == 0 {
+				break
+			}
+		}
+		for id := range candidates {
+			if ix.rooms[id] == room {
+				matched[id] = true
+			}
+		}
+	}
+
+	// tf is the share of the message taken by the word, idf favours rare
+	// words
+	n := float64(len(ix.docs))
+	hits := make([]hit, 0, len(matched))
+	for id := range matched {
+		score := 0.0
+		for word := range scored {
+			if count, ok := ix.postings[word][id]; ok {
+				tf := float64(count) / float64(ix.lengths[id])
+				idf := math.Log(1 + n/float64(len(ix.postings[word])))
+				score += tf * idf
+			}
+		}
+		hits = append(hits, hit{id: id, score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].id > hits[j].id
+	})
+	return hits
+}
+
+// Snippet sizes in words
+const (
+	snippetBefore = 5
+	snippetWords  = 25
+)
+
+// snippet returns an excerpt of content around the first word matching q,
+// HTML-escaped, with matching words in <mark> tags
+func snippet(content string, q Query) string {
+	tokens := tokenize(content)
+	first := -1
+	for i, tok := range tokens {
+		if q.matches(tok.text) {
+			first = i
+			break
+		}
+	}
+
+	from, to := 0, len(tokens)
+	if len(tokens) > snippetWords {
+		from = max(0, first-snippetBefore)
+		to = min(len(tokens), from+snippetWords)
+	}
+
+	var b strings.Builder
+	pos := 0
+	if from > 0 {
+		b.WriteString("…")
+		pos = tokens[from].start
+	}
+	for _, tok := range tokens[from:to] {
+		b.WriteString(html.EscapeString(content[pos:tok.start]))
+		word := html.EscapeString(content[tok.start:tok.end])
+		if q.matches(tok.text) {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+		pos = tok.end
+	}
+	if to < len(tokens) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(html.EscapeString(content[pos:]))
+	}
+	return b.String()
+}
+
+// searchPage loads the requested page of hits through get. Messages that
+// get no longer finds, deleted since the search, are skipped.
+func searchPage(hits []hit, q Query, offset, limit int, get func(id int) (*models.Message, error)) (SearchPage, error) {
+	page := SearchPage{Total: len(hits), Results: []SearchResult{}}
+	hits = hits[min(offset, len(hits)):]
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for _, h := range hits {
+		message, err := get(h.id)
+		if errors.Is(err, ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return SearchPage{}, err
+		}
+		page.Results = append(page.Results, SearchResult{
+			Message: message,
+			Score:   h.score,
+			Snippet: snippet(message.Content, q),
+		})
+	}
+	return page, nil
+}
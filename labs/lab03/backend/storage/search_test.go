@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	var words []string
+	for _, tok := range tokenize("Straße, ÉCOLE & naïve café-2024 ПРИВЕТ!") {
+		words = append(words, tok.text)
+	}
+	want := []string{"straße", "école", "naïve", "café", "2024", "привет"}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("Expected %v, got %v", want, words)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]Term
+	}{
+		{"Hello World", [][]Term{{{Text: "hello"}, {Text: "world"}}}},
+		{"hello AND world", [][]Term{{{Text: "hello"}, {Text: "world"}}}},
+		{"deploy* OR rollback", [][]Term{{{Text: "deploy", Prefix: true}}, {{Text: "rollback"}}}},
+		{"OR e-mail* OR", [][]Term{{{Text: "e"}, {Text: "mail", Prefix: true}}}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.Clauses, tt.want) {
+			t.Errorf("ParseQuery(%q): expected %v, got %v", tt.query, tt.want, q.Clauses)
+		}
+	}
+
+	for _, query := range []string{"", "  ", "OR AND", "*", strings.Repeat("word ", maxQueryTerms+1)} {
+		if _, err := ParseQuery(query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseQuery(%q): expected ErrInvalidQuery, got %v", query, err)
+		}
+	}
+}
+
+func TestSearchIndexRanking(t *testing.T) {
+	ix := newSearchIndex()
+	ix.add(1, "", "go go go")
+	ix.add(2, "", "go is fun")
+	ix.add(3, "", "rust is fun")
+	ix.add(4, "general", "go go")
+
+	q, _ := ParseQuery("go OR fun")
+	hits := ix.search(q, "")
+	var ids []int
+	for _, h := range hits {
+		ids = append(ids, h.id)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected %v, got %v", want, ids)
+	}
+
+	if hits := ix.search(q, "general"); len(hits) != 1 || hits[0].id != 4 {
+		t.Errorf("Expected message 4 in room general, got %v", hits)
+	}
+
+	ix.remove(1)
+	ix.remove(4)
+	ix.add(2, "", "nothing here")
+	q, _ = ParseQuery("g*")
+	if hits := ix.search(q, ""); len(hits) != 0 {
+		t.Errorf("Expected no hits, got %v", hits)
+	}
+	if len(ix.words) != len(ix.postings) {
+		t.Errorf("Expected %d sorted words, got %d", len(ix.postings), len(ix.words))
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	q, _ := ParseQuery("needle")
+
+	if got, want := snippet("a <b>needle</b>", q), "a &lt;b&gt;<mark>needle</mark>&lt;/b&gt;"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	long := strings.Repeat("hay ", 30) + "needle " + strings.Repeat("hay ", 30)
+	got := snippet(long, q)
+	if !strings.HasPrefix(got, "…hay hay hay hay hay <mark>needle</mark> hay") || !strings.HasSuffix(got, "hay…") {
+		t.Errorf("Unexpected snippet %q", got)
+	}
+}
@@ -0,0 +1,1118 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"lab03-backend/models"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema creates the tables. AUTOINCREMENT keeps IDs of purged
+// messages from being reused, like MemoryStorage. message_revisions holds
+// the replaced contents of each message, message_reactions one row per
+// user and emoji, room_members one row per room and user.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	username   TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	timestamp  TIMESTAMP NOT NULL,
+	version    INTEGER NOT NULL DEFAULT 1,
+	edited_at  TIMESTAMP,
+	deleted_at TIMESTAMP,
+	parent_id  INTEGER
+);
+CREATE TABLE IF NOT EXISTS message_reactions (
+	message_id INTEGER NOT NULL,
+	emoji      TEXT NOT NULL,
+	username   TEXT NOT NULL,
+	PRIMARY KEY (message_id, emoji, username)
+);
+CREATE TABLE IF NOT EXISTS message_revisions (
+	message_id INTEGER NOT NULL,
+	version    INTEGER NOT NULL,
+	content    TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (message_id, version)
+);
+CREATE TABLE IF NOT EXISTS rooms (
+	name        TEXT PRIMARY KEY,
+	description TEXT NOT NULL,
+	private     INTEGER NOT NULL,
+	owner       TEXT NOT NULL,
+	created_at  TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS room_members (
+	room     TEXT NOT NULL,
+	username TEXT NOT NULL,
+	PRIMARY KEY (room, username)
+)`
+
+// addedColumns were added to the messages table after its creation, they
+// are missing from older databases
+var addedColumns = []struct{ name, definition string }{
+	{"version", "INTEGER NOT NULL DEFAULT 1"},
+	{"edited_at", "TIMESTAMP"},
+	{"deleted_at", "TIMESTAMP"},
+	{"parent_id", "INTEGER"},
+	{"room", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// sqliteIndexes need the added columns
+const sqliteIndexes = `
+CREATE INDEX IF NOT EXISTS messages_parent_id ON messages (parent_id);
+CREATE INDEX IF NOT EXISTS messages_room ON messages (room, id)`
+
+// messageColumns are read by scanMessage. The reply count is computed,
+// reactions are added by withReactions.
+const messageColumns = `id, username, content, timestamp, version, edited_at, deleted_at, parent_id, room,
+	(SELECT COUNT(*) FROM messages AS reply WHERE reply.parent_id = messages.id AND reply.deleted_at IS NULL)`
+
+// SQLiteStorage keeps messages in a SQLite database file
+type SQLiteStorage struct {
+	db *sql.DB
+	// writes holds writes and their events together, so that events are
+	// published in the order of the changes
+	writes sync.Mutex
+	events *Broker
+	// index is built when the database is opened. Changes made by other
+	// processes sharing the file are not searchable until it is reopened.
+	index *searchIndex
+}
+
+// NewSQLiteStorage opens or creates the database at path
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, one connection avoids lock errors
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteIndexes); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &SQLiteStorage{db: db, events: NewBroker(DefaultReplayBuffer), index: newSearchIndex()}
+	if err := s.buildIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// buildIndex indexes the messages that are not deleted
+func (s *SQLiteStorage) buildIndex() error {
+	rows, err := s.db.Query(`SELECT id, room, content FROM messages WHERE deleted_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var room, content string
+		if err := rows.Scan(&id, &room, &content); err != nil {
+			return err
+		}
+		s.index.add(id, room, content)
+	}
+	return rows.Err()
+}
+
+// addColumns upgrades databases created by earlier versions
+func addColumns(db *sql.DB) error {
+	for _, column := range addedColumns {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('messages') WHERE name = ?`, column.name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE messages ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// GetAll returns all messages. Database errors are logged and yield an
+// empty list, as the method cannot report them.
+func (s *SQLiteStorage) GetAll() []*models.Message {
+	rows, err := s.db.Query(`SELECT ` + messageColumns + ` FROM messages WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		log.Printf("storage: listing messages: %v", err)
+		return []*models.Message{}
+	}
+	defer rows.Close()
+
+	messages := []*models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			log.Printf("storage: reading message: %v", err)
+			return []*models.Message{}
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("storage: listing messages: %v", err)
+		return []*models.Message{}
+	}
+	if err := withReactions(s.db, messages); err != nil {
+		log.Printf("storage: reading reactions: %v", err)
+		return []*models.Message{}
+	}
+	return messages
+}
+
+// querier runs statements on the database or in a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// GetByID returns a message by its ID
+func (s *SQLiteStorage) GetByID(id int) (*models.Message, error) {
+	return lookupMessage(s.db, id, AnyVersion)
+}
+
+// get returns a message, deleted or not
+func (s *SQLiteStorage) get(id int) (*models.Message, error) {
+	return getMessage(s.db, id)
+}
+
+// getMessage returns a message, deleted or not
+func getMessage(q querier, id int) (*models.Message, error) {
+	row := q.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id)
+	message, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := withReactions(q, []*models.Message{message}); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// withReactions fills in the reaction counts of messages
+func withReactions(q querier, messages []*models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	byID := make(map[int]*models.Message, len(messages))
+	ids := make([]int, 0, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+		ids = append(ids, message.ID)
+	}
+	// A JSON array avoids the limit on the number of query parameters
+	idList, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.Query(`SELECT message_id, emoji, COUNT(*) FROM message_reactions
+		WHERE message_id IN (SELECT value FROM json_each(?)) GROUP BY message_id, emoji`, string(idList))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, count int
+		var emoji string
+		if err := rows.Scan(&id, &emoji, &count); err != nil {
+			return err
+		}
+		message := byID[id]
+		if message.Reactions == nil {
+			message.Reactions = make(map[string]int)
+		}
+		message.Reactions[emoji] = count
+	}
+	return rows.Err()
+}
+
+// Create adds a new message to storage
+func (s *SQLiteStorage) Create(username, content string) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	return s.create("", username, content, nil)
+}
+
+// CreateReply adds a reply to a message
+func (s *SQLiteStorage) CreateReply(parentID int, username, content string) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	parent, err := s.GetByID(parentID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return nil, ErrParentNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return s.create(parent.Room, username, content, &parentID)
+}
+
+// CreateInRoom adds a new message to a room
+func (s *SQLiteStorage) CreateInRoom(room, username, content string) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	if _, err := s.GetRoom(room); err != nil {
+		return nil, err
+	}
+	return s.create(room, username, content, nil)
+}
+
+// create inserts a message, the caller holds the write lock
+func (s *SQLiteStorage) create(room, username, content string, parentID *int) (*models.Message, error) {
+	message := models.NewMessage(0, username, content)
+	// Drop the monotonic clock reading, which cannot be stored
+	message.Timestamp = message.Timestamp.Round(0)
+	message.ParentID = parentID
+	message.Room = room
+
+	if err := insertMessage(s.db, message); err != nil {
+		return nil, err
+	}
+	s.index.add(message.ID, room, content)
+	s.events.Publish(EventCreated, message)
+	return message, nil
+}
+
+// insertMessage stores a new message and sets its ID, unless it has one
+func insertMessage(q querier, message *models.Message) error {
+	var editedAt *time.Time
+	if message.EditedAt != nil {
+		t := message.EditedAt.UTC()
+		editedAt = &t
+	}
+	// Timestamps are stored in UTC so that they sort as text. A NULL ID
+	// is assigned by SQLite.
+	res, err := q.Exec(`INSERT INTO messages (id, username, content, timestamp, version, edited_at, parent_id, room)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?)`,
+		message.ID, message.Username, message.Content, message.Timestamp.UTC(), message.Version, editedAt,
+		message.ParentID, message.Room)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	message.ID = int(id)
+	return nil
+}
+
+// Import stores an exported message
+func (s *SQLiteStorage) Import(message models.Message, remap bool) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	normalizeImport(&message)
+	if message.ParentID != nil {
+		parent, err := s.GetByID(*message.ParentID)
+		if errors.Is(err, ErrMessageNotFound) || err == nil && parent.Room != message.Room {
+			return nil, ErrParentNotFound
+		} else if err != nil {
+			return nil, err
+		}
+	} else if message.Room != "" {
+		if _, err := s.GetRoom(message.Room); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.RoomOf(message.ID); err == nil {
+		if !remap {
+			return nil, ErrIDTaken
+		}
+		message.ID = 0
+	} else if !errors.Is(err, ErrMessageNotFound) {
+		return nil, err
+	}
+	if err := insertMessage(s.db, &message); err != nil {
+		return nil, err
+	}
+	s.index.add(message.ID, message.Room, message.Content)
+	s.events.Publish(EventCreated, &message)
+	return clone(&message), nil
+}
+
+// AddReaction records the reaction of a user to a message
+func (s *SQLiteStorage) AddReaction(id int, emoji, username string) (*models.Message, error) {
+	return s.react(id, `INSERT OR IGNORE INTO message_reactions (message_id, emoji, username) VALUES (?, ?, ?)`,
+		emoji, username, ErrReactionExists)
+}
+
+// RemoveReaction withdraws the reaction of a user to a message
+func (s *SQLiteStorage) RemoveReaction(id int, emoji, username string) (*models.Message, error) {
+	return s.react(id, `DELETE FROM message_reactions WHERE message_id = ? AND emoji = ? AND username = ?`,
+		emoji, username, ErrReactionNotFound)
+}
+
+// react runs a statement changing one reaction. If it changes nothing,
+// unchanged is returned.
+func (s *SQLiteStorage) react(id int, query, emoji, username string, unchanged error) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	if _, err := s.GetByID(id); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(query, id, emoji, username)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, unchanged
+	}
+
+	message, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish(EventReacted, message)
+	return message, nil
+}
+
+// Update modifies an existing message
+func (s *SQLiteStorage) Update(id int, content string) (*models.Message, error) {
+	return s.UpdateIf(id, AnyVersion, content)
+}
+
+// UpdateIf modifies a message if it is at the given version
+func (s *SQLiteStorage) UpdateIf(id, version int, content string) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	message, err := s.lookup(id, version)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Round(0)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := updateMessage(tx, message, content, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.index.add(id, message.Room, content)
+	s.events.Publish(EventUpdated, message)
+	return message, nil
+}
+
+// updateMessage replaces the content of message, recording the old one,
+// and updates message to match. q must be a transaction.
+func updateMessage(q querier, message *models.Message, content string, at time.Time) error {
+	previous := currentRevision(message)
+	if _, err := q.Exec(`INSERT OR REPLACE INTO message_revisions (message_id, version, content, created_at) VALUES (?, ?, ?, ?)`,
+		message.ID, previous.Version, previous.Content, previous.CreatedAt.UTC()); err != nil {
+		return err
+	}
+	if _, err := q.Exec(`DELETE FROM message_revisions WHERE message_id = ? AND version NOT IN
+		(SELECT version FROM message_revisions WHERE message_id = ? ORDER BY version DESC LIMIT ?)`,
+		message.ID, message.ID, MaxRevisions); err != nil {
+		return err
+	}
+	// The version condition also guards against other processes sharing
+	// the database file
+	res, err := q.Exec(`UPDATE messages SET content = ?, version = version + 1, edited_at = ? WHERE id = ? AND version = ?`,
+		content, at.UTC(), message.ID, message.Version)
+	if err != nil {
+		return err
+	}
+	if err := changed(res); err != nil {
+		return err
+	}
+
+	message.Content = content
+	message.Version++
+	message.EditedAt = &at
+	return nil
+}
+
+// Delete marks a message as deleted
+func (s *SQLiteStorage) Delete(id int) error {
+	return s.DeleteIf(id, AnyVersion)
+}
+
+// DeleteIf marks a message as deleted if it is at the given version
+func (s *SQLiteStorage) DeleteIf(id, version int) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	message, err := s.lookup(id, version)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Round(0)
+
+	if err := deleteMessage(s.db, message, now); err != nil {
+		return err
+	}
+	s.index.remove(id)
+	s.events.Publish(EventDeleted, message)
+	return nil
+}
+
+// deleteMessage marks message as deleted and updates it to match
+func deleteMessage(q querier, message *models.Message, at time.Time) error {
+	res, err := q.Exec(`UPDATE messages SET deleted_at = ?, version = version + 1 WHERE id = ? AND version = ?`,
+		at.UTC(), message.ID, message.Version)
+	if err != nil {
+		return err
+	}
+	if err := changed(res); err != nil {
+		return err
+	}
+
+	message.Version++
+	message.Deleted = true
+	message.DeletedAt = &at
+	return nil
+}
+
+// Restore brings back a deleted message
+func (s *SQLiteStorage) Restore(id int) (*models.Message, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	message, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !message.Deleted {
+		return nil, ErrNotDeleted
+	}
+
+	res, err := s.db.Exec(`UPDATE messages SET deleted_at = NULL, version = version + 1 WHERE id = ? AND version = ?`,
+		id, message.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := changed(res); err != nil {
+		return nil, err
+	}
+
+	message.Version++
+	message.Deleted = false
+	message.DeletedAt = nil
+	s.index.add(id, message.Room, message.Content)
+	s.events.Publish(EventRestored, message)
+	return message, nil
+}
+
+// Batch applies ops in order in a single transaction, so readers never
+// see part of a batch. In best-effort batches every operation has its own
+// savepoint, which a failure rolls back.
+func (s *SQLiteStorage) Batch(ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Round(0)
+	results := make([]BatchResult, len(ops))
+	var changes []batchChange
+	for i, op := range ops {
+		if !atomic {
+			if _, err := tx.Exec(`SAVEPOINT batch_op`); err != nil {
+				return nil, err
+			}
+		}
+		change, err := applyOp(tx, op, now)
+		if err != nil {
+			results[i].Err = err
+			if atomic {
+				abortBatch(results, i)
+				return results, nil
+			}
+			if _, err := tx.Exec(`ROLLBACK TO batch_op`); err != nil {
+				return nil, err
+			}
+		} else {
+			changes = append(changes, change)
+			results[i].Message = clone(change.message)
+		}
+		if !atomic {
+			if _, err := tx.Exec(`RELEASE batch_op`); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		if change.message.Deleted {
+			s.index.remove(change.message.ID)
+		} else {
+			s.index.add(change.message.ID, change.message.Room, change.message.Content)
+		}
+		s.events.Publish(change.typ, change.message)
+	}
+	return results, nil
+}
+
+// applyOp applies one operation of a batch in the transaction tx
+func applyOp(tx querier, op BatchOp, at time.Time) (batchChange, error) {
+	switch op.Op {
+	case BatchCreate:
+		message := models.NewMessage(0, op.Username, op.Content)
+		message.Timestamp = at
+		message.Room = op.Room
+		if op.ParentID != nil {
+			parent, err := lookupMessage(tx, *op.ParentID, AnyVersion)
+			if errors.Is(err, ErrMessageNotFound) || err == nil && parent.Room != op.Room {
+				return batchChange{}, ErrParentNotFound
+			} else if err != nil {
+				return batchChange{}, err
+			}
+			parentID := *op.ParentID
+			message.ParentID = &parentID
+		} else if op.Room != "" {
+			err := tx.QueryRow(`SELECT name FROM rooms WHERE name = ?`, op.Room).Scan(new(string))
+			if errors.Is(err, sql.ErrNoRows) {
+				return batchChange{}, ErrRoomNotFound
+			} else if err != nil {
+				return batchChange{}, err
+			}
+		}
+		if err := insertMessage(tx, message); err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{typ: EventCreated, message: message}, nil
+
+	case BatchUpdate:
+		message, err := lookupMessage(tx, op.ID, op.Version)
+		if err != nil {
+			return batchChange{}, err
+		}
+		if err := updateMessage(tx, message, op.Content, at); err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{typ: EventUpdated, message: message}, nil
+
+	case BatchDelete:
+		message, err := lookupMessage(tx, op.ID, op.Version)
+		if err != nil {
+			return batchChange{}, err
+		}
+		if err := deleteMessage(tx, message, at); err != nil {
+			return batchChange{}, err
+		}
+		return batchChange{typ: EventDeleted, message: message}, nil
+
+	default:
+		return batchChange{}, errUnknownBatchOp
+	}
+}
+
+// Search returns the messages of room matching q, best first
+func (s *SQLiteStorage) Search(room string, q Query, offset, limit int) (SearchPage, error) {
+	return searchPage(s.index.search(q, room), q, offset, limit, s.GetByID)
+}
+
+// RoomOf returns the room of a message, deleted or not
+func (s *SQLiteStorage) RoomOf(id int) (string, error) {
+	var room string
+	err := s.db.QueryRow(`SELECT room FROM messages WHERE id = ?`, id).Scan(&room)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrMessageNotFound
+	}
+	return room, err
+}
+
+// History returns the revisions of a message, deleted or not
+func (s *SQLiteStorage) History(id int) ([]models.Revision, error) {
+	message, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT version, content, created_at FROM message_revisions WHERE message_id = ? ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.Revision{}
+	for rows.Next() {
+		var revision models.Revision
+		if err := rows.Scan(&revision.Version, &revision.Content, &revision.CreatedAt); err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = revision.CreatedAt.Local()
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return append(revisions, currentRevision(message)), nil
+}
+
+// Purge permanently removes messages deleted before the given time
+func (s *SQLiteStorage) Purge(before time.Time) (int, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"message_revisions", "message_reactions"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE message_id IN
+			(SELECT id FROM messages WHERE deleted_at < ?)`, before.UTC()); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE deleted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// lookup returns a message if it is at version
+func (s *SQLiteStorage) lookup(id, version int) (*models.Message, error) {
+	return lookupMessage(s.db, id, version)
+}
+
+// lookupMessage returns a message if it is not deleted and at version
+func lookupMessage(q querier, id, version int) (*models.Message, error) {
+	message, err := getMessage(q, id)
+	if err == nil && message.Deleted {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version != AnyVersion && message.Version != version {
+		return nil, ErrVersionConflict
+	}
+	return message, nil
+}
+
+// changed returns ErrVersionConflict if a conditional write matched no row
+func changed(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Subscribe delivers an event for every change made through this store
+func (s *SQLiteStorage) Subscribe(lastEventID uint64) *Subscription {
+	return s.events.Subscribe(lastEventID)
+}
+
+// List returns the messages selected by opts. Content matching ignores
+// case for ASCII letters only.
+func (s *SQLiteStorage) List(opts ListOptions) (Page, error) {
+	var where []string
+	var args []interface{}
+	if !opts.AllRooms {
+		where = append(where, "room = ?")
+		args = append(args, opts.Room)
+	}
+	if !opts.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if opts.ParentID != 0 {
+		where = append(where, "parent_id = ?")
+		args = append(args, opts.ParentID)
+	}
+	if opts.Username != "" {
+		where = append(where, "username = ?")
+		args = append(args, opts.Username)
+	}
+	if !opts.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, opts.Since.UTC())
+	}
+	if !opts.Until.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, opts.Until.UTC())
+	}
+	if opts.Contains != "" {
+		where = append(where, "instr(lower(content), lower(?)) > 0")
+		args = append(args, opts.Contains)
+	}
+
+	var page Page
+	countQuery := `SELECT COUNT(*) FROM messages` + whereClause(where)
+	if err := s.db.QueryRow(countQuery, args...).Scan(&page.Total); err != nil {
+		return Page{}, err
+	}
+
+	dir, cmp := "ASC", ">"
+	if opts.Descending {
+		dir, cmp = "DESC", "<"
+	}
+	order := "id " + dir
+	if opts.SortBy == SortByTimestamp {
+		order = "timestamp " + dir + ", id " + dir
+	}
+
+	if after := opts.After; after != nil {
+		if opts.SortBy == SortByTimestamp {
+			where = append(where, "(timestamp "+cmp+" ? OR (timestamp = ? AND id "+cmp+" ?))")
+			args = append(args, after.Timestamp.UTC(), after.Timestamp.UTC(), after.ID)
+		} else {
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, after.ID)
+		}
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	query := `SELECT ` + messageColumns + ` FROM messages` + whereClause(where) + ` ORDER BY ` + order
+	limit := -1
+	if opts.Limit > 0 {
+		limit = opts.Limit + 1
+	}
+	query += ` LIMIT ? OFFSET ?`
+	args = append(args, limit, opts.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+
+	page.Messages = []*models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return Page{}, err
+		}
+		page.Messages = append(page.Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+
+	if opts.Limit > 0 && len(page.Messages) > opts.Limit {
+		page.Messages = page.Messages[:opts.Limit]
+		page.HasMore = true
+	}
+	if err := withReactions(s.db, page.Messages); err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// Count returns the total number of messages
+func (s *SQLiteStorage) Count() int {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE deleted_at IS NULL`).Scan(&count); err != nil {
+		log.Printf("storage: counting messages: %v", err)
+	}
+	return count
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var message models.Message
+	var timestamp time.Time
+	var editedAt, deletedAt sql.NullTime
+	var parentID sql.NullInt64
+	if err := row.Scan(&message.ID, &message.Username, &message.Content, &timestamp, &message.Version,
+		&editedAt, &deletedAt, &parentID, &message.Room, &message.ReplyCount); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		message.ParentID = &id
+	}
+	message.Timestamp = timestamp.Local()
+	if editedAt.Valid {
+		t := editedAt.Time.Local()
+		message.EditedAt = &t
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time.Local()
+		message.Deleted = true
+		message.DeletedAt = &t
+	}
+	return &message, nil
+}
+
+// roomColumns are read by scanRoom. Members are added by withMembers.
+const roomColumns = `name, description, private, owner, created_at,
+	(SELECT COUNT(*) FROM messages WHERE messages.room = rooms.name AND messages.deleted_at IS NULL)`
+
+// CreateRoom adds a room with its owner as the only member
+func (s *SQLiteStorage) CreateRoom(room models.Room) (*models.Room, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO rooms (name, description, private, owner, created_at) VALUES (?, ?, ?, ?, ?)`,
+		room.Name, room.Description, room.Private, room.Owner, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrRoomExists
+	}
+	if _, err := tx.Exec(`INSERT INTO room_members (room, username) VALUES (?, ?)`, room.Name, room.Owner); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetRoom(room.Name)
+}
+
+// GetRoom returns a room by its name
+func (s *SQLiteStorage) GetRoom(name string) (*models.Room, error) {
+	rooms, err := s.queryRooms(`SELECT `+roomColumns+` FROM rooms WHERE name = ?`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rooms) == 0 {
+		return nil, ErrRoomNotFound
+	}
+	return rooms[0], nil
+}
+
+// ListRooms returns all rooms ordered by name
+func (s *SQLiteStorage) ListRooms() ([]*models.Room, error) {
+	return s.queryRooms(`SELECT ` + roomColumns + ` FROM rooms ORDER BY name`)
+}
+
+// queryRooms reads rooms with their members
+func (s *SQLiteStorage) queryRooms(query string, args ...interface{}) ([]*models.Room, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []*models.Room{}
+	byName := make(map[string]*models.Room)
+	for rows.Next() {
+		var room models.Room
+		if err := rows.Scan(&room.Name, &room.Description, &room.Private, &room.Owner, &room.CreatedAt,
+			&room.MessageCount); err != nil {
+			return nil, err
+		}
+		room.CreatedAt = room.CreatedAt.Local()
+		room.Members = []string{}
+		rooms = append(rooms, &room)
+		byName[room.Name] = &room
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(rooms) == 0 {
+		return rooms, nil
+	}
+
+	names := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		names = append(names, room.Name)
+	}
+	nameList, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.db.Query(`SELECT room, username FROM room_members
+		WHERE room IN (SELECT value FROM json_each(?)) ORDER BY room, username`, string(nameList))
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+
+	for members.Next() {
+		var name, username string
+		if err := members.Scan(&name, &username); err != nil {
+			return nil, err
+		}
+		room := byName[name]
+		room.Members = append(room.Members, username)
+	}
+	return rooms, members.Err()
+}
+
+// UpdateRoom changes the description and visibility of a room
+func (s *SQLiteStorage) UpdateRoom(name, description string, private bool) (*models.Room, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	res, err := s.db.Exec(`UPDATE rooms SET description = ?, private = ? WHERE name = ?`, description, private, name)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrRoomNotFound
+	}
+	return s.GetRoom(name)
+}
+
+// DeleteRoom removes a room and its messages
+func (s *SQLiteStorage) DeleteRoom(name string) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	rows, err := s.db.Query(`SELECT id FROM messages WHERE room = ?`, name)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM rooms WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRoomNotFound
+	}
+	for _, table := range []string{"message_revisions", "message_reactions"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE message_id IN
+			(SELECT id FROM messages WHERE room = ?)`, name); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE room = ?`, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM room_members WHERE room = ?`, name); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		s.index.remove(id)
+	}
+	return nil
+}
+
+// AddMember lets a user join a room
+func (s *SQLiteStorage) AddMember(name, username string) (*models.Room, error) {
+	return s.member(name, `INSERT OR IGNORE INTO room_members (room, username) VALUES (?, ?)`,
+		username, ErrAlreadyMember)
+}
+
+// RemoveMember makes a user leave a room
+func (s *SQLiteStorage) RemoveMember(name, username string) (*models.Room, error) {
+	return s.member(name, `DELETE FROM room_members WHERE room = ? AND username = ?`,
+		username, ErrNotMember)
+}
+
+// member runs a statement changing one membership. If it changes nothing,
+// unchanged is returned.
+func (s *SQLiteStorage) member(name, query, username string, unchanged error) (*models.Room, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	if _, err := s.GetRoom(name); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(query, name, username)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, unchanged
+	}
+	return s.GetRoom(name)
+}
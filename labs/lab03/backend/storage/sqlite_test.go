@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStoragePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+
+	store, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := store.Create("alice", "hello")
+	deleted, _ := store.Create("bob", "hello there")
+	store.Delete(deleted.ID)
+	store.Close()
+
+	store, err = NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+
+	got, err := store.GetByID(created.ID)
+	if err != nil || got.Content != "hello" || !got.Timestamp.Equal(created.Timestamp) {
+		t.Errorf("Unexpected message %+v, err %v", got, err)
+	}
+
+	q, _ := ParseQuery("hello")
+	page, err := store.Search("", q, 0, 10)
+	if err != nil || page.Total != 1 || page.Results[0].Message.ID != created.ID {
+		t.Errorf("Expected the index to be rebuilt with message %d, got %+v, err %v", created.ID, page, err)
+	}
+}
+
+func TestSQLiteStorageAddsVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+
+	// The schema before messages had versions
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		content TEXT NOT NULL,
+		timestamp TIMESTAMP NOT NULL
+	);
+	INSERT INTO messages (username, content, timestamp) VALUES ('alice', 'hello', '2025-06-01 12:00:00+00:00')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	defer store.Close()
+
+	got, err := store.GetByID(1)
+	if err != nil || got.Version != 1 {
+		t.Fatalf("Expected version 1, got %+v, err %v", got, err)
+	}
+	if updated, err := store.UpdateIf(1, 1, "edited"); err != nil || updated.Version != 2 {
+		t.Errorf("Expected version 2, got %+v, err %v", updated, err)
+	}
+}
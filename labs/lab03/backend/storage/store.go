@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"lab03-backend/models"
+	"time"
+)
+
+// AnyVersion makes UpdateIf and DeleteIf unconditional
+const AnyVersion = 0
+
+// MessageStore persists chat messages. Every implementation must pass the
+// conformance suite in store_test.go, so handlers behave the same whichever
+// store is configured. Messages returned by a store are copies the caller
+// may keep and modify.
+type MessageStore interface {
+	// GetAll returns all messages ordered by ID
+	GetAll() []*models.Message
+	// GetByID returns ErrMessageNotFound for unknown IDs
+	GetByID(id int) (*models.Message, error)
+	// Create assigns the next ID and the current time to a new message
+	Create(username, content string) (*models.Message, error)
+	// CreateReply creates a reply in the room of its parent, or returns
+	// ErrParentNotFound if the parent does not exist or is deleted
+	CreateReply(parentID int, username, content string) (*models.Message, error)
+	// CreateInRoom creates a message in a room, or returns ErrRoomNotFound
+	CreateInRoom(room, username, content string) (*models.Message, error)
+	// Import stores an exported message with its ID, timestamp, version
+	// and edit time, as a new message that is not deleted. A zero ID gets
+	// the next one, so does a taken ID if remap is set, otherwise it fails
+	// with ErrIDTaken. Rooms and parents are checked like on creation, and
+	// replies must be in the room of their parent.
+	Import(message models.Message, remap bool) (*models.Message, error)
+	// RoomOf returns the room of a message, deleted or not, which is empty
+	// for the lobby
+	RoomOf(id int) (string, error)
+	// Update replaces the content of a message and increments its version
+	Update(id int, content string) (*models.Message, error)
+	// Delete marks a message as deleted. Deleted messages are hidden from
+	// the other methods, except List with IncludeDeleted, History and
+	// Restore, until they are purged. IDs are never reused.
+	Delete(id int) error
+	// UpdateIf and DeleteIf only apply if the message is at version, and
+	// return ErrVersionConflict otherwise. The check and the change are
+	// atomic.
+	UpdateIf(id, version int, content string) (*models.Message, error)
+	DeleteIf(id, version int) error
+	// Restore brings back a deleted message, or returns ErrNotDeleted
+	Restore(id int) (*models.Message, error)
+	// Batch applies ops in order and returns one result per operation,
+	// with the errors of the methods above. An atomic batch applies all
+	// operations or none, the ones that did not fail report
+	// ErrBatchAborted. Readers never see part of a batch, and events are
+	// published for the applied operations only. The error reports a
+	// failure of the store, in which case nothing is applied.
+	Batch(ops []BatchOp, atomic bool) ([]BatchResult, error)
+	// History returns up to MaxRevisions past revisions of a message,
+	// oldest first, followed by the current one
+	History(id int) ([]models.Revision, error)
+	// AddReaction and RemoveReaction change the reaction of a user with an
+	// emoji, which exists at most once. They return ErrReactionExists and
+	// ErrReactionNotFound respectively when there is nothing to change.
+	AddReaction(id int, emoji, username string) (*models.Message, error)
+	RemoveReaction(id int, emoji, username string) (*models.Message, error)
+	// Purge permanently removes messages deleted before the given time
+	// and returns how many there were
+	Purge(before time.Time) (int, error)
+	// Count returns the number of stored messages
+	Count() int
+	// List returns the messages selected by opts, in the requested order.
+	// It only reads the messages of the selected room.
+	List(opts ListOptions) (Page, error)
+	// Search returns the messages of room that are not deleted matching
+	// q, ranked by TF-IDF, with Total counting every match
+	Search(room string, q Query, offset, limit int) (SearchPage, error)
+
+	// CreateRoom stores a room with its owner as the only member, or
+	// returns ErrRoomExists. It sets the creation time.
+	CreateRoom(room models.Room) (*models.Room, error)
+	// GetRoom returns ErrRoomNotFound for unknown rooms
+	GetRoom(name string) (*models.Room, error)
+	// ListRooms returns all rooms ordered by name
+	ListRooms() ([]*models.Room, error)
+	UpdateRoom(name, description string, private bool) (*models.Room, error)
+	// DeleteRoom permanently removes a room and its messages, without
+	// publishing events
+	DeleteRoom(name string) error
+	// AddMember and RemoveMember change the members of a room. They return
+	// ErrAlreadyMember and ErrNotMember when there is nothing to change.
+	AddMember(name, username string) (*models.Room, error)
+	RemoveMember(name, username string) (*models.Room, error)
+
+	// Subscribe delivers an event for every change, see Broker.Subscribe
+	Subscribe(lastEventID uint64) *Subscription
+}
+
+var (
+	_ MessageStore = (*MemoryStorage)(nil)
+	_ MessageStore = (*SQLiteStorage)(nil)
+	_ MessageStore = (*FileStorage)(nil)
+)
@@ -0,0 +1,930 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"lab03-backend/models"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMessageStore is the conformance suite every MessageStore must pass.
+// newStore returns an empty store.
+func testMessageStore(t *testing.T, newStore func(t *testing.T) MessageStore) {
+	t.Run("CRUD", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.Create("alice", "hello")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if created.ID != 1 || created.Username != "alice" || created.Content != "hello" || created.Timestamp.IsZero() {
+			t.Errorf("Unexpected message %+v", created)
+		}
+
+		got, err := store.GetByID(created.ID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if got.Content != "hello" || !got.Timestamp.Equal(created.Timestamp) {
+			t.Errorf("Expected %+v, got %+v", created, got)
+		}
+
+		updated, err := store.Update(created.ID, "hello again")
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if updated.Content != "hello again" || updated.Username != "alice" || !updated.Timestamp.Equal(created.Timestamp) {
+			t.Errorf("Unexpected updated message %+v", updated)
+		}
+
+		if err := store.Delete(created.ID); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if store.Count() != 0 || len(store.GetAll()) != 0 {
+			t.Error("Expected an empty store after delete")
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		store := newStore(t)
+
+		if _, err := store.GetByID(42); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("GetByID: expected ErrMessageNotFound, got %v", err)
+		}
+		if _, err := store.Update(42, "x"); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Update: expected ErrMessageNotFound, got %v", err)
+		}
+		if err := store.Delete(42); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Delete: expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("IDs are not reused", func(t *testing.T) {
+		store := newStore(t)
+
+		store.Create("alice", "one")
+		second, _ := store.Create("bob", "two")
+		store.Delete(second.ID)
+
+		third, err := store.Create("carol", "three")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if third.ID != 3 {
+			t.Errorf("Expected ID 3, got %d", third.ID)
+		}
+		if store.Count() != 2 || len(store.GetAll()) != 2 {
+			t.Errorf("Expected 2 messages, got %d", store.Count())
+		}
+	})
+
+	t.Run("GetAll is ordered", func(t *testing.T) {
+		store := newStore(t)
+		for i := 0; i < 20; i++ {
+			store.Create("user", "content")
+		}
+		for i, m := range store.GetAll() {
+			if m.ID != i+1 {
+				t.Fatalf("Expected ID %d at position %d, got %d", i+1, i, m.ID)
+			}
+		}
+	})
+
+	t.Run("GetAll and Count span rooms", func(t *testing.T) {
+		store := newStore(t)
+		store.CreateRoom(models.Room{Name: "team", Owner: "alice"})
+		store.Create("alice", "hello lobby")
+		store.CreateInRoom("team", "alice", "hello team")
+
+		all := store.GetAll()
+		if len(all) != 2 || all[0].Room != "" || all[1].Room != "team" {
+			t.Errorf("Expected the lobby and the team message, got %+v", all)
+		}
+		if count := store.Count(); count != 2 {
+			t.Errorf("Expected Count 2, got %d", count)
+		}
+		page, err := store.List(ListOptions{AllRooms: true, Room: "ignored"})
+		if err != nil || page.Total != 2 || len(page.Messages) != 2 {
+			t.Errorf("Expected List of all rooms to return 2 messages, got %+v, %v", page, err)
+		}
+		if page, _ := store.List(ListOptions{}); page.Total != 1 {
+			t.Errorf("Expected List to default to the lobby, got %d messages", page.Total)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := newStore(t)
+
+		// alice: 1, 3, 5; bob: 2, 4. Timestamps follow the IDs.
+		var marks []time.Time
+		for i, username := range []string{"alice", "bob", "alice", "bob", "alice"} {
+			time.Sleep(2 * time.Millisecond)
+			marks = append(marks, time.Now())
+			store.Create(username, fmt.Sprintf("Message number %d", i+1))
+		}
+
+		ids := func(page Page) []int {
+			var ids []int
+			for _, m := range page.Messages {
+				ids = append(ids, m.ID)
+			}
+			return ids
+		}
+
+		tests := []struct {
+			name    string
+			opts    ListOptions
+			want    []int
+			total   int
+			hasMore bool
+		}{
+			{"all", ListOptions{}, []int{1, 2, 3, 4, 5}, 5, false},
+			{"limit", ListOptions{Limit: 2}, []int{1, 2}, 5, true},
+			{"offset", ListOptions{Offset: 3, Limit: 2}, []int{4, 5}, 5, false},
+			{"descending", ListOptions{Descending: true, Limit: 3}, []int{5, 4, 3}, 5, true},
+			{"by timestamp", ListOptions{SortBy: SortByTimestamp, Descending: true}, []int{5, 4, 3, 2, 1}, 5, false},
+			{"username", ListOptions{Username: "alice"}, []int{1, 3, 5}, 3, false},
+			{"since", ListOptions{Since: marks[3]}, []int{4, 5}, 2, false},
+			{"until", ListOptions{Until: marks[1]}, []int{1}, 1, false},
+			{"contains ignores case", ListOptions{Contains: "NUMBER 2"}, []int{2}, 1, false},
+			{"after id", ListOptions{After: &Position{ID: 2}, Limit: 2}, []int{3, 4}, 5, true},
+			{"after id descending", ListOptions{After: &Position{ID: 4}, Descending: true}, []int{3, 2, 1}, 5, false},
+			{"combined", ListOptions{Username: "alice", Since: marks[1], SortBy: SortByTimestamp, Descending: true, Limit: 1}, []int{5}, 2, true},
+			{"offset past end", ListOptions{Offset: 10}, nil, 5, false},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := store.List(tt.opts)
+				if err != nil {
+					t.Fatalf("List failed: %v", err)
+				}
+				if got := ids(page); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("Expected IDs %v, got %v", tt.want, got)
+				}
+				if page.Total != tt.total || page.HasMore != tt.hasMore {
+					t.Errorf("Expected total %d and more %v, got %d and %v", tt.total, tt.hasMore, page.Total, page.HasMore)
+				}
+			})
+		}
+
+		// Walking the pages by position visits every message once
+		var seen []int
+		opts := ListOptions{SortBy: SortByTimestamp, Descending: true, Limit: 2}
+		for {
+			page, err := store.List(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen = append(seen, ids(page)...)
+			if !page.HasMore {
+				break
+			}
+			last := PositionOf(page.Messages[len(page.Messages)-1])
+			opts.After = &last
+		}
+		if fmt.Sprint(seen) != "[5 4 3 2 1]" {
+			t.Errorf("Expected to walk [5 4 3 2 1], got %v", seen)
+		}
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		store := newStore(t)
+		created, _ := store.Create("alice", "hello")
+		if created.Version != 1 {
+			t.Errorf("Expected version 1, got %d", created.Version)
+		}
+
+		updated, err := store.UpdateIf(created.ID, 1, "edited")
+		if err != nil {
+			t.Fatalf("UpdateIf failed: %v", err)
+		}
+		if updated.Version != 2 {
+			t.Errorf("Expected version 2, got %d", updated.Version)
+		}
+
+		// A client still holding version 1 must not clobber the edit
+		if _, err := store.UpdateIf(created.ID, 1, "stale"); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("Expected ErrVersionConflict, got %v", err)
+		}
+		if err := store.DeleteIf(created.ID, 1); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("Expected ErrVersionConflict, got %v", err)
+		}
+		got, _ := store.GetByID(created.ID)
+		if got.Content != "edited" || got.Version != 2 {
+			t.Errorf("Expected edited version 2, got %q version %d", got.Content, got.Version)
+		}
+
+		if _, err := store.UpdateIf(99, 1, "x"); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if err := store.DeleteIf(created.ID, 2); err != nil {
+			t.Errorf("DeleteIf failed: %v", err)
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		store := newStore(t)
+		created, _ := store.Create("alice", "one")
+		if created.EditedAt != nil {
+			t.Errorf("Expected no edit time, got %v", created.EditedAt)
+		}
+		store.Update(created.ID, "two")
+		updated, _ := store.Update(created.ID, "three")
+		if updated.EditedAt == nil || updated.EditedAt.Before(created.Timestamp) {
+			t.Errorf("Expected an edit time after creation, got %v", updated.EditedAt)
+		}
+
+		revisions, err := store.History(created.ID)
+		if err != nil {
+			t.Fatalf("History failed: %v", err)
+		}
+		var contents []string
+		for _, revision := range revisions {
+			contents = append(contents, fmt.Sprintf("%d:%s", revision.Version, revision.Content))
+		}
+		if fmt.Sprint(contents) != "[1:one 2:two 3:three]" {
+			t.Errorf("Expected [1:one 2:two 3:three], got %v", contents)
+		}
+		if !revisions[0].CreatedAt.Equal(created.Timestamp) || !revisions[2].CreatedAt.Equal(*updated.EditedAt) {
+			t.Errorf("Unexpected revision times %v", revisions)
+		}
+
+		if _, err := store.History(42); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("history is bounded", func(t *testing.T) {
+		store := newStore(t)
+		created, _ := store.Create("alice", "0")
+		for i := 1; i <= MaxRevisions+5; i++ {
+			store.Update(created.ID, fmt.Sprint(i))
+		}
+
+		revisions, _ := store.History(created.ID)
+		if len(revisions) != MaxRevisions+1 {
+			t.Fatalf("Expected %d revisions, got %d", MaxRevisions+1, len(revisions))
+		}
+		if revisions[0].Content != "5" {
+			t.Errorf("Expected the oldest revisions to be dropped, first is %q", revisions[0].Content)
+		}
+	})
+
+	t.Run("soft delete and restore", func(t *testing.T) {
+		store := newStore(t)
+		created, _ := store.Create("alice", "hello")
+		store.Create("bob", "hi")
+		store.Delete(created.ID)
+
+		if _, err := store.GetByID(created.ID); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected deleted message to be hidden, got %v", err)
+		}
+		if _, err := store.Update(created.ID, "x"); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected deleted message to be read-only, got %v", err)
+		}
+		if store.Count() != 1 || len(store.GetAll()) != 1 {
+			t.Errorf("Expected 1 visible message, got %d", store.Count())
+		}
+
+		page, _ := store.List(ListOptions{IncludeDeleted: true})
+		if page.Total != 2 || !page.Messages[0].Deleted || page.Messages[0].DeletedAt == nil {
+			t.Errorf("Expected the deleted message in the listing, got %+v", page.Messages)
+		}
+		if _, err := store.History(created.ID); err != nil {
+			t.Errorf("Expected history of a deleted message, got %v", err)
+		}
+
+		restored, err := store.Restore(created.ID)
+		if err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		if restored.Deleted || restored.DeletedAt != nil || restored.Content != "hello" || restored.Version != 3 {
+			t.Errorf("Unexpected restored message %+v", restored)
+		}
+		if _, err := store.Restore(created.ID); !errors.Is(err, ErrNotDeleted) {
+			t.Errorf("Expected ErrNotDeleted, got %v", err)
+		}
+		if _, err := store.Restore(42); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if store.Count() != 2 {
+			t.Errorf("Expected 2 messages after restore, got %d", store.Count())
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		store := newStore(t)
+		old, _ := store.Create("alice", "old")
+		store.Update(old.ID, "old, edited")
+		store.Delete(old.ID)
+		cutoff := time.Now().Add(time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+		recent, _ := store.Create("bob", "recent")
+		store.Delete(recent.ID)
+		kept, _ := store.Create("carol", "kept")
+
+		n, err := store.Purge(cutoff)
+		if err != nil || n != 1 {
+			t.Fatalf("Expected 1 purged message, got %d, err %v", n, err)
+		}
+		if _, err := store.History(old.ID); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected purged message to be gone, got %v", err)
+		}
+		if _, err := store.Restore(recent.ID); err != nil {
+			t.Errorf("Expected recently deleted message to remain, got %v", err)
+		}
+		if _, err := store.GetByID(kept.ID); err != nil {
+			t.Errorf("Expected live message to remain, got %v", err)
+		}
+		if n, _ := store.Purge(cutoff); n != 0 {
+			t.Errorf("Expected nothing left to purge, got %d", n)
+		}
+
+		// IDs of purged messages are not reused
+		if next, _ := store.Create("dave", "next"); next.ID != 4 {
+			t.Errorf("Expected ID 4, got %d", next.ID)
+		}
+	})
+
+	t.Run("replies", func(t *testing.T) {
+		store := newStore(t)
+		parent, _ := store.Create("alice", "question")
+		store.Create("bob", "unrelated")
+
+		first, err := store.CreateReply(parent.ID, "bob", "answer")
+		if err != nil {
+			t.Fatalf("CreateReply failed: %v", err)
+		}
+		if first.ParentID == nil || *first.ParentID != parent.ID {
+			t.Errorf("Expected parent %d, got %v", parent.ID, first.ParentID)
+		}
+		second, _ := store.CreateReply(parent.ID, "carol", "another answer")
+		store.CreateReply(first.ID, "alice", "thanks")
+
+		got, _ := store.GetByID(parent.ID)
+		if got.ReplyCount != 2 {
+			t.Errorf("Expected 2 replies, got %d", got.ReplyCount)
+		}
+
+		page, err := store.List(ListOptions{ParentID: parent.ID})
+		if err != nil || page.Total != 2 || page.Messages[0].ID != first.ID || page.Messages[0].ReplyCount != 1 {
+			t.Errorf("Unexpected replies %+v, err %v", page, err)
+		}
+
+		// Deleted replies are not counted
+		store.Delete(second.ID)
+		if got, _ := store.GetByID(parent.ID); got.ReplyCount != 1 {
+			t.Errorf("Expected 1 reply after delete, got %d", got.ReplyCount)
+		}
+		store.Restore(second.ID)
+		if got, _ := store.GetByID(parent.ID); got.ReplyCount != 2 {
+			t.Errorf("Expected 2 replies after restore, got %d", got.ReplyCount)
+		}
+
+		if _, err := store.CreateReply(42, "bob", "x"); !errors.Is(err, ErrParentNotFound) {
+			t.Errorf("Expected ErrParentNotFound, got %v", err)
+		}
+		store.Delete(parent.ID)
+		if _, err := store.CreateReply(parent.ID, "bob", "x"); !errors.Is(err, ErrParentNotFound) {
+			t.Errorf("Expected ErrParentNotFound for a deleted parent, got %v", err)
+		}
+	})
+
+	t.Run("reactions", func(t *testing.T) {
+		store := newStore(t)
+		message, _ := store.Create("alice", "hello")
+
+		store.AddReaction(message.ID, "👍", "bob")
+		store.AddReaction(message.ID, "👍", "carol")
+		reacted, err := store.AddReaction(message.ID, "🎉", "bob")
+		if err != nil {
+			t.Fatalf("AddReaction failed: %v", err)
+		}
+		if reacted.Reactions["👍"] != 2 || reacted.Reactions["🎉"] != 1 || reacted.Version != 1 {
+			t.Errorf("Unexpected reactions %v version %d", reacted.Reactions, reacted.Version)
+		}
+
+		if _, err := store.AddReaction(message.ID, "👍", "bob"); !errors.Is(err, ErrReactionExists) {
+			t.Errorf("Expected ErrReactionExists, got %v", err)
+		}
+		if _, err := store.RemoveReaction(message.ID, "🎉", "carol"); !errors.Is(err, ErrReactionNotFound) {
+			t.Errorf("Expected ErrReactionNotFound, got %v", err)
+		}
+		if _, err := store.AddReaction(42, "👍", "bob"); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+
+		removed, err := store.RemoveReaction(message.ID, "🎉", "bob")
+		if err != nil {
+			t.Fatalf("RemoveReaction failed: %v", err)
+		}
+		if _, ok := removed.Reactions["🎉"]; ok || removed.Reactions["👍"] != 2 {
+			t.Errorf("Unexpected reactions %v", removed.Reactions)
+		}
+		if reacted.Reactions["🎉"] != 1 {
+			t.Errorf("Expected earlier copies to keep their counts, got %v", reacted.Reactions)
+		}
+
+		all := store.GetAll()
+		page, _ := store.List(ListOptions{})
+		if all[0].Reactions["👍"] != 2 || page.Messages[0].Reactions["👍"] != 2 {
+			t.Errorf("Expected reactions in listings, got %v and %v", all[0].Reactions, page.Messages[0].Reactions)
+		}
+	})
+
+	t.Run("returns copies", func(t *testing.T) {
+		store := newStore(t)
+		created, _ := store.Create("alice", "hello")
+		created.Content = "changed"
+
+		fetched, _ := store.GetByID(created.ID)
+		fetched.Content = "changed"
+		all := store.GetAll()
+		all[0].Content = "changed"
+
+		store.Update(created.ID, "edited")
+		if fetched.Content != "changed" || fetched.Version != 1 {
+			t.Errorf("Expected update to leave earlier results alone, got %q version %d", fetched.Content, fetched.Version)
+		}
+
+		got, _ := store.GetByID(created.ID)
+		if got.Content != "edited" {
+			t.Errorf("Expected %q, got %q", "edited", got.Content)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		store := newStore(t)
+		sub := store.Subscribe(0)
+		defer sub.Close()
+
+		created, _ := store.Create("alice", "hello")
+		store.Update(created.ID, "edited")
+		store.Delete(created.ID)
+		store.Restore(created.ID)
+
+		want := []struct {
+			typ     EventType
+			content string
+		}{{EventCreated, "hello"}, {EventUpdated, "edited"}, {EventDeleted, "edited"}, {EventRestored, "edited"}}
+		for i, w := range want {
+			select {
+			case event := <-sub.C:
+				if event.ID != uint64(i+1) || event.Type != w.typ {
+					t.Errorf("Expected event %d %s, got %d %s", i+1, w.typ, event.ID, event.Type)
+				}
+				if event.Message.ID != created.ID || event.Message.Content != w.content {
+					t.Errorf("Expected message %d %q, got %d %q", created.ID, w.content, event.Message.ID, event.Message.Content)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected %s event", w.typ)
+			}
+		}
+
+		// Failed writes publish nothing
+		store.Restore(created.ID)
+		select {
+		case event := <-sub.C:
+			t.Errorf("Expected no event, got %s", event.Type)
+		default:
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		store := newStore(t)
+		store.CreateRoom(models.Room{Name: "team", Owner: "alice"})
+		first, _ := store.Create("alice", "hello")
+		sub := store.Subscribe(0)
+		defer sub.Close()
+
+		parentID := 3
+		results, err := store.Batch([]BatchOp{
+			{Op: BatchCreate, Username: "bob", Content: "in the lobby"},
+			{Op: BatchCreate, Room: "team", Username: "bob", Content: "in the team"},
+			{Op: BatchCreate, Room: "team", ParentID: &parentID, Username: "carol", Content: "reply"},
+			{Op: BatchUpdate, ID: first.ID, Version: 2, Content: "stale"},
+			{Op: BatchUpdate, ID: first.ID, Version: 1, Content: "hello, edited"},
+			{Op: BatchDelete, ID: 2},
+			{Op: BatchCreate, Room: "nowhere", Username: "bob", Content: "x"},
+			{Op: BatchCreate, ParentID: &parentID, Username: "bob", Content: "not in team"},
+		}, false)
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+
+		wantErrs := []error{nil, nil, nil, ErrVersionConflict, nil, nil, ErrRoomNotFound, ErrParentNotFound}
+		for i, want := range wantErrs {
+			if !errors.Is(results[i].Err, want) || (want == nil) != (results[i].Message != nil) {
+				t.Errorf("Operation %d: expected error %v, got %+v", i, want, results[i])
+			}
+		}
+		if results[1].Message.Room != "team" || results[2].Message.Room != "team" || *results[2].Message.ParentID != 3 {
+			t.Errorf("Expected the message and its reply in team, got %+v and %+v", results[1].Message, results[2].Message)
+		}
+		if results[4].Message.Version != 2 || !results[5].Message.Deleted {
+			t.Errorf("Unexpected results %+v and %+v", results[4].Message, results[5].Message)
+		}
+
+		if store.Count() != 3 {
+			t.Errorf("Expected 3 messages, got %d", store.Count())
+		}
+		if parent, _ := store.GetByID(3); parent.ReplyCount != 1 {
+			t.Errorf("Expected 1 reply, got %d", parent.ReplyCount)
+		}
+		for _, typ := range []EventType{EventCreated, EventCreated, EventCreated, EventUpdated, EventDeleted} {
+			select {
+			case event := <-sub.C:
+				if event.Type != typ {
+					t.Errorf("Expected %s event, got %s", typ, event.Type)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected %s event", typ)
+			}
+		}
+	})
+
+	t.Run("atomic batch", func(t *testing.T) {
+		store := newStore(t)
+		first, _ := store.Create("alice", "hello")
+		second, _ := store.Create("bob", "hi")
+		store.CreateReply(second.ID, "alice", "welcome")
+		sub := store.Subscribe(0)
+		defer sub.Close()
+
+		results, err := store.Batch([]BatchOp{
+			{Op: BatchCreate, Username: "carol", Content: "imported"},
+			{Op: BatchUpdate, ID: first.ID, Content: "hello, edited"},
+			{Op: BatchDelete, ID: second.ID},
+			{Op: BatchUpdate, ID: 42, Content: "missing"},
+			{Op: BatchCreate, Username: "carol", Content: "never reached"},
+		}, true)
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		for i, result := range results {
+			want := ErrBatchAborted
+			if i == 3 {
+				want = ErrMessageNotFound
+			}
+			if !errors.Is(result.Err, want) || result.Message != nil {
+				t.Errorf("Operation %d: expected %v, got %+v", i, want, result)
+			}
+		}
+
+		// Nothing was applied
+		if store.Count() != 3 {
+			t.Errorf("Expected 3 messages, got %d", store.Count())
+		}
+		if got, _ := store.GetByID(first.ID); got.Content != "hello" || got.Version != 1 {
+			t.Errorf("Expected the update to be undone, got %+v", got)
+		}
+		if revisions, _ := store.History(first.ID); len(revisions) != 1 {
+			t.Errorf("Expected no recorded revision, got %+v", revisions)
+		}
+		if got, err := store.GetByID(second.ID); err != nil || got.ReplyCount != 1 {
+			t.Errorf("Expected the delete to be undone, got %+v, err %v", got, err)
+		}
+		q, _ := ParseQuery("imported OR edited")
+		if page, _ := store.Search("", q, 0, 10); page.Total != 0 {
+			t.Errorf("Expected the index to be unchanged, got %d matches", page.Total)
+		}
+		select {
+		case event := <-sub.C:
+			t.Errorf("Expected no event, got %s", event.Type)
+		default:
+		}
+
+		results, err = store.Batch([]BatchOp{
+			{Op: BatchCreate, Username: "carol", Content: "imported"},
+			{Op: BatchDelete, ID: second.ID, Version: 1},
+		}, true)
+		if err != nil || results[0].Err != nil || results[1].Err != nil {
+			t.Fatalf("Unexpected results %+v, err %v", results, err)
+		}
+		if results[0].Message.ID != 4 {
+			t.Errorf("Expected an aborted batch to leave IDs unused, got %d", results[0].Message.ID)
+		}
+		if store.Count() != 3 {
+			t.Errorf("Expected 3 messages, got %d", store.Count())
+		}
+	})
+
+	t.Run("import", func(t *testing.T) {
+		store := newStore(t)
+		store.CreateRoom(models.Room{Name: "team", Owner: "alice"})
+		written := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		edited := written.Add(time.Hour)
+
+		imported, err := store.Import(models.Message{
+			ID: 10, Username: "alice", Content: "from the archive", Timestamp: written,
+			Version: 3, EditedAt: &edited, Deleted: true, ReplyCount: 5, Reactions: map[string]int{"👍": 1},
+		}, false)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		got, err := store.GetByID(10)
+		if err != nil || got.Content != "from the archive" || !got.Timestamp.Equal(written) || got.Version != 3 ||
+			got.EditedAt == nil || !got.EditedAt.Equal(edited) || got.ReplyCount != 0 || got.Reactions != nil {
+			t.Errorf("Unexpected imported message %+v, err %v", got, err)
+		}
+		if imported.ID != 10 || imported.Deleted {
+			t.Errorf("Unexpected result %+v", imported)
+		}
+
+		if next, _ := store.Create("bob", "after the import"); next.ID != 11 {
+			t.Errorf("Expected the next ID to be 11, got %d", next.ID)
+		}
+		if _, err := store.Import(models.Message{ID: 10, Username: "bob", Content: "again"}, false); !errors.Is(err, ErrIDTaken) {
+			t.Errorf("Expected ErrIDTaken, got %v", err)
+		}
+		remapped, err := store.Import(models.Message{ID: 10, Username: "bob", Content: "again"}, true)
+		if err != nil || remapped.ID != 12 || remapped.Timestamp.IsZero() || remapped.Version != 1 {
+			t.Errorf("Expected a remapped message 12, got %+v, err %v", remapped, err)
+		}
+
+		parentID := 10
+		if _, err := store.Import(models.Message{ParentID: &parentID, Username: "bob", Content: "reply"}, false); err != nil {
+			t.Errorf("Import of a reply failed: %v", err)
+		}
+		if got, _ := store.GetByID(10); got.ReplyCount != 1 {
+			t.Errorf("Expected 1 reply, got %d", got.ReplyCount)
+		}
+		if _, err := store.Import(models.Message{ParentID: &parentID, Room: "team", Username: "bob", Content: "x"}, false); !errors.Is(err, ErrParentNotFound) {
+			t.Errorf("Expected ErrParentNotFound, got %v", err)
+		}
+		if _, err := store.Import(models.Message{Room: "nowhere", Username: "bob", Content: "x"}, false); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("Expected ErrRoomNotFound, got %v", err)
+		}
+		if inRoom, err := store.Import(models.Message{Room: "team", Username: "bob", Content: "x"}, false); err != nil || inRoom.Room != "team" {
+			t.Errorf("Unexpected message %+v, err %v", inRoom, err)
+		}
+
+		q, _ := ParseQuery("archive")
+		if page, _ := store.Search("", q, 0, 10); page.Total != 1 {
+			t.Errorf("Expected the imported message to be searchable, got %d matches", page.Total)
+		}
+	})
+
+	t.Run("concurrent writes", func(t *testing.T) {
+		store := newStore(t)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := store.Create("user", "content"); err != nil {
+					t.Errorf("Concurrent create failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if count := store.Count(); count != 10 {
+			t.Errorf("Expected 10 messages, got %d", count)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		store := newStore(t)
+
+		deploy, _ := store.Create("alice", "Deploy failed on staging, deploy again")
+		store.Create("bob", "The deploy went fine")
+		rollback, _ := store.Create("carol", "Rolling back the release")
+		gone, _ := store.Create("dave", "deploy notes")
+		store.Delete(gone.ID)
+
+		search := func(query string) SearchPage {
+			t.Helper()
+			q, err := ParseQuery(query)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) failed: %v", query, err)
+			}
+			page, err := store.Search("", q, 0, 10)
+			if err != nil {
+				t.Fatalf("Search(%q) failed: %v", query, err)
+			}
+			return page
+		}
+
+		page := search("DEPLOY")
+		if page.Total != 2 || len(page.Results) != 2 {
+			t.Fatalf("Expected 2 results, got %d of %d", len(page.Results), page.Total)
+		}
+		if page.Results[0].Message.ID != deploy.ID {
+			t.Errorf("Expected message %d to rank first, got %d", deploy.ID, page.Results[0].Message.ID)
+		}
+		if page.Results[0].Score <= page.Results[1].Score {
+			t.Errorf("Expected descending scores, got %v", page.Results)
+		}
+		if want := "<mark>Deploy</mark> failed on staging, <mark>deploy</mark> again"; page.Results[0].Snippet != want {
+			t.Errorf("Expected snippet %q, got %q", want, page.Results[0].Snippet)
+		}
+
+		if page := search("deploy staging"); page.Total != 1 {
+			t.Errorf("Expected AND to match 1 message, got %d", page.Total)
+		}
+		if page := search("staging OR roll*"); page.Total != 2 {
+			t.Errorf("Expected OR with prefix to match 2 messages, got %d", page.Total)
+		}
+
+		store.Update(rollback.ID, "Rollback cancelled")
+		if page := search("rolling"); page.Total != 0 {
+			t.Errorf("Expected edited words to be dropped, got %d results", page.Total)
+		}
+		if page := search("cancelled"); page.Total != 1 {
+			t.Errorf("Expected edited words to be found, got %d results", page.Total)
+		}
+
+		store.Restore(gone.ID)
+		if page := search("notes"); page.Total != 1 {
+			t.Errorf("Expected restored message to be found, got %d results", page.Total)
+		}
+		store.Delete(gone.ID)
+		if page := search("notes"); page.Total != 0 {
+			t.Errorf("Expected deleted message to be dropped, got %d results", page.Total)
+		}
+
+		q, _ := ParseQuery("deploy")
+		paged, err := store.Search("", q, 1, 1)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if paged.Total != 2 || len(paged.Results) != 1 || paged.Results[0].Message.ID == deploy.ID {
+			t.Errorf("Expected the second result of 2, got %d of %d", len(paged.Results), paged.Total)
+		}
+	})
+
+	t.Run("rooms", func(t *testing.T) {
+		store := newStore(t)
+
+		room, err := store.CreateRoom(models.Room{Name: "team", Description: "Team chat", Owner: "alice"})
+		if err != nil {
+			t.Fatalf("CreateRoom failed: %v", err)
+		}
+		if room.Owner != "alice" || len(room.Members) != 1 || room.Members[0] != "alice" || room.CreatedAt.IsZero() {
+			t.Errorf("Unexpected room %+v", room)
+		}
+		if _, err := store.CreateRoom(models.Room{Name: "team", Owner: "bob"}); !errors.Is(err, ErrRoomExists) {
+			t.Errorf("Expected ErrRoomExists, got %v", err)
+		}
+		store.CreateRoom(models.Room{Name: "announcements", Owner: "bob", Private: true})
+
+		if room, err = store.AddMember("team", "carol"); err != nil || !room.IsMember("carol") {
+			t.Fatalf("AddMember failed: %+v, %v", room, err)
+		}
+		if _, err := store.AddMember("team", "carol"); !errors.Is(err, ErrAlreadyMember) {
+			t.Errorf("Expected ErrAlreadyMember, got %v", err)
+		}
+		if _, err := store.RemoveMember("team", "dave"); !errors.Is(err, ErrNotMember) {
+			t.Errorf("Expected ErrNotMember, got %v", err)
+		}
+		if _, err := store.AddMember("nowhere", "carol"); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("Expected ErrRoomNotFound, got %v", err)
+		}
+
+		lobby, _ := store.Create("alice", "hello lobby")
+		first, err := store.CreateInRoom("team", "alice", "hello team")
+		if err != nil || first.Room != "team" {
+			t.Fatalf("CreateInRoom failed: %+v, %v", first, err)
+		}
+		reply, _ := store.CreateReply(first.ID, "carol", "hello alice")
+		if reply.Room != "team" {
+			t.Errorf("Expected reply in room team, got %q", reply.Room)
+		}
+		if _, err := store.CreateInRoom("nowhere", "alice", "x"); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("Expected ErrRoomNotFound, got %v", err)
+		}
+		if room, _ := store.RoomOf(reply.ID); room != "team" {
+			t.Errorf("Expected RoomOf %q, got %q", "team", room)
+		}
+		if _, err := store.RoomOf(99); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+
+		page, _ := store.List(ListOptions{})
+		if page.Total != 1 || page.Messages[0].ID != lobby.ID {
+			t.Errorf("Expected the lobby to hold message %d only, got %d messages", lobby.ID, page.Total)
+		}
+		page, _ = store.List(ListOptions{Room: "team"})
+		if page.Total != 2 || page.Messages[0].ID != first.ID {
+			t.Errorf("Expected 2 messages in room team, got %d", page.Total)
+		}
+		q, _ := ParseQuery("hello")
+		if results, _ := store.Search("team", q, 0, 10); results.Total != 2 {
+			t.Errorf("Expected 2 search results in room team, got %d", results.Total)
+		}
+
+		store.Delete(reply.ID)
+		room, _ = store.UpdateRoom("team", "Renamed", true)
+		if room.Description != "Renamed" || !room.Private || room.MessageCount != 1 || len(room.Members) != 2 {
+			t.Errorf("Unexpected updated room %+v", room)
+		}
+		if _, err := store.UpdateRoom("nowhere", "", false); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("Expected ErrRoomNotFound, got %v", err)
+		}
+
+		rooms, err := store.ListRooms()
+		if err != nil || len(rooms) != 2 || rooms[0].Name != "announcements" || rooms[1].Name != "team" {
+			t.Fatalf("Expected rooms announcements and team, got %v, %v", rooms, err)
+		}
+
+		if err := store.DeleteRoom("team"); err != nil {
+			t.Fatalf("DeleteRoom failed: %v", err)
+		}
+		if _, err := store.GetRoom("team"); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("Expected ErrRoomNotFound, got %v", err)
+		}
+		if _, err := store.History(reply.ID); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected messages of the room to be removed, got %v", err)
+		}
+		if results, _ := store.Search("team", q, 0, 10); results.Total != 0 {
+			t.Errorf("Expected no search results, got %d", results.Total)
+		}
+		if count := store.Count(); count != 1 {
+			t.Errorf("Expected 1 message left, got %d", count)
+		}
+		if err := store.DeleteRoom("team"); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("Expected ErrRoomNotFound, got %v", err)
+		}
+	})
+
+	// Run with -race to check the index is updated under the store locks
+	t.Run("concurrent search", func(t *testing.T) {
+		store := newStore(t)
+		q, _ := ParseQuery("needle")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					message, err := store.Create("user", "haystack")
+					if err != nil {
+						t.Errorf("Create failed: %v", err)
+						return
+					}
+					store.Update(message.ID, "needle in a haystack")
+					if j%2 == 0 {
+						store.Delete(message.ID)
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					page, err := store.Search("", q, 0, 100)
+					if err != nil {
+						t.Errorf("Search failed: %v", err)
+						return
+					}
+					for _, result := range page.Results {
+						if result.Message.Content != "needle in a haystack" || result.Message.Deleted {
+							t.Errorf("Unexpected result %+v", result.Message)
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		page, err := store.Search("", q, 0, 100)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if page.Total != 25 || len(page.Results) != 25 {
+			t.Errorf("Expected 25 results, got %d of %d", len(page.Results), page.Total)
+		}
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	testMessageStore(t, func(t *testing.T) MessageStore { return NewMemoryStorage() })
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	testMessageStore(t, func(t *testing.T) MessageStore {
+		store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "messages.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestFileStorageConformance(t *testing.T) {
+	testMessageStore(t, func(t *testing.T) MessageStore {
+		store, err := NewFileStorage(filepath.Join(t.TempDir(), "messages.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
@@ -3,13 +3,26 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"os"
 	"time"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
 // Config holds database configuration
 type Config struct {
+	// Driver is DriverSQLite (the default) or DriverPostgres
+	Driver string
+	// DSN is passed to the driver. For SQLite it defaults to DatabasePath
+	// with foreign keys enabled.
+	DSN             string
 	DatabasePath    string
 	MaxOpenConns    int
 	MaxIdleConns    int
@@ -20,6 +33,7 @@ type Config struct {
 // DefaultConfig returns a default database configuration
 func DefaultConfig() *Config {
 	return &Config{
+		Driver:          DriverSQLite,
 		DatabasePath:    "./lab04.db",
 		MaxOpenConns:    25,
 		MaxIdleConns:    5,
@@ -28,31 +42,82 @@ func DefaultConfig() *Config {
 	}
 }
 
-// TODO: Implement InitDB function
+// ConfigFromEnv returns the default configuration with the driver and DSN
+// taken from DATABASE_DRIVER and DATABASE_URL, if set. A DATABASE_URL
+// without a driver selects Postgres, as in docker-compose.
+func ConfigFromEnv() *Config {
+	config := DefaultConfig()
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		config.Driver = DriverPostgres
+		config.DSN = dsn
+	}
+	if driver := os.Getenv("DATABASE_DRIVER"); driver != "" {
+		config.Driver = driver
+	}
+	return config
+}
+
+// InitDB opens the database of DefaultConfig
 func InitDB() (*sql.DB, error) {
-	// TODO: Initialize database connection with SQLite
-	// - Open database connection using sqlite3 driver
-	// - Apply connection pool configuration from DefaultConfig()
-	// - Test connection with Ping()
-	// - Return the database connection or error
-	return nil, fmt.Errorf("TODO: implement InitDB function")
+	return InitDBWithConfig(DefaultConfig())
 }
 
-// TODO: Implement InitDBWithConfig function
+// InitDBWithConfig opens a database, applies the connection pool settings
+// and checks the connection
 func InitDBWithConfig(config *Config) (*sql.DB, error) {
-	// TODO: Initialize database connection with custom configuration
-	// - Open database connection using the provided config
-	// - Apply all connection pool settings
-	// - Test connection with Ping()
-	// - Return the database connection or error
-	return nil, fmt.Errorf("TODO: implement InitDBWithConfig function")
+	if config == nil {
+		return nil, fmt.Errorf("database config cannot be nil")
+	}
+	driver, dsn, err := config.source()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	return db, nil
+}
+
+// source returns the driver and DSN to open
+func (c *Config) source() (string, string, error) {
+	driver := c.Driver
+	if driver == "" {
+		driver = DriverSQLite
+	}
+	switch driver {
+	case DriverSQLite:
+		if c.DSN != "" {
+			return driver, c.DSN, nil
+		}
+		if c.DatabasePath == "" {
+			return "", "", fmt.Errorf("database path cannot be empty")
+		}
+		return driver, "file:" + c.DatabasePath + "?_foreign_keys=on", nil
+	case DriverPostgres:
+		if c.DSN == "" {
+			return "", "", fmt.Errorf("postgres requires a DSN")
+		}
+		return driver, c.DSN, nil
+	default:
+		return "", "", fmt.Errorf("unsupported database driver %q", driver)
+	}
 }
 
-// TODO: Implement CloseDB function
+// CloseDB closes a database connection
 func CloseDB(db *sql.DB) error {
-	// TODO: Properly close database connection
-	// - Check if db is not nil
-	// - Close the database connection
-	// - Return any error that occurs
-	return fmt.Errorf("TODO: implement CloseDB function")
+	if db == nil {
+		return fmt.Errorf("database connection cannot be nil")
+	}
+	return db.Close()
 }
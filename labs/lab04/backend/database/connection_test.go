@@ -1,7 +1,10 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -36,7 +39,7 @@ func TestDefaultConfig(t *testing.T) {
 
 func TestInitDB(t *testing.T) {
 	// Clean up test database before and after
-	testDB := "./test_init.db"
+	testDB := DefaultConfig().DatabasePath
 	defer os.Remove(testDB)
 	os.Remove(testDB)
 
@@ -158,6 +161,100 @@ func TestMigrate(t *testing.T) {
 	}
 }
 
+func TestMigrationsKeepPosts(t *testing.T) {
+	t.Run(DriverSQLite, func(t *testing.T) {
+		db, err := InitDBWithConfig(&Config{DatabasePath: filepath.Join(t.TempDir(), "test.db"), MaxOpenConns: 5})
+		if err != nil {
+			t.Fatalf("InitDBWithConfig() failed: %v", err)
+		}
+		defer CloseDB(db)
+		testMigrationsKeepPosts(t, db)
+	})
+	t.Run(DriverPostgres, func(t *testing.T) {
+		dsn := os.Getenv("LAB04_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("LAB04_POSTGRES_DSN is not set, skipping Postgres tests")
+		}
+		// A single connection keeps the search path of the test schema
+		db, err := InitDBWithConfig(&Config{Driver: DriverPostgres, DSN: dsn, MaxOpenConns: 1, MaxIdleConns: 1})
+		if err != nil {
+			t.Fatalf("InitDBWithConfig() failed: %v", err)
+		}
+		defer CloseDB(db)
+		schema := fmt.Sprintf("lab04_migrations_%d", time.Now().UnixNano())
+		if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+		defer db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		if _, err := db.Exec(`SET search_path TO ` + schema); err != nil {
+			t.Fatalf("Failed to set search path: %v", err)
+		}
+		testMigrationsKeepPosts(t, db)
+	})
+}
+
+func testMigrationsKeepPosts(t *testing.T, db *sql.DB) {
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("RunMigrations() failed: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO users (id, name, email) VALUES (1, 'Test User', 'test@example.com')`); err != nil {
+		t.Fatalf("Cannot insert into users table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO posts (user_id, title) VALUES (1, 'Test Post')`); err != nil {
+		t.Fatalf("Cannot insert into posts table: %v", err)
+	}
+
+	// Migrations rebuilding or altering the users table must neither
+	// cascade to posts nor lose their foreign key, in either direction
+	for _, migrate := range []func(*sql.DB) error{RollbackMigration, RollbackMigration, RunMigrations} {
+		if err := migrate(db); err != nil {
+			t.Fatalf("Migration failed: %v", err)
+		}
+		var posts int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM posts`).Scan(&posts); err != nil || posts != 1 {
+			t.Errorf("posts = %d, %v, want 1 after migrating", posts, err)
+		}
+		if _, err := db.Exec(`INSERT INTO posts (user_id, title) VALUES (99999, 'Orphan')`); err == nil {
+			t.Error("posts should still reference users after migrating")
+		}
+	}
+
+	// Only users that are not deleted have unique emails
+	if _, err := db.Exec(`INSERT INTO users (id, name, email) VALUES (2, 'Second User', 'test@example.com')`); err == nil {
+		t.Error("emails of users should be unique")
+	}
+	if _, err := db.Exec(`UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = 1`); err != nil {
+		t.Fatalf("Cannot delete user: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (id, name, email) VALUES (2, 'Second User', 'test@example.com')`); err != nil {
+		t.Errorf("Cannot reuse the email of a deleted user: %v", err)
+	}
+}
+
+func TestOpenGORM(t *testing.T) {
+	db, err := InitDBWithConfig(&Config{DatabasePath: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("InitDBWithConfig() failed: %v", err)
+	}
+	defer CloseDB(db)
+
+	gormDB, err := OpenGORM(db)
+	if err != nil {
+		t.Fatalf("OpenGORM() failed: %v", err)
+	}
+	if name := gormDB.Dialector.Name(); name != "sqlite" {
+		t.Errorf("OpenGORM() dialector = %q, want sqlite", name)
+	}
+	if pool, _ := gormDB.DB(); pool != db {
+		t.Error("OpenGORM() should use the connection pool of db")
+	}
+
+	if _, err := OpenGORM(nil); err == nil {
+		t.Error("OpenGORM(nil) should return an error")
+	}
+}
+
 func TestCloseDB(t *testing.T) {
 	// Test closing nil database
 	err := CloseDB(nil)
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect captures the SQL differences between the supported drivers.
+// Repositories write queries with ? placeholders and Rebind them.
+type Dialect string
+
+// Supported dialects, named after their drivers
+const (
+	SQLite   Dialect = DriverSQLite
+	Postgres Dialect = DriverPostgres
+)
+
+// DialectOf returns the dialect of an open database. Unknown drivers are
+// treated as SQLite.
+func DialectOf(db *sql.DB) Dialect {
+	switch db.Driver().(type) {
+	case *pq.Driver:
+		return Postgres
+	case *sqlite3.SQLiteDriver:
+		return SQLite
+	default:
+		return SQLite
+	}
+}
+
+// Placeholder returns the placeholder of the nth argument, from 1
+func (d Dialect) Placeholder(n int) string {
+	if d == Postgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// Rebind replaces the ? placeholders of query with the ones of the dialect.
+// Question marks in quoted literals are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// PlaceholderFormat returns the placeholder format for squirrel builders
+func (d Dialect) PlaceholderFormat() squirrel.PlaceholderFormat {
+	if d == Postgres {
+		return squirrel.Dollar
+	}
+	return squirrel.Question
+}
+
+// CaseInsensitiveLike returns the LIKE operator that ignores case. SQLite's
+// LIKE already does for ASCII.
+func (d Dialect) CaseInsensitiveLike() string {
+	if d == Postgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
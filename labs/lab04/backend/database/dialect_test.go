@@ -0,0 +1,75 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDialectRebind(t *testing.T) {
+	query := `SELECT * FROM users WHERE name = ? AND email <> '?' AND id IN (?, ?)`
+
+	if got := SQLite.Rebind(query); got != query {
+		t.Errorf("SQLite.Rebind() = %q, want the query unchanged", got)
+	}
+	want := `SELECT * FROM users WHERE name = $1 AND email <> '?' AND id IN ($2, $3)`
+	if got := Postgres.Rebind(query); got != want {
+		t.Errorf("Postgres.Rebind() = %q, want %q", got, want)
+	}
+}
+
+func TestDialectOf(t *testing.T) {
+	db, err := InitDBWithConfig(&Config{DatabasePath: filepath.Join(t.TempDir(), "dialect.db"), MaxOpenConns: 1})
+	if err != nil {
+		t.Fatalf("InitDBWithConfig() failed: %v", err)
+	}
+	defer CloseDB(db)
+
+	if got := DialectOf(db); got != SQLite {
+		t.Errorf("DialectOf() = %v, want %v", got, SQLite)
+	}
+
+	dsn := os.Getenv("LAB04_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LAB04_POSTGRES_DSN is not set, skipping Postgres dialect test")
+	}
+	pg, err := InitDBWithConfig(&Config{Driver: DriverPostgres, DSN: dsn, MaxOpenConns: 1})
+	if err != nil {
+		t.Fatalf("InitDBWithConfig() failed: %v", err)
+	}
+	defer CloseDB(pg)
+
+	if got := DialectOf(pg); got != Postgres {
+		t.Errorf("DialectOf() = %v, want %v", got, Postgres)
+	}
+}
+
+func TestInitDBWithConfigDrivers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"unknown driver", Config{Driver: "mysql", DSN: "user@/db"}},
+		{"postgres without DSN", Config{Driver: DriverPostgres}},
+		{"sqlite without path", Config{Driver: DriverSQLite}},
+	}
+	for _, tt := range tests {
+		if db, err := InitDBWithConfig(&tt.config); err == nil {
+			db.Close()
+			t.Errorf("%s: InitDBWithConfig() should fail", tt.name)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("DATABASE_DRIVER", "")
+	t.Setenv("DATABASE_URL", "")
+	if config := ConfigFromEnv(); config.Driver != DriverSQLite || config.DatabasePath == "" {
+		t.Errorf("ConfigFromEnv() = %+v, want the SQLite default", config)
+	}
+
+	t.Setenv("DATABASE_URL", "postgres://localhost/lab04")
+	if config := ConfigFromEnv(); config.Driver != DriverPostgres || config.DSN != "postgres://localhost/lab04" {
+		t.Errorf("ConfigFromEnv() = %+v, want Postgres", config)
+	}
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// OpenGORM returns a GORM database running on the connection pool of db,
+// so the GORM and sql.DB based repositories share the database and its
+// dialect
+func OpenGORM(db *sql.DB) (*gorm.DB, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection cannot be nil")
+	}
+
+	var dialector gorm.Dialector
+	switch DialectOf(db) {
+	case Postgres:
+		dialector = postgres.New(postgres.Config{Conn: db})
+	default:
+		dialector = &sqlite.Dialector{Conn: db}
+	}
+	return gorm.Open(dialector, &gorm.Config{})
+}
@@ -3,46 +3,103 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pressly/goose/v3"
 )
 
+// MigrationsDir holds a folder of migrations per dialect, named after the
+// dialect. The default is relative to the package directories, where tests
+// run.
+var MigrationsDir = "../migrations"
+
+// migrationTemplate is the content of new migrations
+const migrationTemplate = `-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
+`
+
 // RunMigrations runs database migrations using goose
 func RunMigrations(db *sql.DB) error {
-	if db == nil {
-		return fmt.Errorf("database connection cannot be nil")
+	dir, err := migrationsFor(db)
+	if err != nil {
+		return err
 	}
 
-	// Set goose dialect for SQLite
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		return fmt.Errorf("failed to set goose dialect: %v", err)
-	}
-
-	// Get path to migrations directory (relative to backend directory)
-	migrationsDir := "../migrations"
-
-	// Run migrations from the migrations directory
-	if err := goose.Up(db, migrationsDir); err != nil {
+	if err := goose.Up(db, dir); err != nil {
 		return fmt.Errorf("failed to run migrations: %v", err)
 	}
 
 	return nil
 }
 
-// TODO: Implement this function
 // RollbackMigration rolls back the last migration using goose
 func RollbackMigration(db *sql.DB) error {
+	dir, err := migrationsFor(db)
+	if err != nil {
+		return err
+	}
+
+	if err := goose.Down(db, dir); err != nil {
+		return fmt.Errorf("failed to roll back migration: %v", err)
+	}
+
 	return nil
 }
 
-// TODO: Implement this function
 // GetMigrationStatus checks migration status using goose
 func GetMigrationStatus(db *sql.DB) error {
+	dir, err := migrationsFor(db)
+	if err != nil {
+		return err
+	}
+
+	if err := goose.Status(db, dir); err != nil {
+		return fmt.Errorf("failed to get migration status: %v", err)
+	}
+
 	return nil
 }
 
-// TODO: Implement this function
-// CreateMigration creates a new migration file
+// CreateMigration creates a new migration file in the folder of every
+// dialect, with the same version so they are applied in the same order
 func CreateMigration(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("migration name cannot be empty")
+	}
+	version := time.Now().UTC().Format("20060102150405")
+	filename := version + "_" + strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_")) + ".sql"
+
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		path := filepath.Join(MigrationsDir, string(dialect), filename)
+		if err := os.WriteFile(path, []byte(migrationTemplate), 0o644); err != nil {
+			return fmt.Errorf("failed to create migration: %v", err)
+		}
+	}
+
 	return nil
 }
+
+// migrationsFor sets the goose dialect of db and returns its migrations
+// folder
+func migrationsFor(db *sql.DB) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database connection cannot be nil")
+	}
+
+	dialect := DialectOf(db)
+	if err := goose.SetDialect(string(dialect)); err != nil {
+		return "", fmt.Errorf("failed to set goose dialect: %v", err)
+	}
+
+	return filepath.Join(MigrationsDir, string(dialect)), nil
+}
@@ -6,19 +6,18 @@ import (
 
 	"lab04-backend/database"
 	"lab04-backend/repository"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
-	// TODO: Initialize database connection
-	db, err := database.InitDB()
+	// SQLite by default, Postgres when DATABASE_URL is set
+	db, err := database.InitDBWithConfig(database.ConfigFromEnv())
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	// TODO: Run migrations (using goose-based approach)
+	// Run migrations from the backend directory
+	database.MigrationsDir = "./migrations"
 	if err := database.RunMigrations(db); err != nil {
 		log.Fatal("Failed to run migrations:", err)
 	}
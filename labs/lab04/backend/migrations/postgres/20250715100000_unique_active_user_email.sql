@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Let soft deleted users keep their email while it is registered again
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+
+-- Create unique index over the emails of users that are not deleted
+CREATE UNIQUE INDEX idx_users_email_active ON users(email) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Restore the UNIQUE constraint, which fails while a deleted user shares
+-- the email of another user
+DROP INDEX IF EXISTS idx_users_email_active;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+-- +goose StatementEnd
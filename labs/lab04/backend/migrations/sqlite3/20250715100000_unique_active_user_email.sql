@@ -0,0 +1,62 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- +goose StatementBegin
+-- Let soft deleted users keep their email while it is registered again.
+-- SQLite cannot drop the UNIQUE column constraint, so the table is rebuilt
+-- with foreign keys off to keep the posts of users. Both pragmas only apply
+-- outside a transaction and to the connection running them, hence a single
+-- statement without a goose transaction.
+PRAGMA foreign_keys = OFF;
+BEGIN;
+
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME NULL
+);
+INSERT INTO users_new (id, name, email, password_hash, created_at, updated_at, deleted_at)
+    SELECT id, name, email, password_hash, created_at, updated_at, deleted_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX idx_users_email ON users(email);
+CREATE INDEX idx_users_deleted_at ON users(deleted_at);
+
+-- Create unique index over the emails of users that are not deleted
+CREATE UNIQUE INDEX idx_users_email_active ON users(email) WHERE deleted_at IS NULL;
+
+COMMIT;
+PRAGMA foreign_keys = ON;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Restore the UNIQUE constraint, which fails while a deleted user shares
+-- the email of another user
+PRAGMA foreign_keys = OFF;
+BEGIN;
+
+CREATE TABLE users_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    password_hash VARCHAR(255),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME NULL
+);
+INSERT INTO users_old (id, name, email, password_hash, created_at, updated_at, deleted_at)
+    SELECT id, name, email, password_hash, created_at, updated_at, deleted_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX idx_users_email ON users(email);
+CREATE INDEX idx_users_deleted_at ON users(deleted_at);
+
+COMMIT;
+PRAGMA foreign_keys = ON;
+-- +goose StatementEnd
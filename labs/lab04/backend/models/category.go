@@ -118,10 +118,13 @@ func (c *Category) IsActive() bool {
 	return c.Active
 }
 
+// PostCount returns the number of posts in the category that are not
+// deleted
 func (c *Category) PostCount(db *gorm.DB) (int64, error) {
-	// TODO: Get post count for this category using GORM association
-	// var count int64
-	// err := db.Model(c).Association("Posts").Count(&count)
-	// return count, err
-	return 0, nil
+	association := db.Model(c).Where("posts.deleted_at IS NULL").Association("Posts")
+	if association.Error != nil {
+		return 0, association.Error
+	}
+	count := association.Count()
+	return count, association.Error
 }
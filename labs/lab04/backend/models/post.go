@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 )
 
@@ -14,6 +16,8 @@ type Post struct {
 	Published bool      `json:"published" db:"published"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// DeletedAt is set while the post is soft deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
 // CreatePostRequest represents the payload for creating a post
@@ -31,43 +35,78 @@ type UpdatePostRequest struct {
 	Published *bool   `json:"published,omitempty"`
 }
 
-// TODO: Implement Validate method for Post
+// Validate checks the title, author and content of a post
 func (p *Post) Validate() error {
-	// TODO: Add validation logic
-	// - Title should not be empty and should be at least 5 characters
-	// - Content should not be empty if published is true
-	// - UserID should be greater than 0
-	// Return appropriate errors if validation fails
-	return nil
+	return validatePost(p.UserID, p.Title, p.Content, p.Published)
 }
 
-// TODO: Implement Validate method for CreatePostRequest
+// Validate checks the title, author and content of a new post
 func (req *CreatePostRequest) Validate() error {
-	// TODO: Add validation logic
-	// - Title should not be empty and should be at least 5 characters
-	// - UserID should be greater than 0
-	// - Content should not be empty if published is true
-	// Return appropriate errors if validation fails
+	return validatePost(req.UserID, req.Title, req.Content, req.Published)
+}
+
+// Validate checks the fields that are set. Whether a published post has
+// content depends on the stored post, see Post.Validate.
+func (req *UpdatePostRequest) Validate() error {
+	if req.Title != nil {
+		return validateTitle(*req.Title)
+	}
 	return nil
 }
 
-// TODO: Implement ToPost method for CreatePostRequest
+// ToPost converts the request to a post created now
 func (req *CreatePostRequest) ToPost() *Post {
-	// TODO: Convert CreatePostRequest to Post
-	// Set timestamps to current time
-	return nil
+	now := time.Now()
+	return &Post{
+		UserID:    req.UserID,
+		Title:     req.Title,
+		Content:   req.Content,
+		Published: req.Published,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
 }
 
-// TODO: Implement ScanRow method for Post
+// ScanRow scans a row of the columns id, user_id, title, content,
+// published, created_at, updated_at and deleted_at
 func (p *Post) ScanRow(row *sql.Row) error {
-	// TODO: Scan database row into Post struct
-	// Handle the case where row might be nil
-	return nil
+	if row == nil {
+		return errors.New("row cannot be nil")
+	}
+	return row.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.Published, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt)
 }
 
-// TODO: Implement ScanRows method for Post slice
+// ScanPosts scans and closes rows of the columns read by ScanRow
 func ScanPosts(rows *sql.Rows) ([]Post, error) {
-	// TODO: Scan multiple database rows into Post slice
-	// Make sure to close rows and handle errors properly
-	return nil, nil
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.Published, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func validatePost(userID int, title, content string, published bool) error {
+	if userID <= 0 {
+		return errors.New("user ID must be positive")
+	}
+	if err := validateTitle(title); err != nil {
+		return err
+	}
+	if published && strings.TrimSpace(content) == "" {
+		return errors.New("published posts must have content")
+	}
+	return nil
+}
+
+func validateTitle(title string) error {
+	if len(strings.TrimSpace(title)) < 5 {
+		return errors.New("title must be at least 5 characters")
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -143,3 +144,25 @@ func TestCreateUserRequest_ToUser(t *testing.T) {
 		t.Error("ToUser() UpdatedAt should be recent")
 	}
 }
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{name: "valid password", password: "correct horse", wantErr: false},
+		{name: "too short", password: "short", wantErr: true},
+		{name: "minimum length", password: strings.Repeat("a", MinPasswordLength), wantErr: false},
+		{name: "too long for bcrypt", password: strings.Repeat("a", MaxPasswordLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
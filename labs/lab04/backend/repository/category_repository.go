@@ -1,7 +1,7 @@
 package repository
 
 import (
-	"fmt"
+	"context"
 
 	"lab04-backend/models"
 
@@ -11,157 +11,124 @@ import (
 // CategoryRepository handles database operations for categories using GORM
 // This repository demonstrates GORM ORM approach for database operations
 type CategoryRepository struct {
-	db *gorm.DB
+	db    *gorm.DB
+	retry RetryPolicy
 }
 
-// NewCategoryRepository creates a new CategoryRepository with GORM
+// NewCategoryRepository creates a new CategoryRepository with GORM and
+// DefaultRetryPolicy
 func NewCategoryRepository(gormDB *gorm.DB) *CategoryRepository {
-	return &CategoryRepository{db: gormDB}
+	return &CategoryRepository{db: gormDB, retry: DefaultRetryPolicy}
 }
 
-// TODO: Implement Create method using GORM
-func (r *CategoryRepository) Create(category *models.Category) error {
-	// TODO: Create a new category using GORM
-	// - Use GORM's Create method: r.db.Create(category)
-	// - GORM automatically handles ID generation and timestamps
-	// - No need for manual SQL or RETURNING clauses
-	// Example: result := r.db.Create(category)
-	// return result.Error
-	//
-	// Notice how much simpler this is compared to manual SQL!
-	return fmt.Errorf("TODO: implement Create method with GORM")
+// run calls fn with the database bound to ctx and the retry policy
+func (r *CategoryRepository) run(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return r.retry.Do(ctx, func() error { return fn(r.db.WithContext(ctx)) })
 }
 
-// TODO: Implement GetByID method using GORM
-func (r *CategoryRepository) GetByID(id uint) (*models.Category, error) {
-	// TODO: Get category by ID using GORM
-	// - Use GORM's First method: r.db.First(&category, id)
-	// - GORM automatically generates the WHERE clause
-	// - Returns gorm.ErrRecordNotFound if not found
-	// Example:
-	// var category models.Category
-	// result := r.db.First(&category, id)
-	// return &category, result.Error
-	//
-	// Much cleaner than manual row scanning!
-	return nil, fmt.Errorf("TODO: implement GetByID method with GORM")
+// Create inserts a category, GORM sets its ID and timestamps
+func (r *CategoryRepository) Create(ctx context.Context, category *models.Category) error {
+	return r.run(ctx, func(db *gorm.DB) error {
+		return db.Create(category).Error
+	})
 }
 
-// TODO: Implement GetAll method using GORM
-func (r *CategoryRepository) GetAll() ([]models.Category, error) {
-	// TODO: Get all categories using GORM
-	// - Use GORM's Find method: r.db.Find(&categories)
-	// - GORM automatically handles the slice allocation
-	// - Can add Order() for sorting: r.db.Order("name").Find(&categories)
-	// Example:
-	// var categories []models.Category
-	// result := r.db.Order("name").Find(&categories)
-	// return categories, result.Error
-	return nil, fmt.Errorf("TODO: implement GetAll method with GORM")
+// GetByID returns gorm.ErrRecordNotFound if the category does not exist
+func (r *CategoryRepository) GetByID(ctx context.Context, id uint) (*models.Category, error) {
+	var category models.Category
+	err := r.run(ctx, func(db *gorm.DB) error {
+		return db.First(&category, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
 }
 
-// TODO: Implement Update method using GORM
-func (r *CategoryRepository) Update(category *models.Category) error {
-	// TODO: Update category using GORM
-	// - Use GORM's Save method: r.db.Save(category)
-	// - GORM automatically updates only changed fields
-	// - Handles updated_at timestamp automatically
-	// - Alternative: r.db.Model(category).Updates(updates)
-	//
-	// Example:
-	// result := r.db.Save(category)
-	// return result.Error
-	return fmt.Errorf("TODO: implement Update method with GORM")
+// GetAll returns all categories ordered by name
+func (r *CategoryRepository) GetAll(ctx context.Context) ([]models.Category, error) {
+	var categories []models.Category
+	err := r.run(ctx, func(db *gorm.DB) error {
+		return db.Order("name").Find(&categories).Error
+	})
+	return categories, err
 }
 
-// TODO: Implement Delete method using GORM
-func (r *CategoryRepository) Delete(id uint) error {
-	// TODO: Delete category using GORM
-	// - Use GORM's Delete method: r.db.Delete(&models.Category{}, id)
-	// - GORM can do soft delete if model has DeletedAt field
-	// - For hard delete: r.db.Unscoped().Delete(&models.Category{}, id)
-	//
-	// Example:
-	// result := r.db.Delete(&models.Category{}, id)
-	// return result.Error
-	return fmt.Errorf("TODO: implement Delete method with GORM")
+// Update saves all fields of a category
+func (r *CategoryRepository) Update(ctx context.Context, category *models.Category) error {
+	return r.run(ctx, func(db *gorm.DB) error {
+		return db.Save(category).Error
+	})
 }
 
-// TODO: Implement FindByName method using GORM
-func (r *CategoryRepository) FindByName(name string) (*models.Category, error) {
-	// TODO: Find category by name using GORM
-	// - Use GORM's Where method: r.db.Where("name = ?", name).First(&category)
-	// - GORM handles SQL injection protection automatically
-	// - Can use struct for conditions: r.db.Where(&models.Category{Name: name}).First(&category)
-	//
-	// Example:
-	// var category models.Category
-	// result := r.db.Where("name = ?", name).First(&category)
-	// return &category, result.Error
-	return nil, fmt.Errorf("TODO: implement FindByName method with GORM")
+// Delete soft deletes a category, or returns gorm.ErrRecordNotFound if it
+// does not exist
+func (r *CategoryRepository) Delete(ctx context.Context, id uint) error {
+	return r.run(ctx, func(db *gorm.DB) error {
+		result := db.Delete(&models.Category{}, id)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
 }
 
-// TODO: Implement SearchCategories method using GORM
-func (r *CategoryRepository) SearchCategories(query string, limit int) ([]models.Category, error) {
-	// TODO: Search categories using GORM
-	// - Use GORM's Where with LIKE: r.db.Where("name LIKE ?", "%"+query+"%")
-	// - Add Limit: .Limit(limit)
-	// - Add Order: .Order("name")
-	//
-	// Example:
-	// var categories []models.Category
-	// result := r.db.Where("name LIKE ?", "%"+query+"%").
-	//               Order("name").
-	//               Limit(limit).
-	//               Find(&categories)
-	// return categories, result.Error
-	return nil, fmt.Errorf("TODO: implement SearchCategories method with GORM")
+// FindByName returns gorm.ErrRecordNotFound if no category has the name
+func (r *CategoryRepository) FindByName(ctx context.Context, name string) (*models.Category, error) {
+	var category models.Category
+	err := r.run(ctx, func(db *gorm.DB) error {
+		return db.Where("name = ?", name).First(&category).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
 }
 
-// TODO: Implement GetCategoriesWithPosts method using GORM associations
-func (r *CategoryRepository) GetCategoriesWithPosts() ([]models.Category, error) {
-	// TODO: Get categories with associated posts using GORM
-	// - Use GORM's Preload to load associations: r.db.Preload("Posts").Find(&categories)
-	// - GORM automatically handles the JOINs and relationships
-	// - Much simpler than manual JOIN queries!
-	//
-	// Example:
-	// var categories []models.Category
-	// result := r.db.Preload("Posts").Find(&categories)
-	// return categories, result.Error
-	//
-	// This assumes Category model has Posts relationship defined
-	return nil, fmt.Errorf("TODO: implement GetCategoriesWithPosts method with GORM Preload")
+// SearchCategories returns up to limit categories whose name contains
+// query, ordered by name
+func (r *CategoryRepository) SearchCategories(ctx context.Context, query string, limit int) ([]models.Category, error) {
+	var categories []models.Category
+	err := r.run(ctx, func(db *gorm.DB) error {
+		return db.Where("name LIKE ?", "%"+query+"%").
+			Order("name").
+			Limit(limit).
+			Find(&categories).Error
+	})
+	return categories, err
 }
 
-// TODO: Implement Count method using GORM
-func (r *CategoryRepository) Count() (int64, error) {
-	// TODO: Count categories using GORM
-	// - Use GORM's Count method: r.db.Model(&models.Category{}).Count(&count)
-	// - GORM returns int64 for count operations
-	//
-	// Example:
-	// var count int64
-	// result := r.db.Model(&models.Category{}).Count(&count)
-	// return count, result.Error
-	return 0, fmt.Errorf("TODO: implement Count method with GORM")
+// GetCategoriesWithPosts returns all categories with their posts that are
+// not deleted loaded
+func (r *CategoryRepository) GetCategoriesWithPosts(ctx context.Context) ([]models.Category, error) {
+	var categories []models.Category
+	err := r.run(ctx, func(db *gorm.DB) error {
+		// models.Post is soft deleted by the sql.DB repositories, which GORM
+		// does not know about
+		return db.Preload("Posts", "deleted_at IS NULL").Find(&categories).Error
+	})
+	return categories, err
 }
 
-// TODO: Implement Transaction example using GORM
-func (r *CategoryRepository) CreateWithTransaction(categories []models.Category) error {
-	// TODO: Create multiple categories in a transaction using GORM
-	// - Use GORM's Transaction method: r.db.Transaction(func(tx *gorm.DB) error {...})
-	// - GORM automatically handles rollback on error
-	// - Much simpler than manual transaction management!
-	//
-	// Example:
-	// return r.db.Transaction(func(tx *gorm.DB) error {
-	//     for _, category := range categories {
-	//         if err := tx.Create(&category).Error; err != nil {
-	//             return err // GORM will rollback automatically
-	//         }
-	//     }
-	//     return nil // GORM will commit automatically
-	// })
-	return fmt.Errorf("TODO: implement CreateWithTransaction method with GORM")
+// Count returns the number of categories that are not deleted
+func (r *CategoryRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.run(ctx, func(db *gorm.DB) error {
+		return db.Model(&models.Category{}).Count(&count).Error
+	})
+	return count, err
+}
+
+// CreateWithTransaction creates all categories or none
+func (r *CategoryRepository) CreateWithTransaction(ctx context.Context, categories []models.Category) error {
+	return r.run(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			for i := range categories {
+				if err := tx.Create(&categories[i]).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
 }
@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"lab04-backend/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials does not tell an unknown email from a wrong
+	// password
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrAccountLocked is returned while a lockout lasts, whatever the
+	// password. Only existing accounts are locked, so callers that must
+	// not reveal which emails exist should answer it like
+	// ErrInvalidCredentials.
+	ErrAccountLocked = errors.New("account is temporarily locked")
+	// ErrInvalidResetToken is returned for unknown, used and expired
+	// password reset tokens
+	ErrInvalidResetToken = errors.New("password reset token is invalid or expired")
+)
+
+// LockoutPolicy locks accounts after failed logins in a row
+type LockoutPolicy struct {
+	// MaxAttempts is the number of failed logins that locks the account,
+	// zero or less disables lockouts
+	MaxAttempts int
+	Duration    time.Duration
+}
+
+// DefaultLockoutPolicy is used by the repositories unless configured
+// otherwise
+var DefaultLockoutPolicy = LockoutPolicy{MaxAttempts: 5, Duration: 15 * time.Minute}
+
+// passwordCost is the bcrypt cost of new password hashes
+var passwordCost = bcrypt.DefaultCost
+
+// dummyHash is checked when a user has no password hash, so unknown emails
+// take as long to reject as wrong passwords
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("no password"), passwordCost)
+	return hash
+})
+
+// SetPassword hashes and stores the password of a user, ending any lockout
+// and invalidating their reset tokens. It returns sql.ErrNoRows if the user
+// does not exist or is deleted.
+func (r *UserRepository) SetPassword(ctx context.Context, id int, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return r.atomic(ctx, func(q dbtx) error {
+		return r.storePasswordHash(ctx, q, id, hash)
+	})
+}
+
+// ChangePassword replaces the password of a user after checking the
+// current one, or returns ErrInvalidCredentials. Failures do not count
+// towards a lockout. It returns sql.ErrNoRows if the user does not exist or
+// is deleted.
+func (r *UserRepository) ChangePassword(ctx context.Context, id int, currentPassword, newPassword string) error {
+	var hash sql.NullString
+	err := r.run(ctx, func(q dbtx) error {
+		return q.QueryRowContext(ctx, r.dialect.Rebind(`SELECT password_hash FROM users WHERE id = ? AND deleted_at IS NULL`), id).Scan(&hash)
+	})
+	if err != nil {
+		return err
+	}
+	if !checkPassword(hash, currentPassword) {
+		return ErrInvalidCredentials
+	}
+	return r.SetPassword(ctx, id, newPassword)
+}
+
+// VerifyCredentials returns the user with the email if the password is
+// theirs. It returns ErrInvalidCredentials otherwise and ErrAccountLocked
+// while the account is locked, taking as long in every case. Every attempt
+// counts towards a lockout before the password is checked, so parallel
+// attempts cannot get around it, and a successful login starts the count
+// over.
+func (r *UserRepository) VerifyCredentials(ctx context.Context, email, password string) (*models.User, error) {
+	var id int
+	var hash sql.NullString
+	err := r.atomic(ctx, func(q dbtx) error {
+		if r.Lockout.MaxAttempts <= 0 {
+			return q.QueryRowContext(ctx, r.dialect.Rebind(`
+				SELECT id, password_hash FROM users
+				WHERE email = ? AND deleted_at IS NULL`), email).Scan(&id, &hash)
+		}
+		return r.countLogin(ctx, q, email, &id, &hash)
+	})
+	switch {
+	case errors.Is(err, ErrAccountLocked):
+		checkPassword(sql.NullString{}, password)
+		return nil, err
+	case errors.Is(err, sql.ErrNoRows):
+		checkPassword(sql.NullString{}, password)
+		return nil, ErrInvalidCredentials
+	case err != nil:
+		return nil, err
+	}
+
+	if !checkPassword(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	var user models.User
+	err = r.run(ctx, func(q dbtx) error {
+		return user.ScanRow(q.QueryRowContext(ctx, r.dialect.Rebind(`
+			UPDATE users SET failed_logins = 0, locked_until = NULL
+			WHERE id = ?
+			RETURNING `+userColumns), id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// loginCount is the failed_logins of a user after one more attempt, which
+// starts over once a lockout has ended
+const loginCount = `CASE WHEN locked_until IS NULL THEN failed_logins + 1 ELSE 1 END`
+
+// countLogin counts a login attempt with the email unless the account is
+// locked, locking it once the lockout policy allows no more, and scans the
+// id and password hash of the user. It returns ErrAccountLocked while the
+// account is locked and sql.ErrNoRows if no user that is not deleted has
+// the email.
+func (r *UserRepository) countLogin(ctx context.Context, q dbtx, email string, id *int, hash *sql.NullString) error {
+	now := now()
+	// Both assignments see the values from before the update
+	err := q.QueryRowContext(ctx, r.dialect.Rebind(`
+		UPDATE users SET
+			locked_until = CASE WHEN `+loginCount+` >= ? THEN ? ELSE NULL END,
+			failed_logins = `+loginCount+`
+		WHERE email = ? AND deleted_at IS NULL AND (locked_until IS NULL OR locked_until <= ?)
+		RETURNING id, password_hash`), r.Lockout.MaxAttempts, now.Add(r.Lockout.Duration), email, now).Scan(id, hash)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var exists int
+	err = q.QueryRowContext(ctx, r.dialect.Rebind(`
+		SELECT COUNT(*) FROM users WHERE email = ? AND deleted_at IS NULL`), email).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+	return ErrAccountLocked
+}
+
+// CreatePasswordResetToken returns a token for ResetPassword that expires
+// after ttl, or sql.ErrNoRows if no user that is not deleted has the email.
+// Only a hash of the token is stored, and callers should not reveal whether
+// the email exists.
+func (r *UserRepository) CreatePasswordResetToken(ctx context.Context, email string, ttl time.Duration) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	now := now()
+	query := r.dialect.Rebind(`
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_at)
+		SELECT id, ?, ?, ? FROM users WHERE email = ? AND deleted_at IS NULL`)
+
+	err := r.run(ctx, func(q dbtx) error {
+		result, err := q.ExecContext(ctx, query, hashToken(token), now.Add(ttl), now, email)
+		if err != nil {
+			return err
+		}
+		return expectAffected(result)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword sets the password of the user a reset token was created
+// for, using up the token, or returns ErrInvalidResetToken
+func (r *UserRepository) ResetPassword(ctx context.Context, token, newPassword string) error {
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	now := now()
+	err = r.atomic(ctx, func(q dbtx) error {
+		var userID int
+		err := q.QueryRowContext(ctx, r.dialect.Rebind(`
+			UPDATE password_reset_tokens SET used_at = ?
+			WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+			RETURNING user_id`), now, hashToken(token), now).Scan(&userID)
+		if err != nil {
+			return err
+		}
+		return r.storePasswordHash(ctx, q, userID, hash)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	return err
+}
+
+// storePasswordHash sets the password hash of a user, ends their lockout
+// and invalidates their unused reset tokens
+func (r *UserRepository) storePasswordHash(ctx context.Context, q dbtx, id int, hash []byte) error {
+	now := now()
+	result, err := q.ExecContext(ctx, r.dialect.Rebind(`
+		UPDATE users SET password_hash = ?, failed_logins = 0, locked_until = NULL, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL`), string(hash), now, id)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(result); err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, r.dialect.Rebind(`
+		UPDATE password_reset_tokens SET used_at = ?
+		WHERE user_id = ? AND used_at IS NULL`), now, id)
+	return err
+}
+
+// hashPassword validates and hashes a new password
+func hashPassword(password string) ([]byte, error) {
+	if err := models.ValidatePassword(password); err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+}
+
+// checkPassword reports whether password matches hash, taking as long when
+// there is no hash
+func checkPassword(hash sql.NullString, password string) bool {
+	if !hash.Valid {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) == nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash a reset token is stored as
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
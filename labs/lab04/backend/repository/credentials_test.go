@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lab04-backend/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	// Hashing at the default cost makes the tests slow, under -race most
+	passwordCost = bcrypt.MinCost
+}
+
+func TestUserRepository_Passwords(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		repo := NewUserRepository(db)
+		ctx := context.Background()
+
+		user, err := repo.Create(ctx, &models.CreateUserRequest{Name: "Password User", Email: "credentials@example.com"})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, ""); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("VerifyCredentials() without a password error = %v, want ErrInvalidCredentials", err)
+		}
+
+		if err := repo.SetPassword(ctx, user.ID, "short"); err == nil {
+			t.Error("SetPassword() should validate the password")
+		}
+		if err := repo.SetPassword(ctx, 99999, "long enough"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("SetPassword() error = %v, want sql.ErrNoRows", err)
+		}
+		if err := repo.SetPassword(ctx, user.ID, "first password"); err != nil {
+			t.Fatalf("SetPassword() failed: %v", err)
+		}
+		var stored string
+		if err := db.QueryRow(repo.dialect.Rebind(`SELECT password_hash FROM users WHERE id = ?`), user.ID).Scan(&stored); err != nil {
+			t.Fatalf("Failed to read password hash: %v", err)
+		}
+		if !strings.HasPrefix(stored, "$2a$") {
+			t.Errorf("password_hash = %q, want a bcrypt hash", stored)
+		}
+
+		verified, err := repo.VerifyCredentials(ctx, user.Email, "first password")
+		if err != nil {
+			t.Fatalf("VerifyCredentials() failed: %v", err)
+		}
+		if verified.ID != user.ID {
+			t.Errorf("VerifyCredentials() = %+v, want user %d", verified, user.ID)
+		}
+		encoded, _ := json.Marshal(verified)
+		if strings.Contains(string(encoded), "password_hash") || strings.Contains(string(encoded), stored) {
+			t.Errorf("JSON of the user contains the password hash: %s", encoded)
+		}
+		for _, tt := range []struct{ email, password string }{
+			{user.Email, "wrong password"},
+			{"nobody@example.com", "first password"},
+		} {
+			if _, err := repo.VerifyCredentials(ctx, tt.email, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("VerifyCredentials(%q, %q) error = %v, want ErrInvalidCredentials", tt.email, tt.password, err)
+			}
+		}
+
+		if err := repo.ChangePassword(ctx, user.ID, "wrong password", "second password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("ChangePassword() error = %v, want ErrInvalidCredentials", err)
+		}
+		if err := repo.ChangePassword(ctx, user.ID, "first password", "second password"); err != nil {
+			t.Fatalf("ChangePassword() failed: %v", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "first password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("VerifyCredentials() with the old password error = %v, want ErrInvalidCredentials", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "second password"); err != nil {
+			t.Errorf("VerifyCredentials() with the new password failed: %v", err)
+		}
+
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "second password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("VerifyCredentials() of a deleted user error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+}
+
+func TestUserRepository_Lockout(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		repo := NewUserRepository(db)
+		repo.Lockout = LockoutPolicy{MaxAttempts: 3, Duration: time.Hour}
+		ctx := context.Background()
+
+		user, _ := repo.Create(ctx, &models.CreateUserRequest{Name: "Locked User", Email: "locked@example.com"})
+		if err := repo.SetPassword(ctx, user.ID, "right password"); err != nil {
+			t.Fatalf("SetPassword() failed: %v", err)
+		}
+		fail := func(times int) {
+			t.Helper()
+			for i := 0; i < times; i++ {
+				if _, err := repo.VerifyCredentials(ctx, user.Email, "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("VerifyCredentials() error = %v, want ErrInvalidCredentials", err)
+				}
+			}
+		}
+
+		// A successful login starts the count over
+		fail(2)
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "right password"); err != nil {
+			t.Fatalf("VerifyCredentials() failed: %v", err)
+		}
+		fail(2)
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "right password"); err != nil {
+			t.Fatalf("VerifyCredentials() after 2 failures failed: %v", err)
+		}
+
+		fail(3)
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "right password"); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("VerifyCredentials() error = %v, want ErrAccountLocked", err)
+		}
+
+		// Let the lockout expire
+		expire := repo.dialect.Rebind(`UPDATE users SET locked_until = ? WHERE id = ?`)
+		if _, err := db.Exec(expire, now().Add(-time.Minute), user.ID); err != nil {
+			t.Fatalf("Failed to expire lockout: %v", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "right password"); err != nil {
+			t.Errorf("VerifyCredentials() after the lockout failed: %v", err)
+		}
+
+		fail(3)
+		if err := repo.SetPassword(ctx, user.ID, "new password"); err != nil {
+			t.Fatalf("SetPassword() failed: %v", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "new password"); err != nil {
+			t.Errorf("VerifyCredentials() should not be locked after SetPassword(): %v", err)
+		}
+	})
+}
+
+func TestUserRepository_ParallelLogins(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		repo := NewUserRepository(db)
+		repo.Lockout = LockoutPolicy{MaxAttempts: 3, Duration: time.Hour}
+		ctx := context.Background()
+
+		user, _ := repo.Create(ctx, &models.CreateUserRequest{Name: "Attacked User", Email: "attacked@example.com"})
+		if err := repo.SetPassword(ctx, user.ID, "right password"); err != nil {
+			t.Fatalf("SetPassword() failed: %v", err)
+		}
+
+		// Every attempt that reads the account unlocked gets a guess
+		errs := make(chan error, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repo.VerifyCredentials(ctx, user.Email, "wrong password")
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		var guesses int
+		for err := range errs {
+			switch {
+			case errors.Is(err, ErrInvalidCredentials):
+				guesses++
+			case !errors.Is(err, ErrAccountLocked):
+				t.Errorf("VerifyCredentials() error = %v", err)
+			}
+		}
+		if guesses != 3 {
+			t.Errorf("%d parallel attempts got a guess, want 3", guesses)
+		}
+
+		// Attempts while locked neither count nor extend the lockout
+		lockout := func() (failed int, lockedUntil time.Time) {
+			t.Helper()
+			err := db.QueryRow(repo.dialect.Rebind(`SELECT failed_logins, locked_until FROM users WHERE id = ?`), user.ID).Scan(&failed, &lockedUntil)
+			if err != nil {
+				t.Fatalf("Failed to read lockout: %v", err)
+			}
+			return failed, lockedUntil
+		}
+		failed, lockedUntil := lockout()
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "right password"); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("VerifyCredentials() error = %v, want ErrAccountLocked", err)
+		}
+		if failedAfter, lockedAfter := lockout(); failed != 3 || failedAfter != 3 || !lockedAfter.Equal(lockedUntil) {
+			t.Errorf("lockout = %d until %v, then %d until %v, want 3 until the same time", failed, lockedUntil, failedAfter, lockedAfter)
+		}
+	})
+}
+
+func TestUserRepository_PasswordReset(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		repo := NewUserRepository(db)
+		ctx := context.Background()
+
+		user, _ := repo.Create(ctx, &models.CreateUserRequest{Name: "Forgetful User", Email: "forgetful@example.com"})
+		if _, err := repo.CreatePasswordResetToken(ctx, "nobody@example.com", time.Hour); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("CreatePasswordResetToken() error = %v, want sql.ErrNoRows", err)
+		}
+
+		token, err := repo.CreatePasswordResetToken(ctx, user.Email, time.Hour)
+		if err != nil {
+			t.Fatalf("CreatePasswordResetToken() failed: %v", err)
+		}
+		var stored int
+		if err := db.QueryRow(repo.dialect.Rebind(`SELECT COUNT(*) FROM password_reset_tokens WHERE token_hash = ?`), token).Scan(&stored); err != nil || stored != 0 {
+			t.Errorf("password_reset_tokens stores the token itself: %d, %v", stored, err)
+		}
+
+		if err := repo.ResetPassword(ctx, token, "short"); err == nil {
+			t.Error("ResetPassword() should validate the password")
+		}
+		if err := repo.ResetPassword(ctx, "not a token", "reset password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("ResetPassword() error = %v, want ErrInvalidResetToken", err)
+		}
+		if err := repo.ResetPassword(ctx, token, "reset password"); err != nil {
+			t.Fatalf("ResetPassword() failed: %v", err)
+		}
+		if _, err := repo.VerifyCredentials(ctx, user.Email, "reset password"); err != nil {
+			t.Errorf("VerifyCredentials() with the reset password failed: %v", err)
+		}
+		if err := repo.ResetPassword(ctx, token, "second reset"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("ResetPassword() with a used token error = %v, want ErrInvalidResetToken", err)
+		}
+
+		expired, _ := repo.CreatePasswordResetToken(ctx, user.Email, -time.Minute)
+		if err := repo.ResetPassword(ctx, expired, "expired reset"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("ResetPassword() with an expired token error = %v, want ErrInvalidResetToken", err)
+		}
+
+		// Changing the password invalidates the tokens issued before
+		pending, _ := repo.CreatePasswordResetToken(ctx, user.Email, time.Hour)
+		if err := repo.ChangePassword(ctx, user.ID, "reset password", "changed password"); err != nil {
+			t.Fatalf("ChangePassword() failed: %v", err)
+		}
+		if err := repo.ResetPassword(ctx, pending, "pending reset"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("ResetPassword() after ChangePassword() error = %v, want ErrInvalidResetToken", err)
+		}
+	})
+}
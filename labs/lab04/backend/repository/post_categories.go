@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"lab04-backend/models"
+
+	"github.com/georgysavva/scany/v2/sqlscan"
+)
+
+// categoryColumns are the columns mapped to models.Category, which GORM may
+// leave NULL
+const categoryColumns = `id, name, COALESCE(description, '') AS description, COALESCE(color, '') AS color,
+	COALESCE(active, TRUE) AS active, created_at, updated_at`
+
+// inCategory matches the posts assigned to a category that is not deleted
+const inCategory = `id IN (SELECT pc.post_id FROM post_categories pc
+	JOIN categories c ON c.id = pc.category_id
+	WHERE pc.category_id = ? AND c.deleted_at IS NULL)`
+
+// AssignCategories adds categories to a post, keeping the ones it has. It
+// returns sql.ErrNoRows if the post or one of the categories does not exist
+// or is deleted.
+func (r *PostRepository) AssignCategories(ctx context.Context, postID int, categoryIDs []uint) error {
+	ids := make(map[uint]bool)
+	var args []interface{}
+	for _, id := range categoryIDs {
+		if !ids[id] {
+			ids[id] = true
+			args = append(args, id)
+		}
+	}
+
+	now := now()
+	return r.atomic(ctx, func(q dbtx) error {
+		var exists int
+		err := q.QueryRowContext(ctx, r.dialect.Rebind(`SELECT COUNT(*) FROM posts WHERE id = ? AND deleted_at IS NULL`), postID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return sql.ErrNoRows
+		}
+		if len(args) == 0 {
+			return nil
+		}
+
+		var found int
+		err = q.QueryRowContext(ctx, r.dialect.Rebind(`
+			SELECT COUNT(*) FROM categories
+			WHERE deleted_at IS NULL AND id IN (`+placeholders(len(args))+`)`), args...).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(args) {
+			return sql.ErrNoRows
+		}
+
+		insert := r.dialect.Rebind(`
+			INSERT INTO post_categories (post_id, category_id, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (post_id, category_id) DO NOTHING`)
+		for _, id := range args {
+			if _, err := q.ExecContext(ctx, insert, postID, id, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveCategory takes a category from a post, or returns sql.ErrNoRows if
+// the post does not have it
+func (r *PostRepository) RemoveCategory(ctx context.Context, postID int, categoryID uint) error {
+	return r.run(ctx, func(q dbtx) error {
+		result, err := q.ExecContext(ctx, r.dialect.Rebind(`
+			DELETE FROM post_categories WHERE post_id = ? AND category_id = ?`), postID, categoryID)
+		if err != nil {
+			return err
+		}
+		return expectAffected(result)
+	})
+}
+
+// GetPostsByCategory returns a page of the posts in a category that are
+// not deleted, newest first. A limit of zero or less returns the default
+// page size of searches.
+func (r *PostRepository) GetPostsByCategory(ctx context.Context, categoryID uint, limit, offset int, opts ...QueryOption) ([]models.Post, error) {
+	query := r.dialect.Rebind(`SELECT ` + postColumns + ` FROM posts ` + whereClause(opts, inCategory) + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?`)
+
+	var posts []models.Post
+	err := r.run(ctx, func(q dbtx) error {
+		posts = []models.Post{}
+		return sqlscan.Select(ctx, q, &posts, query, categoryID, limitOrDefault(limit), max(offset, 0))
+	})
+	return posts, err
+}
+
+// GetCategoriesForPost returns the categories of a post that are not
+// deleted, ordered by name
+func (r *PostRepository) GetCategoriesForPost(ctx context.Context, postID int) ([]models.Category, error) {
+	query := r.dialect.Rebind(`SELECT ` + categoryColumns + ` FROM categories
+		WHERE deleted_at IS NULL AND id IN (SELECT category_id FROM post_categories WHERE post_id = ?)
+		ORDER BY name`)
+
+	var categories []models.Category
+	err := r.run(ctx, func(q dbtx) error {
+		categories = []models.Category{}
+		return sqlscan.Select(ctx, q, &categories, query, postID)
+	})
+	return categories, err
+}
+
+// placeholders returns n comma separated placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"lab04-backend/database"
+	"lab04-backend/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPostRepository_Categories(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		gormDB, err := database.OpenGORM(db)
+		if err != nil {
+			t.Fatalf("OpenGORM() failed: %v", err)
+		}
+		users := NewUserRepository(db)
+		posts := NewPostRepository(db)
+		categories := NewCategoryRepository(gormDB)
+		searchService := NewSearchService(db)
+		ctx := context.Background()
+
+		author, err := users.Create(ctx, &models.CreateUserRequest{Name: "Post Author", Email: "author@example.com"})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		var created []*models.Post
+		for i := 1; i <= 3; i++ {
+			post, err := posts.Create(ctx, &models.CreatePostRequest{UserID: author.ID, Title: fmt.Sprintf("Post number %d", i)})
+			if err != nil {
+				t.Fatalf("Failed to create post: %v", err)
+			}
+			created = append(created, post)
+		}
+		golang := &models.Category{Name: "Golang", Color: "#00add8"}
+		databases := &models.Category{Name: "Databases", Description: "SQL and more"}
+		for _, category := range []*models.Category{golang, databases} {
+			if err := categories.Create(ctx, category); err != nil {
+				t.Fatalf("Failed to create category: %v", err)
+			}
+		}
+
+		for _, post := range created {
+			if err := posts.AssignCategories(ctx, post.ID, []uint{golang.ID}); err != nil {
+				t.Fatalf("AssignCategories() failed: %v", err)
+			}
+		}
+		// Assigning again keeps the categories a post has
+		if err := posts.AssignCategories(ctx, created[0].ID, []uint{databases.ID, golang.ID, databases.ID}); err != nil {
+			t.Fatalf("AssignCategories() again failed: %v", err)
+		}
+		if err := posts.AssignCategories(ctx, created[0].ID, []uint{golang.ID, 99999}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("AssignCategories() with a missing category error = %v, want sql.ErrNoRows", err)
+		}
+		if err := posts.AssignCategories(ctx, 99999, []uint{golang.ID}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("AssignCategories() with a missing post error = %v, want sql.ErrNoRows", err)
+		}
+
+		found, err := posts.GetCategoriesForPost(ctx, created[0].ID)
+		if err != nil {
+			t.Fatalf("GetCategoriesForPost() failed: %v", err)
+		}
+		if len(found) != 2 || found[0].Name != "Databases" || found[0].Description != "SQL and more" ||
+			found[1].ID != golang.ID || found[1].Color != "#00add8" || !found[1].Active {
+			t.Errorf("GetCategoriesForPost() = %+v, want Databases and Golang", found)
+		}
+
+		titles := func(posts []models.Post) string {
+			var titles []string
+			for _, post := range posts {
+				titles = append(titles, post.Title)
+			}
+			return fmt.Sprint(titles)
+		}
+		pages := []struct {
+			limit, offset int
+			want          string
+		}{
+			{0, 0, "[Post number 3 Post number 2 Post number 1]"},
+			{2, 0, "[Post number 3 Post number 2]"},
+			{2, 2, "[Post number 1]"},
+		}
+		for _, page := range pages {
+			byCategory, err := posts.GetPostsByCategory(ctx, golang.ID, page.limit, page.offset)
+			if err != nil {
+				t.Fatalf("GetPostsByCategory() failed: %v", err)
+			}
+			if got := titles(byCategory); got != page.want {
+				t.Errorf("GetPostsByCategory(limit %d, offset %d) = %v, want %v", page.limit, page.offset, got, page.want)
+			}
+		}
+		searched, err := searchService.SearchPosts(ctx, SearchFilters{CategoryID: &databases.ID})
+		if err != nil || titles(searched) != "[Post number 1]" {
+			t.Errorf("SearchPosts() by category = %v, %v, want [Post number 1]", titles(searched), err)
+		}
+
+		// GORM sees the assignments and the soft deletes of the sql.DB side
+		if err := posts.Delete(ctx, created[2].ID); err != nil {
+			t.Fatalf("Failed to delete post: %v", err)
+		}
+		if count, err := golang.PostCount(gormDB); err != nil || count != 2 {
+			t.Errorf("PostCount() = %d, %v, want 2", count, err)
+		}
+		withPosts, err := categories.GetCategoriesWithPosts(ctx)
+		if err != nil {
+			t.Fatalf("GetCategoriesWithPosts() failed: %v", err)
+		}
+		for _, category := range withPosts {
+			if category.ID == golang.ID && len(category.Posts) != 2 {
+				t.Errorf("GetCategoriesWithPosts() loaded %d posts of Golang, want 2", len(category.Posts))
+			}
+		}
+
+		if err := posts.RemoveCategory(ctx, created[0].ID, golang.ID); err != nil {
+			t.Fatalf("RemoveCategory() failed: %v", err)
+		}
+		if err := posts.RemoveCategory(ctx, created[0].ID, golang.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("RemoveCategory() twice error = %v, want sql.ErrNoRows", err)
+		}
+		if byCategory, _ := posts.GetPostsByCategory(ctx, golang.ID, 0, 0); titles(byCategory) != "[Post number 2]" {
+			t.Errorf("GetPostsByCategory() = %v, want [Post number 2]", titles(byCategory))
+		}
+
+		// and the other way around
+		if err := categories.Delete(ctx, databases.ID); err != nil {
+			t.Fatalf("Failed to delete category: %v", err)
+		}
+		if found, _ := posts.GetCategoriesForPost(ctx, created[0].ID); len(found) != 0 {
+			t.Errorf("GetCategoriesForPost() = %+v, want no deleted categories", found)
+		}
+		if byCategory, _ := posts.GetPostsByCategory(ctx, databases.ID, 0, 0); len(byCategory) != 0 {
+			t.Errorf("GetPostsByCategory() = %v, want no posts of a deleted category", titles(byCategory))
+		}
+		if err := posts.AssignCategories(ctx, created[1].ID, []uint{databases.ID}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("AssignCategories() with a deleted category error = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+// TestCategoriesOnSharedFile opens GORM and database/sql on the same SQLite
+// file separately, as two processes would
+func TestCategoriesOnSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.db")
+	db := openTestDB(t, &database.Config{Driver: database.DriverSQLite, DatabasePath: path, MaxOpenConns: 5})
+	gormDB, err := gorm.Open(sqlite.Open("file:"+path+"?_foreign_keys=on"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open GORM: %v", err)
+	}
+	t.Cleanup(func() {
+		if pool, err := gormDB.DB(); err == nil {
+			pool.Close()
+		}
+	})
+
+	users := NewUserRepository(db)
+	posts := NewPostRepository(db)
+	ctx := context.Background()
+	author, _ := users.Create(ctx, &models.CreateUserRequest{Name: "Post Author", Email: "author@example.com"})
+	post, err := posts.Create(ctx, &models.CreatePostRequest{UserID: author.ID, Title: "Shared post"})
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	category := models.Category{Name: "Shared"}
+	if err := NewCategoryRepository(gormDB).Create(ctx, &category); err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
+	// Omitting Posts.* links the existing post instead of saving it
+	if err := gormDB.Model(&category).Omit("Posts.*").Association("Posts").Append(post); err != nil {
+		t.Fatalf("Association().Append() failed: %v", err)
+	}
+
+	byCategory, err := posts.GetPostsByCategory(ctx, category.ID, 10, 0)
+	if err != nil || len(byCategory) != 1 || byCategory[0].ID != post.ID {
+		t.Errorf("GetPostsByCategory() = %+v, %v, want the post GORM assigned", byCategory, err)
+	}
+	found, err := posts.GetCategoriesForPost(ctx, post.ID)
+	if err != nil || len(found) != 1 || !found[0].CreatedAt.Equal(category.CreatedAt) {
+		t.Errorf("GetCategoriesForPost() = %+v, %v, want %+v", found, err, category)
+	}
+
+	if err := posts.RemoveCategory(ctx, post.ID, category.ID); err != nil {
+		t.Fatalf("RemoveCategory() failed: %v", err)
+	}
+	if count, err := category.PostCount(gormDB); err != nil || count != 0 {
+		t.Errorf("PostCount() = %d, %v, want 0 after RemoveCategory()", count, err)
+	}
+}
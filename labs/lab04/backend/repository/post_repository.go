@@ -1,101 +1,186 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"strings"
+	"time"
 
 	"lab04-backend/models"
+
+	"github.com/georgysavva/scany/v2/sqlscan"
 )
 
+// postColumns are the columns mapped to models.Post
+const postColumns = "id, user_id, title, content, published, created_at, updated_at, deleted_at"
+
 // PostRepository handles database operations for posts
 // This repository demonstrates SCANY MAPPING approach for result scanning
 type PostRepository struct {
-	db *sql.DB
+	conn
 }
 
-// NewPostRepository creates a new PostRepository
+// NewPostRepository creates a new PostRepository with DefaultRetryPolicy
 func NewPostRepository(db *sql.DB) *PostRepository {
-	return &PostRepository{db: db}
+	return &PostRepository{newConn(db)}
 }
 
-// TODO: Implement Create method using scany for result mapping
-func (r *PostRepository) Create(req *models.CreatePostRequest) (*models.Post, error) {
-	// TODO: Create a new post in the database using scany for result mapping
-	// - Validate the request using req.Validate()
-	// - Insert into posts table with RETURNING clause
-	// - Use sqlscan.Get() to scan the RETURNING result into a Post struct
-	// Example: sqlscan.Get(context.Background(), r.db, &post, query, args...)
-	// This eliminates manual row scanning compared to user repository
-	return nil, fmt.Errorf("TODO: implement Create method with scany mapping")
+// Create inserts a post and returns it with its ID and timestamps
+func (r *PostRepository) Create(ctx context.Context, req *models.CreatePostRequest) (*models.Post, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := now()
+	query := r.dialect.Rebind(`
+		INSERT INTO posts (user_id, title, content, published, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING ` + postColumns)
+
+	var post models.Post
+	err := r.run(ctx, func(q dbtx) error {
+		return sqlscan.Get(ctx, q, &post, query, req.UserID, req.Title, req.Content, req.Published, now, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
-// TODO: Implement GetByID method using scany
-func (r *PostRepository) GetByID(id int) (*models.Post, error) {
-	// TODO: Get post by ID from database using scany
-	// - Use sqlscan.Get() instead of manual row.Scan()
-	// Example: sqlscan.Get(context.Background(), r.db, &post, "SELECT * FROM posts WHERE id = $1", id)
-	// Notice how this eliminates the need for manual field scanning
-	return nil, fmt.Errorf("TODO: implement GetByID method with scany")
+// GetByID returns sql.ErrNoRows if the post does not exist or is deleted
+func (r *PostRepository) GetByID(ctx context.Context, id int, opts ...QueryOption) (*models.Post, error) {
+	query := r.dialect.Rebind(`SELECT ` + postColumns + ` FROM posts ` + whereClause(opts, `id = ?`))
+
+	var post models.Post
+	err := r.run(ctx, func(q dbtx) error {
+		return sqlscan.Get(ctx, q, &post, query, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
-// TODO: Implement GetByUserID method using scany
-func (r *PostRepository) GetByUserID(userID int) ([]models.Post, error) {
-	// TODO: Get all posts by user ID using scany
-	// - Use sqlscan.Select() for multiple rows instead of manual rows.Next() loop
-	// Example: sqlscan.Select(context.Background(), r.db, &posts, query, userID)
-	// This eliminates manual iteration and scanning
-	return nil, fmt.Errorf("TODO: implement GetByUserID method with scany")
+// GetByUserID returns the posts of a user that are not deleted, newest
+// first
+func (r *PostRepository) GetByUserID(ctx context.Context, userID int, opts ...QueryOption) ([]models.Post, error) {
+	return r.selectPosts(ctx, whereClause(opts, `user_id = ?`), userID)
 }
 
-// TODO: Implement GetPublished method using scany
-func (r *PostRepository) GetPublished() ([]models.Post, error) {
-	// TODO: Get all published posts using scany
-	// - Use sqlscan.Select() for multiple rows
-	// - Query posts where published = true
-	// - Order by created_at DESC
-	return nil, fmt.Errorf("TODO: implement GetPublished method with scany")
+// GetPublished returns the published posts that are not deleted, newest
+// first
+func (r *PostRepository) GetPublished(ctx context.Context, opts ...QueryOption) ([]models.Post, error) {
+	return r.selectPosts(ctx, whereClause(opts, `published = ?`), true)
 }
 
-// TODO: Implement GetAll method using scany
-func (r *PostRepository) GetAll() ([]models.Post, error) {
-	// TODO: Get all posts from database using scany
-	// - Use sqlscan.Select() instead of manual rows iteration
-	// Example: sqlscan.Select(context.Background(), r.db, &posts, "SELECT * FROM posts ORDER BY created_at DESC")
-	// Compare this simplicity with manual scanning in user repository
-	return nil, fmt.Errorf("TODO: implement GetAll method with scany")
+// GetAll returns the posts that are not deleted, newest first
+func (r *PostRepository) GetAll(ctx context.Context, opts ...QueryOption) ([]models.Post, error) {
+	return r.selectPosts(ctx, whereClause(opts))
 }
 
-// TODO: Implement Update method using scany
-func (r *PostRepository) Update(id int, req *models.UpdatePostRequest) (*models.Post, error) {
-	// TODO: Update post in database using scany
-	// - Build dynamic UPDATE query based on non-nil fields in req
-	// - Update updated_at timestamp
-	// - Use sqlscan.Get() with RETURNING clause to get updated post
-	// This avoids a separate SELECT query after UPDATE
-	return nil, fmt.Errorf("TODO: implement Update method with scany")
+// selectPosts returns the posts matching the where clause, newest first
+func (r *PostRepository) selectPosts(ctx context.Context, where string, args ...interface{}) ([]models.Post, error) {
+	query := r.dialect.Rebind(`SELECT ` + postColumns + ` FROM posts ` + where + ` ORDER BY created_at DESC, id DESC`)
+
+	var posts []models.Post
+	err := r.run(ctx, func(q dbtx) error {
+		posts = []models.Post{}
+		return sqlscan.Select(ctx, q, &posts, query, args...)
+	})
+	return posts, err
 }
 
-// TODO: Implement Delete method (standard SQL)
-func (r *PostRepository) Delete(id int) error {
-	// TODO: Delete post from database
-	// - Delete from posts table by ID
-	// - Return error if post doesn't exist
-	// Note: Delete operations typically don't need scany since no data is returned
-	return fmt.Errorf("TODO: implement Delete method")
+// Update changes the fields of req that are set and returns the post, or
+// sql.ErrNoRows if it does not exist or is deleted
+func (r *PostRepository) Update(ctx context.Context, id int, req *models.UpdatePostRequest) (*models.Post, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	var set []string
+	var args []interface{}
+	if req.Title != nil {
+		set = append(set, "title = ?")
+		args = append(args, *req.Title)
+	}
+	if req.Content != nil {
+		set = append(set, "content = ?")
+		args = append(args, *req.Content)
+	}
+	if req.Published != nil {
+		set = append(set, "published = ?")
+		args = append(args, *req.Published)
+	}
+	set = append(set, "updated_at = ?")
+	args = append(args, now(), id)
+
+	query := r.dialect.Rebind(`UPDATE posts SET ` + strings.Join(set, ", ") + ` WHERE id = ? AND deleted_at IS NULL RETURNING ` + postColumns)
+
+	var post models.Post
+	err := r.run(ctx, func(q dbtx) error {
+		return sqlscan.Get(ctx, q, &post, query, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
 
-// TODO: Implement Count method (standard SQL)
-func (r *PostRepository) Count() (int, error) {
-	// TODO: Count total number of posts
-	// - Return count of posts in database
-	// - Can use standard QueryRow.Scan() for single values like count
-	return 0, fmt.Errorf("TODO: implement Count method")
+// Delete soft deletes a post, or returns sql.ErrNoRows if it does not exist
+// or is already deleted
+func (r *PostRepository) Delete(ctx context.Context, id int) error {
+	now := now()
+	return r.run(ctx, func(q dbtx) error {
+		result, err := q.ExecContext(ctx, r.dialect.Rebind(`
+			UPDATE posts SET deleted_at = ?, updated_at = ?
+			WHERE id = ? AND deleted_at IS NULL`), now, now, id)
+		if err != nil {
+			return err
+		}
+		return expectAffected(result)
+	})
 }
 
-// TODO: Implement CountByUserID method (standard SQL)
-func (r *PostRepository) CountByUserID(userID int) (int, error) {
-	// TODO: Count posts by user ID
-	// - Return count of posts for specific user
-	// - Use standard QueryRow.Scan() for single integer result
-	return 0, fmt.Errorf("TODO: implement CountByUserID method")
+// Restore undoes Delete and returns the post, or sql.ErrNoRows if it does
+// not exist or is not deleted
+func (r *PostRepository) Restore(ctx context.Context, id int) (*models.Post, error) {
+	query := r.dialect.Rebind(`
+		UPDATE posts SET deleted_at = NULL, updated_at = ?
+		WHERE id = ? AND deleted_at IS NOT NULL
+		RETURNING ` + postColumns)
+
+	var post models.Post
+	err := r.run(ctx, func(q dbtx) error {
+		return sqlscan.Get(ctx, q, &post, query, now(), id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// Purge permanently removes the posts deleted more than olderThan ago and
+// returns how many it removed
+func (r *PostRepository) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	return r.purge(ctx, "posts", olderThan)
+}
+
+// Count returns the number of posts that are not deleted
+func (r *PostRepository) Count(ctx context.Context, opts ...QueryOption) (int, error) {
+	var count int
+	err := r.run(ctx, func(q dbtx) error {
+		return q.QueryRowContext(ctx, `SELECT COUNT(*) FROM posts `+whereClause(opts)).Scan(&count)
+	})
+	return count, err
+}
+
+// CountByUserID returns the number of posts of a user that are not deleted
+func (r *PostRepository) CountByUserID(ctx context.Context, userID int, opts ...QueryOption) (int, error) {
+	query := r.dialect.Rebind(`SELECT COUNT(*) FROM posts ` + whereClause(opts, `user_id = ?`))
+
+	var count int
+	err := r.run(ctx, func(q dbtx) error {
+		return q.QueryRowContext(ctx, query, userID).Scan(&count)
+	})
+	return count, err
 }
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"lab04-backend/models"
+)
+
+func TestPostRepository(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		users := NewUserRepository(db)
+		repo := NewPostRepository(db)
+		ctx := context.Background()
+
+		author, err := users.Create(ctx, &models.CreateUserRequest{Name: "Post Author", Email: "author@example.com"})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		draft, err := repo.Create(ctx, &models.CreatePostRequest{UserID: author.ID, Title: "First draft", Content: "draft"})
+		if err != nil {
+			t.Fatalf("Create() failed: %v", err)
+		}
+		if draft.ID == 0 || draft.Published || draft.CreatedAt.IsZero() {
+			t.Errorf("Create() returned %+v", draft)
+		}
+		if _, err := repo.Create(ctx, &models.CreatePostRequest{UserID: author.ID, Title: "Bad"}); err == nil {
+			t.Error("Create() should validate the request")
+		}
+		if _, err := repo.Create(ctx, &models.CreatePostRequest{UserID: 99999, Title: "No such author"}); err == nil {
+			t.Error("Create() should enforce the user foreign key")
+		}
+
+		found, err := repo.GetByID(ctx, draft.ID)
+		if err != nil {
+			t.Fatalf("GetByID() failed: %v", err)
+		}
+		if found.ID != draft.ID || found.Title != draft.Title || !found.CreatedAt.Equal(draft.CreatedAt) {
+			t.Errorf("GetByID() = %+v, want %+v", *found, *draft)
+		}
+		if _, err := repo.GetByID(ctx, 99999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetByID() error = %v, want sql.ErrNoRows", err)
+		}
+
+		published := true
+		content := "now with content"
+		updated, err := repo.Update(ctx, draft.ID, &models.UpdatePostRequest{Content: &content, Published: &published})
+		if err != nil {
+			t.Fatalf("Update() failed: %v", err)
+		}
+		if updated.Title != draft.Title || updated.Content != content || !updated.Published ||
+			!updated.UpdatedAt.After(draft.UpdatedAt) {
+			t.Errorf("Update() returned %+v", updated)
+		}
+		if _, err := repo.Update(ctx, 99999, &models.UpdatePostRequest{Content: &content}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Update() error = %v, want sql.ErrNoRows", err)
+		}
+
+		second, _ := repo.Create(ctx, &models.CreatePostRequest{UserID: author.ID, Title: "Second post"})
+		if all, err := repo.GetAll(ctx); err != nil || len(all) != 2 || all[0].ID != second.ID {
+			t.Errorf("GetAll() = %+v, %v, want newest first", all, err)
+		}
+		if byUser, err := repo.GetByUserID(ctx, author.ID); err != nil || len(byUser) != 2 {
+			t.Errorf("GetByUserID() = %+v, %v", byUser, err)
+		}
+		if published, err := repo.GetPublished(ctx); err != nil || len(published) != 1 || published[0].ID != draft.ID {
+			t.Errorf("GetPublished() = %+v, %v", published, err)
+		}
+		if count, err := repo.CountByUserID(ctx, author.ID); err != nil || count != 2 {
+			t.Errorf("CountByUserID() = %d, %v, want 2", count, err)
+		}
+
+		if err := repo.Delete(ctx, second.ID); err != nil {
+			t.Fatalf("Delete() failed: %v", err)
+		}
+		if err := repo.Delete(ctx, second.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Delete() error = %v, want sql.ErrNoRows", err)
+		}
+
+		// Deleting the author deletes their posts
+		if err := users.Delete(ctx, author.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if count, err := repo.Count(ctx); err != nil || count != 0 {
+			t.Errorf("Count() = %d, %v, want 0", count, err)
+		}
+	})
+}
+
+func TestPostRepository_SoftDelete(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		users := NewUserRepository(db)
+		repo := NewPostRepository(db)
+		ctx := context.Background()
+
+		author, err := users.Create(ctx, &models.CreateUserRequest{Name: "Post Author", Email: "author@example.com"})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		post, _ := repo.Create(ctx, &models.CreatePostRequest{UserID: author.ID, Title: "Soon deleted", Content: "text", Published: true})
+
+		if err := repo.Delete(ctx, post.ID); err != nil {
+			t.Fatalf("Delete() failed: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, post.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetByID() error = %v, want sql.ErrNoRows", err)
+		}
+		if published, err := repo.GetPublished(ctx); err != nil || len(published) != 0 {
+			t.Errorf("GetPublished() = %+v, %v, want no posts", published, err)
+		}
+		if all, err := repo.GetAll(ctx, IncludeDeleted()); err != nil || len(all) != 1 || all[0].DeletedAt == nil {
+			t.Errorf("GetAll(IncludeDeleted()) = %+v, %v, want the deleted post", all, err)
+		}
+		title := "Edited after deletion"
+		if _, err := repo.Update(ctx, post.ID, &models.UpdatePostRequest{Title: &title}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Update() error = %v, want sql.ErrNoRows for a deleted post", err)
+		}
+		if purged, err := repo.Purge(ctx, time.Hour); err != nil || purged != 0 {
+			t.Errorf("Purge() = %d, %v, want nothing within retention", purged, err)
+		}
+
+		restored, err := repo.Restore(ctx, post.ID)
+		if err != nil {
+			t.Fatalf("Restore() failed: %v", err)
+		}
+		if restored.DeletedAt != nil || restored.Title != post.Title {
+			t.Errorf("Restore() = %+v", restored)
+		}
+		if _, err := repo.Restore(ctx, post.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Restore() error = %v, want sql.ErrNoRows for a post that is not deleted", err)
+		}
+		if count, err := repo.Count(ctx); err != nil || count != 1 {
+			t.Errorf("Count() = %d, %v, want 1", count, err)
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"lab04-backend/database"
+	"lab04-backend/models"
+)
+
+// ErrEmailTaken is returned when a user would share the email of another
+// user that is not deleted
+var ErrEmailTaken = errors.New("email is already registered")
+
+// UserStore is the context-aware interface of UserRepository
+type UserStore interface {
+	Create(ctx context.Context, req *models.CreateUserRequest) (*models.User, error)
+	GetByID(ctx context.Context, id int, opts ...QueryOption) (*models.User, error)
+	GetByEmail(ctx context.Context, email string, opts ...QueryOption) (*models.User, error)
+	GetAll(ctx context.Context, opts ...QueryOption) ([]models.User, error)
+	Update(ctx context.Context, id int, req *models.UpdateUserRequest) (*models.User, error)
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) (*models.User, error)
+	Purge(ctx context.Context, olderThan time.Duration) (int, error)
+	Count(ctx context.Context, opts ...QueryOption) (int, error)
+	SetPassword(ctx context.Context, id int, password string) error
+	ChangePassword(ctx context.Context, id int, currentPassword, newPassword string) error
+	VerifyCredentials(ctx context.Context, email, password string) (*models.User, error)
+	CreatePasswordResetToken(ctx context.Context, email string, ttl time.Duration) (string, error)
+	ResetPassword(ctx context.Context, token, newPassword string) error
+}
+
+// PostStore is the context-aware interface of PostRepository
+type PostStore interface {
+	Create(ctx context.Context, req *models.CreatePostRequest) (*models.Post, error)
+	GetByID(ctx context.Context, id int, opts ...QueryOption) (*models.Post, error)
+	GetByUserID(ctx context.Context, userID int, opts ...QueryOption) ([]models.Post, error)
+	GetPublished(ctx context.Context, opts ...QueryOption) ([]models.Post, error)
+	GetAll(ctx context.Context, opts ...QueryOption) ([]models.Post, error)
+	Update(ctx context.Context, id int, req *models.UpdatePostRequest) (*models.Post, error)
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) (*models.Post, error)
+	Purge(ctx context.Context, olderThan time.Duration) (int, error)
+	Count(ctx context.Context, opts ...QueryOption) (int, error)
+	CountByUserID(ctx context.Context, userID int, opts ...QueryOption) (int, error)
+	AssignCategories(ctx context.Context, postID int, categoryIDs []uint) error
+	RemoveCategory(ctx context.Context, postID int, categoryID uint) error
+	GetPostsByCategory(ctx context.Context, categoryID uint, limit, offset int, opts ...QueryOption) ([]models.Post, error)
+	GetCategoriesForPost(ctx context.Context, postID int) ([]models.Category, error)
+}
+
+// CategoryStore is the context-aware interface of CategoryRepository
+type CategoryStore interface {
+	Create(ctx context.Context, category *models.Category) error
+	GetByID(ctx context.Context, id uint) (*models.Category, error)
+	GetAll(ctx context.Context) ([]models.Category, error)
+	Update(ctx context.Context, category *models.Category) error
+	Delete(ctx context.Context, id uint) error
+	FindByName(ctx context.Context, name string) (*models.Category, error)
+	SearchCategories(ctx context.Context, query string, limit int) ([]models.Category, error)
+	GetCategoriesWithPosts(ctx context.Context) ([]models.Category, error)
+	Count(ctx context.Context) (int64, error)
+	CreateWithTransaction(ctx context.Context, categories []models.Category) error
+}
+
+var (
+	_ UserStore     = (*UserRepository)(nil)
+	_ PostStore     = (*PostRepository)(nil)
+	_ CategoryStore = (*CategoryRepository)(nil)
+)
+
+// Repos are the sql.DB based repositories, bound to the database or to a
+// transaction of UnitOfWork.WithTx
+type Repos struct {
+	Users UserStore
+	Posts PostStore
+}
+
+// RetryPolicy retries operations failing with database.IsRetryable errors,
+// waiting exponentially longer with jitter between attempts
+type RetryPolicy struct {
+	// Attempts is the total number of attempts, one or less disables
+	// retries
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used by the repositories unless configured otherwise
+var DefaultRetryPolicy = RetryPolicy{Attempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 500 * time.Millisecond}
+
+// Do calls fn until it succeeds, fails with an error that is not
+// retryable, runs out of attempts or ctx is done. Errors after ctx is done
+// are reported as ctx.Err(), so callers can tell cancellation apart.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	delay := p.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil || attempt >= p.Attempts || !database.IsRetryable(err) {
+			return err
+		}
+
+		// Full jitter spreads out connections that conflicted together
+		wait := time.Duration(rand.Int63n(int64(delay) + 1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// dbtx runs queries, it is either a *sql.DB or a *sql.Tx
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn is where the sql.DB based repositories run their queries
+type conn struct {
+	db      dbtx
+	dialect database.Dialect
+	// retry is the zero policy in transactions, which are retried as a
+	// whole by UnitOfWork.WithTx
+	retry RetryPolicy
+}
+
+func newConn(db *sql.DB) conn {
+	return conn{db: db, dialect: database.DialectOf(db), retry: DefaultRetryPolicy}
+}
+
+// run calls fn with the retry policy
+func (c conn) run(ctx context.Context, fn func(q dbtx) error) error {
+	return c.retry.Do(ctx, func() error { return fn(c.db) })
+}
+
+// atomic calls fn in a transaction with the retry policy, or directly if
+// the repository is already bound to a transaction
+func (c conn) atomic(ctx context.Context, fn func(q dbtx) error) error {
+	db, ok := c.db.(*sql.DB)
+	if !ok {
+		return fn(c.db)
+	}
+	return c.retry.Do(ctx, func() error {
+		return inTx(ctx, db, func(tx *sql.Tx) error { return fn(tx) })
+	})
+}
+
+// purge permanently removes the rows of table soft deleted more than
+// olderThan ago and returns how many it removed
+func (c conn) purge(ctx context.Context, table string, olderThan time.Duration) (int, error) {
+	var purged int64
+	err := c.run(ctx, func(q dbtx) error {
+		result, err := q.ExecContext(ctx, c.dialect.Rebind(`DELETE FROM `+table+` WHERE deleted_at < ?`), now().Add(-olderThan))
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return int(purged), err
+}
+
+// QueryOption changes which rows the read methods of the repositories
+// return
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	includeDeleted bool
+}
+
+// IncludeDeleted makes reads return soft deleted rows along with the others
+func IncludeDeleted() QueryOption {
+	return func(o *queryOptions) { o.includeDeleted = true }
+}
+
+// whereClause joins conds into a WHERE clause, adding the one that leaves
+// out soft deleted rows unless opts include them
+func whereClause(opts []QueryOption, conds ...string) string {
+	var o queryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.includeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conds, " AND ")
+}
+
+// UnitOfWork runs functions over the sql.DB based repositories in a
+// transaction
+type UnitOfWork struct {
+	db *sql.DB
+	// Retry applies to whole transactions in WithTx and to the operations
+	// of Repos
+	Retry RetryPolicy
+	// Lockout applies to the users of Repos and WithTx
+	Lockout LockoutPolicy
+}
+
+// NewUnitOfWork creates a UnitOfWork with DefaultRetryPolicy and
+// DefaultLockoutPolicy
+func NewUnitOfWork(db *sql.DB) *UnitOfWork {
+	return &UnitOfWork{db: db, Retry: DefaultRetryPolicy, Lockout: DefaultLockoutPolicy}
+}
+
+// Repos returns the repositories outside of a transaction
+func (u *UnitOfWork) Repos() Repos {
+	c := newConn(u.db)
+	c.retry = u.Retry
+	return u.repos(c)
+}
+
+// WithTx calls fn with repositories bound to a transaction, which is
+// committed if fn returns nil and rolled back otherwise. The transaction is
+// retried from the start on retryable errors, so fn may be called more than
+// once and must not have other side effects. Cancelling ctx rolls it back.
+func (u *UnitOfWork) WithTx(ctx context.Context, fn func(tx Repos) error) error {
+	dialect := database.DialectOf(u.db)
+	return u.Retry.Do(ctx, func() error {
+		return inTx(ctx, u.db, func(tx *sql.Tx) error {
+			return fn(u.repos(conn{db: tx, dialect: dialect}))
+		})
+	})
+}
+
+func (u *UnitOfWork) repos(c conn) Repos {
+	return Repos{Users: &UserRepository{conn: c, Lockout: u.Lockout}, Posts: &PostRepository{c}}
+}
+
+// inTx calls fn in a transaction that is committed if fn returns nil and
+// rolled back otherwise
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// now returns the current time at the precision every dialect stores, so
+// returned rows compare equal to the ones read back later
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// expectAffected returns sql.ErrNoRows if result changed no row
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lab04-backend/database"
+	"lab04-backend/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestUnitOfWork_WithTx(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		uow := NewUnitOfWork(db)
+		repos := uow.Repos()
+		ctx := context.Background()
+
+		// A user and their first post are created together
+		err := uow.WithTx(ctx, func(tx Repos) error {
+			user, err := tx.Users.Create(ctx, &models.CreateUserRequest{Name: "New Author", Email: "new@example.com"})
+			if err != nil {
+				return err
+			}
+			_, err = tx.Posts.Create(ctx, &models.CreatePostRequest{UserID: user.ID, Title: "Hello world", Content: "first"})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("WithTx() failed: %v", err)
+		}
+		user, err := repos.Users.GetByEmail(ctx, "new@example.com")
+		if err != nil {
+			t.Fatalf("GetByEmail() failed: %v", err)
+		}
+		if count, _ := repos.Posts.CountByUserID(ctx, user.ID); count != 1 {
+			t.Errorf("CountByUserID() = %d, want 1", count)
+		}
+
+		// Or not at all
+		err = uow.WithTx(ctx, func(tx Repos) error {
+			if _, err := tx.Users.Create(ctx, &models.CreateUserRequest{Name: "Lost Author", Email: "lost@example.com"}); err != nil {
+				return err
+			}
+			_, err := tx.Posts.Create(ctx, &models.CreatePostRequest{UserID: user.ID, Title: "Bad"})
+			return err
+		})
+		if err == nil {
+			t.Fatal("WithTx() should return the error of fn")
+		}
+		if _, err := repos.Users.GetByEmail(ctx, "lost@example.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetByEmail() error = %v, want the user to be rolled back", err)
+		}
+	})
+}
+
+func TestCancellation(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		uow := NewUnitOfWork(db)
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := uow.Repos().Users.Count(cancelled); !errors.Is(err, context.Canceled) {
+			t.Errorf("Count() error = %v, want context.Canceled", err)
+		}
+
+		// Cancelling during a transaction rolls it back
+		ctx, cancel := context.WithCancel(context.Background())
+		err := uow.WithTx(ctx, func(tx Repos) error {
+			if _, err := tx.Users.Create(ctx, &models.CreateUserRequest{Name: "Cancelled", Email: "cancelled@example.com"}); err != nil {
+				return err
+			}
+			cancel()
+			_, err := tx.Users.Count(ctx)
+			return err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("WithTx() error = %v, want context.Canceled", err)
+		}
+		if count, _ := uow.Repos().Users.Count(context.Background()); count != 0 {
+			t.Errorf("Count() = %d, want the transaction to be rolled back", count)
+		}
+	})
+}
+
+func TestRetryOnSQLiteBusy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "busy.db")
+	// Without a busy timeout, SQLite fails at once while another
+	// connection writes
+	db := openTestDB(t, &database.Config{DSN: "file:" + path + "?_foreign_keys=on&_busy_timeout=0", MaxOpenConns: 2})
+	locker, err := sql.Open(database.DriverSQLite, "file:"+path+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locker.Close()
+
+	lock := func() *sql.Tx {
+		t.Helper()
+		tx, err := locker.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec(`INSERT INTO users (name, email) VALUES ('Locker', ?)`, fmt.Sprint(time.Now().UnixNano())); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	ctx := context.Background()
+	uow := NewUnitOfWork(db)
+
+	uow.Retry = RetryPolicy{Attempts: 1}
+	tx := lock()
+	_, err = uow.Repos().Users.Create(ctx, &models.CreateUserRequest{Name: "Impatient", Email: "impatient@example.com"})
+	if !database.IsRetryable(err) {
+		t.Errorf("Create() error = %v, want SQLITE_BUSY", err)
+	}
+	tx.Rollback()
+
+	uow.Retry = RetryPolicy{Attempts: 50, BaseDelay: 5 * time.Millisecond, MaxDelay: 20 * time.Millisecond}
+	tx = lock()
+	time.AfterFunc(50*time.Millisecond, func() { tx.Commit() })
+	if _, err := uow.Repos().Users.Create(ctx, &models.CreateUserRequest{Name: "Patient", Email: "patient@example.com"}); err != nil {
+		t.Errorf("Create() should be retried until the lock is released, got %v", err)
+	}
+
+	tx = lock()
+	time.AfterFunc(50*time.Millisecond, func() { tx.Commit() })
+	calls := 0
+	err = uow.WithTx(ctx, func(tx Repos) error {
+		calls++
+		_, err := tx.Users.Create(ctx, &models.CreateUserRequest{Name: "Transaction", Email: "tx@example.com"})
+		return err
+	})
+	if err != nil || calls < 2 {
+		t.Errorf("WithTx() = %v after %d calls, want the transaction to be retried", err, calls)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	calls := 0
+	err := policy.Do(context.Background(), func() error {
+		calls++
+		return busy
+	})
+	if calls != 3 || !errors.Is(err, busy) {
+		t.Errorf("Do() = %v after %d calls, want 3 attempts", err, calls)
+	}
+
+	calls = 0
+	policy.Do(context.Background(), func() error {
+		calls++
+		return sql.ErrNoRows
+	})
+	if calls != 1 {
+		t.Errorf("Do() made %d calls, want errors that are not retryable to be returned", calls)
+	}
+
+	// Waiting stops when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	policy = RetryPolicy{Attempts: 100, BaseDelay: time.Second, MaxDelay: time.Second}
+	start := time.Now()
+	err = policy.Do(ctx, func() error { return busy })
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Do() = %v after %v, want context.DeadlineExceeded", err, time.Since(start))
+	}
+}
@@ -3,18 +3,30 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"strings"
 
+	"lab04-backend/database"
 	"lab04-backend/models"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/v2/sqlscan"
 )
 
+// defaultSearchLimit applies when a search has no limit
+const defaultSearchLimit = 50
+
+// wordCount counts the space separated words of a post's content
+const wordCount = `(CASE WHEN TRIM(COALESCE(content, '')) = '' THEN 0
+	ELSE LENGTH(TRIM(content)) - LENGTH(REPLACE(TRIM(content), ' ', '')) + 1 END)`
+
+// postOrderFields are the fields posts may be ordered by
+var postOrderFields = map[string]bool{"title": true, "created_at": true, "updated_at": true}
+
 // SearchService handles dynamic search operations using Squirrel query builder
 // This service demonstrates SQUIRREL QUERY BUILDER approach for dynamic SQL
 type SearchService struct {
-	db   *sql.DB
-	psql squirrel.StatementBuilderType
+	conn
+	builder squirrel.StatementBuilderType
 }
 
 // SearchFilters represents search parameters
@@ -23,83 +35,101 @@ type SearchFilters struct {
 	UserID       *int   // Filter by user ID
 	Published    *bool  // Filter by published status
 	MinWordCount *int   // Minimum word count in content
+	CategoryID   *uint  // Filter by category
 	Limit        int    // Results limit (default 50)
 	Offset       int    // Results offset (for pagination)
 	OrderBy      string // Order by field (title, created_at, updated_at)
 	OrderDir     string // Order direction (ASC, DESC)
+	// IncludeDeleted also matches soft deleted posts
+	IncludeDeleted bool
 }
 
 // NewSearchService creates a new SearchService
 func NewSearchService(db *sql.DB) *SearchService {
+	c := newConn(db)
 	return &SearchService{
-		db:   db,
-		psql: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		conn:    c,
+		builder: squirrel.StatementBuilder.PlaceholderFormat(c.dialect.PlaceholderFormat()),
 	}
 }
 
-// TODO: Implement SearchPosts method using Squirrel query builder
+// SearchPosts returns the posts matching filters, newest first unless
+// ordered otherwise
 func (s *SearchService) SearchPosts(ctx context.Context, filters SearchFilters) ([]models.Post, error) {
-	// TODO: Build dynamic query using Squirrel instead of string concatenation
-	//
-	// Start with base query:
-	// query := s.psql.Select("id", "user_id", "title", "content", "published", "created_at", "updated_at").
-	//              From("posts")
-	//
-	// Add WHERE conditions dynamically:
-	// - If filters.Query: add ILIKE conditions for title and content
-	// - If filters.UserID: add user_id = ?
-	// - If filters.Published: add published = ?
-	// - If filters.MinWordCount: add word count condition
-	//
-	// Add ORDER BY dynamically:
-	// - Use OrderBy() and validate sort fields
-	//
-	// Add LIMIT/OFFSET:
-	// - Use Limit() and Offset()
-	//
-	// Build final SQL:
-	// sql, args, err := query.ToSql()
-	//
-	// Execute with scany:
-	// var posts []models.Post
-	// err = sqlscan.Select(ctx, s.db, &posts, sql, args...)
-	//
-	// This demonstrates the power of combining Squirrel (dynamic queries)
-	// with scany (automatic result mapping)
-
-	return nil, fmt.Errorf("TODO: implement SearchPosts with Squirrel query builder")
+	query := s.BuildDynamicQuery(s.builder.Select(strings.Split(postColumns, ", ")...).From("posts"), filters)
+
+	orderBy := "created_at"
+	if postOrderFields[filters.OrderBy] {
+		orderBy = filters.OrderBy
+	}
+	orderDir := "DESC"
+	if strings.EqualFold(filters.OrderDir, "ASC") {
+		orderDir = "ASC"
+	}
+	query = query.OrderBy(orderBy+" "+orderDir, "id "+orderDir).
+		Limit(uint64(limitOrDefault(filters.Limit)))
+	if filters.Offset > 0 {
+		query = query.Offset(uint64(filters.Offset))
+	}
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var posts []models.Post
+	err = s.run(ctx, func(q dbtx) error {
+		posts = []models.Post{}
+		return sqlscan.Select(ctx, q, &posts, sql, args...)
+	})
+	return posts, err
 }
 
-// TODO: Implement SearchUsers method using Squirrel
+// SearchUsers returns the users that are not deleted whose name contains
+// nameQuery, ignoring case, ordered by name
 func (s *SearchService) SearchUsers(ctx context.Context, nameQuery string, limit int) ([]models.User, error) {
-	// TODO: Build user search query with Squirrel
-	// query := s.psql.Select("id", "name", "email", "created_at", "updated_at").
-	//              From("users").
-	//              Where(squirrel.Like{"name": "%" + nameQuery + "%"}).
-	//              OrderBy("name").
-	//              Limit(uint64(limit))
-	//
-	// sql, args, err := query.ToSql()
-	// var users []models.User
-	// err = sqlscan.Select(ctx, s.db, &users, sql, args...)
-
-	return nil, fmt.Errorf("TODO: implement SearchUsers with Squirrel")
+	query := s.builder.Select(strings.Split(userColumns, ", ")...).
+		From("users").
+		Where(s.contains("name", nameQuery)).
+		Where("deleted_at IS NULL").
+		OrderBy("name", "id").
+		Limit(uint64(limitOrDefault(limit)))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	err = s.run(ctx, func(q dbtx) error {
+		users = []models.User{}
+		return sqlscan.Select(ctx, q, &users, sql, args...)
+	})
+	return users, err
 }
 
-// TODO: Implement GetPostStats method using Squirrel with JOINs
+// GetPostStats returns statistics over the posts that are not deleted,
+// leaving out those of deleted users
 func (s *SearchService) GetPostStats(ctx context.Context) (*PostStats, error) {
-	// TODO: Build complex query with JOINs using Squirrel
-	// query := s.psql.Select(
-	//     "COUNT(p.id) as total_posts",
-	//     "COUNT(CASE WHEN p.published = true THEN 1 END) as published_posts",
-	//     "COUNT(DISTINCT p.user_id) as active_users",
-	//     "AVG(LENGTH(p.content)) as avg_content_length",
-	// ).From("posts p").
-	//   Join("users u ON p.user_id = u.id")
-	//
-	// This shows how Squirrel handles complex queries better than string building
-
-	return nil, fmt.Errorf("TODO: implement GetPostStats with Squirrel JOINs")
+	query := s.builder.Select(
+		"COUNT(p.id) AS total_posts",
+		"COUNT(CASE WHEN p.published = TRUE THEN 1 END) AS published_posts",
+		"COUNT(DISTINCT p.user_id) AS active_users",
+		"COALESCE(AVG(LENGTH(p.content)), 0) AS avg_content_length",
+	).From("posts p").
+		Join("users u ON p.user_id = u.id").
+		Where("p.deleted_at IS NULL AND u.deleted_at IS NULL")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var stats PostStats
+	err = s.run(ctx, func(q dbtx) error {
+		return sqlscan.Get(ctx, q, &stats, sql, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
 }
 
 // PostStats represents aggregated post statistics
@@ -110,54 +140,75 @@ type PostStats struct {
 	AvgContentLength float64 `db:"avg_content_length"`
 }
 
-// TODO: Implement BuildDynamicQuery helper method
+// BuildDynamicQuery adds the conditions of filters to a query of posts
 func (s *SearchService) BuildDynamicQuery(baseQuery squirrel.SelectBuilder, filters SearchFilters) squirrel.SelectBuilder {
-	// TODO: Demonstrate how to build queries step by step with Squirrel
-	//
-	// query := baseQuery
-	//
-	// if filters.Query != "" {
-	//     searchTerm := "%" + filters.Query + "%"
-	//     query = query.Where(squirrel.Or{
-	//         squirrel.ILike{"title": searchTerm},
-	//         squirrel.ILike{"content": searchTerm},
-	//     })
-	// }
-	//
-	// if filters.UserID != nil {
-	//     query = query.Where(squirrel.Eq{"user_id": *filters.UserID})
-	// }
-	//
-	// if filters.Published != nil {
-	//     query = query.Where(squirrel.Eq{"published": *filters.Published})
-	// }
-	//
-	// This modular approach makes dynamic queries much cleaner
-	// than string concatenation used in manual SQL approaches
-
-	return baseQuery
+	query := baseQuery
+
+	if filters.Query != "" {
+		query = query.Where(squirrel.Or{
+			s.contains("title", filters.Query),
+			s.contains("content", filters.Query),
+		})
+	}
+
+	if filters.UserID != nil {
+		query = query.Where(squirrel.Eq{"user_id": *filters.UserID})
+	}
+
+	if filters.Published != nil {
+		query = query.Where(squirrel.Eq{"published": *filters.Published})
+	}
+
+	if filters.MinWordCount != nil {
+		query = query.Where(squirrel.Expr(wordCount+" >= ?", *filters.MinWordCount))
+	}
+
+	if filters.CategoryID != nil {
+		query = query.Where(inCategory, *filters.CategoryID)
+	}
+
+	if !filters.IncludeDeleted {
+		query = query.Where("deleted_at IS NULL")
+	}
+
+	return query
 }
 
-// TODO: Implement GetTopUsers method using Squirrel with complex aggregation
+// GetTopUsers returns the users with the most posts, including users
+// without posts. Deleted users and posts are left out.
 func (s *SearchService) GetTopUsers(ctx context.Context, limit int) ([]UserWithStats, error) {
-	// TODO: Build complex aggregation query with Squirrel
-	// query := s.psql.Select(
-	//     "u.id",
-	//     "u.name",
-	//     "u.email",
-	//     "COUNT(p.id) as post_count",
-	//     "COUNT(CASE WHEN p.published = true THEN 1 END) as published_count",
-	//     "MAX(p.created_at) as last_post_date",
-	// ).From("users u").
-	//   LeftJoin("posts p ON u.id = p.user_id").
-	//   GroupBy("u.id", "u.name", "u.email").
-	//   OrderBy("post_count DESC").
-	//   Limit(uint64(limit))
-	//
-	// Notice how Squirrel makes complex queries more readable
-	// compared to building SQL strings manually
-
-	return nil, fmt.Errorf("TODO: implement GetTopUsers with Squirrel aggregation")
+	// Postgres returns a timestamp for MAX, SQLite the stored text
+	lastPostDate := "COALESCE(MAX(p.created_at), '')"
+	if s.dialect == database.Postgres {
+		lastPostDate = "COALESCE(MAX(p.created_at)::text, '')"
+	}
+
+	query := s.builder.Select(
+		"u.id",
+		"u.name",
+		"u.email",
+		"u.created_at",
+		"u.updated_at",
+		"COUNT(p.id) AS post_count",
+		"COUNT(CASE WHEN p.published = TRUE THEN 1 END) AS published_count",
+		lastPostDate+" AS last_post_date",
+	).From("users u").
+		LeftJoin("posts p ON u.id = p.user_id AND p.deleted_at IS NULL").
+		Where("u.deleted_at IS NULL").
+		GroupBy("u.id", "u.name", "u.email", "u.created_at", "u.updated_at").
+		OrderBy("post_count DESC", "u.id").
+		Limit(uint64(limitOrDefault(limit)))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var users []UserWithStats
+	err = s.run(ctx, func(q dbtx) error {
+		users = []UserWithStats{}
+		return sqlscan.Select(ctx, q, &users, sql, args...)
+	})
+	return users, err
 }
 
 // UserWithStats represents a user with post statistics
@@ -167,3 +218,15 @@ type UserWithStats struct {
 	PublishedCount int    `db:"published_count"`
 	LastPostDate   string `db:"last_post_date"`
 }
+
+// contains matches column values containing term, ignoring case
+func (s *SearchService) contains(column, term string) squirrel.Sqlizer {
+	return squirrel.Expr(column+" "+s.dialect.CaseInsensitiveLike()+" ?", "%"+term+"%")
+}
+
+func limitOrDefault(limit int) int {
+	if limit <= 0 {
+		return defaultSearchLimit
+	}
+	return limit
+}
@@ -1,109 +1,152 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"testing"
 
 	"lab04-backend/database"
+	"lab04-backend/models"
 )
 
 // TestSearchService tests the Squirrel query builder approach
 func TestSearchService(t *testing.T) {
-	// Initialize database for testing
-	db, err := database.InitDB()
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer database.CloseDB(db)
-
-	// Run migrations
-	if err := database.RunMigrations(db); err != nil {
-		t.Fatalf("Failed to run migrations: %v", err)
-	}
-
-	// Create service instance
-	searchService := NewSearchService(db)
-
-	// TODO: Test SearchPosts with various filters
-	t.Run("SearchPosts with filters", func(t *testing.T) {
-		// TODO: Test dynamic query building with Squirrel
-		// - Test empty filters (should return all posts)
-		// - Test search by query string
-		// - Test filter by user ID
-		// - Test filter by published status
-		// - Test pagination (limit/offset)
-		// - Test sorting (order by different fields)
-		//
-		// Example test structure:
-		// filters := SearchFilters{
-		//     Query: "golang",
-		//     Published: &[]bool{true}[0],
-		//     Limit: 10,
-		//     OrderBy: "created_at",
-		//     OrderDir: "DESC",
-		// }
-		// posts, err := searchService.SearchPosts(context.Background(), filters)
-		// assert.NoError(t, err)
-		// assert.LessOrEqual(t, len(posts), 10)
-
-		// Use searchService to avoid "declared and not used" error
-		_ = searchService
-		t.Skip("TODO: implement SearchPosts test with Squirrel filters")
-	})
-
-	// TODO: Test SearchUsers functionality
-	t.Run("SearchUsers", func(t *testing.T) {
-		// TODO: Test user search with Squirrel
-		// - Test exact name matches
-		// - Test partial name matches with LIKE
-		// - Test case insensitive search
-		// - Test limit functionality
-
-		_ = searchService
-		t.Skip("TODO: implement SearchUsers test with Squirrel")
-	})
-
-	// TODO: Test GetPostStats with complex aggregation
-	t.Run("GetPostStats", func(t *testing.T) {
-		// TODO: Test complex aggregation query
-		// - Insert test data (users and posts)
-		// - Test aggregation calculations
-		// - Verify JOIN functionality
-		// - Test with no data (empty tables)
-
-		_ = searchService
-		t.Skip("TODO: implement GetPostStats test with Squirrel JOINs")
-	})
-
-	// TODO: Test GetTopUsers with aggregation and sorting
-	t.Run("GetTopUsers", func(t *testing.T) {
-		// TODO: Test user ranking with post statistics
-		// - Insert users with different post counts
-		// - Test ordering by post count
-		// - Test LEFT JOIN behavior (users with no posts)
-		// - Test limit functionality
-
-		_ = searchService
-		t.Skip("TODO: implement GetTopUsers test with Squirrel aggregation")
-	})
-
-	// TODO: Test BuildDynamicQuery helper
-	t.Run("BuildDynamicQuery", func(t *testing.T) {
-		// TODO: Test query building step by step
-		// - Test with different filter combinations
-		// - Verify generated SQL syntax
-		// - Test parameter binding
-		//
-		// Example:
-		// baseQuery := searchService.psql.Select("*").From("posts")
-		// filters := SearchFilters{Query: "test", Published: &[]bool{true}[0]}
-		// query := searchService.BuildDynamicQuery(baseQuery, filters)
-		// sql, args, err := query.ToSql()
-		// assert.NoError(t, err)
-		// assert.Contains(t, sql, "WHERE")
-		// assert.Contains(t, sql, "published")
-
-		_ = searchService
-		t.Skip("TODO: implement BuildDynamicQuery test")
+	forEachDatabase(t, func(t *testing.T, db *sql.DB) {
+		users := NewUserRepository(db)
+		posts := NewPostRepository(db)
+		searchService := NewSearchService(db)
+		ctx := context.Background()
+
+		alice, _ := users.Create(ctx, &models.CreateUserRequest{Name: "Alice Smith", Email: "alice@example.com"})
+		bob, _ := users.Create(ctx, &models.CreateUserRequest{Name: "Bob Jones", Email: "bob@example.com"})
+		users.Create(ctx, &models.CreateUserRequest{Name: "Carol White", Email: "carol@example.com"})
+		for _, req := range []models.CreatePostRequest{
+			{UserID: alice.ID, Title: "Learning Golang", Content: "golang is fun to write", Published: true},
+			{UserID: alice.ID, Title: "Draft about SQL", Content: "joins"},
+			{UserID: bob.ID, Title: "GOLANG generics", Content: "type parameters", Published: true},
+		} {
+			if _, err := posts.Create(ctx, &req); err != nil {
+				t.Fatalf("Failed to create post: %v", err)
+			}
+		}
+
+		t.Run("SearchPosts with filters", func(t *testing.T) {
+			titles := func(filters SearchFilters) []string {
+				t.Helper()
+				found, err := searchService.SearchPosts(ctx, filters)
+				if err != nil {
+					t.Fatalf("SearchPosts() failed: %v", err)
+				}
+				var titles []string
+				for _, post := range found {
+					titles = append(titles, post.Title)
+				}
+				return titles
+			}
+			published := true
+			minWords := 4
+
+			tests := []struct {
+				name    string
+				filters SearchFilters
+				want    string
+			}{
+				{"no filters", SearchFilters{}, "[GOLANG generics Draft about SQL Learning Golang]"},
+				{"query ignores case", SearchFilters{Query: "golang", OrderBy: "title", OrderDir: "asc"}, "[GOLANG generics Learning Golang]"},
+				{"user", SearchFilters{UserID: &alice.ID}, "[Draft about SQL Learning Golang]"},
+				{"published", SearchFilters{Published: &published, OrderBy: "title", OrderDir: "ASC"}, "[GOLANG generics Learning Golang]"},
+				{"word count", SearchFilters{MinWordCount: &minWords}, "[Learning Golang]"},
+				{"pagination", SearchFilters{Limit: 1, Offset: 1}, "[Draft about SQL]"},
+				{"unknown order falls back", SearchFilters{OrderBy: "id; DROP TABLE posts", Limit: 1}, "[GOLANG generics]"},
+			}
+			for _, tt := range tests {
+				if got := fmt.Sprint(titles(tt.filters)); got != tt.want {
+					t.Errorf("%s: SearchPosts() = %v, want %v", tt.name, got, tt.want)
+				}
+			}
+		})
+
+		t.Run("SearchUsers", func(t *testing.T) {
+			found, err := searchService.SearchUsers(ctx, "o", 2)
+			if err != nil {
+				t.Fatalf("SearchUsers() failed: %v", err)
+			}
+			if len(found) != 2 || found[0].Name != "Bob Jones" || found[1].Name != "Carol White" {
+				t.Errorf("SearchUsers() = %+v, want Bob and Carol", found)
+			}
+			if found, _ := searchService.SearchUsers(ctx, "ALICE", 0); len(found) != 1 || found[0].ID != alice.ID {
+				t.Errorf("SearchUsers() should ignore case, got %+v", found)
+			}
+		})
+
+		t.Run("GetPostStats", func(t *testing.T) {
+			stats, err := searchService.GetPostStats(ctx)
+			if err != nil {
+				t.Fatalf("GetPostStats() failed: %v", err)
+			}
+			want := PostStats{TotalPosts: 3, PublishedPosts: 2, ActiveUsers: 2, AvgContentLength: 14}
+			if *stats != want {
+				t.Errorf("GetPostStats() = %+v, want %+v", *stats, want)
+			}
+		})
+
+		t.Run("GetTopUsers", func(t *testing.T) {
+			top, err := searchService.GetTopUsers(ctx, 10)
+			if err != nil {
+				t.Fatalf("GetTopUsers() failed: %v", err)
+			}
+			if len(top) != 3 {
+				t.Fatalf("GetTopUsers() returned %d users, want 3", len(top))
+			}
+			if top[0].ID != alice.ID || top[0].PostCount != 2 || top[0].PublishedCount != 1 || top[0].LastPostDate == "" {
+				t.Errorf("GetTopUsers() first = %+v", top[0])
+			}
+			if top[2].Name != "Carol White" || top[2].PostCount != 0 || top[2].LastPostDate != "" {
+				t.Errorf("GetTopUsers() should include users without posts, got %+v", top[2])
+			}
+		})
+
+		t.Run("BuildDynamicQuery", func(t *testing.T) {
+			published := true
+			query := searchService.BuildDynamicQuery(searchService.builder.Select("*").From("posts"),
+				SearchFilters{Query: "test", UserID: &alice.ID, Published: &published})
+			sql, args, err := query.ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() failed: %v", err)
+			}
+			want := "SELECT * FROM posts WHERE (title LIKE ? OR content LIKE ?) AND user_id = ? AND published = ? AND deleted_at IS NULL"
+			if searchService.dialect == database.Postgres {
+				want = "SELECT * FROM posts WHERE (title ILIKE $1 OR content ILIKE $2) AND user_id = $3 AND published = $4 AND deleted_at IS NULL"
+			}
+			if sql != want {
+				t.Errorf("ToSql() = %q, want %q", sql, want)
+			}
+			if len(args) != 4 || args[0] != "%test%" {
+				t.Errorf("ToSql() args = %v", args)
+			}
+		})
+
+		t.Run("Soft deleted rows", func(t *testing.T) {
+			if err := users.Delete(ctx, bob.ID); err != nil {
+				t.Fatalf("Failed to delete user: %v", err)
+			}
+			if found, _ := searchService.SearchPosts(ctx, SearchFilters{Query: "generics"}); len(found) != 0 {
+				t.Errorf("SearchPosts() = %+v, want no deleted posts", found)
+			}
+			if found, _ := searchService.SearchPosts(ctx, SearchFilters{Query: "generics", IncludeDeleted: true}); len(found) != 1 {
+				t.Errorf("SearchPosts(IncludeDeleted) = %+v, want the deleted post", found)
+			}
+			if found, _ := searchService.SearchUsers(ctx, "bob", 0); len(found) != 0 {
+				t.Errorf("SearchUsers() = %+v, want no deleted users", found)
+			}
+			if stats, _ := searchService.GetPostStats(ctx); stats == nil || stats.TotalPosts != 2 || stats.ActiveUsers != 1 {
+				t.Errorf("GetPostStats() = %+v, want the posts of Alice only", stats)
+			}
+			if top, _ := searchService.GetTopUsers(ctx, 10); len(top) != 2 {
+				t.Errorf("GetTopUsers() = %+v, want Alice and Carol", top)
+			}
+		})
 	})
 }
 
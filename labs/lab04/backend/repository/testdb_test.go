@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lab04-backend/database"
+)
+
+// postgresDSNEnv names the variable pointing the tests to a Postgres
+// server, such as the one of docker-compose that make test-postgres
+// starts. Postgres tests are skipped without it.
+const postgresDSNEnv = "LAB04_POSTGRES_DSN"
+
+// forEachDatabase runs test against a fresh, migrated database of every
+// driver
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *sql.DB)) {
+	t.Run(database.DriverSQLite, func(t *testing.T) {
+		test(t, openTestDB(t, &database.Config{
+			Driver:       database.DriverSQLite,
+			DatabasePath: filepath.Join(t.TempDir(), "test.db"),
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		}))
+	})
+	t.Run(database.DriverPostgres, func(t *testing.T) {
+		dsn := os.Getenv(postgresDSNEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set, skipping Postgres tests", postgresDSNEnv)
+		}
+		test(t, openTestDB(t, &database.Config{
+			Driver:       database.DriverPostgres,
+			DSN:          withTestSchema(t, dsn),
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		}))
+	})
+}
+
+// openTestDB opens and migrates a database that is closed when the test
+// ends
+func openTestDB(t *testing.T, config *database.Config) *sql.DB {
+	t.Helper()
+	db, err := database.InitDBWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { database.CloseDB(db) })
+
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	return db
+}
+
+// withTestSchema creates a schema that is dropped when the test ends and
+// returns dsn with it as the search path, so tests start empty and do not
+// see each other's tables
+func withTestSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	admin, err := sql.Open(database.DriverPostgres, dsn)
+	if err != nil {
+		t.Fatalf("Failed to open Postgres: %v", err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("lab04_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if admin, err := sql.Open(database.DriverPostgres, dsn); err == nil {
+			admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			admin.Close()
+		}
+	})
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("Invalid %s: %v", postgresDSNEnv, err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
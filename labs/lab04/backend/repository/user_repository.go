@@ -1,82 +1,199 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"strings"
+	"time"
 
+	"lab04-backend/database"
 	"lab04-backend/models"
 )
 
+// userColumns are the columns read by models.User.ScanRow
+const userColumns = "id, name, email, created_at, updated_at, deleted_at"
+
 // UserRepository handles database operations for users
 // This repository demonstrates MANUAL SQL approach with database/sql package
 type UserRepository struct {
-	db *sql.DB
+	conn
+	// Lockout applies to VerifyCredentials
+	Lockout LockoutPolicy
 }
 
-// NewUserRepository creates a new UserRepository
+// NewUserRepository creates a new UserRepository with DefaultRetryPolicy
+// and DefaultLockoutPolicy
 func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+	return &UserRepository{conn: newConn(db), Lockout: DefaultLockoutPolicy}
+}
+
+// Create inserts a user and returns it with its ID and timestamps, or
+// ErrEmailTaken. The email of a soft deleted user may be registered again.
+func (r *UserRepository) Create(ctx context.Context, req *models.CreateUserRequest) (*models.User, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := now()
+	query := r.dialect.Rebind(`
+		INSERT INTO users (name, email, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING ` + userColumns)
+
+	var user models.User
+	err := r.run(ctx, func(q dbtx) error {
+		return user.ScanRow(q.QueryRowContext(ctx, query, req.Name, req.Email, now, now))
+	})
+	if database.IsUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetByID returns sql.ErrNoRows if the user does not exist or is deleted
+func (r *UserRepository) GetByID(ctx context.Context, id int, opts ...QueryOption) (*models.User, error) {
+	return r.getBy(ctx, "id", id, opts)
 }
 
-// TODO: Implement Create method
-func (r *UserRepository) Create(req *models.CreateUserRequest) (*models.User, error) {
-	// TODO: Create a new user in the database
-	// - Validate the request
-	// - Insert into users table
-	// - Return the created user with ID and timestamps
-	// Use RETURNING clause to get the generated ID and timestamps
-	return nil, fmt.Errorf("TODO: implement Create method")
+// GetByEmail returns sql.ErrNoRows if no user that is not deleted has the
+// email. Including deleted users, it prefers the user registered with the
+// email now and then the one deleted last.
+func (r *UserRepository) GetByEmail(ctx context.Context, email string, opts ...QueryOption) (*models.User, error) {
+	return r.getBy(ctx, "email", email, opts)
 }
 
-// TODO: Implement GetByID method
-func (r *UserRepository) GetByID(id int) (*models.User, error) {
-	// TODO: Get user by ID from database
-	// - Query users table by ID
-	// - Return user or sql.ErrNoRows if not found
-	// - Handle scanning properly
-	return nil, fmt.Errorf("TODO: implement GetByID method")
+// getBy returns the user whose column has value
+func (r *UserRepository) getBy(ctx context.Context, column string, value interface{}, opts []QueryOption) (*models.User, error) {
+	query := r.dialect.Rebind(`SELECT ` + userColumns + ` FROM users ` + whereClause(opts, column+` = ?`) + `
+		ORDER BY deleted_at IS NOT NULL, deleted_at DESC, id DESC
+		LIMIT 1`)
+
+	var user models.User
+	err := r.run(ctx, func(q dbtx) error {
+		return user.ScanRow(q.QueryRowContext(ctx, query, value))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetAll returns the users that are not deleted in the order they were
+// created
+func (r *UserRepository) GetAll(ctx context.Context, opts ...QueryOption) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users ` + whereClause(opts) + ` ORDER BY created_at, id`
+
+	var users []models.User
+	err := r.run(ctx, func(q dbtx) error {
+		rows, err := q.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		users, err = models.ScanUsers(rows)
+		return err
+	})
+	return users, err
 }
 
-// TODO: Implement GetByEmail method
-func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
-	// TODO: Get user by email from database
-	// - Query users table by email
-	// - Return user or sql.ErrNoRows if not found
-	// - Handle scanning properly
-	return nil, fmt.Errorf("TODO: implement GetByEmail method")
+// Update changes the fields of req that are set and returns the user. It
+// returns sql.ErrNoRows if the user does not exist or is deleted, and
+// ErrEmailTaken.
+func (r *UserRepository) Update(ctx context.Context, id int, req *models.UpdateUserRequest) (*models.User, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	var set []string
+	var args []interface{}
+	if req.Name != nil {
+		set = append(set, "name = ?")
+		args = append(args, *req.Name)
+	}
+	if req.Email != nil {
+		set = append(set, "email = ?")
+		args = append(args, *req.Email)
+	}
+	set = append(set, "updated_at = ?")
+	args = append(args, now(), id)
+
+	query := r.dialect.Rebind(`UPDATE users SET ` + strings.Join(set, ", ") + ` WHERE id = ? AND deleted_at IS NULL RETURNING ` + userColumns)
+
+	var user models.User
+	err := r.run(ctx, func(q dbtx) error {
+		return user.ScanRow(q.QueryRowContext(ctx, query, args...))
+	})
+	if database.IsUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
-// TODO: Implement GetAll method
-func (r *UserRepository) GetAll() ([]models.User, error) {
-	// TODO: Get all users from database
-	// - Query all users ordered by created_at
-	// - Return slice of users
-	// - Handle empty result properly
-	return nil, fmt.Errorf("TODO: implement GetAll method")
+// Delete soft deletes a user and their posts, or returns sql.ErrNoRows if
+// the user does not exist or is already deleted
+func (r *UserRepository) Delete(ctx context.Context, id int) error {
+	now := now()
+	return r.atomic(ctx, func(q dbtx) error {
+		result, err := q.ExecContext(ctx, r.dialect.Rebind(`
+			UPDATE users SET deleted_at = ?, updated_at = ?
+			WHERE id = ? AND deleted_at IS NULL`), now, now, id)
+		if err != nil {
+			return err
+		}
+		if err := expectAffected(result); err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, r.dialect.Rebind(`
+			UPDATE posts SET deleted_at = ?, updated_at = ?
+			WHERE user_id = ? AND deleted_at IS NULL`), now, now, id)
+		return err
+	})
 }
 
-// TODO: Implement Update method
-func (r *UserRepository) Update(id int, req *models.UpdateUserRequest) (*models.User, error) {
-	// TODO: Update user in database
-	// - Build dynamic UPDATE query based on non-nil fields in req
-	// - Update updated_at timestamp
-	// - Return updated user
-	// - Handle case where user doesn't exist
-	return nil, fmt.Errorf("TODO: implement Update method")
+// Restore undoes Delete for the user and the posts deleted along with them,
+// but not the ones deleted before. It returns the user, sql.ErrNoRows if
+// the user does not exist or is not deleted, or ErrEmailTaken if their
+// email was registered again meanwhile.
+func (r *UserRepository) Restore(ctx context.Context, id int) (*models.User, error) {
+	now := now()
+	var user models.User
+	err := r.atomic(ctx, func(q dbtx) error {
+		_, err := q.ExecContext(ctx, r.dialect.Rebind(`
+			UPDATE posts SET deleted_at = NULL, updated_at = ?
+			WHERE user_id = ? AND deleted_at = (SELECT deleted_at FROM users WHERE id = ?)`), now, id, id)
+		if err != nil {
+			return err
+		}
+		return user.ScanRow(q.QueryRowContext(ctx, r.dialect.Rebind(`
+			UPDATE users SET deleted_at = NULL, updated_at = ?
+			WHERE id = ? AND deleted_at IS NOT NULL
+			RETURNING `+userColumns), now, id))
+	})
+	if database.IsUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
-// TODO: Implement Delete method
-func (r *UserRepository) Delete(id int) error {
-	// TODO: Delete user from database
-	// - Delete from users table by ID
-	// - Return error if user doesn't exist
-	// - Consider cascading deletes for posts
-	return fmt.Errorf("TODO: implement Delete method")
+// Purge permanently removes the users deleted more than olderThan ago,
+// along with all their posts, and returns how many users it removed
+func (r *UserRepository) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	return r.purge(ctx, "users", olderThan)
 }
 
-// TODO: Implement Count method
-func (r *UserRepository) Count() (int, error) {
-	// TODO: Count total number of users
-	// - Return count of users in database
-	return 0, fmt.Errorf("TODO: implement Count method")
+// Count returns the number of users that are not deleted
+func (r *UserRepository) Count(ctx context.Context, opts ...QueryOption) (int, error) {
+	var count int
+	err := r.run(ctx, func(q dbtx) error {
+		return q.QueryRowContext(ctx, `SELECT COUNT(*) FROM users `+whereClause(opts)).Scan(&count)
+	})
+	return count, err
 }
@@ -0,0 +1,38 @@
+package calculator
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsInterceptor counts gRPC calls per calculator method and status
+// code and records their latency
+func MetricsInterceptor(reg prometheus.Registerer) grpc.UnaryServerInterceptor {
+	calls := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "calculator_grpc_calls_total",
+		Help: "gRPC calls handled by the calculator, by method and status code.",
+	}, []string{"method", "code"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calculator_grpc_call_duration_seconds",
+		Help:    "Latency of calculator gRPC calls, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	reg.MustRegister(calls, duration)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		// FullMethod looks like /calculator.Calculator/Add
+		method := path.Base(info.FullMethod)
+		calls.WithLabelValues(method, status.Code(err).String()).Inc()
+		duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+		return resp, err
+	}
+}
@@ -0,0 +1,41 @@
+package calculator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pb "lab06-backend/proto"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	interceptor := MetricsInterceptor(registry)
+	service := NewService()
+
+	call := func(method string, handler grpc.UnaryHandler, req interface{}) {
+		info := &grpc.UnaryServerInfo{FullMethod: "/calculator.Calculator/" + method}
+		interceptor(context.Background(), req, info, handler)
+	}
+
+	divide := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return service.Divide(ctx, req.(*pb.OperationRequest))
+	}
+
+	call("Divide", divide, &pb.OperationRequest{A: 6, B: 3})
+	call("Divide", divide, &pb.OperationRequest{A: 6, B: 0})
+
+	expected := `
+# HELP calculator_grpc_calls_total gRPC calls handled by the calculator, by method and status code.
+# TYPE calculator_grpc_calls_total counter
+calculator_grpc_calls_total{code="InvalidArgument",method="Divide"} 1
+calculator_grpc_calls_total{code="OK",method="Divide"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "calculator_grpc_calls_total"); err != nil {
+		t.Error(err)
+	}
+}
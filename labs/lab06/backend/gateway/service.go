@@ -8,16 +8,39 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics/muxroute"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem/muxproblem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/requestid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	pb "lab06-backend/proto"
 )
 
+// defaultCORS allows any origin, which is what the lab frontend expects
+// during development
+var defaultCORS = cors.MustNew(cors.Options{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+	AllowedHeaders: []string{"Content-Type", "Authorization", "Accept", "Origin", "X-Requested-With", requestid.Header},
+	ExposedHeaders: []string{"Content-Length", requestid.Header, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+})
+
 // Service represents the HTTP gateway service
 type Service struct {
 	calculatorClient pb.CalculatorClient
 	router           *mux.Router
+	cors             *cors.Policy
+	registry         *prometheus.Registry
+	httpMetrics      *metrics.HTTP
+	limiter          *ratelimit.Limiter
 }
 
 // OperationRequest represents HTTP request format
@@ -68,31 +91,23 @@ func NewService(calculatorAddr string) (*Service, error) {
 
 // setupRoutes configures HTTP routes
 func (s *Service) setupRoutes() {
-	// Enable CORS middleware for all requests
-	s.router.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Set CORS headers for all requests
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Origin, X-Requested-With")
-			w.Header().Set("Access-Control-Expose-Headers", "Content-Length")
-
-			// Handle preflight OPTIONS requests
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	})
+	if s.registry == nil {
+		s.SetMetrics(metrics.NewRegistry())
+	}
 
-	api := s.router.PathPrefix("/api/v1").Subrouter()
+	// Unmatched requests get problem details like handler errors
+	muxproblem.Install(s.router)
 
-	// Add explicit OPTIONS handler for all routes
-	api.HandleFunc("/calculate/{operation}", s.handleOptions).Methods("OPTIONS")
-	api.HandleFunc("/history", s.handleOptions).Methods("OPTIONS")
-	api.HandleFunc("/health", s.handleOptions).Methods("OPTIONS")
+	// Enable request ID, metrics and CORS middleware for all requests
+	s.router.Use(requestid.Middleware)
+	s.router.Use(s.metricsMiddleware)
+	s.router.Use(s.corsMiddleware)
+	s.router.Use(s.rateLimitMiddleware)
+
+	// Prometheus metrics
+	s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+
+	api := s.router.PathPrefix("/api/v1").Subrouter()
 
 	// Regular API routes
 	api.HandleFunc("/calculate/add", s.handleAdd).Methods("POST")
@@ -101,6 +116,60 @@ func (s *Service) setupRoutes() {
 	api.HandleFunc("/calculate/divide", s.handleDivide).Methods("POST")
 	api.HandleFunc("/history", s.handleHistory).Methods("GET")
 	api.HandleFunc("/health", s.handleHealth).Methods("GET")
+
+	// mux only runs middleware for matched routes, so preflight requests
+	// need a route of their own to reach the CORS policy. A method matcher
+	// would turn every unknown path into 405 Method Not Allowed.
+	s.router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return r.Method == http.MethodOptions
+	}).HandlerFunc(s.handleOptions)
+}
+
+// SetCORSPolicy replaces the default allow-all CORS policy
+func (s *Service) SetCORSPolicy(policy *cors.Policy) {
+	s.cors = policy
+}
+
+// SetMetrics records the gateway metrics in reg, which may be shared with
+// the other services of the process
+func (s *Service) SetMetrics(reg *prometheus.Registry) {
+	s.registry = reg
+	s.httpMetrics = metrics.NewHTTP(reg, "gateway")
+}
+
+// metricsMiddleware records request metrics per route template
+func (s *Service) metricsMiddleware(next http.Handler) http.Handler {
+	return s.httpMetrics.Middleware(muxroute.Template)(next)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (s *Service) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Handler(s.registry).ServeHTTP(w, r)
+}
+
+// corsMiddleware applies the configured CORS policy
+func (s *Service) corsMiddleware(next http.Handler) http.Handler {
+	policy := s.cors
+	if policy == nil {
+		policy = defaultCORS
+	}
+	return policy.Handler(next)
+}
+
+// SetRateLimiter limits requests with limiter. Requests are not limited by
+// default.
+func (s *Service) SetRateLimiter(limiter *ratelimit.Limiter) {
+	s.limiter = limiter
+}
+
+// rateLimitMiddleware applies the rate limiter, if one is set
+func (s *Service) rateLimitMiddleware(next http.Handler) http.Handler {
+	if s.limiter == nil {
+		return next
+	}
+	return s.limiter.Middleware(muxroute.Template, func(w http.ResponseWriter, r *http.Request, res *ratelimit.Result) {
+		problem.Write(w, r, problem.RateLimited("Rate limit exceeded"))
+	})(next)
 }
 
 // GetRouter returns the HTTP router
@@ -112,7 +181,7 @@ func (s *Service) GetRouter() *mux.Router {
 func (s *Service) handleAdd(w http.ResponseWriter, r *http.Request) {
 	var req OperationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation("Invalid request body"))
 		return
 	}
 
@@ -121,18 +190,18 @@ func (s *Service) handleAdd(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.calculatorClient.Add(ctx, &pb.OperationRequest{A: req.A, B: req.B})
 	if err != nil {
-		http.Error(w, "Calculator service error", http.StatusInternalServerError)
+		problem.Write(w, r, calculatorError(err))
 		return
 	}
 
-	s.writeResponse(w, resp)
+	s.writeResponse(w, r, resp)
 }
 
 // handleSubtract handles subtraction requests
 func (s *Service) handleSubtract(w http.ResponseWriter, r *http.Request) {
 	var req OperationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation("Invalid request body"))
 		return
 	}
 
@@ -141,18 +210,18 @@ func (s *Service) handleSubtract(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.calculatorClient.Subtract(ctx, &pb.OperationRequest{A: req.A, B: req.B})
 	if err != nil {
-		http.Error(w, "Calculator service error", http.StatusInternalServerError)
+		problem.Write(w, r, calculatorError(err))
 		return
 	}
 
-	s.writeResponse(w, resp)
+	s.writeResponse(w, r, resp)
 }
 
 // handleMultiply handles multiplication requests
 func (s *Service) handleMultiply(w http.ResponseWriter, r *http.Request) {
 	var req OperationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation("Invalid request body"))
 		return
 	}
 
@@ -161,18 +230,18 @@ func (s *Service) handleMultiply(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.calculatorClient.Multiply(ctx, &pb.OperationRequest{A: req.A, B: req.B})
 	if err != nil {
-		http.Error(w, "Calculator service error", http.StatusInternalServerError)
+		problem.Write(w, r, calculatorError(err))
 		return
 	}
 
-	s.writeResponse(w, resp)
+	s.writeResponse(w, r, resp)
 }
 
 // handleDivide handles division requests
 func (s *Service) handleDivide(w http.ResponseWriter, r *http.Request) {
 	var req OperationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		problem.Write(w, r, problem.Validation("Invalid request body"))
 		return
 	}
 
@@ -180,22 +249,18 @@ func (s *Service) handleDivide(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	resp, err := s.calculatorClient.Divide(ctx, &pb.OperationRequest{A: req.A, B: req.B})
-
-	// Handle division by zero gracefully
+	// The calculator rejects a zero divisor as an invalid argument
+	if status.Code(err) == codes.InvalidArgument {
+		problem.Write(w, r, problem.Validation("division by zero",
+			problem.FieldError{In: "body", Field: "b", Message: "must not be zero"}))
+		return
+	}
 	if err != nil {
-		errorResp := &OperationResponse{
-			Result:    0,
-			Operation: "divide",
-			Success:   false,
-			Error:     "division by zero",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResp)
+		problem.Write(w, r, calculatorError(err))
 		return
 	}
 
-	s.writeResponse(w, resp)
+	s.writeResponse(w, r, resp)
 }
 
 // handleHistory handles history requests
@@ -214,7 +279,7 @@ func (s *Service) handleHistory(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.calculatorClient.GetHistory(ctx, &pb.HistoryRequest{Limit: limit})
 	if err != nil {
-		http.Error(w, "Calculator service error", http.StatusInternalServerError)
+		problem.Write(w, r, calculatorError(err))
 		return
 	}
 
@@ -248,14 +313,20 @@ func (s *Service) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
-// handleOptions handles CORS preflight OPTIONS requests
+// handleOptions handles OPTIONS requests that are not CORS preflights,
+// preflights are answered by the CORS middleware
 func (s *Service) handleOptions(w http.ResponseWriter, r *http.Request) {
-	// CORS headers are already set by middleware
 	w.WriteHeader(http.StatusOK)
 }
 
-// writeResponse writes a gRPC response as HTTP JSON
-func (s *Service) writeResponse(w http.ResponseWriter, resp *pb.OperationResponse) {
+// writeResponse writes a gRPC response as HTTP JSON, failed operations as
+// problem details
+func (s *Service) writeResponse(w http.ResponseWriter, r *http.Request, resp *pb.OperationResponse) {
+	if !resp.Success {
+		problem.Write(w, r, problem.Validation(resp.Error))
+		return
+	}
+
 	httpResp := &OperationResponse{
 		Result:    resp.Result,
 		Operation: resp.Operation,
@@ -264,10 +335,14 @@ func (s *Service) writeResponse(w http.ResponseWriter, resp *pb.OperationRespons
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(httpResp)
+}
 
-	if !resp.Success {
-		w.WriteHeader(http.StatusBadRequest)
+// calculatorError maps a failed call to the calculator service to the
+// problem reported to clients
+func calculatorError(err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() == codes.InvalidArgument {
+		return problem.Validation(st.Message())
 	}
-
-	json.NewEncoder(w).Encode(httpResp)
+	return problem.Unavailable("Calculator service error", err)
 }
@@ -6,11 +6,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	pb "lab06-backend/proto"
 
 	"github.com/gorilla/mux"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/problem"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -191,15 +196,56 @@ func TestService_HandleDivideByZero(t *testing.T) {
 		t.Errorf("Expected status 400, got %d", rr.Code)
 	}
 
-	var resp OperationResponse
+	var resp problem.Problem
 	json.NewDecoder(rr.Body).Decode(&resp)
 
-	if resp.Success {
-		t.Error("Expected success to be false for division by zero")
+	if resp.Type != problem.KindValidation.Type() {
+		t.Errorf("Expected a validation problem, got '%s'", resp.Type)
+	}
+
+	if resp.Detail != "division by zero" {
+		t.Errorf("Expected detail 'division by zero', got '%s'", resp.Detail)
 	}
 
-	if resp.Error != "division by zero" {
-		t.Errorf("Expected error 'division by zero', got '%s'", resp.Error)
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "b" {
+		t.Errorf("Expected an error for field b, got %v", resp.Errors)
+	}
+}
+
+func TestService_ProblemDetails(t *testing.T) {
+	service := &Service{
+		calculatorClient: &MockCalculatorClient{shouldError: true},
+		router:           mux.NewRouter(),
+	}
+	service.setupRoutes()
+
+	tests := []struct {
+		method, path, body string
+		kind               problem.Kind
+	}{
+		{"POST", "/api/v1/calculate/add", `{"a":1,"b":2}`, problem.KindUnavailable},
+		{"GET", "/api/v1/history", "", problem.KindUnavailable},
+		{"POST", "/api/v1/calculate/add", `{"a":`, problem.KindValidation},
+		{"GET", "/api/v1/unknown", "", problem.KindNotFound},
+		{"GET", "/api/v1/calculate/add", "", problem.KindMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("X-Request-ID", "req-1")
+		rr := httptest.NewRecorder()
+		service.GetRouter().ServeHTTP(rr, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+			t.Errorf("%s %s: expected Content-Type %s, got %s", tt.method, tt.path, problem.ContentType, ct)
+		}
+		var p problem.Problem
+		json.NewDecoder(rr.Body).Decode(&p)
+		if rr.Code != tt.kind.Status() || p.Type != tt.kind.Type() || p.RequestID != "req-1" {
+			t.Errorf("%s %s: expected %s, got %d %+v", tt.method, tt.path, tt.kind, rr.Code, p)
+		}
+		if strings.Contains(p.Detail, "mock error") {
+			t.Errorf("%s %s: expected the upstream error to stay hidden, got %q", tt.method, tt.path, p.Detail)
+		}
 	}
 }
 
@@ -253,6 +299,106 @@ func TestService_HandleHealth(t *testing.T) {
 	}
 }
 
+func TestService_CORSPreflight(t *testing.T) {
+	service := createTestService()
+
+	req := httptest.NewRequest("OPTIONS", "/api/v1/calculate/add", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	rr := httptest.NewRecorder()
+
+	service.GetRouter().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rr.Code)
+	}
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Expected any origin to be allowed, got '%s'", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	policy, err := cors.New(cors.Options{AllowedOrigins: []string{"https://app.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.SetCORSPolicy(policy)
+
+	req = httptest.NewRequest("GET", "/api/v1/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+
+	service.GetRouter().ServeHTTP(rr, req)
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected the origin to be echoed, got '%s'", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	if rr.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected Vary: Origin, got '%s'", rr.Header().Get("Vary"))
+	}
+}
+
+func TestService_Metrics(t *testing.T) {
+	service := createTestService()
+
+	req := httptest.NewRequest("GET", "/api/v1/health", nil)
+	service.GetRouter().ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	want := `http_requests_total{method="GET",route="/api/v1/health",service="gateway",status="200"} 1`
+	if !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("Expected %q in the metrics output", want)
+	}
+}
+
+func TestService_RateLimit(t *testing.T) {
+	service := createTestService()
+
+	limiter := ratelimit.New(ratelimit.NewMemoryStore())
+	err := limiter.Route("POST", "/api/v1/calculate/add", ratelimit.Policy{
+		Limit: ratelimit.Limit{Requests: 1, Window: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.SetRateLimiter(limiter)
+
+	add := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(OperationRequest{A: 1, B: 2})
+		req := httptest.NewRequest("POST", "/api/v1/calculate/add", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		service.GetRouter().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := add(); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	rr := add()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" || rr.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("Expected rate limit headers, got %v", rr.Header())
+	}
+
+	// Routes without a policy are not limited
+	req := httptest.NewRequest("GET", "/api/v1/health", nil)
+	rr = httptest.NewRecorder()
+	service.GetRouter().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for the health check, got %d", rr.Code)
+	}
+}
+
 func TestService_InvalidRequestBody(t *testing.T) {
 	service := createTestService()
 
@@ -4,8 +4,14 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/ratelimit"
 	"google.golang.org/grpc"
 
 	"lab06-backend/calculator"
@@ -15,27 +21,32 @@ import (
 )
 
 func main() {
+	corsPolicy := loadCORSPolicy()
+
+	// All services share one registry, each HTTP service serves it on /metrics
+	registry := metrics.NewRegistry()
+
 	var wg sync.WaitGroup
 
 	// Start gRPC Calculator Service
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		startCalculatorService()
+		startCalculatorService(registry)
 	}()
 
 	// Start Gateway HTTP Service
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		startGatewayService()
+		startGatewayService(corsPolicy, registry)
 	}()
 
 	// Start WebSocket Service
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		startWebSocketService()
+		startWebSocketService(corsPolicy, registry)
 	}()
 
 	log.Println("All services started successfully!")
@@ -47,13 +58,13 @@ func main() {
 }
 
 // startCalculatorService starts the gRPC calculator service
-func startCalculatorService() {
+func startCalculatorService(registry prometheus.Registerer) {
 	lis, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		log.Fatalf("Failed to listen on :50051: %v", err)
 	}
 
-	server := grpc.NewServer()
+	server := grpc.NewServer(grpc.UnaryInterceptor(calculator.MetricsInterceptor(registry)))
 	calculatorService := calculator.NewService()
 
 	pb.RegisterCalculatorServer(server, calculatorService)
@@ -65,11 +76,16 @@ func startCalculatorService() {
 }
 
 // startGatewayService starts the HTTP gateway service
-func startGatewayService() {
+func startGatewayService(corsPolicy *cors.Policy, registry *prometheus.Registry) {
 	gatewayService, err := gateway.NewService("localhost:50051")
 	if err != nil {
 		log.Fatalf("Failed to create gateway service: %v", err)
 	}
+	gatewayService.SetCORSPolicy(corsPolicy)
+	gatewayService.SetMetrics(registry)
+	if limiter := loadRateLimiter(); limiter != nil {
+		gatewayService.SetRateLimiter(limiter)
+	}
 
 	server := &http.Server{
 		Addr:    ":8080",
@@ -83,32 +99,22 @@ func startGatewayService() {
 }
 
 // startWebSocketService starts the WebSocket service
-func startWebSocketService() {
+func startWebSocketService(corsPolicy *cors.Policy, registry *prometheus.Registry) {
 	wsServiceInstance := wsService.NewService()
+	wsServiceInstance.SetCORSPolicy(corsPolicy)
+	if err := wsServiceInstance.RegisterMetrics(registry); err != nil {
+		log.Fatalf("Failed to register WebSocket metrics: %v", err)
+	}
+	httpMetrics := metrics.NewHTTP(registry, "websocket")
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", wsServiceInstance.GetHandler())
-	mux.HandleFunc("/stats", wsServiceInstance.GetStatsHandler())
-
-	// Add CORS middleware
-	corsHandler := func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
+	mux.Handle("/ws", httpMetrics.Wrap("/ws", wsServiceInstance.GetHandler()))
+	mux.Handle("/stats", httpMetrics.Wrap("/stats", wsServiceInstance.GetStatsHandler()))
+	mux.Handle("/metrics", metrics.Handler(registry))
 
 	server := &http.Server{
 		Addr:    ":8081",
-		Handler: corsHandler(mux),
+		Handler: corsPolicy.Handler(mux),
 	}
 
 	log.Println("WebSocket service starting on :8081")
@@ -116,3 +122,49 @@ func startWebSocketService() {
 		log.Fatalf("Failed to serve WebSocket service: %v", err)
 	}
 }
+
+// loadCORSPolicy builds the CORS policy shared by the HTTP services. All
+// origins are allowed unless CORS_ORIGINS lists specific ones, e.g.
+// CORS_ORIGINS=http://localhost:3000,https://*.example.com
+func loadCORSPolicy() *cors.Policy {
+	origins := []string{"*"}
+	if value := os.Getenv("CORS_ORIGINS"); value != "" {
+		origins = strings.Split(value, ",")
+	}
+
+	policy, err := cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "Accept", "Origin", "X-Requested-With"},
+		ExposedHeaders: []string{"Content-Length"},
+	})
+	if err != nil {
+		log.Fatalf("Invalid CORS_ORIGINS: %v", err)
+	}
+	return policy
+}
+
+// loadRateLimiter limits each gateway client, identified by API key or
+// address, when RATE_LIMIT is set, e.g. RATE_LIMIT=100/1m
+func loadRateLimiter() *ratelimit.Limiter {
+	spec := os.Getenv("RATE_LIMIT")
+	if spec == "" {
+		return nil
+	}
+
+	limit, err := ratelimit.ParseLimit(spec)
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT: %v", err)
+	}
+
+	limiter := ratelimit.New(ratelimit.NewMemoryStore())
+	limiter.Exempt("/metrics", "/api/v1/health")
+	err = limiter.SetDefault(ratelimit.Policy{
+		Limit: limit,
+		Key:   ratelimit.FirstOf(ratelimit.ByAPIKey("X-API-Key"), ratelimit.ByIP),
+	})
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT: %v", err)
+	}
+	return limiter
+}
@@ -8,14 +8,10 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/cors"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
-}
-
 // Message represents a WebSocket message
 type Message struct {
 	Type      string    `json:"type"`
@@ -46,7 +42,8 @@ type Hub struct {
 
 // Service represents the WebSocket service
 type Service struct {
-	hub *Hub
+	hub  *Hub
+	cors *cors.Policy
 }
 
 // NewService creates a new WebSocket service
@@ -64,6 +61,21 @@ func NewService() *Service {
 	return service
 }
 
+// SetCORSPolicy restricts the origins allowed to open WebSocket
+// connections. Without a policy every origin is allowed.
+func (s *Service) SetCORSPolicy(policy *cors.Policy) {
+	s.cors = policy
+}
+
+// checkOrigin is used by the upgrader, browsers do not send CORS
+// preflights for WebSocket handshakes
+func (s *Service) checkOrigin(r *http.Request) bool {
+	if s.cors == nil {
+		return true // Allow all origins for development
+	}
+	return s.cors.CheckOrigin(r)
+}
+
 // GetHandler returns the WebSocket HTTP handler
 func (s *Service) GetHandler() http.HandlerFunc {
 	return s.handleWebSocket
@@ -213,10 +225,7 @@ func (h *Hub) broadcastToOthers(message Message, sender *Client) {
 func (s *Service) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("🔗 New WebSocket connection request from %s", r.RemoteAddr)
 
-	// Add CORS headers for WebSocket handshake
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("❌ WebSocket upgrade failed: %v", err)
@@ -259,7 +268,6 @@ func (s *Service) handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(stats)
 }
 
@@ -377,6 +385,16 @@ func (s *Service) GetConnectedClients() int {
 	return len(s.hub.clients)
 }
 
+// RegisterMetrics exposes the number of active clients in the hub
+func (s *Service) RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "websocket_active_clients",
+		Help: "WebSocket clients currently connected to the hub.",
+	}, func() float64 {
+		return float64(s.GetConnectedClients())
+	}))
+}
+
 // BroadcastMessage sends a message to all connected clients
 func (s *Service) BroadcastMessage(message Message) {
 	message.Timestamp = time.Now()
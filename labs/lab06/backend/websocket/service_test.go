@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/timur-harin/sum25-go-flutter-course/backend/pkg/metrics"
 )
 
 func TestService_NewService(t *testing.T) {
@@ -355,3 +356,38 @@ func TestWebSocket_PingPong(t *testing.T) {
 		t.Error("Did not receive pong response to ping")
 	}
 }
+
+func TestService_Metrics(t *testing.T) {
+	service := NewService()
+	registry := metrics.NewRegistry()
+
+	if err := service.RegisterMetrics(registry); err != nil {
+		t.Fatalf("RegisterMetrics failed: %v", err)
+	}
+
+	// The instrumented handler must still support the websocket upgrade
+	httpMetrics := metrics.NewHTTP(registry, "websocket")
+	server := httptest.NewServer(httpMetrics.Wrap("/ws", service.GetHandler()))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?user_id=metrics"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect through the instrumented handler: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	metrics.Handler(registry).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, want := range []string{
+		"websocket_active_clients 1",
+		`http_requests_total{method="GET",route="/ws",service="websocket",status="101"} 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("Expected %q in the metrics output", want)
+		}
+	}
+}
@@ -31,7 +31,8 @@ class CalculatorResult {
       result: (json['result'] ?? 0.0).toDouble(),
       operation: json['operation'] ?? '',
       success: json['success'] ?? false,
-      error: json['error'],
+      // Failed operations come back as problem details
+      error: json['error'] ?? json['detail'],
     );
   }
 